@@ -0,0 +1,311 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/history"
+)
+
+// anthropicProvider talks to the Anthropic messages API. The system prompt is
+// lifted out of the message list into the top-level "system" field, and messages
+// are converted to the content blocks that API expects. Tool calls become
+// tool_use blocks and their results tool_result blocks in a user message.
+type anthropicProvider struct{}
+
+func (p *anthropicProvider) Name() string {
+	return AnthropicProvider
+}
+
+func (p *anthropicProvider) Path(cfg config.Config) string {
+	return cfg.MessagesPath
+}
+
+func (p *anthropicProvider) BuildRequest(cfg config.Config, messages []api.Message, tools []api.FunctionDefinition, stream bool) ([]byte, error) {
+	var (
+		system    []string
+		converted []api.Message
+	)
+
+	for _, message := range messages {
+		if message.Role == SystemRole {
+			if s, ok := message.Content.(string); ok && s != "" {
+				system = append(system, s)
+			}
+			continue
+		}
+
+		// results of parallel tool calls have to share a single user message
+		if message.Role == ToolRole {
+			result := toMessagesToolResult(message)
+			if last := len(converted) - 1; last >= 0 {
+				if results, ok := converted[last].Content.([]api.MessagesToolResult); ok {
+					converted[last].Content = append(results, result)
+					continue
+				}
+			}
+			converted = append(converted, api.Message{
+				Role:    UserRole,
+				Content: []api.MessagesToolResult{result},
+			})
+			continue
+		}
+
+		m, err := toMessagesAPIMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, m)
+	}
+
+	req := &api.MessagesRequest{
+		Model:     cfg.Model,
+		System:    strings.Join(system, "\n\n"),
+		Messages:  converted,
+		MaxTokens: cfg.MaxTokens,
+		Stream:    stream,
+	}
+
+	if cfg.Capabilities().Temperature {
+		req.Temperature = cfg.Temperature
+	}
+
+	for _, tool := range tools {
+		schema := tool.Parameters
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object"}
+		}
+		req.Tools = append(req.Tools, api.MessagesTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: schema,
+		})
+	}
+
+	return json.Marshal(req)
+}
+
+func (p *anthropicProvider) ParseResponse(raw []byte) (Reply, error) {
+	var res api.MessagesResponse
+
+	if err := decodeResponse(raw, &res); err != nil {
+		return Reply{}, err
+	}
+
+	reply := Reply{
+		Tokens:       res.Usage.InputTokens + res.Usage.OutputTokens,
+		Usage:        messagesUsage(res.Usage),
+		Model:        res.Model,
+		ResponseID:   res.ID,
+		FinishReason: res.StopReason,
+	}
+
+	for _, content := range res.Content {
+		switch content.Type {
+		case textType:
+			reply.Text += content.Text
+		case toolUseType:
+			reply.ToolCalls = append(reply.ToolCalls, api.ToolCall{
+				ID:   content.ID,
+				Type: functionType,
+				Function: api.FunctionCall{
+					Name:      content.Name,
+					Arguments: string(content.Input),
+				},
+			})
+		}
+	}
+
+	if reply.Text == "" && len(reply.ToolCalls) == 0 {
+		return reply, errors.New(errNoResponseReturned)
+	}
+
+	return reply, nil
+}
+
+// DecodeStream decodes the messages event stream. Text arrives in
+// content_block_delta events and the stream ends with a message_stop event. The
+// input tokens are reported by message_start and the output tokens by message_delta.
+func (p *anthropicProvider) DecodeStream(reader io.Reader, emit EventHandler) Reply {
+	var (
+		result []byte
+		calls  streamedToolCalls
+		usage  api.MessagesUsage
+		reply  Reply
+	)
+
+	// the event name is repeated in the payload's "type" field
+	readSSE(reader, func(_, payload string) bool {
+		var env struct {
+			Type         string              `json:"type"`
+			Index        int                 `json:"index"`
+			ContentBlock api.MessagesContent `json:"content_block"`
+			Delta        struct {
+				Type        string `json:"type"`
+				Text        string `json:"text"`
+				Thinking    string `json:"thinking"`
+				PartialJSON string `json:"partial_json"`
+				StopReason  string `json:"stop_reason"`
+			} `json:"delta"`
+			Message struct {
+				ID    string            `json:"id"`
+				Model string            `json:"model"`
+				Usage api.MessagesUsage `json:"usage"`
+			} `json:"message"`
+			Usage api.MessagesUsage `json:"usage"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(payload), &env); err != nil {
+			streamError(emit, err)
+			return false
+		}
+
+		switch env.Type {
+		case "message_start":
+			usage = env.Message.Usage
+			reply.ResponseID = env.Message.ID
+			reply.Model = env.Message.Model
+		case "message_delta":
+			usage.OutputTokens = env.Usage.OutputTokens
+			reply.FinishReason = env.Delta.StopReason
+		case "content_block_start":
+			if env.ContentBlock.Type == toolUseType {
+				calls.add(env.Index, env.ContentBlock.ID, env.ContentBlock.Name, "")
+			}
+		case "content_block_delta":
+			switch env.Delta.Type {
+			case "text_delta":
+				result = writeDelta(emit, result, env.Delta.Text)
+			case "thinking_delta":
+				writeReasoning(emit, env.Delta.Thinking)
+			case "input_json_delta":
+				calls.add(env.Index, "", "", env.Delta.PartialJSON)
+			}
+		case "message_stop":
+			result = finishStream(emit, result, calls.list())
+			return true
+		case "error":
+			streamError(emit, errors.New(env.Error.Message))
+			return true
+		default:
+			// ignore content_block_stop and ping
+		}
+		return false
+	})
+
+	reply.Text = string(result)
+	reply.Tokens = usage.InputTokens + usage.OutputTokens
+	reply.Usage = messagesUsage(usage)
+	reply.ToolCalls = calls.list()
+
+	return reply
+}
+
+// messagesUsage counts the tokens read from and written to the prompt cache as
+// prompt tokens, which the messages API reports apart from the input tokens.
+func messagesUsage(usage api.MessagesUsage) history.Usage {
+	prompt := usage.InputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens
+
+	return history.Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: usage.OutputTokens,
+		CachedTokens:     usage.CacheReadInputTokens,
+		TotalTokens:      prompt + usage.OutputTokens,
+	}
+}
+
+// toMessagesAPIMessage converts a chat message to the shape accepted by the messages
+// API, which only knows the user and assistant roles and uses its own image blocks.
+func toMessagesAPIMessage(message api.Message) (api.Message, error) {
+	result := api.Message{
+		Role:    message.Role,
+		Content: message.Content,
+	}
+
+	if result.Role != AssistantRole {
+		result.Role = UserRole
+	}
+
+	if len(message.ToolCalls) > 0 {
+		var blocks []interface{}
+		if text, ok := message.Content.(string); ok && text != "" {
+			blocks = append(blocks, api.MessagesTextContent{Type: textType, Text: text})
+		}
+		for _, call := range message.ToolCalls {
+			input := json.RawMessage(call.Function.Arguments)
+			if !json.Valid(input) {
+				input = json.RawMessage("{}")
+			}
+			blocks = append(blocks, api.MessagesToolUse{
+				Type:  toolUseType,
+				ID:    call.ID,
+				Name:  call.Function.Name,
+				Input: input,
+			})
+		}
+		result.Content = blocks
+		return result, nil
+	}
+
+	switch content := message.Content.(type) {
+	case []api.ContentPart:
+		var blocks []interface{}
+		for _, part := range content {
+			switch part.Type {
+			case imageURLType:
+				if part.ImageURL != nil {
+					blocks = append(blocks, toMessagesImageContent(part.ImageURL.URL))
+				}
+			case audioType:
+				return api.Message{}, fmt.Errorf(errAudioUnsupported, AnthropicProvider)
+			default:
+				if part.Text != "" {
+					blocks = append(blocks, api.MessagesTextContent{Type: textType, Text: part.Text})
+				}
+			}
+		}
+		result.Content = blocks
+	}
+
+	return result, nil
+}
+
+func toMessagesToolResult(message api.Message) api.MessagesToolResult {
+	content, _ := message.Content.(string)
+	return api.MessagesToolResult{
+		Type:      toolResultType,
+		ToolUseID: message.ToolCallID,
+		Content:   content,
+	}
+}
+
+func toMessagesImageContent(imageURL string) api.MessagesImageContent {
+	if strings.HasPrefix(imageURL, "data:") {
+		if mediaType, data, ok := strings.Cut(strings.TrimPrefix(imageURL, "data:"), ";base64,"); ok {
+			return api.MessagesImageContent{
+				Type: imageType,
+				Source: api.MessagesImageSource{
+					Type:      base64SourceType,
+					MediaType: mediaType,
+					Data:      data,
+				},
+			}
+		}
+	}
+
+	return api.MessagesImageContent{
+		Type: imageType,
+		Source: api.MessagesImageSource{
+			Type: urlSourceType,
+			URL:  imageURL,
+		},
+	}
+}
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/internal"
+	"github.com/kardolus/chatgpt-cli/tokenizer"
+)
+
+const (
+	// imageTokens and audioTokens estimate what an attachment costs. The real
+	// cost depends on the size of the image or the length of the audio, which
+	// would have to be decoded to be known. 765 tokens is a 1024x1024 image at
+	// high detail.
+	imageTokens = 765
+	audioTokens = 500
+	errNoBlobs  = "the attachment %s is stored as a blob, but the client has no blob directory"
+)
+
+// attachments returns the media in ctx as content parts. Media that is in the
+// history of this session already is skipped, so an interactive session does
+// not attach its image to every query.
+func (c *Client) attachments(ctx context.Context) ([]api.ContentPart, error) {
+	var (
+		part api.ContentPart
+		data []byte
+		err  error
+	)
+
+	if binary, ok := ctx.Value(internal.BinaryDataKey).([]byte); ok {
+		mime, _ := getMimeTypeFromBytes(binary)
+		part = api.ContentPart{Type: imageURLType, MediaType: mime}
+		data = binary
+	} else if path, ok := ctx.Value(internal.ImagePathKey).(string); ok {
+		if isValidURL(path) {
+			part = api.ContentPart{Type: imageURLType, ImageURL: &api.ImageURL{URL: path}}
+		} else {
+			mime, err := c.getMimeTypeFromFileContent(path)
+			if err != nil {
+				return nil, err
+			}
+			if data, err = c.reader.ReadFile(path); err != nil {
+				return nil, err
+			}
+			part = api.ContentPart{Type: imageURLType, MediaType: mime, Name: filepath.Base(path)}
+		}
+	} else if path, ok := ctx.Value(internal.AudioPathKey).(string); ok {
+		format, err := c.detectAudioFormat(path)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = c.reader.ReadFile(path); err != nil {
+			return nil, err
+		}
+		part = api.ContentPart{Type: audioType, InputAudio: &api.InputAudio{Format: format}, Name: filepath.Base(path)}
+	} else {
+		return nil, nil
+	}
+
+	key := part.Type + ":"
+	if part.ImageURL != nil {
+		key += part.ImageURL.URL
+	} else {
+		sum := sha256.Sum256(data)
+		key += hex.EncodeToString(sum[:])
+	}
+	if c.attached[key] {
+		return nil, nil
+	}
+
+	if part.ImageURL == nil {
+		if part, err = c.storeAttachment(part, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.attached == nil {
+		c.attached = make(map[string]bool)
+	}
+	c.attached[key] = true
+
+	return []api.ContentPart{part}, nil
+}
+
+// storeAttachment puts data in the blobs of the client and refers part to it,
+// or embeds data in part when the client has no blobs.
+func (c *Client) storeAttachment(part api.ContentPart, data []byte) (api.ContentPart, error) {
+	if c.blobs != nil {
+		hash, err := c.blobs.Put(data)
+		if err != nil {
+			return part, err
+		}
+		part.Blob = hash
+		return part, nil
+	}
+
+	return withData(part, data), nil
+}
+
+// resolveContent returns content the way it is sent: attachments are loaded from
+// their blobs and the fields only the history knows about are left out. Text
+// content is returned as it is.
+func (c *Client) resolveContent(content interface{}) (interface{}, error) {
+	if _, ok := content.(string); ok || content == nil {
+		return content, nil
+	}
+
+	parts := api.ContentParts(content)
+	result := make([]api.ContentPart, 0, len(parts))
+
+	for _, part := range parts {
+		if part.Blob != "" {
+			if c.blobs == nil {
+				return nil, fmt.Errorf(errNoBlobs, part.Blob)
+			}
+			data, err := c.blobs.Get(part.Blob)
+			if err != nil {
+				return nil, err
+			}
+			part = withData(part, data)
+		}
+
+		part.Blob, part.MediaType, part.Name = "", "", ""
+		result = append(result, part)
+	}
+
+	return result, nil
+}
+
+// withData embeds data in an image or audio part.
+func withData(part api.ContentPart, data []byte) api.ContentPart {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	if part.Type == audioType {
+		audio := api.InputAudio{Data: encoded}
+		if part.InputAudio != nil {
+			audio.Format = part.InputAudio.Format
+		}
+		part.InputAudio = &audio
+		return part
+	}
+
+	part.ImageURL = &api.ImageURL{URL: fmt.Sprintf(imageContent, part.MediaType, encoded)}
+	return part
+}
+
+// countContent counts the tokens of the content of a message, estimating those
+// of its attachments.
+func countContent(t tokenizer.Tokenizer, content interface{}) int {
+	if text, ok := content.(string); ok {
+		return t.Count(text)
+	}
+
+	var result int
+	for _, part := range api.ContentParts(content) {
+		switch part.Type {
+		case imageURLType:
+			result += imageTokens
+		case audioType:
+			result += audioTokens
+		default:
+			result += t.Count(part.Text)
+		}
+	}
+
+	return result
+}
+
+// contentText returns the text of the content of a message, without its
+// attachments. ok is false when the content has no text at all.
+func contentText(content interface{}) (string, bool) {
+	if text, ok := content.(string); ok {
+		return text, true
+	}
+
+	var (
+		texts []string
+		ok    bool
+	)
+	for _, part := range api.ContentParts(content) {
+		if part.Type == textType {
+			texts = append(texts, part.Text)
+			ok = true
+		}
+	}
+
+	return strings.Join(texts, "\n"), ok
+}
+
+// contentAttachments returns the parts of the content of a message that are not
+// text.
+func contentAttachments(content interface{}) []api.ContentPart {
+	if _, ok := content.(string); ok {
+		return nil
+	}
+
+	var result []api.ContentPart
+	for _, part := range api.ContentParts(content) {
+		if part.Type != textType {
+			result = append(result, part)
+		}
+	}
+
+	return result
+}
@@ -16,15 +16,17 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
-	"unicode/utf8"
 
 	"github.com/kardolus/chatgpt-cli/api"
 	"github.com/kardolus/chatgpt-cli/api/http"
+	"github.com/kardolus/chatgpt-cli/api/mcp"
 	"github.com/kardolus/chatgpt-cli/cmd/chatgpt/utils"
 	"github.com/kardolus/chatgpt-cli/config"
 	"github.com/kardolus/chatgpt-cli/history"
 	"github.com/kardolus/chatgpt-cli/internal"
+	"github.com/kardolus/chatgpt-cli/tokenizer"
 
 	"go.uber.org/zap"
 	"golang.org/x/text/cases"
@@ -37,23 +39,33 @@ const (
 	ErrMissingMCPAPIKey      = "the %s api key is not configured"
 	ErrUnsupportedProvider   = "unsupported MCP provider"
 	ErrHistoryTracking       = "history tracking needs to be enabled to use this feature"
+	TruncationMarker         = "[response truncated]"
 	MaxTokenBufferPercentage = 20
+	tokensPerMessage         = 4
 	SystemRole               = "system"
 	UserRole                 = "user"
 	FunctionRole             = "function"
+	ToolRole                 = "tool"
 	InteractiveThreadPrefix  = "int_"
-	SearchModelPattern       = "-search"
 	ApifyURL                 = "https://api.apify.com/v2/acts/"
 	ApifyPath                = "/run-sync-get-dataset-items"
 	ApifyProxyConfig         = "proxyConfiguration"
 	gptPrefix                = "gpt"
 	o1Prefix                 = "o1"
-	o1ProPattern             = "o1-pro"
-	gpt5Pattern              = "gpt-5"
 	audioType                = "input_audio"
 	imageURLType             = "image_url"
+	imageType                = "image"
+	base64SourceType         = "base64"
+	urlSourceType            = "url"
+	textType                 = "text"
 	messageType              = "message"
 	outputTextType           = "output_text"
+	inputTextType            = "input_text"
+	inputImageType           = "input_image"
+	errAudioUnsupported      = "audio input is not supported by the %s provider"
+	errPinnedBudget          = "the pinned messages take %d tokens and the sticky files %d tokens, which leaves no room for the rest of the thread in a context window of %d tokens, free some with --unpin or --unstick"
+	errReadThread            = "failed to read thread %s: %w"
+	errStoreAnswer           = "the answer was not stored in thread %s: %w"
 	imageContent             = "data:%s;base64,%s"
 	httpScheme               = "http"
 	httpsScheme              = "https"
@@ -114,10 +126,31 @@ type Client struct {
 	Config       config.Config
 	History      []history.History
 	caller       http.Caller
+	provider     Provider
 	historyStore history.Store
+	output       io.Writer
 	timer        Timer
 	reader       FileReader
 	writer       FileWriter
+	toolRunner   ToolRunner
+	tokenizer    tokenizer.Tokenizer
+	mcpFactory   mcp.ClientFactory
+	mcpSessions  map[string]mcp.Client
+	// mcpTools is nil until the tools of the MCP servers have been listed
+	mcpTools       map[string]mcpTool
+	mcpDefinitions []api.FunctionDefinition
+	// metadataMu serializes the metadata updates of the queries and of the
+	// background titles
+	metadataMu sync.Mutex
+	titled     map[string]bool
+	background sync.WaitGroup
+	blobs      *history.Blobs
+	// attached holds the media of this session that is in the history already
+	attached map[string]bool
+	// pending holds the attachments of a query that was taken back to be retried
+	pending []api.ContentPart
+	// sticky holds the sticky files of the thread as they were read for this turn
+	sticky []api.Message
 }
 
 func New(callerFactory http.CallerFactory, hs history.Store, t Timer, r FileReader, w FileWriter, cfg config.Config, interactiveMode bool) *Client {
@@ -133,9 +166,12 @@ func New(callerFactory http.CallerFactory, hs history.Store, t Timer, r FileRead
 		Config:       cfg,
 		caller:       caller,
 		historyStore: hs,
+		output:       os.Stdout,
 		timer:        t,
 		reader:       r,
 		writer:       w,
+		toolRunner:   &RealToolRunner{},
+		mcpFactory:   mcp.RealClientFactory,
 	}
 }
 
@@ -149,14 +185,75 @@ func (c *Client) WithServiceURL(url string) *Client {
 	return c
 }
 
-// InjectMCPContext calls an MCP plugin (e.g. Apify) with the given parameters,
-// retrieves the result, and adds it to the chat history as a function message.
-// The result is formatted as a string and tagged with the function name.
-func (c *Client) InjectMCPContext(mcp api.MCPRequest) error {
+// WithProvider pins the provider, overriding the one selected by the config.
+func (c *Client) WithProvider(provider Provider) *Client {
+	c.provider = provider
+	return c
+}
+
+func (c *Client) WithToolRunner(runner ToolRunner) *Client {
+	c.toolRunner = runner
+	return c
+}
+
+// WithOutput replaces the writer Stream prints the answer to, which is os.Stdout
+// by default.
+func (c *Client) WithOutput(w io.Writer) *Client {
+	c.output = w
+	return c
+}
+
+// WithTokenizer pins the tokenizer, overriding the one selected by the encoding
+// of the model.
+func (c *Client) WithTokenizer(t tokenizer.Tokenizer) *Client {
+	c.tokenizer = t
+	return c
+}
+
+// WithBlobs stores the images and audio of queries in b, so the history refers
+// to them by hash. Without blobs, they are embedded in the history.
+func (c *Client) WithBlobs(b *history.Blobs) *Client {
+	c.blobs = b
+	return c
+}
+
+// Capabilities reports what the configured model supports, as declared by the
+// built-in model registry and the models section of the config.
+func (c *Client) Capabilities() config.ModelCapabilities {
+	return c.Config.Capabilities()
+}
+
+// InjectMCPContext calls an MCP plugin (e.g. Apify) or a configured MCP server
+// with the given parameters, retrieves the result, and adds it to the chat history
+// as a function message. The result is formatted as a string and tagged with the
+// function name. Prompts of MCP servers are added with the roles they declare.
+func (c *Client) InjectMCPContext(ctx context.Context, mcp api.MCPRequest) error {
 	if c.Config.OmitHistory {
 		return errors.New(ErrHistoryTracking)
 	}
 
+	if server, ok := c.mcpServer(mcp.Provider); ok {
+		messages, err := c.injectServerContext(server, mcp)
+		if err != nil {
+			return err
+		}
+
+		if err := c.initHistory(); err != nil {
+			return err
+		}
+		for _, message := range messages {
+			c.History = append(c.History, history.History{
+				Message:   message,
+				Timestamp: c.timer.Now(),
+			})
+		}
+		if err := c.truncateHistory(ctx); err != nil {
+			return err
+		}
+
+		return c.historyStore.Write(c.History)
+	}
+
 	endpoint, headers, body, err := c.buildMCPRequest(mcp)
 	if err != nil {
 		return err
@@ -164,7 +261,7 @@ func (c *Client) InjectMCPContext(mcp api.MCPRequest) error {
 
 	c.printRequestDebugInfo(endpoint, body, headers)
 
-	raw, err := c.caller.PostWithHeaders(endpoint, body, headers)
+	raw, err := c.caller.PostWithHeaders(ctx, endpoint, body, headers)
 	if err != nil {
 		return err
 	}
@@ -173,7 +270,9 @@ func (c *Client) InjectMCPContext(mcp api.MCPRequest) error {
 
 	formatted := formatMCPResponse(raw, mcp.Function)
 
-	c.initHistory()
+	if err := c.initHistory(); err != nil {
+		return err
+	}
 	c.History = append(c.History, history.History{
 		Message: api.Message{
 			Role:    FunctionRole,
@@ -182,7 +281,9 @@ func (c *Client) InjectMCPContext(mcp api.MCPRequest) error {
 		},
 		Timestamp: c.timer.Now(),
 	})
-	c.truncateHistory()
+	if err := c.truncateHistory(ctx); err != nil {
+		return err
+	}
 
 	return c.historyStore.Write(c.History)
 }
@@ -193,14 +294,14 @@ func (c *Client) InjectMCPContext(mcp api.MCPRequest) error {
 // The currently active model is marked with an asterisk (*) in the list.
 // In case of an error during the retrieval or processing of the models,
 // the method returns an error. If the API response is empty, an error is returned as well.
-func (c *Client) ListModels() ([]string, error) {
+func (c *Client) ListModels(ctx context.Context) ([]string, error) {
 	var result []string
 
 	endpoint := c.getEndpoint(c.Config.ModelsPath)
 
 	c.printRequestDebugInfo(endpoint, nil, nil)
 
-	raw, err := c.caller.Get(c.getEndpoint(c.Config.ModelsPath))
+	raw, err := c.caller.Get(ctx, endpoint)
 	c.printResponseDebugInfo(raw)
 
 	if err != nil {
@@ -208,7 +309,7 @@ func (c *Client) ListModels() ([]string, error) {
 	}
 
 	var response api.ListModelsResponse
-	if err := c.processResponse(raw, &response); err != nil {
+	if err := decodeResponse(raw, &response); err != nil {
 		return nil, err
 	}
 
@@ -233,13 +334,11 @@ func (c *Client) ListModels() ([]string, error) {
 // provided string into a series of messages. This allows the ChatGPT API to have
 // prior knowledge of the provided context when generating responses.
 //
-// The context string should contain the text you want to provide as context,
-// and the method will split it into messages, preserving punctuation and special
-// characters.
-func (c *Client) ProvideContext(context string) {
-	c.initHistory()
-	historyEntries := c.createHistoryEntriesFromString(context)
-	c.History = append(c.History, historyEntries...)
+// The context is kept as it is, including newlines and indentation. It is only
+// split into several messages when it is too long for one. See ProvideContextFrom
+// for context that is labeled with its source.
+func (c *Client) ProvideContext(context string) error {
+	return c.ProvideContextFrom("", context)
 }
 
 // Query sends a query to the API, returning the response as a string along with the token usage.
@@ -249,6 +348,8 @@ func (c *Client) ProvideContext(context string) {
 //
 // Returns the API response string, the number of tokens used, and an error if any issues occur.
 // If the response contains choices, it decodes the JSON and returns the content of the first choice.
+// When the model calls configured tools, they are run and their results are sent back until the
+// model answers. Only the final answer is added to the history.
 //
 // Parameters:
 //   - ctx: A context.Context that controls request cancellation and deadlines.
@@ -259,110 +360,154 @@ func (c *Client) ProvideContext(context string) {
 //   - int: The total number of tokens used in the request.
 //   - error: An error if the request fails or the response is invalid.
 func (c *Client) Query(ctx context.Context, input string) (string, int, error) {
-	c.prepareQuery(input)
-
-	body, err := c.createBody(ctx, false)
-	if err != nil {
-		return "", 0, err
-	}
-
-	endpoint := c.getChatEndpoint()
-
-	c.printRequestDebugInfo(endpoint, body, nil)
-
-	raw, err := c.caller.Post(endpoint, body, false)
-	c.printResponseDebugInfo(raw)
-
-	if err != nil {
+	if err := c.prepareQuery(ctx, input); err != nil {
 		return "", 0, err
 	}
 
 	var (
-		response   string
+		exchange   []api.Message
 		tokensUsed int
+		usage      history.Usage
+		sent       = c.queryTime()
 	)
 
-	caps := GetCapabilities(c.Config.Model)
-
-	if caps.UsesResponsesAPI {
-		var res api.ResponsesResponse
-		if err := c.processResponse(raw, &res); err != nil {
-			return "", 0, err
+	for round := 0; ; round++ {
+		body, err := c.createBody(exchange, false)
+		if err != nil {
+			return "", tokensUsed, err
 		}
-		tokensUsed = res.Usage.TotalTokens
 
-		for _, output := range res.Output {
-			if output.Type != messageType {
-				continue
-			}
-			for _, content := range output.Content {
-				if content.Type == outputTextType {
-					response = content.Text
-					break
-				}
-			}
-		}
+		endpoint := c.getChatEndpoint()
 
-		if response == "" {
-			return "", tokensUsed, errors.New("no response returned")
+		c.printRequestDebugInfo(endpoint, body, nil)
+
+		raw, err := c.caller.Post(ctx, endpoint, body)
+		c.printResponseDebugInfo(raw)
+
+		if err != nil {
+			return "", tokensUsed, err
 		}
-	} else {
-		var res api.CompletionsResponse
-		if err := c.processResponse(raw, &res); err != nil {
-			return "", 0, err
+
+		reply, err := c.getProvider().ParseResponse(raw)
+		tokensUsed += reply.Tokens
+		usage.Add(reply.Usage)
+		if err != nil {
+			return "", tokensUsed, err
 		}
-		tokensUsed = res.Usage.TotalTokens
 
-		if len(res.Choices) == 0 {
-			return "", tokensUsed, errors.New("no responses returned")
+		if len(reply.ToolCalls) == 0 {
+			if err := c.addAnswer(answerEntry(reply, usage), sent); err != nil {
+				return reply.Text, tokensUsed, err
+			}
+			c.recordTurn(tokensUsed)
+			return reply.Text, tokensUsed, nil
 		}
 
-		var ok bool
-		response, ok = res.Choices[0].Message.Content.(string)
-		if !ok {
-			return "", tokensUsed, errors.New("response cannot be converted to a string")
+		if round >= c.Config.MaxToolRounds {
+			return "", tokensUsed, fmt.Errorf(ErrToolRounds, c.Config.MaxToolRounds)
 		}
-	}
 
-	c.updateHistory(response)
+		exchange = append(exchange, c.runToolCalls(reply)...)
+	}
+}
 
-	return response, tokensUsed, nil
+// Stream sends a query to the API and prints the answer to the output of the
+// client as it arrives. It is StreamEvents with a handler that writes the text
+// deltas and the errors reported within the stream.
+//
+// Parameters:
+//   - ctx: A context.Context that controls request cancellation and deadlines.
+//   - input: The query string to send to the API.
+//
+// Returns:
+//   - error: An error if the request fails or the response is invalid.
+func (c *Client) Stream(ctx context.Context, input string) error {
+	return c.StreamEvents(ctx, input, c.printEvent)
 }
 
-// Stream sends a query to the API and processes the response as a stream.
+// StreamEvents sends a query to the API and hands the answer to handle as typed
+// events while it is streamed.
 //
-// It takes a context `ctx` and an input string, constructs a request body, and makes a POST API call.
-// The context allows for request scoping, timeouts, and cancellation handling.
+// It takes a context `ctx` and an input string, constructs a request body, and opens
+// the stream using the `PostStream` method. The configured provider decodes the stream
+// into text deltas, reasoning summaries and errors. After every round the tool calls
+// of the model and the tokens it used are reported. Tool calls are handled as in Query,
+// with a new stream opened for every round, and the answer is added to the history
+// before the closing EventDone.
 //
-// The method creates a request body with the input and calls the API using the `Post` method.
-// The actual processing of the streamed response is handled inside the `Post` method.
+// When the stream is cancelled or times out, the text received so far is kept in
+// the history, followed by the TruncationMarker.
 //
 // Parameters:
 //   - ctx: A context.Context that controls request cancellation and deadlines.
 //   - input: The query string to send to the API.
+//   - handle: The EventHandler that receives the events in order.
 //
 // Returns:
 //   - error: An error if the request fails or the response is invalid.
-func (c *Client) Stream(ctx context.Context, input string) error {
-	c.prepareQuery(input)
-
-	body, err := c.createBody(ctx, true)
-	if err != nil {
+func (c *Client) StreamEvents(ctx context.Context, input string, handle EventHandler) error {
+	if err := c.prepareQuery(ctx, input); err != nil {
 		return err
 	}
 
-	endpoint := c.getChatEndpoint()
+	var (
+		exchange   []api.Message
+		tokensUsed int
+		usage      history.Usage
+		sent       = c.queryTime()
+	)
 
-	c.printRequestDebugInfo(endpoint, body, nil)
+	for round := 0; ; round++ {
+		body, err := c.createBody(exchange, true)
+		if err != nil {
+			return err
+		}
 
-	result, err := c.caller.Post(endpoint, body, true)
-	if err != nil {
-		return err
-	}
+		endpoint := c.getChatEndpoint()
 
-	c.updateHistory(string(result))
+		c.printRequestDebugInfo(endpoint, body, nil)
 
-	return nil
+		stream, err := c.caller.PostStream(ctx, endpoint, body)
+		if err != nil {
+			return err
+		}
+
+		reader := &streamReader{reader: stream}
+		reply := c.getProvider().DecodeStream(reader, handle)
+		_ = stream.Close()
+
+		if reader.err != nil {
+			if err := c.keepPartialAnswer(reply.Text, handle); err != nil {
+				return errors.Join(reader.err, err)
+			}
+			return reader.err
+		}
+
+		for i := range reply.ToolCalls {
+			handle(Event{Type: EventToolCall, ToolCall: &reply.ToolCalls[i]})
+		}
+
+		usage.Add(reply.Usage)
+		if reply.Tokens > 0 {
+			tokensUsed += reply.Tokens
+			handle(Event{Type: EventUsage, Tokens: reply.Tokens})
+		}
+
+		if len(reply.ToolCalls) == 0 {
+			if err := c.addAnswer(answerEntry(reply, usage), sent); err != nil {
+				return err
+			}
+			c.recordTurn(tokensUsed)
+			handle(Event{Type: EventDone, Text: reply.Text})
+			return nil
+		}
+
+		if round >= c.Config.MaxToolRounds {
+			return fmt.Errorf(ErrToolRounds, c.Config.MaxToolRounds)
+		}
+
+		exchange = append(exchange, c.runToolCalls(reply)...)
+	}
 }
 
 // SynthesizeSpeech converts the given input text into speech using the configured TTS model,
@@ -376,14 +521,14 @@ func (c *Client) Stream(ctx context.Context, input string) error {
 //   - outputPath: The path to the output audio file. The file extension determines the response format.
 //
 // Returns an error if the request fails, the response cannot be written, or the file cannot be created.
-func (c *Client) SynthesizeSpeech(inputText, outputPath string) error {
+func (c *Client) SynthesizeSpeech(ctx context.Context, inputText, outputPath string) error {
 	req := api.Speech{
 		Model:          c.Config.Model,
 		Voice:          c.Config.Voice,
 		Input:          inputText,
 		ResponseFormat: getExtension(outputPath),
 	}
-	return c.postAndWriteBinaryOutput(c.getEndpoint(c.Config.SpeechPath), req, outputPath, "binary", nil)
+	return c.postAndWriteBinaryOutput(ctx, c.getEndpoint(c.Config.SpeechPath), req, outputPath, "binary", nil)
 }
 
 // GenerateImage sends a prompt to the configured image generation model (e.g., gpt-image-1)
@@ -401,13 +546,14 @@ func (c *Client) SynthesizeSpeech(inputText, outputPath string) error {
 //
 // Returns:
 //   - An error if any part of the request, decoding, or file writing fails.
-func (c *Client) GenerateImage(inputText, outputPath string) error {
+func (c *Client) GenerateImage(ctx context.Context, inputText, outputPath string) error {
 	req := api.Draw{
 		Model:  c.Config.Model,
 		Prompt: inputText,
 	}
 
 	return c.postAndWriteBinaryOutput(
+		ctx,
 		c.getEndpoint(c.Config.ImageGenerationsPath),
 		req,
 		outputPath,
@@ -458,7 +604,7 @@ func (c *Client) GenerateImage(inputText, outputPath string) error {
 //	if err != nil {
 //	    log.Fatal(err)
 //	}
-func (c *Client) EditImage(inputText, inputPath, outputPath string) error {
+func (c *Client) EditImage(ctx context.Context, inputText, inputPath, outputPath string) error {
 	endpoint := c.getEndpoint(c.Config.ImageEditsPath)
 
 	file, err := c.reader.Open(inputPath)
@@ -505,7 +651,7 @@ func (c *Client) EditImage(inputText, inputPath, outputPath string) error {
 		"Content-Type": writer.FormDataContentType(),
 	})
 
-	respBytes, err := c.caller.PostWithHeaders(endpoint, buf.Bytes(), map[string]string{
+	respBytes, err := c.caller.PostWithHeaders(ctx, endpoint, buf.Bytes(), map[string]string{
 		c.Config.AuthHeader:           fmt.Sprintf("%s %s", c.Config.AuthTokenPrefix, c.Config.APIKey),
 		internal.HeaderContentTypeKey: writer.FormDataContentType(),
 	})
@@ -559,8 +705,10 @@ func (c *Client) EditImage(inputText, inputPath, outputPath string) error {
 //   - error: An error if the file can't be read, the request fails, or the response is invalid.
 //
 // This method supports formats like mp3, mp4, mpeg, mpga, m4a, wav, and webm, depending on API compatibility.
-func (c *Client) Transcribe(audioPath string) (string, error) {
-	c.initHistory()
+func (c *Client) Transcribe(ctx context.Context, audioPath string) (string, error) {
+	if err := c.initHistory(); err != nil {
+		return "", err
+	}
 
 	file, err := c.reader.Open(audioPath)
 	if err != nil {
@@ -593,7 +741,7 @@ func (c *Client) Transcribe(audioPath string) (string, error) {
 
 	c.printRequestDebugInfo(endpoint, buf.Bytes(), headers)
 
-	raw, err := c.caller.PostWithHeaders(endpoint, buf.Bytes(), headers)
+	raw, err := c.caller.PostWithHeaders(ctx, endpoint, buf.Bytes(), headers)
 	if err != nil {
 		return "", err
 	}
@@ -623,286 +771,197 @@ func (c *Client) Transcribe(audioPath string) (string, error) {
 		Timestamp: c.timer.Now(),
 	})
 
-	c.truncateHistory()
-
-	if !c.Config.OmitHistory {
-		_ = c.historyStore.Write(c.History)
-	}
-
-	return res.Text, nil
-}
-
-func (c *Client) appendMediaMessages(ctx context.Context, messages []api.Message) ([]api.Message, error) {
-	if data, ok := ctx.Value(internal.BinaryDataKey).([]byte); ok {
-		content, err := c.createImageContentFromBinary(data)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, api.Message{
-			Role:    UserRole,
-			Content: []api.ImageContent{content},
-		})
-	} else if path, ok := ctx.Value(internal.ImagePathKey).(string); ok {
-		content, err := c.createImageContentFromURLOrFile(path)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, api.Message{
-			Role:    UserRole,
-			Content: []api.ImageContent{content},
-		})
-	} else if path, ok := ctx.Value(internal.AudioPathKey).(string); ok {
-		content, err := c.createAudioContentFromFile(path)
-		if err != nil {
-			return nil, err
-		}
-		messages = append(messages, api.Message{
-			Role:    UserRole,
-			Content: []api.AudioContent{content},
-		})
-	}
-	return messages, nil
-}
-
-func (c *Client) createBody(ctx context.Context, stream bool) ([]byte, error) {
-	caps := GetCapabilities(c.Config.Model)
-
-	if caps.UsesResponsesAPI {
-		req, err := c.createResponsesRequest(ctx, stream)
-		if err != nil {
-			return nil, err
-		}
-		return json.Marshal(req)
+	if err := c.truncateHistory(ctx); err != nil {
+		return "", err
 	}
 
-	req, err := c.createCompletionsRequest(ctx, stream)
-	if err != nil {
-		return nil, err
-	}
-	return json.Marshal(req)
-}
-
-func (c *Client) createCompletionsRequest(ctx context.Context, stream bool) (*api.CompletionsRequest, error) {
-	var messages []api.Message
-	caps := GetCapabilities(c.Config.Model)
-
-	for index, item := range c.History {
-		if caps.OmitFirstSystemMsg && index == 0 {
-			continue
+	if !c.Config.OmitHistory {
+		if err := c.historyStore.Write(c.History); err != nil {
+			return res.Text, fmt.Errorf(errStoreAnswer, c.historyStore.GetThread(), err)
 		}
-		messages = append(messages, item.Message)
 	}
 
-	messages, err := c.appendMediaMessages(ctx, messages)
-	if err != nil {
-		return nil, err
-	}
-
-	req := &api.CompletionsRequest{
-		Messages:         messages,
-		Model:            c.Config.Model,
-		MaxTokens:        c.Config.MaxTokens,
-		FrequencyPenalty: c.Config.FrequencyPenalty,
-		PresencePenalty:  c.Config.PresencePenalty,
-		Seed:             c.Config.Seed,
-		Stream:           stream,
-	}
-
-	if caps.SupportsTemperature {
-		req.Temperature = c.Config.Temperature
-		req.TopP = c.Config.TopP
-	}
-
-	return req, nil
+	return res.Text, nil
 }
 
-func (c *Client) createResponsesRequest(ctx context.Context, stream bool) (*api.ResponsesRequest, error) {
+// createBody builds the request from the history, with the attachments loaded
+// from their blobs and the sticky files after the system role, and the tool
+// exchange of the current query.
+func (c *Client) createBody(exchange []api.Message, stream bool) ([]byte, error) {
 	var messages []api.Message
-	caps := GetCapabilities(c.Config.Model)
+	caps := c.Capabilities()
 
 	for index, item := range c.History {
-		if caps.OmitFirstSystemMsg && index == 0 {
+		if caps.OmitSystemRole && index == 0 {
+			messages = append(messages, c.sticky...)
 			continue
 		}
-		messages = append(messages, item.Message)
-	}
-
-	messages, err := c.appendMediaMessages(ctx, messages)
-	if err != nil {
-		return nil, err
-	}
-
-	req := &api.ResponsesRequest{
-		Model:           c.Config.Model,
-		Input:           messages,
-		MaxOutputTokens: c.Config.MaxTokens,
-		Reasoning: api.Reasoning{
-			Effort: c.Config.Effort,
-		},
-		Stream:      stream,
-		Temperature: c.Config.Temperature,
-		TopP:        c.Config.TopP,
-	}
-
-	return req, nil
-}
-
-func (c *Client) createImageContentFromBinary(binary []byte) (api.ImageContent, error) {
-	mime, err := getMimeTypeFromBytes(binary)
-	if err != nil {
-		return api.ImageContent{}, err
-	}
-
-	encoded := base64.StdEncoding.EncodeToString(binary)
-	content := api.ImageContent{
-		Type: imageURLType,
-		ImageURL: struct {
-			URL string `json:"url"`
-		}{
-			URL: fmt.Sprintf(imageContent, mime, encoded),
-		},
-	}
-
-	return content, nil
-}
-
-func (c *Client) createAudioContentFromFile(audio string) (api.AudioContent, error) {
-
-	format, err := c.detectAudioFormat(audio)
-	if err != nil {
-		return api.AudioContent{}, err
-	}
-
-	encodedAudio, err := c.base64Encode(audio)
-	if err != nil {
-		return api.AudioContent{}, err
-	}
-
-	return api.AudioContent{
-		Type: audioType,
-		InputAudio: api.InputAudio{
-			Data:   encodedAudio,
-			Format: format,
-		},
-	}, nil
-}
-
-func (c *Client) createImageContentFromURLOrFile(image string) (api.ImageContent, error) {
-	var content api.ImageContent
 
-	if isValidURL(image) {
-		content = api.ImageContent{
-			Type: imageURLType,
-			ImageURL: struct {
-				URL string `json:"url"`
-			}{
-				URL: image,
-			},
-		}
-	} else {
-		mime, err := c.getMimeTypeFromFileContent(image)
+		message := item.Message
+		content, err := c.resolveContent(message.Content)
 		if err != nil {
-			return content, err
+			return nil, err
 		}
+		message.Content = content
 
-		encodedImage, err := c.base64Encode(image)
-		if err != nil {
-			return content, err
+		// a summary is stored as a system message, which models without a
+		// system role reject in the middle of a conversation
+		if caps.OmitSystemRole && item.Summary {
+			message.Role = UserRole
 		}
 
-		content = api.ImageContent{
-			Type: imageURLType,
-			ImageURL: struct {
-				URL string `json:"url"`
-			}{
-				URL: fmt.Sprintf(imageContent, mime, encodedImage),
-			},
+		messages = append(messages, message)
+
+		if index == 0 {
+			messages = append(messages, c.sticky...)
 		}
 	}
+	messages = append(messages, exchange...)
 
-	return content, nil
+	return c.getProvider().BuildRequest(c.Config, messages, c.toolDefinitions(), stream)
 }
 
-func (c *Client) initHistory() {
+// initHistory reads the thread. A new thread starts with the configured role,
+// which stays the system role of the thread when the configured role changes.
+// A thread that cannot be read, because it is corrupt or encrypted with another
+// key, is reported instead of being started over, so the next write does not
+// replace it.
+func (c *Client) initHistory() error {
 	if len(c.History) != 0 {
-		return
+		return nil
 	}
 
 	if !c.Config.OmitHistory {
-		c.History, _ = c.historyStore.Read()
+		entries, err := c.historyStore.Read()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf(errReadThread, c.historyStore.GetThread(), err)
+		}
+		c.History = entries
 	}
 
-	if len(c.History) == 0 {
-		c.History = []history.History{{
+	if len(c.History) == 0 || c.History[0].Role != SystemRole {
+		c.History = append([]history.History{{
 			Message: api.Message{
-				Role: SystemRole,
+				Role:    SystemRole,
+				Content: c.Config.Role,
 			},
 			Timestamp: c.timer.Now(),
-		}}
+		}}, c.History...)
 	}
 
-	c.History[0].Content = c.Config.Role
+	return nil
 }
 
-func (c *Client) addQuery(query string) {
+// addQuery adds the query to the history. The media in ctx, and the attachments
+// of a query that is retried, become parts of the same message.
+func (c *Client) addQuery(ctx context.Context, query string) error {
+	timestamp := c.timer.Now()
+
+	attachments, err := c.attachments(ctx)
+	if err != nil {
+		return err
+	}
+	attachments = append(c.pending, attachments...)
+	c.pending = nil
+
 	message := api.Message{
 		Role:    UserRole,
 		Content: query,
 	}
 
+	if len(attachments) > 0 {
+		var parts []api.ContentPart
+		if query != "" {
+			parts = append(parts, api.ContentPart{Type: textType, Text: query})
+		}
+		message.Content = append(parts, attachments...)
+	}
+
 	c.History = append(c.History, history.History{
 		Message:   message,
-		Timestamp: c.timer.Now(),
+		Timestamp: timestamp,
 	})
-	c.truncateHistory()
+	if err := c.truncateHistory(ctx); err != nil {
+		// the query is not kept, so the thread is as it was
+		c.History = c.History[:len(c.History)-1]
+		return err
+	}
+	return nil
 }
 
-func (c *Client) getChatEndpoint() string {
-	caps := GetCapabilities(c.Config.Model)
+// getProvider resolves the provider from the current config, so changing the
+// model on a client also changes the API it talks to. An invalid provider key is
+// rejected at startup; should one get here anyway the model registry decides.
+func (c *Client) getProvider() Provider {
+	if c.provider != nil {
+		return c.provider
+	}
 
-	var endpoint string
-	if caps.UsesResponsesAPI {
-		endpoint = c.getEndpoint(c.Config.ResponsesPath)
-	} else {
-		endpoint = c.getEndpoint(c.Config.CompletionsPath)
+	provider, err := NewProvider(c.Config)
+	if err != nil {
+		return inferProvider(c.Config.Capabilities())
 	}
-	return endpoint
+	return provider
+}
+
+func (c *Client) getChatEndpoint() string {
+	return c.getEndpoint(c.getProvider().Path(c.Config))
 }
 
 func (c *Client) getEndpoint(path string) string {
 	return c.Config.URL + path
 }
 
-func (c *Client) prepareQuery(input string) {
-	c.initHistory()
-	c.addQuery(input)
+func (c *Client) prepareQuery(ctx context.Context, input string) error {
+	if err := c.initHistory(); err != nil {
+		return err
+	}
+	if err := c.loadSticky(); err != nil {
+		return err
+	}
+	return c.addQuery(ctx, input)
 }
 
-func (c *Client) processResponse(raw []byte, v interface{}) error {
-	if raw == nil {
-		return errors.New(ErrEmptyResponse)
-	}
+// truncateHistory drops the oldest messages after the system prompt once the
+// history no longer fits the context window. With the summarize context strategy
+// the dropped messages are condensed into a summary message instead. Pinned
+// messages are kept, and the tokens of the sticky files are reserved first. It
+// fails when the pinned messages and sticky files leave too little room for the
+// rest of the thread to fit.
+func (c *Client) truncateHistory(ctx context.Context) error {
+	tokens, rolling := c.countTokens(c.History)
+	sticky := c.stickyTokens()
+	effectiveTokenSize := calculateEffectiveContextWindow(c.contextWindow(), MaxTokenBufferPercentage) - sticky
 
-	if err := json.Unmarshal(raw, v); err != nil {
-		return fmt.Errorf("failed to decode response: %w", err)
+	if tokens <= effectiveTokenSize {
+		return nil
 	}
 
-	return nil
-}
+	diff := tokens - effectiveTokenSize
 
-func (c *Client) truncateHistory() {
-	tokens, rolling := countTokens(c.History)
-	effectiveTokenSize := calculateEffectiveContextWindow(c.Config.ContextWindow, MaxTokenBufferPercentage)
+	var droppable, pinnedTokens int
+	for i := 1; i < len(rolling); i++ {
+		if c.History[i].Pinned {
+			pinnedTokens += rolling[i]
+		} else {
+			droppable += rolling[i]
+		}
+	}
+	if (pinnedTokens > 0 || sticky > 0) && droppable <= diff {
+		return fmt.Errorf(errPinnedBudget, pinnedTokens, sticky, c.contextWindow())
+	}
 
-	if tokens <= effectiveTokenSize {
-		return
+	if c.Config.ContextStrategy == ContextStrategySummarize && c.summarizeHistory(ctx, tokens, rolling, effectiveTokenSize) {
+		return nil
 	}
 
 	var index int
 	var total int
-	diff := tokens - effectiveTokenSize
 
 	for i := 1; i < len(rolling); i++ {
+		if c.History[i].Pinned {
+			continue
+		}
 		total += rolling[i]
 		if total > diff {
 			index = i
@@ -910,56 +969,87 @@ func (c *Client) truncateHistory() {
 		}
 	}
 
-	c.History = append(c.History[:1], c.History[index+1:]...)
+	c.History = append(c.History[:1], append(pinned(c.History[1:index+1]), c.History[index+1:]...)...)
+	return nil
 }
 
-func (c *Client) updateHistory(response string) {
-	c.History = append(c.History, history.History{
+// pinned returns the pinned entries of entries.
+func pinned(entries []history.History) []history.History {
+	var result []history.History
+	for _, entry := range entries {
+		if entry.Pinned {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+func (c *Client) updateHistory(response string) error {
+	return c.addAnswer(history.History{
 		Message: api.Message{
 			Role:    AssistantRole,
 			Content: response,
 		},
-		Timestamp: c.timer.Now(),
-	})
+	}, time.Time{})
+}
 
-	if !c.Config.OmitHistory {
-		_ = c.historyStore.Write(c.History)
+// addAnswer adds an answer to the history and stores the thread. The latency of
+// the answer is measured from sent, unless it is zero.
+func (c *Client) addAnswer(entry history.History, sent time.Time) error {
+	entry.Timestamp = c.timer.Now()
+	if !sent.IsZero() && entry.Timestamp.After(sent) {
+		entry.LatencyMS = entry.Timestamp.Sub(sent).Milliseconds()
 	}
-}
 
-func (c *Client) base64Encode(path string) (string, error) {
-	imageData, err := c.reader.ReadFile(path)
-	if err != nil {
-		return "", err
+	c.History = append(c.History, entry)
+
+	if !c.Config.OmitHistory {
+		if err := c.historyStore.Write(c.History); err != nil {
+			return fmt.Errorf(errStoreAnswer, c.historyStore.GetThread(), err)
+		}
 	}
 
-	return base64.StdEncoding.EncodeToString(imageData), nil
+	return nil
 }
 
-func (c *Client) createHistoryEntriesFromString(input string) []history.History {
-	var result []history.History
+// queryTime returns the time the query that was just added to the history was
+// made.
+func (c *Client) queryTime() time.Time {
+	if len(c.History) == 0 {
+		return time.Time{}
+	}
+	return c.History[len(c.History)-1].Timestamp
+}
 
-	words := strings.Fields(input)
+// answerEntry turns the final reply of a query into a history entry with the
+// usage of all the rounds of the query.
+func answerEntry(reply Reply, usage history.Usage) history.History {
+	entry := history.History{
+		Message: api.Message{
+			Role:    AssistantRole,
+			Content: reply.Text,
+		},
+		Model:        reply.Model,
+		ResponseID:   reply.ResponseID,
+		FinishReason: reply.FinishReason,
+	}
 
-	for i := 0; i < len(words); i += 100 {
-		end := i + 100
-		if end > len(words) {
-			end = len(words)
-		}
+	if usage != (history.Usage{}) {
+		entry.Usage = &usage
+	}
 
-		content := strings.Join(words[i:end], " ")
+	return entry
+}
 
-		item := history.History{
-			Message: api.Message{
-				Role:    UserRole,
-				Content: content,
-			},
-			Timestamp: c.timer.Now(),
-		}
-		result = append(result, item)
+// keepPartialAnswer stores the text of an interrupted stream, marked so that
+// neither the user nor the model mistakes it for a complete answer.
+func (c *Client) keepPartialAnswer(text string, handle EventHandler) error {
+	if strings.TrimSpace(text) == "" {
+		return nil
 	}
 
-	return result
+	handle(Event{Type: EventTextDelta, Text: "\n" + TruncationMarker + "\n"})
+	return c.updateHistory(strings.TrimRight(text, "\n") + "\n\n" + TruncationMarker)
 }
 
 func (c *Client) detectAudioFormat(path string) (string, error) {
@@ -1037,7 +1127,12 @@ func (c *Client) printRequestDebugInfo(endpoint string, body []byte, headers map
 			sugar.Debugf("  --header '%s: %s' \\", k, v)
 		}
 	} else {
-		sugar.Debugf("  --header \"%s: %s${%s_API_KEY}\" \\", c.Config.AuthHeader, c.Config.AuthTokenPrefix, strings.ToUpper(c.Config.Name))
+		if c.Config.MessagesPath != "" && strings.Contains(endpoint, c.Config.MessagesPath) {
+			sugar.Debugf("  --header \"%s: ${%s_API_KEY}\" \\", internal.HeaderAPIKeyKey, strings.ToUpper(c.Config.Name))
+			sugar.Debugf("  --header '%s: %s' \\", internal.HeaderAnthropicVersionKey, c.Config.AnthropicVersion)
+		} else {
+			sugar.Debugf("  --header \"%s: %s${%s_API_KEY}\" \\", c.Config.AuthHeader, c.Config.AuthTokenPrefix, strings.ToUpper(c.Config.Name))
+		}
 		sugar.Debugf("  --header '%s: %s' \\", internal.HeaderContentTypeKey, internal.HeaderContentTypeValue)
 		sugar.Debugf("  --header '%s: %s' \\", internal.HeaderUserAgentKey, c.Config.UserAgent)
 
@@ -1059,7 +1154,7 @@ func (c *Client) printResponseDebugInfo(raw []byte) {
 	sugar.Debugf("%s\n", raw)
 }
 
-func (c *Client) postAndWriteBinaryOutput(endpoint string, requestBody interface{}, outputPath, debugLabel string, transform func([]byte) ([]byte, error)) error {
+func (c *Client) postAndWriteBinaryOutput(ctx context.Context, endpoint string, requestBody interface{}, outputPath, debugLabel string, transform func([]byte) ([]byte, error)) error {
 	body, err := json.Marshal(requestBody)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
@@ -1067,7 +1162,7 @@ func (c *Client) postAndWriteBinaryOutput(endpoint string, requestBody interface
 
 	c.printRequestDebugInfo(endpoint, body, nil)
 
-	respBytes, err := c.caller.Post(endpoint, body, false)
+	respBytes, err := c.caller.Post(ctx, endpoint, body)
 	if err != nil {
 		return fmt.Errorf("API request failed: %w", err)
 	}
@@ -1122,22 +1217,6 @@ func (c *Client) buildMCPRequest(mcp api.MCPRequest) (string, map[string]string,
 	return endpoint, headers, body, nil
 }
 
-type ModelCapabilities struct {
-	SupportsTemperature bool
-	SupportsStreaming   bool
-	UsesResponsesAPI    bool
-	OmitFirstSystemMsg  bool
-}
-
-func GetCapabilities(model string) ModelCapabilities {
-	return ModelCapabilities{
-		SupportsTemperature: !strings.Contains(model, SearchModelPattern),
-		SupportsStreaming:   !strings.Contains(model, o1ProPattern),
-		UsesResponsesAPI:    strings.Contains(model, o1ProPattern) || strings.Contains(model, gpt5Pattern),
-		OmitFirstSystemMsg:  strings.HasPrefix(model, o1Prefix) && !strings.Contains(model, o1ProPattern),
-	}
-}
-
 func formatMCPResponse(raw []byte, function string) string {
 	var result interface{}
 	if err := json.Unmarshal(raw, &result); err != nil {
@@ -1176,28 +1255,32 @@ func formatKeyValues(obj map[string]interface{}) []string {
 	return lines
 }
 
+// contextWindow returns the context window declared for the model in the models
+// section, falling back to the context_window setting.
+func (c *Client) contextWindow() int {
+	if window := c.Capabilities().ContextWindow; window > 0 {
+		return window
+	}
+	return c.Config.ContextWindow
+}
+
 func calculateEffectiveContextWindow(window int, bufferPercentage int) int {
 	adjustedPercentage := 100 - bufferPercentage
 	effectiveContextWindow := (window * adjustedPercentage) / 100
 	return effectiveContextWindow
 }
 
-func countTokens(entries []history.History) (int, []int) {
+// countTokens returns the token count of the entries and of every single entry.
+// Each message costs tokensPerMessage on top of its content for the role and the
+// delimiters of the chat format. Images and audio are estimated.
+func (c *Client) countTokens(entries []history.History) (int, []int) {
 	var result int
 	var rolling []int
 
-	for _, entry := range entries {
-		charCount, wordCount := 0, 0
-		words := strings.Fields(entry.Content.(string))
-		wordCount += len(words)
-
-		for _, word := range words {
-			charCount += utf8.RuneCountInString(word)
-		}
+	t := c.getTokenizer()
 
-		// This is a simple approximation; actual token count may differ.
-		// You can adjust this based on your language and the specific tokenizer used by the model.
-		tokenCountForMessage := (charCount + wordCount) / 2
+	for _, entry := range entries {
+		tokenCountForMessage := countContent(t, entry.Content) + tokensPerMessage
 		result += tokenCountForMessage
 		rolling = append(rolling, tokenCountForMessage)
 	}
@@ -1205,6 +1288,14 @@ func countTokens(entries []history.History) (int, []int) {
 	return result, rolling
 }
 
+// getTokenizer returns the pinned tokenizer or the one of the encoding of the model.
+func (c *Client) getTokenizer() tokenizer.Tokenizer {
+	if c.tokenizer != nil {
+		return c.tokenizer
+	}
+	return tokenizer.Get(c.Capabilities().Encoding)
+}
+
 func getExtension(path string) string {
 	ext := filepath.Ext(path) // e.g. ".mp4"
 	if ext != "" {
@@ -1235,3 +1326,18 @@ func isValidURL(input string) bool {
 
 	return false
 }
+
+// streamReader remembers why a stream ended early. The providers stop decoding at
+// the first read error, which would otherwise be indistinguishable from the end.
+type streamReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	n, err := s.reader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.err = err
+	}
+	return n, err
+}
@@ -3,7 +3,9 @@ package client_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,15 +14,20 @@ import (
 	"github.com/kardolus/chatgpt-cli/api"
 	"github.com/kardolus/chatgpt-cli/api/client"
 	"github.com/kardolus/chatgpt-cli/api/http"
+	"github.com/kardolus/chatgpt-cli/api/mcp"
 	"github.com/kardolus/chatgpt-cli/cmd/chatgpt/utils"
 	config2 "github.com/kardolus/chatgpt-cli/config"
 	"github.com/kardolus/chatgpt-cli/history"
 	"github.com/kardolus/chatgpt-cli/internal"
 	"github.com/kardolus/chatgpt-cli/test"
+	"github.com/kardolus/chatgpt-cli/tokenizer"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	. "github.com/onsi/gomega"
@@ -33,6 +40,8 @@ import (
 //go:generate mockgen -destination=timermocks_test.go -package=client_test github.com/kardolus/chatgpt-cli/api/client Timer
 //go:generate mockgen -destination=readermocks_test.go -package=client_test github.com/kardolus/chatgpt-cli/api/client FileReader
 //go:generate mockgen -destination=writermocks_test.go -package=client_test github.com/kardolus/chatgpt-cli/api/client FileWriter
+//go:generate mockgen -destination=toolrunnermocks_test.go -package=client_test github.com/kardolus/chatgpt-cli/api/client ToolRunner
+//go:generate mockgen -destination=mcpmocks_test.go -package=client_test -mock_names=Client=MockMCPClient github.com/kardolus/chatgpt-cli/api/mcp Client
 
 const (
 	envApiKey       = "api-key"
@@ -47,6 +56,7 @@ var (
 	mockTimer        *MockTimer
 	mockReader       *MockFileReader
 	mockWriter       *MockFileWriter
+	mockToolRunner   *MockToolRunner
 	factory          *clientFactory
 	apiKeyEnvVar     string
 	config           config2.Config
@@ -67,6 +77,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 		mockTimer = NewMockTimer(mockCtrl)
 		mockReader = NewMockFileReader(mockCtrl)
 		mockWriter = NewMockFileWriter(mockCtrl)
+		mockToolRunner = NewMockToolRunner(mockCtrl)
 		config = MockConfig()
 
 		factory = newClientFactory(mockHistoryStore)
@@ -207,7 +218,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 				respBytes, err := tt.setupPostReturn()
 				Expect(err).NotTo(HaveOccurred())
-				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, body, false).Return(respBytes, tt.postError)
+				mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, body).Return(respBytes, tt.postError)
 
 				mockTimer.EXPECT().Now().Return(time.Time{}).Times(2)
 
@@ -217,6 +228,59 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			})
 		}
 
+		it("reports a thread that cannot be read instead of starting it over", func() {
+			mockHistoryStore.EXPECT().Read().Return(nil, history.ErrKey).Times(1)
+			mockHistoryStore.EXPECT().GetThread().Return(config.Thread).AnyTimes()
+			subject := factory.buildClientWithoutConfig()
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).To(MatchError(history.ErrKey))
+			Expect(err.Error()).To(ContainSubstring("failed to read thread " + config.Thread))
+		})
+
+		it("does not replace a corrupt thread without a usable backup", func() {
+			dir := t.TempDir()
+			path := filepath.Join(dir, config.Thread+".json")
+			Expect(os.WriteFile(path, []byte(`[{"role":"user","cont`), 0644)).To(Succeed())
+
+			store := (&history.FileIO{}).WithDirectory(dir)
+			subject := client.New(mockCallerFactory, store, mockTimer, mockReader, mockWriter, MockConfig(), commandLineMode)
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).To(MatchError(ContainSubstring("failed to read thread " + config.Thread)))
+
+			data, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`[{"role":"user","cont`))
+		})
+
+		it("starts a thread that does not exist yet", func() {
+			mockHistoryStore.EXPECT().Read().Return(nil, os.ErrNotExist).Times(1)
+			mockHistoryStore.EXPECT().Write(gomock.Any()).Times(1)
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Return([]byte(`{"id":"id","choices":[{"message":{"role":"assistant","content":"answer"}}]}`), nil)
+
+			result, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("answer"))
+		})
+
+		it("returns the answer with an error when it cannot be stored", func() {
+			factory.withoutHistory()
+			mockHistoryStore.EXPECT().Write(gomock.Any()).Return(errors.New("disk full")).Times(1)
+			mockHistoryStore.EXPECT().GetThread().Return(config.Thread).AnyTimes()
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Return([]byte(`{"id":"id","choices":[{"message":{"role":"assistant","content":"answer"}}]}`), nil)
+
+			result, _, err := subject.Query(context.Background(), query)
+			Expect(err).To(MatchError(ContainSubstring("the answer was not stored in thread " + config.Thread + ": disk full")))
+			Expect(result).To(Equal("answer"))
+		})
+
 		when("a valid http response is received", func() {
 			testValidHTTPResponse := func(subject *client.Client, expectedBody []byte, omitHistory bool) {
 				const (
@@ -247,7 +311,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 				respBytes, err := json.Marshal(response)
 				Expect(err).NotTo(HaveOccurred())
-				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, expectedBody, false).Return(respBytes, nil)
+				mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, expectedBody).Return(respBytes, nil)
 
 				var request api.CompletionsRequest
 				err = json.Unmarshal(expectedBody, &request)
@@ -268,6 +332,13 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 							Role:    client.AssistantRole,
 							Content: answer,
 						},
+						Model:      subject.Config.Model,
+						ResponseID: "id",
+						Usage: &history.Usage{
+							PromptTokens:     123,
+							CompletionTokens: 456,
+							TotalTokens:      tokens,
+						},
 					}))
 				}
 
@@ -385,14 +456,283 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				factory.withHistory(hs)
 				subject := factory.buildClientWithoutConfig()
 
-				// messages get truncated. Index 1+2 are cut out
-				messages = append(messages[:1], messages[3:]...)
+				// messages get truncated. Index 1 to 3 are cut out
+				messages = append(messages[:1], messages[4:]...)
+
+				body, err = createBody(messages, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				testValidHTTPResponse(subject, body, false)
+			})
+			it("keeps pinned messages when truncating", func() {
+				hs := []history.History{{Message: api.Message{Role: client.SystemRole, Content: config.Role}}}
+				for _, content := range []string{"question 1", "answer 1", "question 2", "answer 2", "question 3", "answer 3"} {
+					role := client.UserRole
+					if strings.HasPrefix(content, "answer") {
+						role = client.AssistantRole
+					}
+					hs = append(hs, history.History{Message: api.Message{Role: role, Content: content}})
+				}
+				hs[1].Pinned = true
+
+				factory.withHistory(hs)
+				subject := factory.buildClientWithoutConfig()
+
+				mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var request api.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+
+					var contents []interface{}
+					for _, message := range request.Messages {
+						contents = append(contents, message.Content)
+					}
+					// the pinned question stays, so the unpinned messages up to index 5 are cut out instead of 1 to 3
+					Expect(contents).To(Equal([]interface{}{config.Role, "question 1", "answer 3", query}))
+
+					return json.Marshal(api.CompletionsResponse{
+						Choices: []api.Choice{{Message: api.Message{Role: client.AssistantRole, Content: "answer 4"}}},
+					})
+				})
+				mockHistoryStore.EXPECT().Write(gomock.Any())
+
+				_, _, err := subject.Query(context.Background(), query)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(subject.History[1].Pinned).To(BeTrue())
+			})
+			it("fails when the pinned messages leave no room for the rest of the thread", func() {
+				hs := []history.History{{Message: api.Message{Role: client.SystemRole, Content: config.Role}}}
+				for _, content := range []string{"question 1", "answer 1", "question 2", "answer 2", "question 3", "answer 3"} {
+					role := client.UserRole
+					if strings.HasPrefix(content, "answer") {
+						role = client.AssistantRole
+					}
+					hs = append(hs, history.History{Message: api.Message{Role: role, Content: content}, Pinned: true})
+				}
+
+				factory.withHistory(hs)
+				subject := factory.buildClientWithoutConfig()
+
+				mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				mockHistoryStore.EXPECT().Write(gomock.Any()).Times(0)
+
+				_, _, err := subject.Query(context.Background(), query)
+				Expect(err).To(MatchError(MatchRegexp(`^the pinned messages take \d+ tokens and the sticky files 0 tokens, which leaves no room for the rest of the thread in a context window of 50 tokens`)))
+				Expect(subject.History).To(HaveLen(len(hs)))
+			})
+			it("uses the context window declared for the model in the models section", func() {
+				var hs []history.History
+				for _, content := range []string{"question 1", "answer 1", "question 2", "answer 2", "question 3", "answer 3"} {
+					role := client.UserRole
+					if strings.HasPrefix(content, "answer") {
+						role = client.AssistantRole
+					}
+					hs = append(hs, history.History{Message: api.Message{Role: role, Content: content}})
+				}
+				hs = append([]history.History{{Message: api.Message{Role: client.SystemRole, Content: config.Role}}}, hs...)
+
+				messages = createMessages(hs, query)
+
+				factory.withHistory(hs)
+				subject := factory.buildClientWithoutConfig()
+
+				window := 1000
+				subject.Config.Models = map[string]config2.ModelSpec{
+					subject.Config.Model: {ContextWindow: &window},
+				}
 
+				// nothing is truncated
 				body, err = createBody(messages, false)
 				Expect(err).NotTo(HaveOccurred())
 
 				testValidHTTPResponse(subject, body, false)
 			})
+			when("the context strategy is summarize", func() {
+				var hs []history.History
+
+				it.Before(func() {
+					hs = []history.History{{Message: api.Message{Role: client.SystemRole, Content: config.Role}}}
+					for _, content := range []string{"question 1", "answer 1", "question 2", "answer 2", "question 3", "answer 3"} {
+						role := client.UserRole
+						if strings.HasPrefix(content, "answer") {
+							role = client.AssistantRole
+						}
+						hs = append(hs, history.History{Message: api.Message{Role: role, Content: content}})
+					}
+				})
+
+				it("replaces the oldest messages with a summary written by the model", func() {
+					factory.withHistory(hs)
+					subject := factory.buildClientWithoutConfig()
+					subject.Config.ContextStrategy = client.ContextStrategySummarize
+
+					mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+					summaryResponse, _ := json.Marshal(api.CompletionsResponse{
+						Choices: []api.Choice{{Message: api.Message{Role: client.AssistantRole, Content: "The user asked three questions."}}},
+					})
+					answerResponse, _ := json.Marshal(api.CompletionsResponse{
+						Choices: []api.Choice{{Message: api.Message{Role: client.AssistantRole, Content: "answer 4"}}},
+					})
+
+					summary := history.History{
+						Message: api.Message{
+							Role:    client.SystemRole,
+							Content: "Summary of the earlier conversation:\nThe user asked three questions.",
+						},
+						Summary: true,
+					}
+
+					endpoint := subject.Config.URL + subject.Config.CompletionsPath
+
+					gomock.InOrder(
+						mockCaller.EXPECT().Post(gomock.Any(), endpoint, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+							var req api.CompletionsRequest
+							Expect(json.Unmarshal(body, &req)).To(Succeed())
+							Expect(req.MaxTokens).To(Equal(10))
+							Expect(req.Messages).To(HaveLen(1))
+							Expect(req.Messages[0].Role).To(Equal(client.UserRole))
+							Expect(req.Messages[0].Content).To(ContainSubstring("USER: question 1\n\nASSISTANT: answer 1"))
+							Expect(req.Messages[0].Content).To(ContainSubstring("USER: question 3"))
+							Expect(req.Messages[0].Content).NotTo(ContainSubstring("answer 3"))
+
+							return summaryResponse, nil
+						}),
+						mockHistoryStore.EXPECT().Write(gomock.Any()).DoAndReturn(func(h []history.History) error {
+							Expect(h).To(Equal([]history.History{hs[0], summary, hs[6], {Message: api.Message{Role: client.UserRole, Content: query}}}))
+							return nil
+						}),
+						mockCaller.EXPECT().Post(gomock.Any(), endpoint, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+							var req api.CompletionsRequest
+							Expect(json.Unmarshal(body, &req)).To(Succeed())
+							Expect(req.Messages).To(HaveLen(4))
+							Expect(req.Messages[1]).To(Equal(summary.Message))
+
+							return answerResponse, nil
+						}),
+						mockHistoryStore.EXPECT().Write(gomock.Any()).DoAndReturn(func(h []history.History) error {
+							Expect(h).To(HaveLen(5))
+							Expect(h[1].Summary).To(BeTrue())
+							return nil
+						}),
+					)
+
+					result, _, err := subject.Query(context.Background(), query)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(result).To(Equal("answer 4"))
+				})
+				it("drops the oldest messages when the summary cannot be written", func() {
+					factory.withHistory(hs)
+					subject := factory.buildClientWithoutConfig()
+					subject.Config.ContextStrategy = client.ContextStrategySummarize
+
+					mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+					answerResponse, _ := json.Marshal(api.CompletionsResponse{
+						Choices: []api.Choice{{Message: api.Message{Role: client.AssistantRole, Content: "answer 4"}}},
+					})
+
+					gomock.InOrder(
+						mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("rate limited")),
+						mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+							var req api.CompletionsRequest
+							Expect(json.Unmarshal(body, &req)).To(Succeed())
+							// index 1 to 3 are cut out, like with the truncate strategy
+							Expect(req.Messages).To(HaveLen(5))
+							Expect(req.Messages[1].Content).To(Equal("answer 2"))
+
+							return answerResponse, nil
+						}),
+					)
+					mockHistoryStore.EXPECT().Write(gomock.Any())
+
+					_, _, err := subject.Query(context.Background(), query)
+					Expect(err).NotTo(HaveOccurred())
+				})
+				it("sends a summary as a user message to models without a system role", func() {
+					summary := history.History{
+						Message: api.Message{Role: client.SystemRole, Content: "Summary of the earlier conversation:\nThe user asked."},
+						Summary: true,
+					}
+					factory.withHistory([]history.History{hs[0], summary, hs[6]})
+					subject := factory.buildClientWithoutConfig()
+					subject.Config.ContextWindow = 4096
+					subject.Config.ContextStrategy = client.ContextStrategySummarize
+
+					omit := true
+					subject.Config.Models = map[string]config2.ModelSpec{
+						subject.Config.Model: {OmitSystemRole: &omit},
+					}
+
+					mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+					answerResponse, _ := json.Marshal(api.CompletionsResponse{
+						Choices: []api.Choice{{Message: api.Message{Role: client.AssistantRole, Content: "answer 4"}}},
+					})
+
+					mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+						var req api.CompletionsRequest
+						Expect(json.Unmarshal(body, &req)).To(Succeed())
+						Expect(req.Messages).To(HaveLen(3))
+						Expect(req.Messages[0]).To(Equal(api.Message{Role: client.UserRole, Content: summary.Content}))
+						for _, message := range req.Messages {
+							Expect(message.Role).NotTo(Equal(client.SystemRole))
+						}
+
+						return answerResponse, nil
+					})
+					mockHistoryStore.EXPECT().Write(gomock.Any()).DoAndReturn(func(h []history.History) error {
+						// the summary is stored as it was
+						Expect(h[1]).To(Equal(summary))
+						return nil
+					})
+
+					_, _, err := subject.Query(context.Background(), query)
+					Expect(err).NotTo(HaveOccurred())
+				})
+			})
+			it("keeps the role of an existing thread when the configured role changes", func() {
+				factory.withHistory([]history.History{
+					{Message: api.Message{Role: client.SystemRole, Content: "You are a pirate."}},
+					{Message: api.Message{Role: client.UserRole, Content: "Second message"}},
+				})
+
+				subject := factory.buildClientWithoutConfig()
+				subject.Config.Role = "You are a lawyer."
+
+				expectedBody, err := createBody([]api.Message{
+					{Role: client.SystemRole, Content: "You are a pirate."},
+					{Role: client.UserRole, Content: "Second message"},
+					{Role: client.UserRole, Content: "test query"},
+				}, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				mockTimer.EXPECT().Now().Return(time.Now()).Times(1)
+				mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, expectedBody).Return(nil, nil)
+
+				_, _, _ = subject.Query(context.Background(), "test query")
+			})
+			it("adds the configured role to a thread without one", func() {
+				factory.withHistory([]history.History{
+					{Message: api.Message{Role: client.UserRole, Content: "imported message"}},
+				})
+
+				subject := factory.buildClientWithoutConfig()
+				subject.Config.Role = "You are a lawyer."
+
+				expectedBody, err := createBody([]api.Message{
+					{Role: client.SystemRole, Content: "You are a lawyer."},
+					{Role: client.UserRole, Content: "imported message"},
+					{Role: client.UserRole, Content: "test query"},
+				}, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				mockTimer.EXPECT().Now().Return(time.Now()).Times(2)
+				mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, expectedBody).Return(nil, nil)
+
+				_, _, _ = subject.Query(context.Background(), "test query")
+			})
 			it("should skip the first message when the model starts with o1Prefix", func() {
 				factory.withHistory([]history.History{
 					{Message: api.Message{Role: client.SystemRole, Content: "First message"}},
@@ -412,7 +752,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				Expect(err).NotTo(HaveOccurred())
 
 				mockTimer.EXPECT().Now().Return(time.Now()).AnyTimes()
-				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, expectedBody, false).Return(nil, nil)
+				mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, expectedBody).Return(nil, nil)
 
 				_, _, _ = subject.Query(context.Background(), "test query")
 			})
@@ -439,7 +779,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				Expect(err).NotTo(HaveOccurred())
 
 				mockTimer.EXPECT().Now().Return(time.Now()).AnyTimes()
-				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, expectedBody, false).Return(nil, nil)
+				mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, expectedBody).Return(nil, nil)
 
 				_, _, _ = subject.Query(context.Background(), "test query")
 			})
@@ -458,8 +798,8 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				mockTimer.EXPECT().Now().Return(time.Now()).AnyTimes()
 
 				mockCaller.EXPECT().
-					Post(gomock.Any(), gomock.Any(), false).
-					DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					Post(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
 						var req map[string]interface{}
 						Expect(json.Unmarshal(body, &req)).To(Succeed())
 
@@ -487,8 +827,8 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				mockTimer.EXPECT().Now().Return(time.Now()).AnyTimes()
 
 				mockCaller.EXPECT().
-					Post(gomock.Any(), gomock.Any(), false).
-					DoAndReturn(func(_ string, body []byte, _ bool) ([]byte, error) {
+					Post(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
 						var req map[string]interface{}
 						Expect(json.Unmarshal(body, &req)).To(Succeed())
 
@@ -519,26 +859,22 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				subject := factory.buildClientWithoutConfig()
 
 				subject.Config.Role = systemRole
+				subject.Config.ContextWindow = 4096 // leaves room for the estimated tokens of the attachment
 
 				ctx := context.Background()
 				ctx = context.WithValue(ctx, internal.ImagePathKey, website)
 
 				expectedBody, err := createBody([]api.Message{
 					{Role: client.SystemRole, Content: systemRole},
-					{Role: client.UserRole, Content: query},
-					{Role: client.UserRole, Content: []api.ImageContent{{
-						Type: "image_url",
-						ImageURL: struct {
-							URL string `json:"url"`
-						}{
-							URL: website,
-						},
-					}}},
+					{Role: client.UserRole, Content: []api.ContentPart{
+						{Type: "text", Text: query},
+						{Type: "image_url", ImageURL: &api.ImageURL{URL: website}},
+					}},
 				}, false)
 				Expect(err).NotTo(HaveOccurred())
 
 				mockTimer.EXPECT().Now().Return(time.Now()).Times(2)
-				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, expectedBody, false).Return(nil, nil)
+				mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, expectedBody).Return(nil, nil)
 
 				_, _, _ = subject.Query(ctx, query)
 			})
@@ -596,6 +932,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 				subject := factory.buildClientWithoutConfig()
 				subject.Config.Role = systemRole
+				subject.Config.ContextWindow = 4096 // leaves room for the estimated tokens of the attachment
 
 				ctx := context.Background()
 				ctx = context.WithValue(ctx, internal.ImagePathKey, image)
@@ -606,20 +943,15 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 				expectedBody, err := createBody([]api.Message{
 					{Role: client.SystemRole, Content: systemRole},
-					{Role: client.UserRole, Content: query},
-					{Role: client.UserRole, Content: []api.ImageContent{{
-						Type: "image_url",
-						ImageURL: struct {
-							URL string `json:"url"`
-						}{
-							URL: "data:text/plain; charset=utf-8;base64,",
-						},
-					}}},
+					{Role: client.UserRole, Content: []api.ContentPart{
+						{Type: "text", Text: query},
+						{Type: "image_url", ImageURL: &api.ImageURL{URL: "data:text/plain; charset=utf-8;base64,"}},
+					}},
 				}, false)
 				Expect(err).NotTo(HaveOccurred())
 
 				mockTimer.EXPECT().Now().Return(time.Now()).Times(2)
-				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, expectedBody, false).Return(nil, nil)
+				mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, expectedBody).Return(nil, nil)
 
 				_, _, _ = subject.Query(ctx, query)
 			})
@@ -674,6 +1006,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				audioFile := &os.File{}
 				subject := factory.buildClientWithoutConfig()
 				subject.Config.Role = systemRole
+				subject.Config.ContextWindow = 4096 // leaves room for the estimated tokens of the attachment
 
 				ctx := context.Background()
 				ctx = context.WithValue(ctx, internal.AudioPathKey, audio)
@@ -684,27 +1017,134 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 				expectedBody, err := createBody([]api.Message{
 					{Role: client.SystemRole, Content: systemRole},
-					{Role: client.UserRole, Content: query},
-					{Role: client.UserRole, Content: []api.AudioContent{{
-						Type: "input_audio",
-						InputAudio: struct {
-							Data   string `json:"data"`
-							Format string `json:"format"`
-						}{
+					{Role: client.UserRole, Content: []api.ContentPart{
+						{Type: "text", Text: query},
+						{Type: "input_audio", InputAudio: &api.InputAudio{
 							Data:   "YXVkaW8tYnl0ZXM=", // base64 of "audio-bytes"
 							Format: "wav",
-						},
-					}}},
+						}},
+					}},
 				}, false)
 				Expect(err).NotTo(HaveOccurred())
 
 				mockTimer.EXPECT().Now().Return(time.Now()).Times(2)
-				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, expectedBody, false).Return(nil, nil)
+				mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, expectedBody).Return(nil, nil)
 
 				_, _, _ = subject.Query(ctx, query)
 			})
 		})
 
+		when("the client stores attachments as blobs", func() {
+			const (
+				query    = "what is this?"
+				followUp = "what color is it?"
+			)
+
+			var (
+				subject *client.Client
+				blobs   *history.Blobs
+				png     = []byte("\x89PNG\r\n\x1a\n")
+			)
+
+			reply := func(text string) []byte {
+				raw, _ := json.Marshal(api.CompletionsResponse{
+					Choices: []api.Choice{{Message: api.Message{Role: client.AssistantRole, Content: text}}},
+				})
+				return raw
+			}
+
+			sentImages := func(body []byte) []string {
+				var req struct {
+					Messages []struct {
+						Content []map[string]interface{} `json:"content"`
+					} `json:"messages"`
+				}
+				_ = json.Unmarshal(body, &req)
+
+				var result []string
+				for _, message := range req.Messages {
+					for _, part := range message.Content {
+						Expect(part).NotTo(HaveKey("blob"))
+						if url, ok := part["image_url"].(map[string]interface{}); ok {
+							result = append(result, url["url"].(string))
+						}
+					}
+				}
+				return result
+			}
+
+			it.Before(func() {
+				factory.withoutHistory()
+				blobs = (&history.Blobs{}).WithDirectory(t.TempDir())
+				subject = factory.buildClientWithoutConfig().WithBlobs(blobs)
+				subject.Config.ContextWindow = 4096
+
+				mockTimer.EXPECT().Now().Return(time.Now()).AnyTimes()
+			})
+
+			it("keeps the image in the thread by hash and sends it with follow-up questions", func() {
+				sum := sha256.Sum256(png)
+				hash := hex.EncodeToString(sum[:])
+				dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+
+				var stored []history.History
+
+				gomock.InOrder(
+					mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+						Expect(sentImages(body)).To(Equal([]string{dataURL}))
+						return reply("a cat"), nil
+					}),
+					mockHistoryStore.EXPECT().Write(gomock.Any()).DoAndReturn(func(h []history.History) error {
+						stored = h
+						return nil
+					}),
+					mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+						Expect(sentImages(body)).To(Equal([]string{dataURL}))
+						return reply("orange"), nil
+					}),
+					mockHistoryStore.EXPECT().Write(gomock.Any()),
+				)
+
+				ctx := context.WithValue(context.Background(), internal.BinaryDataKey, png)
+				_, _, err := subject.Query(ctx, query)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(stored[1].Content).To(Equal([]api.ContentPart{
+					{Type: "text", Text: query},
+					{Type: "image_url", Blob: hash, MediaType: "image/png"},
+				}))
+
+				data, err := blobs.Get(hash)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(data).To(Equal(png))
+
+				// the image is attached once, even though ctx still carries it
+				_, _, err = subject.Query(ctx, followUp)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(subject.History[3].Content).To(Equal(followUp))
+			})
+
+			it("keeps the attachments of a query that is retried", func() {
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Return(reply("a cat"), nil).Times(2)
+				mockHistoryStore.EXPECT().Write(gomock.Any()).Times(2)
+
+				ctx := context.WithValue(context.Background(), internal.BinaryDataKey, png)
+				_, _, err := subject.Query(ctx, query)
+				Expect(err).NotTo(HaveOccurred())
+
+				last, err := subject.RemoveLastExchange()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(last).To(Equal(query))
+
+				_, _, err = subject.Query(context.Background(), last)
+				Expect(err).NotTo(HaveOccurred())
+
+				parts := subject.History[1].Content.([]api.ContentPart)
+				Expect(parts).To(HaveLen(2))
+				Expect(parts[1].Type).To(Equal("image_url"))
+			})
+		})
+
 		when("the model is o1-pro or gpt-5", func() {
 			models := []string{"o1-pro", "gpt-5"}
 
@@ -736,7 +1176,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 						body, err := json.Marshal(api.ResponsesRequest{
 							Model:           subject.Config.Model,
-							Input:           messages,
+							Input:           toInput(messages),
 							MaxOutputTokens: subject.Config.MaxTokens,
 							Reasoning:       api.Reasoning{Effort: "low"},
 							Stream:          false,
@@ -758,7 +1198,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 						raw, _ := json.Marshal(response)
 
 						mockCaller.EXPECT().
-							Post(subject.Config.URL+"/v1/responses", body, false).
+							Post(gomock.Any(), subject.Config.URL+"/v1/responses", body).
 							Return(raw, nil)
 
 						text, tokens, err := subject.Query(context.Background(), query)
@@ -779,7 +1219,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 						body, _ := json.Marshal(api.ResponsesRequest{
 							Model:           subject.Config.Model,
-							Input:           messages,
+							Input:           toInput(messages),
 							MaxOutputTokens: subject.Config.MaxTokens,
 							Reasoning:       api.Reasoning{Effort: "low"},
 							Stream:          false,
@@ -796,7 +1236,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 						raw, _ := json.Marshal(response)
 
 						mockCaller.EXPECT().
-							Post(subject.Config.URL+"/v1/responses", body, false).
+							Post(gomock.Any(), subject.Config.URL+"/v1/responses", body).
 							Return(raw, nil)
 
 						_, _, err := subject.Query(context.Background(), query)
@@ -816,7 +1256,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 						body, _ := json.Marshal(api.ResponsesRequest{
 							Model:           subject.Config.Model,
-							Input:           messages,
+							Input:           toInput(messages),
 							MaxOutputTokens: subject.Config.MaxTokens,
 							Reasoning:       api.Reasoning{Effort: "low"},
 							Stream:          false,
@@ -836,7 +1276,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 						raw, _ := json.Marshal(response)
 
 						mockCaller.EXPECT().
-							Post(subject.Config.URL+"/v1/responses", body, false).
+							Post(gomock.Any(), subject.Config.URL+"/v1/responses", body).
 							Return(raw, nil)
 
 						_, _, err := subject.Query(context.Background(), query)
@@ -846,6 +1286,145 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				})
 			}
 		})
+
+		when("the model is a claude model", func() {
+			const (
+				claudeModel = "claude-sonnet-4-5"
+				query       = "what's the weather"
+				systemRole  = "you are helpful"
+			)
+
+			it.Before(func() {
+				config.Model = claudeModel
+				config.Role = systemRole
+				factory.withoutHistory()
+			})
+
+			it("sends the system prompt as a top-level field and returns the text blocks", func() {
+				subject := factory.buildClientWithoutConfig()
+				subject.Config.Model = claudeModel
+				subject.Config.Role = systemRole
+
+				body, err := json.Marshal(api.MessagesRequest{
+					Model:       claudeModel,
+					System:      systemRole,
+					Messages:    []api.Message{{Role: client.UserRole, Content: query}},
+					MaxTokens:   subject.Config.MaxTokens,
+					Stream:      false,
+					Temperature: subject.Config.Temperature,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				mockTimer.EXPECT().Now().Times(3)
+				mockHistoryStore.EXPECT().Write(gomock.Any())
+
+				response := api.MessagesResponse{
+					Content: []api.MessagesContent{
+						{Type: "text", Text: "yes, "},
+						{Type: "text", Text: "it does"},
+					},
+					Usage: api.MessagesUsage{InputTokens: 30, OutputTokens: 12},
+				}
+				raw, _ := json.Marshal(response)
+
+				mockCaller.EXPECT().
+					Post(gomock.Any(), subject.Config.URL+subject.Config.MessagesPath, body).
+					Return(raw, nil)
+
+				text, tokens, err := subject.Query(context.Background(), query)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(text).To(Equal("yes, it does"))
+				Expect(tokens).To(Equal(42))
+			})
+
+			it("errors when no text blocks are present", func() {
+				subject := factory.buildClientWithoutConfig()
+				subject.Config.Model = claudeModel
+
+				mockTimer.EXPECT().Now().Times(2)
+
+				raw, _ := json.Marshal(api.MessagesResponse{Content: []api.MessagesContent{}})
+
+				mockCaller.EXPECT().
+					Post(gomock.Any(), subject.Config.URL+subject.Config.MessagesPath, gomock.Any()).
+					Return(raw, nil)
+
+				_, _, err := subject.Query(context.Background(), query)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(Equal("no response returned"))
+			})
+
+			it("converts images to base64 image blocks", func() {
+				subject := factory.buildClientWithoutConfig()
+				subject.Config.Model = claudeModel
+				subject.Config.ContextWindow = 4096 // leaves room for the estimated tokens of the attachment
+
+				ctx := context.WithValue(context.Background(), internal.BinaryDataKey, []byte("\x89PNG\r\n\x1a\n"))
+
+				mockTimer.EXPECT().Now().Times(2)
+
+				mockCaller.EXPECT().
+					Post(gomock.Any(), subject.Config.URL+subject.Config.MessagesPath, gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+						var req map[string]interface{}
+						Expect(json.Unmarshal(body, &req)).To(Succeed())
+
+						messages := req["messages"].([]interface{})
+						Expect(messages).To(HaveLen(1))
+
+						image := messages[0].(map[string]interface{})
+						Expect(image["role"]).To(Equal(client.UserRole))
+
+						blocks := image["content"].([]interface{})
+						Expect(blocks).To(HaveLen(2))
+						Expect(blocks[0]).To(Equal(map[string]interface{}{"type": "text", "text": query}))
+
+						block := blocks[1].(map[string]interface{})
+						Expect(block["type"]).To(Equal("image"))
+						Expect(block["source"]).To(Equal(map[string]interface{}{
+							"type":       "base64",
+							"media_type": "image/png",
+							"data":       base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n")),
+						}))
+
+						return nil, nil
+					})
+
+				_, _, _ = subject.Query(ctx, query)
+			})
+
+			it("rejects audio input", func() {
+				audioFile := &os.File{}
+				subject := factory.buildClientWithoutConfig()
+				subject.Config.Model = claudeModel
+				subject.Config.ContextWindow = 4096 // leaves room for the estimated tokens of the attachment
+
+				ctx := context.WithValue(context.Background(), internal.AudioPathKey, "audio.wav")
+
+				mockTimer.EXPECT().Now().Times(2)
+				mockReader.EXPECT().Open("audio.wav").Return(audioFile, nil)
+				mockReader.EXPECT().ReadBufferFromFile(audioFile).Return([]byte("RIFFxxxxWAVE..."), nil)
+				mockReader.EXPECT().ReadFile("audio.wav").Return([]byte("audio-bytes"), nil)
+
+				_, _, err := subject.Query(ctx, query)
+				Expect(err).To(MatchError(ContainSubstring("audio input is not supported")))
+			})
+
+			it("uses the configured provider instead of the inferred one", func() {
+				subject := factory.buildClientWithoutConfig()
+				subject.Config.Model = claudeModel
+				subject.Config.Provider = client.CompletionsProvider
+
+				mockTimer.EXPECT().Now().Times(2)
+
+				mockCaller.EXPECT().
+					Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, gomock.Any()).
+					Return(nil, errors.New("error message"))
+
+				_, _, err := subject.Query(context.Background(), query)
+				Expect(err).To(MatchError("error message"))
+			})
+		})
 	})
 	when("Stream()", func() {
 		var (
@@ -863,7 +1442,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			Expect(err).NotTo(HaveOccurred())
 
 			errorMsg := "error message"
-			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, body, true).Return(nil, errors.New(errorMsg))
+			mockCaller.EXPECT().PostStream(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, body).Return(nil, errors.New(errorMsg))
 
 			mockTimer.EXPECT().Now().Return(time.Time{}).Times(2)
 
@@ -871,6 +1450,85 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal(errorMsg))
 		})
+		it("keeps the partial answer with a truncation marker when the stream is cancelled", func() {
+			factory.withHistory(nil)
+			subject := factory.buildClientWithoutConfig()
+
+			partial := io.MultiReader(
+				strings.NewReader(`data: {"choices":[{"delta":{"content":"partial"}}]}`+"\n\n"),
+				iotest.ErrReader(context.Canceled),
+			)
+			mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any(), gomock.Any()).Return(io.NopCloser(partial), nil)
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+			mockHistoryStore.EXPECT().Write(gomock.Any()).DoAndReturn(func(hs []history.History) error {
+				Expect(hs).To(HaveLen(3))
+				Expect(hs[2].Role).To(Equal(client.AssistantRole))
+				Expect(hs[2].Content).To(Equal("partial\n\n" + client.TruncationMarker))
+				return nil
+			})
+
+			err := subject.Stream(context.Background(), query)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		it("writes the answer to the configured output", func() {
+			factory.withHistory(nil)
+			buf := &bytes.Buffer{}
+			subject := factory.buildClientWithoutConfig().WithOutput(buf)
+
+			mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any(), gomock.Any()).Return(completionsStream("a", " b"), nil)
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+			mockHistoryStore.EXPECT().Write(gomock.Any())
+
+			Expect(subject.Stream(context.Background(), query)).To(Succeed())
+			Expect(buf.String()).To(Equal("a b\n"))
+		})
+
+		it("delivers a failed request as the last event of StreamChannel", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("boom"))
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+			var events []client.Event
+			for event := range subject.StreamChannel(context.Background(), query) {
+				events = append(events, event)
+			}
+
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Type).To(Equal(client.EventError))
+			Expect(events[0].Err).To(MatchError("boom"))
+		})
+
+		it("closes the channel of StreamChannel when the consumer cancels without reading", func() {
+			factory.withoutHistory()
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("boom"))
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			events := subject.StreamChannel(ctx, query)
+			cancel()
+
+			// nothing reads the error event, so it is dropped instead of blocking
+			time.Sleep(50 * time.Millisecond)
+			Expect(events).To(BeClosed())
+		})
+
+		it("does not store an answer when the stream is cancelled before any text arrived", func() {
+			factory.withHistory(nil)
+			subject := factory.buildClientWithoutConfig()
+
+			mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any(), gomock.Any()).Return(io.NopCloser(iotest.ErrReader(context.DeadlineExceeded)), nil)
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+			mockHistoryStore.EXPECT().Write(gomock.Any()).Times(0)
+
+			err := subject.Stream(context.Background(), query)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
 		when("a valid http response is received", func() {
 			const answer = "answer"
 
@@ -879,7 +1537,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				body, err = createBody(messages, true)
 				Expect(err).NotTo(HaveOccurred())
 
-				mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.CompletionsPath, expectedBody, true).Return([]byte(answer), nil)
+				mockCaller.EXPECT().PostStream(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, expectedBody).Return(completionsStream(answer), nil)
 
 				mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
 
@@ -896,7 +1554,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				mockHistoryStore.EXPECT().Write(append(hs, history.History{
 					Message: api.Message{
 						Role:    client.AssistantRole,
-						Content: answer,
+						Content: answer + "\n",
 					},
 				}))
 
@@ -934,16 +1592,442 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 							Content: "answer x",
 						},
 					},
-				}
-				factory.withHistory(h)
-				subject := factory.buildClientWithoutConfig()
+				}
+				factory.withHistory(h)
+				subject := factory.buildClientWithoutConfig()
+
+				messages = createMessages(h, query)
+				body, err = createBody(messages, true)
+				Expect(err).NotTo(HaveOccurred())
+
+				testValidHTTPResponse(subject, h, body)
+			})
+		})
+	})
+	when("the model calls tools", func() {
+		const (
+			toolName    = "get_weather"
+			toolCommand = "weather --json"
+			arguments   = `{"city":"Oslo"}`
+			toolOutput  = "12 degrees"
+			answer      = "It is 12 degrees in Oslo"
+		)
+
+		var weatherTool = config2.ToolConfig{
+			Name:        toolName,
+			Description: "Get the current weather",
+			Parameters: map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+			},
+			Command: toolCommand,
+		}
+
+		toolCallResponse := func(id string) []byte {
+			raw, _ := json.Marshal(api.CompletionsResponse{
+				Usage: api.Usage{TotalTokens: 10},
+				Choices: []api.Choice{{
+					Message: api.Message{
+						Role: client.AssistantRole,
+						ToolCalls: []api.ToolCall{{
+							ID:       id,
+							Type:     "function",
+							Function: api.FunctionCall{Name: toolName, Arguments: arguments},
+						}},
+					},
+					FinishReason: "tool_calls",
+				}},
+			})
+			return raw
+		}
+
+		answerResponse := func() []byte {
+			raw, _ := json.Marshal(api.CompletionsResponse{
+				Usage: api.Usage{TotalTokens: 20},
+				Choices: []api.Choice{{
+					Message:      api.Message{Role: client.AssistantRole, Content: answer},
+					FinishReason: "stop",
+				}},
+			})
+			return raw
+		}
+
+		it.Before(func() {
+			factory.withoutHistory()
+		})
+
+		it("runs the tool and sends the result back until the model answers in Query", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.Tools = []config2.ToolConfig{weatherTool}
+
+			now := time.Unix(1700000000, 0)
+			mockTimer.EXPECT().Now().DoAndReturn(func() time.Time {
+				now = now.Add(time.Second)
+				return now
+			}).Times(3)
+			mockToolRunner.EXPECT().Run(toolCommand, arguments).Return(toolOutput, nil)
+
+			endpoint := subject.Config.URL + subject.Config.CompletionsPath
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), endpoint, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var req api.CompletionsRequest
+					Expect(json.Unmarshal(body, &req)).To(Succeed())
+					Expect(req.Tools).To(HaveLen(1))
+					Expect(req.Tools[0].Type).To(Equal("function"))
+					Expect(req.Tools[0].Function.Name).To(Equal(toolName))
+					Expect(req.Tools[0].Function.Parameters).To(HaveKeyWithValue("type", "object"))
+					Expect(req.Messages).To(HaveLen(2))
+
+					return toolCallResponse("call_1"), nil
+				}),
+				mockCaller.EXPECT().Post(gomock.Any(), endpoint, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var req api.CompletionsRequest
+					Expect(json.Unmarshal(body, &req)).To(Succeed())
+					Expect(req.Messages).To(HaveLen(4))
+
+					call := req.Messages[2]
+					Expect(call.Role).To(Equal(client.AssistantRole))
+					Expect(call.ToolCalls).To(HaveLen(1))
+					Expect(call.ToolCalls[0].ID).To(Equal("call_1"))
+
+					result := req.Messages[3]
+					Expect(result.Role).To(Equal(client.ToolRole))
+					Expect(result.ToolCallID).To(Equal("call_1"))
+					Expect(result.Content).To(Equal(toolOutput))
+
+					return answerResponse(), nil
+				}),
+			)
+
+			mockHistoryStore.EXPECT().Write(gomock.Any()).DoAndReturn(func(hs []history.History) error {
+				// only the final answer is stored, with the usage of both rounds
+				Expect(hs).To(HaveLen(3))
+				Expect(hs[2].Content).To(Equal(answer))
+				Expect(hs[2].Usage).To(Equal(&history.Usage{TotalTokens: 30}))
+				Expect(hs[2].FinishReason).To(Equal("stop"))
+				Expect(hs[2].LatencyMS).To(Equal(int64(1000)))
+				return nil
+			})
+
+			result, tokens, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(answer))
+			Expect(tokens).To(Equal(30))
+		})
+		it("reports tool failures and unknown tools to the model", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.Tools = []config2.ToolConfig{weatherTool}
+
+			mockTimer.EXPECT().Now().Times(3)
+			mockToolRunner.EXPECT().Run(toolCommand, arguments).Return("", errors.New("exit status 1"))
+
+			unknown, _ := json.Marshal(api.CompletionsResponse{
+				Choices: []api.Choice{{
+					Message: api.Message{
+						Role: client.AssistantRole,
+						ToolCalls: []api.ToolCall{
+							{ID: "call_1", Type: "function", Function: api.FunctionCall{Name: toolName, Arguments: arguments}},
+							{ID: "call_2", Type: "function", Function: api.FunctionCall{Name: "rm_rf", Arguments: "{}"}},
+						},
+					},
+				}},
+			})
+
+			endpoint := subject.Config.URL + subject.Config.CompletionsPath
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), endpoint, gomock.Any()).Return(unknown, nil),
+				mockCaller.EXPECT().Post(gomock.Any(), endpoint, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var req api.CompletionsRequest
+					Expect(json.Unmarshal(body, &req)).To(Succeed())
+					Expect(req.Messages).To(HaveLen(5))
+					Expect(req.Messages[3].Content).To(Equal("error: exit status 1"))
+					Expect(req.Messages[4].Content).To(Equal(`error: unknown tool "rm_rf"`))
+
+					return answerResponse(), nil
+				}),
+			)
+
+			mockHistoryStore.EXPECT().Write(gomock.Any())
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		it("gives up when the model keeps calling tools", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.Tools = []config2.ToolConfig{weatherTool}
+			subject.Config.MaxToolRounds = 2
+
+			mockTimer.EXPECT().Now().Times(2)
+			mockToolRunner.EXPECT().Run(toolCommand, arguments).Return(toolOutput, nil).Times(2)
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Return(toolCallResponse("call_1"), nil).Times(3)
+
+			_, tokens, err := subject.Query(context.Background(), query)
+			Expect(err).To(MatchError("the model kept calling tools after 2 rounds without answering"))
+			Expect(tokens).To(Equal(30))
+		})
+		it("runs the tool loop in Stream", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.Tools = []config2.ToolConfig{weatherTool}
+
+			mockTimer.EXPECT().Now().Times(3)
+			mockToolRunner.EXPECT().Run(toolCommand, arguments).Return(toolOutput, nil)
+
+			toolStream := io.NopCloser(strings.NewReader(
+				`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}` + "\n\n" +
+					`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}` + "\n\n" +
+					`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Oslo\"}"}}]}}]}` + "\n\n" +
+					"data: [DONE]\n"))
+
+			endpoint := subject.Config.URL + subject.Config.CompletionsPath
+
+			gomock.InOrder(
+				mockCaller.EXPECT().PostStream(gomock.Any(), endpoint, gomock.Any()).Return(toolStream, nil),
+				mockCaller.EXPECT().PostStream(gomock.Any(), endpoint, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) (io.ReadCloser, error) {
+					var req api.CompletionsRequest
+					Expect(json.Unmarshal(body, &req)).To(Succeed())
+					Expect(req.Stream).To(BeTrue())
+					Expect(req.Messages).To(HaveLen(4))
+					Expect(req.Messages[2].ToolCalls[0].Function.Arguments).To(Equal(arguments))
+					Expect(req.Messages[3].Content).To(Equal(toolOutput))
+
+					return completionsStream(answer), nil
+				}),
+			)
+
+			mockHistoryStore.EXPECT().Write(gomock.Any()).DoAndReturn(func(hs []history.History) error {
+				Expect(hs).To(HaveLen(3))
+				Expect(hs[2].Content).To(Equal(answer + "\n"))
+				return nil
+			})
+
+			Expect(subject.Stream(context.Background(), query)).To(Succeed())
+		})
+		it("reports the tool calls, the usage and the answer as events in StreamEvents", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.Tools = []config2.ToolConfig{weatherTool}
+
+			mockTimer.EXPECT().Now().Times(3)
+			mockToolRunner.EXPECT().Run(toolCommand, arguments).Return(toolOutput, nil)
+
+			toolStream := io.NopCloser(strings.NewReader(
+				`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Oslo\"}"}}]}}]}` + "\n\n" +
+					`data: {"choices":[],"usage":{"total_tokens":12}}` + "\n\n" +
+					"data: [DONE]\n"))
+
+			gomock.InOrder(
+				mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any(), gomock.Any()).Return(toolStream, nil),
+				mockCaller.EXPECT().PostStream(gomock.Any(), gomock.Any(), gomock.Any()).Return(completionsStream(answer), nil),
+			)
+			mockHistoryStore.EXPECT().Write(gomock.Any())
+
+			var events []client.Event
+			Expect(subject.StreamEvents(context.Background(), query, func(event client.Event) {
+				events = append(events, event)
+			})).To(Succeed())
+
+			Expect(events).To(Equal([]client.Event{
+				{Type: client.EventToolCall, ToolCall: &api.ToolCall{
+					ID:       "call_1",
+					Type:     "function",
+					Function: api.FunctionCall{Name: "get_weather", Arguments: arguments},
+				}},
+				{Type: client.EventUsage, Tokens: 12},
+				{Type: client.EventTextDelta, Text: answer},
+				{Type: client.EventTextDelta, Text: "\n"},
+				{Type: client.EventDone, Text: answer + "\n"},
+			}))
+		})
+		it("uses function_call items with the responses API", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.Model = "gpt-5"
+			subject.Config.Tools = []config2.ToolConfig{weatherTool}
+
+			mockTimer.EXPECT().Now().Times(3)
+			mockToolRunner.EXPECT().Run(toolCommand, arguments).Return(toolOutput, nil)
+
+			callResponse, _ := json.Marshal(api.ResponsesResponse{
+				Output: []api.Output{{
+					ID:        "fc_1",
+					Type:      "function_call",
+					CallID:    "call_1",
+					Name:      toolName,
+					Arguments: arguments,
+				}},
+			})
+			finalResponse, _ := json.Marshal(api.ResponsesResponse{
+				Output: []api.Output{{
+					Type:    "message",
+					Content: []api.Content{{Type: "output_text", Text: answer}},
+				}},
+			})
+
+			endpoint := subject.Config.URL + subject.Config.ResponsesPath
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), endpoint, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var req map[string]interface{}
+					Expect(json.Unmarshal(body, &req)).To(Succeed())
+					Expect(req["tools"]).To(ConsistOf(HaveKeyWithValue("name", toolName)))
+
+					return callResponse, nil
+				}),
+				mockCaller.EXPECT().Post(gomock.Any(), endpoint, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var req map[string]interface{}
+					Expect(json.Unmarshal(body, &req)).To(Succeed())
+
+					input := req["input"].([]interface{})
+					Expect(input).To(HaveLen(4))
+					Expect(input[2]).To(Equal(map[string]interface{}{
+						"type":      "function_call",
+						"call_id":   "call_1",
+						"name":      toolName,
+						"arguments": arguments,
+					}))
+					Expect(input[3]).To(Equal(map[string]interface{}{
+						"type":    "function_call_output",
+						"call_id": "call_1",
+						"output":  toolOutput,
+					}))
+
+					return finalResponse, nil
+				}),
+			)
+
+			mockHistoryStore.EXPECT().Write(gomock.Any())
+
+			result, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(answer))
+		})
+		it("offers the tools of the configured MCP servers and calls them", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.MCPServers = []config2.MCPServerConfig{{Name: "github", Command: "github-mcp"}}
+
+			mockMCPClient := NewMockMCPClient(mockCtrl)
+			subject.WithMCPFactory(func(server config2.MCPServerConfig) (mcp.Client, error) {
+				Expect(server.Name).To(Equal("github"))
+				return mockMCPClient, nil
+			})
+
+			mockTimer.EXPECT().Now().Times(3)
+			mockMCPClient.EXPECT().ListTools().Return([]api.MCPTool{{
+				Name:        "search.issues",
+				Description: "Search issues",
+				InputSchema: map[string]interface{}{"type": "object"},
+			}}, nil)
+			mockMCPClient.EXPECT().CallTool("search.issues", map[string]interface{}{"query": "bug"}).
+				Return(api.MCPCallToolResult{Content: []api.MCPContent{{Type: "text", Text: "#42 crash on start"}}}, nil)
+			mockMCPClient.EXPECT().Close()
+
+			callResponse, _ := json.Marshal(api.CompletionsResponse{
+				Choices: []api.Choice{{
+					Message: api.Message{
+						Role: client.AssistantRole,
+						ToolCalls: []api.ToolCall{{
+							ID:       "call_1",
+							Type:     "function",
+							Function: api.FunctionCall{Name: "github__search_issues", Arguments: `{"query":"bug"}`},
+						}},
+					},
+				}},
+			})
+
+			endpoint := subject.Config.URL + subject.Config.CompletionsPath
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), endpoint, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var req api.CompletionsRequest
+					Expect(json.Unmarshal(body, &req)).To(Succeed())
+					Expect(req.Tools).To(HaveLen(1))
+					Expect(req.Tools[0].Function.Name).To(Equal("github__search_issues"))
+					Expect(req.Tools[0].Function.Description).To(Equal("Search issues"))
+
+					return callResponse, nil
+				}),
+				mockCaller.EXPECT().Post(gomock.Any(), endpoint, gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var req api.CompletionsRequest
+					Expect(json.Unmarshal(body, &req)).To(Succeed())
+					Expect(req.Tools).To(HaveLen(1))
+					Expect(req.Messages[3].Content).To(Equal("#42 crash on start"))
+
+					return answerResponse(), nil
+				}),
+			)
+
+			mockHistoryStore.EXPECT().Write(gomock.Any())
+
+			result, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(answer))
+			Expect(subject.Close()).To(Succeed())
+		})
+		it("reports MCP tool errors to the model", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.MCPServers = []config2.MCPServerConfig{{Name: "github", URL: "https://mcp.example.com"}}
+
+			mockMCPClient := NewMockMCPClient(mockCtrl)
+			subject.WithMCPFactory(func(config2.MCPServerConfig) (mcp.Client, error) {
+				return mockMCPClient, nil
+			})
+
+			mockTimer.EXPECT().Now().Times(3)
+			mockMCPClient.EXPECT().ListTools().Return([]api.MCPTool{{Name: "search"}}, nil)
+			mockMCPClient.EXPECT().CallTool("search", nil).
+				Return(api.MCPCallToolResult{Content: []api.MCPContent{{Type: "text", Text: "rate limited"}}, IsError: true}, nil)
+
+			callResponse, _ := json.Marshal(api.CompletionsResponse{
+				Choices: []api.Choice{{
+					Message: api.Message{
+						Role: client.AssistantRole,
+						ToolCalls: []api.ToolCall{
+							{ID: "call_1", Type: "function", Function: api.FunctionCall{Name: "github__search"}},
+							{ID: "call_2", Type: "function", Function: api.FunctionCall{Name: "github__search", Arguments: "{"}},
+						},
+					},
+				}},
+			})
 
-				messages = createMessages(h, query)
-				body, err = createBody(messages, true)
-				Expect(err).NotTo(HaveOccurred())
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Return(callResponse, nil),
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var req api.CompletionsRequest
+					Expect(json.Unmarshal(body, &req)).To(Succeed())
+					Expect(req.Messages[3].Content).To(Equal("error: rate limited"))
+					Expect(req.Messages[4].Content).To(HavePrefix("error: invalid arguments"))
 
-				testValidHTTPResponse(subject, h, body)
+					return answerResponse(), nil
+				}),
+			)
+
+			mockHistoryStore.EXPECT().Write(gomock.Any())
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		it("skips MCP servers that cannot be reached", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.Tools = []config2.ToolConfig{weatherTool}
+			subject.Config.MCPServers = []config2.MCPServerConfig{{Name: "broken", Command: "missing-binary"}}
+			subject.WithMCPFactory(func(config2.MCPServerConfig) (mcp.Client, error) {
+				return nil, errors.New("executable file not found")
 			})
+
+			mockTimer.EXPECT().Now().Times(3)
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+				var req api.CompletionsRequest
+				Expect(json.Unmarshal(body, &req)).To(Succeed())
+				Expect(req.Tools).To(HaveLen(1))
+				Expect(req.Tools[0].Function.Name).To(Equal(toolName))
+
+				return answerResponse(), nil
+			})
+			mockHistoryStore.EXPECT().Write(gomock.Any())
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.Close()).To(Succeed())
 		})
 	})
 	when("SynthesizeSpeech()", func() {
@@ -975,17 +2059,17 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			response = []byte("mock response")
 		})
 		it("throws an error when the http call fails", func() {
-			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.SpeechPath, body, false).Return(nil, errors.New(errorText))
+			mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.SpeechPath, body).Return(nil, errors.New(errorText))
 
-			err := subject.SynthesizeSpeech(inputText, fileName)
+			err := subject.SynthesizeSpeech(context.Background(), inputText, fileName)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring(errorText))
 		})
 		it("throws an error when a file cannot be created", func() {
-			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.SpeechPath, body, false).Return(response, nil)
+			mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.SpeechPath, body).Return(response, nil)
 			mockWriter.EXPECT().Create(fileName).Return(nil, errors.New(errorText))
 
-			err := subject.SynthesizeSpeech(inputText, fileName)
+			err := subject.SynthesizeSpeech(context.Background(), inputText, fileName)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring(errorText))
 		})
@@ -994,11 +2078,11 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			Expect(err).NotTo(HaveOccurred())
 			defer file.Close()
 
-			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.SpeechPath, body, false).Return(response, nil)
+			mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.SpeechPath, body).Return(response, nil)
 			mockWriter.EXPECT().Create(fileName).Return(file, nil)
 			mockWriter.EXPECT().Write(file, response).Return(errors.New(errorText))
 
-			err = subject.SynthesizeSpeech(inputText, fileName)
+			err = subject.SynthesizeSpeech(context.Background(), inputText, fileName)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring(errorText))
 		})
@@ -1007,11 +2091,11 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			Expect(err).NotTo(HaveOccurred())
 			defer file.Close()
 
-			mockCaller.EXPECT().Post(subject.Config.URL+subject.Config.SpeechPath, body, false).Return(response, nil)
+			mockCaller.EXPECT().Post(gomock.Any(), subject.Config.URL+subject.Config.SpeechPath, body).Return(response, nil)
 			mockWriter.EXPECT().Create(fileName).Return(file, nil)
 			mockWriter.EXPECT().Write(file, response).Return(nil)
 
-			err = subject.SynthesizeSpeech(inputText, fileName)
+			err = subject.SynthesizeSpeech(context.Background(), inputText, fileName)
 			Expect(err).NotTo(HaveOccurred())
 		})
 	})
@@ -1039,28 +2123,28 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 		})
 		it("throws an error when the http call fails", func() {
 			mockCaller.EXPECT().
-				Post(subject.Config.URL+subject.Config.ImageGenerationsPath, body, false).
+				Post(gomock.Any(), subject.Config.URL+subject.Config.ImageGenerationsPath, body).
 				Return(nil, errors.New(errorText))
 
-			err := subject.GenerateImage(inputText, outputFile)
+			err := subject.GenerateImage(context.Background(), inputText, outputFile)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring(errorText))
 		})
 		it("throws an error when no image data is returned", func() {
 			mockCaller.EXPECT().
-				Post(subject.Config.URL+subject.Config.ImageGenerationsPath, body, false).
+				Post(gomock.Any(), subject.Config.URL+subject.Config.ImageGenerationsPath, body).
 				Return([]byte(`{"data":[]}`), nil)
 
-			err := subject.GenerateImage(inputText, outputFile)
+			err := subject.GenerateImage(context.Background(), inputText, outputFile)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("no image data returned"))
 		})
 		it("throws an error when base64 is invalid", func() {
 			mockCaller.EXPECT().
-				Post(subject.Config.URL+subject.Config.ImageGenerationsPath, body, false).
+				Post(gomock.Any(), subject.Config.URL+subject.Config.ImageGenerationsPath, body).
 				Return([]byte(`{"data":[{"b64_json":"!!notbase64!!"}]}`), nil)
 
-			err := subject.GenerateImage(inputText, outputFile)
+			err := subject.GenerateImage(context.Background(), inputText, outputFile)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("failed to decode base64 image"))
 		})
@@ -1068,12 +2152,12 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			valid := base64.StdEncoding.EncodeToString([]byte("image-bytes"))
 
 			mockCaller.EXPECT().
-				Post(subject.Config.URL+subject.Config.ImageGenerationsPath, body, false).
+				Post(gomock.Any(), subject.Config.URL+subject.Config.ImageGenerationsPath, body).
 				Return([]byte(fmt.Sprintf(`{"data":[{"b64_json":"%s"}]}`, valid)), nil)
 
 			mockWriter.EXPECT().Create(outputFile).Return(nil, errors.New(errorText))
 
-			err := subject.GenerateImage(inputText, outputFile)
+			err := subject.GenerateImage(context.Background(), inputText, outputFile)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring(errorText))
 		})
@@ -1084,13 +2168,13 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			defer file.Close()
 
 			mockCaller.EXPECT().
-				Post(subject.Config.URL+subject.Config.ImageGenerationsPath, body, false).
+				Post(gomock.Any(), subject.Config.URL+subject.Config.ImageGenerationsPath, body).
 				Return([]byte(fmt.Sprintf(`{"data":[{"b64_json":"%s"}]}`, valid)), nil)
 
 			mockWriter.EXPECT().Create(outputFile).Return(file, nil)
 			mockWriter.EXPECT().Write(file, []byte("image-bytes")).Return(errors.New(errorText))
 
-			err = subject.GenerateImage(inputText, outputFile)
+			err = subject.GenerateImage(context.Background(), inputText, outputFile)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring(errorText))
 		})
@@ -1101,13 +2185,13 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			defer file.Close()
 
 			mockCaller.EXPECT().
-				Post(subject.Config.URL+subject.Config.ImageGenerationsPath, body, false).
+				Post(gomock.Any(), subject.Config.URL+subject.Config.ImageGenerationsPath, body).
 				Return([]byte(fmt.Sprintf(`{"data":[{"b64_json":"%s"}]}`, valid)), nil)
 
 			mockWriter.EXPECT().Create(outputFile).Return(file, nil)
 			mockWriter.EXPECT().Write(file, []byte("image-bytes")).Return(nil)
 
-			err = subject.GenerateImage(inputText, outputFile)
+			err = subject.GenerateImage(context.Background(), inputText, outputFile)
 			Expect(err).NotTo(HaveOccurred())
 		})
 	})
@@ -1135,7 +2219,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 		it("returns error when input file can't be opened", func() {
 			mockReader.EXPECT().Open(inputFile).Return(nil, errors.New(errorText))
 
-			err := subject.EditImage(inputText, inputFile, outputFile)
+			err := subject.EditImage(context.Background(), inputText, inputFile, outputFile)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("failed to open input image"))
 		})
@@ -1144,7 +2228,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			mockReader.EXPECT().Open(inputFile).Return(file, nil).Times(2)
 			mockReader.EXPECT().ReadBufferFromFile(file).Return([]byte("not an image"), nil)
 
-			err := subject.EditImage(inputText, inputFile, outputFile)
+			err := subject.EditImage(context.Background(), inputText, inputFile, outputFile)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("unsupported MIME type"))
 		})
@@ -1158,10 +2242,10 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				Return([]byte("\x89PNG\r\n\x1a\n"), nil)
 
 			mockCaller.EXPECT().
-				PostWithHeaders(gomock.Any(), gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 				Return(nil, errors.New(errorText))
 
-			err := subject.EditImage(inputText, inputFile, outputFile)
+			err := subject.EditImage(context.Background(), inputText, inputFile, outputFile)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("failed to edit image"))
 		})
@@ -1177,10 +2261,10 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				Return([]byte("\x89PNG\r\n\x1a\n"), nil)
 
 			mockCaller.EXPECT().
-				PostWithHeaders(gomock.Any(), gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 				Return(invalidResp, nil)
 
-			err := subject.EditImage(inputText, inputFile, outputFile)
+			err := subject.EditImage(context.Background(), inputText, inputFile, outputFile)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("failed to decode base64 image"))
 		})
@@ -1195,13 +2279,13 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 				Return([]byte("\x89PNG\r\n\x1a\n"), nil)
 
 			mockCaller.EXPECT().
-				PostWithHeaders(gomock.Any(), gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
 				Return(respBytes, nil)
 
 			mockWriter.EXPECT().Create(outputFile).Return(file, nil)
 			mockWriter.EXPECT().Write(file, imageBytes).Return(nil)
 
-			err := subject.EditImage(inputText, inputFile, outputFile)
+			err := subject.EditImage(context.Background(), inputText, inputFile, outputFile)
 			Expect(err).NotTo(HaveOccurred())
 		})
 	})
@@ -1217,7 +2301,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 			mockReader.EXPECT().Open(audioPath).Return(nil, errors.New("cannot open"))
 
-			_, err := subject.Transcribe(audioPath)
+			_, err := subject.Transcribe(context.Background(), audioPath)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("cannot open"))
 		})
@@ -1237,9 +2321,9 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			mockReader.EXPECT().Open(audioPath).Return(reader, nil)
 
 			mockCaller.EXPECT().
-				PostWithHeaders(subject.Config.URL+subject.Config.TranscriptionsPath, gomock.Any(), gomock.Any())
+				PostWithHeaders(gomock.Any(), subject.Config.URL+subject.Config.TranscriptionsPath, gomock.Any(), gomock.Any())
 
-			_, err = subject.Transcribe(audioPath)
+			_, err = subject.Transcribe(context.Background(), audioPath)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("failed"))
 		})
@@ -1257,10 +2341,10 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			mockReader.EXPECT().Open(audioPath).Return(file, nil)
 
 			mockCaller.EXPECT().
-				PostWithHeaders(subject.Config.URL+subject.Config.TranscriptionsPath, gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), subject.Config.URL+subject.Config.TranscriptionsPath, gomock.Any(), gomock.Any()).
 				Return(nil, errors.New("network error"))
 
-			_, err = subject.Transcribe(audioPath)
+			_, err = subject.Transcribe(context.Background(), audioPath)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("network error"))
 		})
@@ -1281,7 +2365,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 			resp := []byte(`{"text": "Hello, this is a test."}`)
 			mockCaller.EXPECT().
-				PostWithHeaders(subject.Config.URL+subject.Config.TranscriptionsPath, gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), subject.Config.URL+subject.Config.TranscriptionsPath, gomock.Any(), gomock.Any()).
 				Return(resp, nil)
 
 			expectedHistory := []history.History{
@@ -1310,7 +2394,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 			mockHistoryStore.EXPECT().Write(expectedHistory)
 
-			text, err := subject.Transcribe(audioPath)
+			text, err := subject.Transcribe(context.Background(), audioPath)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(text).To(Equal(transcribedText))
 		})
@@ -1320,18 +2404,18 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			subject := factory.buildClientWithoutConfig()
 
 			errorMsg := "error message"
-			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return(nil, errors.New(errorMsg))
+			mockCaller.EXPECT().Get(gomock.Any(), subject.Config.URL+subject.Config.ModelsPath).Return(nil, errors.New(errorMsg))
 
-			_, err := subject.ListModels()
+			_, err := subject.ListModels(context.Background())
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal(errorMsg))
 		})
 		it("throws an error when the response is empty", func() {
 			subject := factory.buildClientWithoutConfig()
 
-			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return(nil, nil)
+			mockCaller.EXPECT().Get(gomock.Any(), subject.Config.URL+subject.Config.ModelsPath).Return(nil, nil)
 
-			_, err := subject.ListModels()
+			_, err := subject.ListModels(context.Background())
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(Equal("empty response"))
 		})
@@ -1339,9 +2423,9 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			subject := factory.buildClientWithoutConfig()
 
 			malformed := `{"invalid":"json"` // missing closing brace
-			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return([]byte(malformed), nil)
+			mockCaller.EXPECT().Get(gomock.Any(), subject.Config.URL+subject.Config.ModelsPath).Return([]byte(malformed), nil)
 
-			_, err := subject.ListModels()
+			_, err := subject.ListModels(context.Background())
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).Should(HavePrefix("failed to decode response:"))
 		})
@@ -1351,9 +2435,9 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			response, err := test.FileToBytes("models.json")
 			Expect(err).NotTo(HaveOccurred())
 
-			mockCaller.EXPECT().Get(subject.Config.URL+subject.Config.ModelsPath).Return(response, nil)
+			mockCaller.EXPECT().Get(gomock.Any(), subject.Config.URL+subject.Config.ModelsPath).Return(response, nil)
 
-			result, err := subject.ListModels()
+			result, err := subject.ListModels(context.Background())
 			Expect(err).NotTo(HaveOccurred())
 			Expect(result).NotTo(BeEmpty())
 			Expect(result).To(HaveLen(5))
@@ -1413,6 +2497,426 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			Expect(contextMessage.Role).To(Equal(client.UserRole))
 			Expect(contextMessage.Content).To(Equal(chatContext))
 		})
+		it("preserves newlines and indentation", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.History = []history.History{{Message: api.Message{Role: client.SystemRole, Content: "system message"}}}
+
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+			chatContext := "func main() {\n\tif true {\n\t\tfmt.Println(\"a  b\")\n\t}\n}\n"
+			subject.ProvideContext(chatContext)
+
+			Expect(subject.History).To(HaveLen(2))
+			Expect(subject.History[1].Content).To(Equal(chatContext))
+		})
+		it("ignores context that is only whitespace", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.History = []history.History{{Message: api.Message{Role: client.SystemRole, Content: "system message"}}}
+
+			subject.ProvideContext(" \n\n\t\n")
+
+			Expect(subject.History).To(HaveLen(1))
+		})
+		it("splits long context at line breaks", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.History = []history.History{{Message: api.Message{Role: client.SystemRole, Content: "system message"}}}
+
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+			line := "    " + strings.Repeat("word ", 20) + "\n"
+			chatContext := strings.Repeat(line, 60)
+			subject.ProvideContext(chatContext)
+
+			Expect(len(subject.History)).To(BeNumerically(">", 2))
+
+			var joined string
+			for _, entry := range subject.History[1:] {
+				content := entry.Content.(string)
+				Expect(content).To(HaveSuffix("\n"))
+				joined += content
+			}
+			Expect(joined).To(Equal(chatContext))
+		})
+		it("cuts words that are too long by their tokens", func() {
+			estimator := tokenizer.NewEstimator(tokenizer.Cl100kBase)
+			subject := factory.buildClientWithoutConfig().WithTokenizer(estimator)
+			subject.History = []history.History{{Message: api.Message{Role: client.SystemRole, Content: "system message"}}}
+
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+			chatContext := strings.Repeat("😀", 3000)
+			subject.ProvideContext(chatContext)
+
+			Expect(len(subject.History)).To(BeNumerically(">", 2))
+
+			var joined string
+			for _, entry := range subject.History[1:] {
+				content := entry.Content.(string)
+				Expect(estimator.Count(content)).To(BeNumerically("<=", 256))
+				joined += content
+			}
+			Expect(joined).To(Equal(chatContext))
+		})
+		it("fences context labeled with its source when fence_context is enabled", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.FenceContext = true
+			subject.History = []history.History{{Message: api.Message{Role: client.SystemRole, Content: "system message"}}}
+
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+			subject.ProvideContextFrom("stdin", "see ```code```\n")
+
+			Expect(subject.History).To(HaveLen(2))
+			Expect(subject.History[1].Content).To(Equal("````stdin\nsee ```code```\n````"))
+		})
+		it("numbers the fenced parts of long context", func() {
+			subject := factory.buildClientWithoutConfig()
+			subject.Config.FenceContext = true
+			subject.History = []history.History{{Message: api.Message{Role: client.SystemRole, Content: "system message"}}}
+
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+			subject.ProvideContextFrom("prompt.md", strings.Repeat(strings.Repeat("word ", 20)+"\n", 60))
+
+			parts := len(subject.History) - 1
+			Expect(parts).To(BeNumerically(">", 1))
+			Expect(subject.History[1].Content).To(HavePrefix(fmt.Sprintf("```prompt.md (part 1 of %d)\n", parts)))
+			Expect(subject.History[parts].Content).To(HavePrefix(fmt.Sprintf("```prompt.md (part %d of %d)\n", parts, parts)))
+			Expect(subject.History[parts].Content).To(HaveSuffix("\n```"))
+		})
+	})
+	when("ProvideFiles()", func() {
+		var subject *client.Client
+
+		it.Before(func() {
+			subject = factory.buildClientWithoutConfig()
+			subject.Config.ContextWindow = 4096
+			subject.History = []history.History{{Message: api.Message{Role: client.SystemRole, Content: "system message"}}}
+
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+		})
+
+		it("adds every file in a fenced block labeled with its path and skips binaries", func() {
+			mockReader.EXPECT().ReadFile("main.go").Return([]byte("package main\n\nfunc main() {}\n"), nil)
+			mockReader.EXPECT().ReadFile("logo.png").Return([]byte{0x89, 'P', 'N', 'G', 0x00, 0xff, 0xfe}, nil)
+			mockReader.EXPECT().ReadFile("README.md").Return([]byte("# Title"), nil)
+
+			skipped, err := subject.ProvideFiles([]string{"main.go", "logo.png", "README.md"}, query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(skipped).To(Equal([]string{"logo.png"}))
+
+			Expect(subject.History).To(HaveLen(3))
+			Expect(subject.History[1].Role).To(Equal(client.UserRole))
+			Expect(subject.History[1].Content).To(Equal("```main.go\npackage main\n\nfunc main() {}\n```"))
+			Expect(subject.History[2].Content).To(Equal("```README.md\n# Title\n```"))
+		})
+
+		it("reports the tokens of every file when they do not fit the context window", func() {
+			subject.Config.ContextWindow = 600
+
+			mockReader.EXPECT().ReadFile("small.txt").Return([]byte("small"), nil)
+			mockReader.EXPECT().ReadFile("large.txt").Return([]byte(strings.Repeat("large ", 500)), nil)
+
+			_, err := subject.ProvideFiles([]string{"small.txt", "large.txt"}, query)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("the files take "))
+			Expect(err.Error()).To(ContainSubstring("of the context window of 600 tokens are left for them:\n"))
+			Expect(err.Error()).To(MatchRegexp(`(?s)\d+  large\.txt\n\s+\d+  small\.txt\n\s+\d+  total$`))
+
+			Expect(subject.History).To(HaveLen(1))
+		})
+
+		it("reserves the tokens of the query and the pinned messages", func() {
+			subject.Config.ContextWindow = 600
+			subject.History = append(subject.History, history.History{
+				Message: api.Message{Role: client.UserRole, Content: strings.Repeat("pinned ", 100)},
+				Pinned:  true,
+			})
+
+			file := []byte(strings.Repeat("line ", 200))
+			mockReader.EXPECT().ReadFile("notes.txt").Return(file, nil).Times(2)
+
+			_, err := subject.ProvideFiles([]string{"notes.txt"}, query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.History).To(HaveLen(3))
+
+			subject.History = subject.History[:2]
+			_, err = subject.ProvideFiles([]string{"notes.txt"}, strings.Repeat("query ", 200))
+			Expect(err).To(MatchError(HavePrefix("the files take ")))
+			Expect(subject.History).To(HaveLen(2))
+		})
+
+		it("returns the error of a file that cannot be read", func() {
+			mockReader.EXPECT().ReadFile("missing.txt").Return(nil, errors.New("no such file"))
+
+			_, err := subject.ProvideFiles([]string{"missing.txt"}, query)
+			Expect(err).To(MatchError("no such file"))
+		})
+	})
+	when("taking back the last exchange", func() {
+		var (
+			subject *client.Client
+			entries []history.History
+		)
+
+		it.Before(func() {
+			subject = factory.buildClientWithoutConfig()
+			mockTimer.EXPECT().Now().Return(time.Time{}).AnyTimes()
+
+			entries = []history.History{
+				{Message: api.Message{Role: client.SystemRole, Content: config.Role}},
+				{Message: api.Message{Role: client.UserRole, Content: "first question"}},
+				{Message: api.Message{Role: client.AssistantRole, Content: "first answer"}},
+				{Message: api.Message{Role: client.UserRole, Content: "second question"}},
+				{Message: api.Message{Role: client.AssistantRole, Content: "bad answer"}},
+			}
+		})
+
+		it("returns the last query", func() {
+			factory.withHistory(entries)
+
+			query, err := subject.LastQuery()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(query).To(Equal("second question"))
+			Expect(subject.History).To(HaveLen(5))
+		})
+
+		it("removes the last exchange without storing the history", func() {
+			factory.withHistory(entries)
+
+			query, err := subject.RemoveLastExchange()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(query).To(Equal("second question"))
+			Expect(subject.History).To(Equal(entries[:3]))
+		})
+
+		it("stores the history when the last exchange is undone", func() {
+			factory.withHistory(entries)
+			mockHistoryStore.EXPECT().Write(entries[:3]).Return(nil).Times(1)
+
+			query, err := subject.Undo()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(query).To(Equal("second question"))
+		})
+
+		it("removes a query that was not answered", func() {
+			factory.withHistory(entries[:4])
+
+			query, err := subject.RemoveLastExchange()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(query).To(Equal("second question"))
+			Expect(subject.History).To(Equal(entries[:3]))
+		})
+
+		it("resends the query with the answer removed", func() {
+			factory.withHistory(entries)
+
+			query, err := subject.RemoveLastExchange()
+			Expect(err).NotTo(HaveOccurred())
+
+			mockCaller.EXPECT().
+				Post(gomock.Any(), subject.Config.URL+subject.Config.CompletionsPath, gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var request api.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+					Expect(request.Messages).To(HaveLen(4))
+					Expect(request.Messages[3].Content).To(Equal("second question"))
+
+					return json.Marshal(api.CompletionsResponse{Choices: []api.Choice{{Message: api.Message{Role: client.AssistantRole, Content: "good answer"}}}})
+				}).Times(1)
+
+			expected := append(append([]history.History{}, entries[:4]...), history.History{Message: api.Message{Role: client.AssistantRole, Content: "good answer"}})
+			mockHistoryStore.EXPECT().Write(expected).Return(nil).Times(1)
+
+			result, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("good answer"))
+		})
+
+		it("returns an error when the thread has no query", func() {
+			factory.withHistory(entries[:1])
+			mockHistoryStore.EXPECT().GetThread().Return("default").Times(1)
+
+			_, err := subject.Undo()
+			Expect(err).To(MatchError("thread default has no query to take back"))
+		})
+
+		it("returns an error when the last query is not text", func() {
+			factory.withHistory([]history.History{entries[0], {Message: api.Message{Role: client.UserRole, Content: []api.ImageContent{{Type: "image_url"}}}}})
+			mockHistoryStore.EXPECT().GetThread().Return("default").Times(1)
+
+			_, err := subject.RemoveLastExchange()
+			Expect(err).To(MatchError("the last query of thread default is not text"))
+		})
+
+		it("returns an error when the history is omitted", func() {
+			subject.Config.OmitHistory = true
+
+			_, err := subject.LastQuery()
+			Expect(err).To(MatchError(client.ErrHistoryTracking))
+		})
+	})
+	when("recording the thread metadata", func() {
+		var (
+			store   *metadataStore
+			subject *client.Client
+		)
+
+		reply := func(text string, tokens int) ([]byte, error) {
+			return json.Marshal(api.CompletionsResponse{
+				Choices: []api.Choice{{Message: api.Message{Role: client.AssistantRole, Content: text}}},
+				Usage:   api.Usage{TotalTokens: tokens},
+			})
+		}
+
+		it.Before(func() {
+			store = &metadataStore{MockStore: mockHistoryStore}
+
+			mockHistoryStore.EXPECT().SetThread(config.Thread).Times(1)
+			mockHistoryStore.EXPECT().GetThread().Return(config.Thread).AnyTimes()
+			mockHistoryStore.EXPECT().Write(gomock.Any()).Return(nil).AnyTimes()
+			mockTimer.EXPECT().Now().Return(time.Unix(1700000000, 0)).AnyTimes()
+
+			cfg := MockConfig()
+			cfg.AutoTitle = true
+			subject = client.New(mockCallerFactory, store, mockTimer, mockReader, mockWriter, cfg, commandLineMode).
+				WithContextWindow(config.ContextWindow).
+				WithTokenizer(tokenizer.NewEstimator(tokenizer.Cl100kBase))
+		})
+
+		it("counts the messages and tokens and names the thread in the background", func() {
+			factory.withoutHistory()
+
+			gomock.InOrder(
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Return(reply("the answer", 42)),
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+						var request api.CompletionsRequest
+						Expect(json.Unmarshal(body, &request)).To(Succeed())
+						Expect(request.Messages).To(HaveLen(1))
+						Expect(request.Messages[0].Content).To(ContainSubstring("USER: " + query))
+						Expect(request.Messages[0].Content).To(ContainSubstring("ASSISTANT: the answer"))
+
+						return reply("\"Testing the client.\"", 7)
+					}),
+			)
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.Close()).To(Succeed())
+
+			metadata := store.read()
+			Expect(metadata.Title).To(Equal("Testing the client"))
+			Expect(metadata.Messages).To(Equal(3))
+			Expect(metadata.Tokens).To(Equal(42))
+			Expect(metadata.Model).To(Equal(config.Model))
+			Expect(metadata.Created).To(Equal(time.Unix(1700000000, 0)))
+			Expect(metadata.Updated).To(Equal(time.Unix(1700000000, 0)))
+		})
+
+		it("sends the sticky files as they are on every turn without storing them", func() {
+			factory.withoutHistory()
+			store.metadata = history.Metadata{Title: "Named", Sticky: []string{"/notes.md"}}
+			subject.Config.ContextWindow = 4096
+
+			sent := func(version string) func(context.Context, string, []byte) ([]byte, error) {
+				return func(_ context.Context, _ string, body []byte) ([]byte, error) {
+					var request api.CompletionsRequest
+					Expect(json.Unmarshal(body, &request)).To(Succeed())
+					Expect(request.Messages[1]).To(Equal(api.Message{
+						Role:    client.SystemRole,
+						Content: "The file /notes.md, as it is now:\n\n" + version,
+					}))
+					return reply("the answer", 42)
+				}
+			}
+
+			gomock.InOrder(
+				mockReader.EXPECT().ReadFile("/notes.md").Return([]byte("version 1"), nil),
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(sent("version 1")),
+				mockReader.EXPECT().ReadFile("/notes.md").Return([]byte("version 2"), nil),
+				mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(sent("version 2")),
+			)
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+			_, _, err = subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(subject.History).To(HaveLen(5))
+			for _, entry := range subject.History {
+				Expect(entry.Content).NotTo(ContainSubstring("version"))
+			}
+		})
+
+		it("sends the sticky files as user messages to models without a system role", func() {
+			factory.withoutHistory()
+			store.metadata = history.Metadata{Title: "Named", Sticky: []string{"/notes.md"}}
+			subject.Config.ContextWindow = 4096
+
+			omit := true
+			subject.Config.Models = map[string]config2.ModelSpec{
+				subject.Config.Model: {OmitSystemRole: &omit},
+			}
+
+			mockReader.EXPECT().ReadFile("/notes.md").Return([]byte("version 1"), nil)
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string, body []byte) ([]byte, error) {
+				var request api.CompletionsRequest
+				Expect(json.Unmarshal(body, &request)).To(Succeed())
+				Expect(request.Messages).To(Equal([]api.Message{
+					{Role: client.UserRole, Content: "The file /notes.md, as it is now:\n\nversion 1"},
+					{Role: client.UserRole, Content: query},
+				}))
+				return reply("the answer", 42)
+			})
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		it("fails when a sticky file cannot be read or does not fit the context window", func() {
+			factory.withoutHistory()
+			store.metadata = history.Metadata{Sticky: []string{"/notes.md"}}
+
+			mockReader.EXPECT().ReadFile("/notes.md").Return(nil, errors.New("gone"))
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).To(MatchError("failed to read the sticky file /notes.md, remove it with --unstick: gone"))
+
+			mockReader.EXPECT().ReadFile("/notes.md").Return([]byte(strings.Repeat("word ", 100)), nil)
+
+			_, _, err = subject.Query(context.Background(), query)
+			Expect(err).To(MatchError(ContainSubstring("leaves no room for the thread in a context window of 50 tokens")))
+		})
+
+		it("adds up the tokens and keeps the title of a named thread", func() {
+			factory.withoutHistory()
+			store.metadata = history.Metadata{Title: "Named", Tokens: 100, Messages: 5}
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Return(reply("the answer", 42)).Times(1)
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.Close()).To(Succeed())
+
+			metadata := store.read()
+			Expect(metadata.Title).To(Equal("Named"))
+			Expect(metadata.Tokens).To(Equal(142))
+			Expect(metadata.Messages).To(Equal(3))
+		})
+
+		it("does not name the thread when auto_title is disabled", func() {
+			factory.withoutHistory()
+			subject.Config.AutoTitle = false
+
+			mockCaller.EXPECT().Post(gomock.Any(), gomock.Any(), gomock.Any()).Return(reply("the answer", 42)).Times(1)
+
+			_, _, err := subject.Query(context.Background(), query)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(subject.Close()).To(Succeed())
+
+			Expect(store.read().Title).To(BeEmpty())
+		})
 	})
 	when("InjectMCPContext()", func() {
 		var subject *client.Client
@@ -1443,7 +2947,7 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 		it("throws an error when the apify API key is missing and the apify provider is used", func() {
 			subject.Config.ApifyAPIKey = ""
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring(utils.ApifyProvider))
 		})
@@ -1452,21 +2956,21 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 
 			req.Provider = "ApIfY"
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring(utils.ApifyProvider))
 		})
 		it("throws an error when history tracking is disabled", func() {
 			subject.Config.OmitHistory = true
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).To(HaveOccurred())
 			Expect(err).To(MatchError(client.ErrHistoryTracking))
 		})
 		it("throws an error when the provider is not supported", func() {
 			req.Provider = "not-supported"
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).To(HaveOccurred())
 			Expect(err).To(MatchError(client.ErrUnsupportedProvider))
 		})
@@ -1474,15 +2978,15 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			msg := "error message"
 
 			mockCaller.EXPECT().
-				PostWithHeaders(endpoint, gomock.Any(), gomock.Any()).Return(nil, errors.New(msg))
+				PostWithHeaders(gomock.Any(), endpoint, gomock.Any(), gomock.Any()).Return(nil, errors.New(msg))
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).To(HaveOccurred())
 			Expect(err).To(MatchError(msg))
 		})
 		it("throws an error when history writing fails", func() {
 			mockCaller.EXPECT().
-				PostWithHeaders(endpoint, gomock.Any(), gomock.Any()).Return([]byte(`{"key":"value"}`), nil)
+				PostWithHeaders(gomock.Any(), endpoint, gomock.Any(), gomock.Any()).Return([]byte(`{"key":"value"}`), nil)
 
 			mockHistoryStore.EXPECT().Read().Times(1)
 			mockTimer.EXPECT().Now().Times(2)
@@ -1490,13 +2994,13 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 			msg := "error message"
 			mockHistoryStore.EXPECT().Write(gomock.Any()).Return(errors.New(msg))
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).To(HaveOccurred())
 			Expect(err).To(MatchError(msg))
 		})
 		it("adds the formatted MCP response to history (array data)", func() {
 			mockCaller.EXPECT().
-				PostWithHeaders(endpoint, gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), endpoint, gomock.Any(), gomock.Any()).
 				Return([]byte(`[{"temperature":"15C","condition":"Sunny"}]`), nil)
 
 			mockHistoryStore.EXPECT().Read().Times(1)
@@ -1514,12 +3018,12 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 					return nil
 				})
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("adds the formatted MCP response to history (single object)", func() {
 			mockCaller.EXPECT().
-				PostWithHeaders(endpoint, gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), endpoint, gomock.Any(), gomock.Any()).
 				Return([]byte(`{"foo":"bar","baz":"qux"}`), nil)
 
 			mockHistoryStore.EXPECT().Read().Times(1)
@@ -1535,12 +3039,12 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 					return nil
 				})
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("adds fallback message when array response is empty", func() {
 			mockCaller.EXPECT().
-				PostWithHeaders(endpoint, gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), endpoint, gomock.Any(), gomock.Any()).
 				Return([]byte(`[]`), nil)
 
 			mockHistoryStore.EXPECT().Read().Times(1)
@@ -1552,12 +3056,12 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 					return nil
 				})
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("adds fallback message when array contains non-object items", func() {
 			mockCaller.EXPECT().
-				PostWithHeaders(endpoint, gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), endpoint, gomock.Any(), gomock.Any()).
 				Return([]byte(`[42, true, "string"]`), nil)
 
 			mockHistoryStore.EXPECT().Read().Times(1)
@@ -1569,12 +3073,12 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 					return nil
 				})
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("adds fallback message when response is invalid JSON", func() {
 			mockCaller.EXPECT().
-				PostWithHeaders(endpoint, gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), endpoint, gomock.Any(), gomock.Any()).
 				Return([]byte(`{invalid json}`), nil)
 
 			mockHistoryStore.EXPECT().Read().Times(1)
@@ -1586,12 +3090,12 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 					return nil
 				})
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("adds fallback message when top-level JSON is a string", func() {
 			mockCaller.EXPECT().
-				PostWithHeaders(endpoint, gomock.Any(), gomock.Any()).
+				PostWithHeaders(gomock.Any(), endpoint, gomock.Any(), gomock.Any()).
 				Return([]byte(`"hello world"`), nil)
 
 			mockHistoryStore.EXPECT().Read().Times(1)
@@ -1603,9 +3107,102 @@ func testClient(t *testing.T, when spec.G, it spec.S) {
 					return nil
 				})
 
-			err := subject.InjectMCPContext(req)
+			err := subject.InjectMCPContext(context.Background(), req)
 			Expect(err).NotTo(HaveOccurred())
 		})
+		when("the provider is a configured MCP server", func() {
+			var mockMCPClient *MockMCPClient
+
+			it.Before(func() {
+				subject.Config.MCPServers = []config2.MCPServerConfig{{Name: "files", Command: "files-mcp"}}
+
+				mockMCPClient = NewMockMCPClient(mockCtrl)
+				subject.WithMCPFactory(func(config2.MCPServerConfig) (mcp.Client, error) {
+					return mockMCPClient, nil
+				})
+			})
+
+			it("adds the result of a tool call to history", func() {
+				mockMCPClient.EXPECT().CallTool("list_dir", map[string]interface{}{"path": "/tmp"}).
+					Return(api.MCPCallToolResult{Content: []api.MCPContent{{Type: "text", Text: "notes.md"}}}, nil)
+
+				mockHistoryStore.EXPECT().Read().Times(1)
+				mockTimer.EXPECT().Now().Times(2)
+
+				mockHistoryStore.EXPECT().Write(gomock.Any()).
+					DoAndReturn(func(h []history.History) error {
+						Expect(h).To(HaveLen(2))
+						Expect(h[1].Message.Role).To(Equal(client.FunctionRole))
+						Expect(h[1].Message.Name).To(Equal("files__list_dir"))
+						Expect(h[1].Message.Content).To(Equal("[MCP: files/list_dir]\nnotes.md"))
+						return nil
+					})
+
+				err := subject.InjectMCPContext(context.Background(), api.MCPRequest{
+					Provider: "files",
+					Function: "list_dir",
+					Params:   map[string]interface{}{"path": "/tmp"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+			it("returns tool errors reported by the server", func() {
+				mockMCPClient.EXPECT().CallTool("list_dir", gomock.Any()).
+					Return(api.MCPCallToolResult{Content: []api.MCPContent{{Type: "text", Text: "no such directory"}}, IsError: true}, nil)
+
+				err := subject.InjectMCPContext(context.Background(), api.MCPRequest{Provider: "files", Function: "list_dir"})
+				Expect(err).To(MatchError("[MCP: files/list_dir] no such directory"))
+			})
+			it("adds a resource to history", func() {
+				mockMCPClient.EXPECT().ReadResource("file:///tmp/notes.md").
+					Return(api.MCPReadResourceResult{Contents: []api.MCPResourceContents{{URI: "file:///tmp/notes.md", Text: "# Notes"}}}, nil)
+
+				mockHistoryStore.EXPECT().Read().Times(1)
+				mockTimer.EXPECT().Now().Times(2)
+
+				mockHistoryStore.EXPECT().Write(gomock.Any()).
+					DoAndReturn(func(h []history.History) error {
+						Expect(h[1].Message.Role).To(Equal(client.FunctionRole))
+						Expect(h[1].Message.Content).To(Equal("[MCP: files/resource:file:///tmp/notes.md]\n# Notes"))
+						return nil
+					})
+
+				err := subject.InjectMCPContext(context.Background(), api.MCPRequest{Provider: "files", Function: "resource:file:///tmp/notes.md"})
+				Expect(err).NotTo(HaveOccurred())
+			})
+			it("adds the messages of a prompt to history with their roles", func() {
+				mockMCPClient.EXPECT().GetPrompt("review", map[string]string{"language": "go", "strict": "true"}).
+					Return(api.MCPGetPromptResult{Messages: []api.MCPPromptMessage{
+						{Role: client.UserRole, Content: api.MCPContent{Type: "text", Text: "Review this go code"}},
+						{Role: client.AssistantRole, Content: api.MCPContent{Type: "text", Text: "Paste it"}},
+					}}, nil)
+
+				mockHistoryStore.EXPECT().Read().Times(1)
+				mockTimer.EXPECT().Now().Times(3)
+
+				mockHistoryStore.EXPECT().Write(gomock.Any()).
+					DoAndReturn(func(h []history.History) error {
+						Expect(h).To(HaveLen(3))
+						Expect(h[1].Message).To(Equal(api.Message{Role: client.UserRole, Content: "Review this go code"}))
+						Expect(h[2].Message).To(Equal(api.Message{Role: client.AssistantRole, Content: "Paste it"}))
+						return nil
+					})
+
+				err := subject.InjectMCPContext(context.Background(), api.MCPRequest{
+					Provider: "files",
+					Function: "prompt:review",
+					Params:   map[string]interface{}{"language": "go", "strict": true},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			})
+			it("returns the error when the server cannot be reached", func() {
+				subject.WithMCPFactory(func(config2.MCPServerConfig) (mcp.Client, error) {
+					return nil, errors.New("connection refused")
+				})
+
+				err := subject.InjectMCPContext(context.Background(), api.MCPRequest{Provider: "files", Function: "list_dir"})
+				Expect(err).To(MatchError("connection refused"))
+			})
+		})
 	})
 }
 
@@ -1632,9 +3229,33 @@ func createBody(messages []api.Message, stream bool) ([]byte, error) {
 		Seed:             config.Seed,
 	}
 
+	if stream {
+		req.StreamOptions = &api.StreamOptions{IncludeUsage: true}
+	}
+
 	return json.Marshal(req)
 }
 
+func toInput(messages []api.Message) []interface{} {
+	var input []interface{}
+	for _, message := range messages {
+		input = append(input, message)
+	}
+	return input
+}
+
+func completionsStream(chunks ...string) io.ReadCloser {
+	var sb strings.Builder
+	for _, chunk := range chunks {
+		data, _ := json.Marshal(map[string]interface{}{
+			"choices": []map[string]interface{}{{"delta": map[string]string{"content": chunk}}},
+		})
+		sb.WriteString("data: " + string(data) + "\n\n")
+	}
+	sb.WriteString("data: [DONE]\n")
+	return io.NopCloser(strings.NewReader(sb.String()))
+}
+
 func createMessages(historyEntries []history.History, query string) []api.Message {
 	var messages []api.Message
 
@@ -1672,7 +3293,9 @@ func (f *clientFactory) buildClientWithoutConfig() *client.Client {
 
 	c := client.New(mockCallerFactory, f.mockHistoryStore, mockTimer, mockReader, mockWriter, MockConfig(), commandLineMode)
 
-	return c.WithContextWindow(config.ContextWindow)
+	return c.WithContextWindow(config.ContextWindow).
+		WithToolRunner(mockToolRunner).
+		WithTokenizer(tokenizer.NewEstimator(tokenizer.Cl100kBase))
 }
 
 func (f *clientFactory) withoutHistory() {
@@ -1683,6 +3306,38 @@ func (f *clientFactory) withHistory(history []history.History) {
 	f.mockHistoryStore.EXPECT().Read().Return(history, nil).Times(1)
 }
 
+// metadataStore adds the metadata of a single thread to the mocked store.
+type metadataStore struct {
+	*MockStore
+	mu       sync.Mutex
+	metadata history.Metadata
+}
+
+func (m *metadataStore) ReadMetadata(_ string) (history.Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.metadata, nil
+}
+
+func (m *metadataStore) WriteMetadata(_ string, metadata history.Metadata) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metadata = metadata
+	return nil
+}
+
+func (m *metadataStore) UpdateMetadata(_ string, update func(*history.Metadata)) (history.Metadata, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	update(&m.metadata)
+	return m.metadata, nil
+}
+
+func (m *metadataStore) read() history.Metadata {
+	metadata, _ := m.ReadMetadata("")
+	return metadata
+}
+
 func mockCallerFactory(_ config2.Config) http.Caller {
 	return mockCaller
 }
@@ -1694,6 +3349,7 @@ func MockConfig() config2.Config {
 		Model:               "gpt-3.5-turbo",
 		MaxTokens:           100,
 		ContextWindow:       50,
+		MaxToolRounds:       3,
 		Role:                "You are a test assistant.",
 		Temperature:         0.7,
 		TopP:                0.9,
@@ -1714,6 +3370,8 @@ func MockConfig() config2.Config {
 		Seed:                1,
 		Effort:              "low",
 		ResponsesPath:       "/v1/responses",
+		MessagesPath:        "/v1/test/messages",
+		AnthropicVersion:    "mock-anthropic-version",
 		Voice:               "mock-voice",
 		TranscriptionsPath:  "/v1/test/transcriptions",
 		SpeechPath:          "/v1/test/speech",
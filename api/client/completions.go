@@ -0,0 +1,165 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/history"
+)
+
+// completionsProvider talks to the OpenAI chat completions API, which is also
+// the API most OpenAI compatible services implement.
+type completionsProvider struct{}
+
+func (p *completionsProvider) Name() string {
+	return CompletionsProvider
+}
+
+func (p *completionsProvider) Path(cfg config.Config) string {
+	return cfg.CompletionsPath
+}
+
+func (p *completionsProvider) BuildRequest(cfg config.Config, messages []api.Message, tools []api.FunctionDefinition, stream bool) ([]byte, error) {
+	req := &api.CompletionsRequest{
+		Messages:         messages,
+		Model:            cfg.Model,
+		MaxTokens:        cfg.MaxTokens,
+		FrequencyPenalty: cfg.FrequencyPenalty,
+		PresencePenalty:  cfg.PresencePenalty,
+		Seed:             cfg.Seed,
+		Stream:           stream,
+	}
+
+	if stream {
+		req.StreamOptions = &api.StreamOptions{IncludeUsage: true}
+	}
+
+	if cfg.Capabilities().Temperature {
+		req.Temperature = cfg.Temperature
+		req.TopP = cfg.TopP
+	}
+
+	for _, tool := range tools {
+		req.Tools = append(req.Tools, api.CompletionsTool{
+			Type:     functionType,
+			Function: tool,
+		})
+	}
+
+	return json.Marshal(req)
+}
+
+func (p *completionsProvider) ParseResponse(raw []byte) (Reply, error) {
+	var res api.CompletionsResponse
+	if err := decodeResponse(raw, &res); err != nil {
+		return Reply{}, err
+	}
+
+	reply := Reply{
+		Tokens:     res.Usage.TotalTokens,
+		Usage:      completionsUsage(res.Usage),
+		Model:      res.Model,
+		ResponseID: res.ID,
+	}
+
+	if len(res.Choices) == 0 {
+		return reply, errors.New("no responses returned")
+	}
+
+	reply.FinishReason = res.Choices[0].FinishReason
+	message := res.Choices[0].Message
+	reply.ToolCalls = message.ToolCalls
+
+	if message.Content == nil && len(reply.ToolCalls) > 0 {
+		return reply, nil
+	}
+
+	response, ok := message.Content.(string)
+	if !ok {
+		return reply, errors.New("response cannot be converted to a string")
+	}
+	reply.Text = response
+
+	return reply, nil
+}
+
+// DecodeStream decodes completion chunks. The usage arrives in a final chunk
+// without choices, which is only sent when the request asks for it.
+func (p *completionsProvider) DecodeStream(reader io.Reader, emit EventHandler) Reply {
+	var (
+		result []byte
+		calls  streamedToolCalls
+		reply  Reply
+	)
+
+	readSSE(reader, func(_, payload string) bool {
+		if payload == sseDoneMarker {
+			result = finishStream(emit, result, calls.list())
+			return true
+		}
+
+		var data struct {
+			ID      string `json:"id"`
+			Model   string `json:"model"`
+			Choices []struct {
+				Delta struct {
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						Index    int    `json:"index"`
+						ID       string `json:"id"`
+						Function struct {
+							Name      string `json:"name"`
+							Arguments string `json:"arguments"`
+						} `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *api.Usage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(payload), &data); err != nil {
+			streamError(emit, err)
+			return false
+		}
+
+		if data.ID != "" {
+			reply.ResponseID = data.ID
+		}
+		if data.Model != "" {
+			reply.Model = data.Model
+		}
+		if data.Usage != nil {
+			reply.Tokens = data.Usage.TotalTokens
+			reply.Usage = completionsUsage(*data.Usage)
+		}
+
+		for _, choice := range data.Choices {
+			if choice.FinishReason != "" {
+				reply.FinishReason = choice.FinishReason
+			}
+			result = writeDelta(emit, result, choice.Delta.Content)
+			for _, call := range choice.Delta.ToolCalls {
+				calls.add(call.Index, call.ID, call.Function.Name, call.Function.Arguments)
+			}
+		}
+		return false
+	})
+
+	reply.Text = string(result)
+	reply.ToolCalls = calls.list()
+
+	return reply
+}
+
+func completionsUsage(usage api.Usage) history.Usage {
+	return history.Usage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		CachedTokens:     usage.PromptTokensDetails.CachedTokens,
+		ReasoningTokens:  usage.CompletionTokensDetails.ReasoningTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/tokenizer"
+)
+
+const (
+	// contextChunkDivisor caps a context message at this fraction of the
+	// effective context window, so truncation can drop old context piece by piece.
+	contextChunkDivisor = 8
+	minContextChunk     = 256
+	minFenceLength      = 3
+	partLabel           = "%s (part %d of %d)"
+)
+
+// ProvideContextFrom adds custom context read from source, such as "stdin" or
+// the path of a prompt file, to the history. The context is kept as it is,
+// including newlines and indentation, and split into messages at line breaks
+// when it is too long for one message. When fence_context is enabled, every
+// message is wrapped in a fenced block labeled with the source. It fails when
+// the thread cannot be read.
+func (c *Client) ProvideContextFrom(source, context string) error {
+	if err := c.initHistory(); err != nil {
+		return err
+	}
+	historyEntries := c.createHistoryEntriesFromString(source, context)
+	c.History = append(c.History, historyEntries...)
+	return nil
+}
+
+func (c *Client) createHistoryEntriesFromString(source, input string) []history.History {
+	var result []history.History
+
+	chunks := c.splitContext(input)
+	for i, chunk := range chunks {
+		content := chunk
+		if c.Config.FenceContext {
+			label := source
+			if label != "" && len(chunks) > 1 {
+				label = fmt.Sprintf(partLabel, source, i+1, len(chunks))
+			}
+			content = fence(label, chunk)
+		}
+
+		result = append(result, history.History{
+			Message: api.Message{
+				Role:    UserRole,
+				Content: content,
+			},
+			Timestamp: c.timer.Now(),
+		})
+	}
+
+	return result
+}
+
+// splitContext splits input into chunks that fit the context chunk limit. It
+// prefers to split at line breaks, then at spaces, and only cuts words that are
+// longer than the limit by themselves. Chunks that hold only whitespace are
+// dropped.
+func (c *Client) splitContext(input string) []string {
+	t := c.getTokenizer()
+
+	limit := calculateEffectiveContextWindow(c.contextWindow(), MaxTokenBufferPercentage) / contextChunkDivisor
+	if limit < minContextChunk {
+		limit = minContextChunk
+	}
+
+	var (
+		result  []string
+		current strings.Builder
+		tokens  int
+	)
+
+	flush := func() {
+		if strings.TrimSpace(current.String()) != "" {
+			result = append(result, current.String())
+		}
+		current.Reset()
+		tokens = 0
+	}
+
+	for _, piece := range contextPieces(t, input, limit) {
+		count := t.Count(piece)
+		if tokens+count > limit {
+			flush()
+		}
+		current.WriteString(piece)
+		tokens += count
+	}
+	flush()
+
+	return result
+}
+
+// contextPieces splits input into lines, keeping their line breaks. Lines that
+// exceed limit are split after spaces, and words that still exceed it are cut
+// into pieces of at most limit tokens.
+func contextPieces(t tokenizer.Tokenizer, input string, limit int) []string {
+	var result []string
+
+	for _, line := range strings.SplitAfter(input, "\n") {
+		if t.Count(line) <= limit {
+			result = append(result, line)
+			continue
+		}
+
+		for _, word := range strings.SplitAfter(line, " ") {
+			if t.Count(word) <= limit {
+				result = append(result, word)
+				continue
+			}
+
+			runes := []rune(word)
+			for t.Count(string(runes)) > limit {
+				cut := fittingPrefix(t, runes, limit)
+				result = append(result, string(runes[:cut]))
+				runes = runes[cut:]
+			}
+			result = append(result, string(runes))
+		}
+	}
+
+	return result
+}
+
+// fittingPrefix returns the number of runes of the longest prefix of runes that
+// takes at most limit tokens, found by bisection. It is at least 1, so a single
+// rune that takes more than limit tokens still makes progress.
+func fittingPrefix(t tokenizer.Tokenizer, runes []rune, limit int) int {
+	low, high := 1, len(runes)
+	for low < high {
+		middle := (low + high + 1) / 2
+		if t.Count(string(runes[:middle])) <= limit {
+			low = middle
+		} else {
+			high = middle - 1
+		}
+	}
+	return low
+}
+
+// fence wraps content in a fenced block with label as its info string. The
+// fence is longer than any run of backticks in content, so it cannot be closed
+// early.
+func fence(label, content string) string {
+	length, run := minFenceLength, 0
+	for _, r := range content {
+		if r != '`' {
+			run = 0
+			continue
+		}
+		run++
+		if run >= length {
+			length = run + 1
+		}
+	}
+
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	marker := strings.Repeat("`", length)
+	return marker + label + "\n" + content + marker
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+
+	"github.com/kardolus/chatgpt-cli/api"
+)
+
+// EventType tells what a streamed Event carries.
+type EventType string
+
+const (
+	// EventTextDelta carries the next piece of the answer in Text.
+	EventTextDelta EventType = "text_delta"
+	// EventReasoningSummary carries the next piece of the model's reasoning summary in Text.
+	EventReasoningSummary EventType = "reasoning_summary"
+	// EventToolCall carries a tool call of the model in ToolCall. The call is run
+	// before the next round is streamed.
+	EventToolCall EventType = "tool_call"
+	// EventUsage carries the number of tokens a round used in Tokens.
+	EventUsage EventType = "usage"
+	// EventDone ends a successful stream and carries the complete answer in Text.
+	EventDone EventType = "done"
+	// EventError carries an error the API reported within the stream in Err.
+	EventError EventType = "error"
+)
+
+// Event is a single typed piece of a streamed answer.
+type Event struct {
+	Type     EventType
+	Text     string
+	ToolCall *api.ToolCall
+	Tokens   int
+	Err      error
+}
+
+// EventHandler receives the events of a stream in the order they arrive.
+type EventHandler func(event Event)
+
+// StreamChannel runs StreamEvents in the background and delivers its events on
+// the returned channel. When the request fails, the error arrives as the last
+// event. The channel is closed once the stream is over. A consumer that stops
+// reading before that has to cancel ctx, which ends the stream and the
+// goroutine behind it.
+func (c *Client) StreamChannel(ctx context.Context, input string) <-chan Event {
+	events := make(chan Event)
+
+	go func() {
+		defer close(events)
+
+		send := func(event Event) {
+			select {
+			case events <- event:
+			case <-ctx.Done():
+			}
+		}
+
+		if err := c.StreamEvents(ctx, input, send); err != nil {
+			send(Event{Type: EventError, Err: err})
+		}
+	}()
+
+	return events
+}
+
+// printEvent is the handler of Stream. It writes the answer to the output of the
+// client as it arrives.
+func (c *Client) printEvent(event Event) {
+	switch event.Type {
+	case EventTextDelta:
+		_, _ = c.output.Write([]byte(event.Text))
+	case EventError:
+		_, _ = c.output.Write([]byte("Error: " + event.Err.Error() + "\n"))
+	}
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/cmd/chatgpt/utils"
+	"github.com/kardolus/chatgpt-cli/history"
+)
+
+const (
+	errFilesBudget = "the files take %d tokens, but only %d tokens of the context window of %d tokens are left for them:\n%s"
+	budgetLine     = "%8d  %s\n"
+	budgetTotal    = "%8d  total\n"
+)
+
+// ProvideFiles adds the files at paths to the history, each one as a message
+// with its contents in a fenced block labeled with its path. Binary files are
+// skipped and returned. The files are never split, so they have to fit the
+// context window together with the system role, the sticky files, the pinned
+// messages and the query they are sent with. Otherwise nothing is added and the
+// returned error reports the tokens of every file.
+func (c *Client) ProvideFiles(paths []string, query string) ([]string, error) {
+	if err := c.initHistory(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadSticky(); err != nil {
+		return nil, err
+	}
+
+	t := c.getTokenizer()
+
+	var (
+		entries []history.History
+		skipped []string
+		counts  = make(map[string]int)
+		total   int
+	)
+
+	for _, path := range paths {
+		data, err := c.reader.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if utils.IsBinary(data) {
+			skipped = append(skipped, path)
+			continue
+		}
+
+		content := fence(path, string(data))
+		counts[path] = t.Count(content) + tokensPerMessage
+		total += counts[path]
+
+		entries = append(entries, history.History{
+			Message: api.Message{
+				Role:    UserRole,
+				Content: content,
+			},
+			Timestamp: c.timer.Now(),
+		})
+	}
+
+	// everything that truncateHistory cannot drop is reserved
+	budget := calculateEffectiveContextWindow(c.contextWindow(), MaxTokenBufferPercentage) - c.stickyTokens()
+	budget -= t.Count(query) + tokensPerMessage
+	for i, entry := range c.History {
+		if i == 0 && entry.Role == SystemRole || entry.Pinned {
+			budget -= countContent(t, entry.Content) + tokensPerMessage
+		}
+	}
+
+	if total > budget {
+		return nil, fmt.Errorf(errFilesBudget, total, max(budget, 0), c.contextWindow(), budgetReport(counts, total))
+	}
+
+	c.History = append(c.History, entries...)
+
+	return skipped, nil
+}
+
+// budgetReport lists the tokens of every file, the largest first.
+func budgetReport(counts map[string]int, total int) string {
+	paths := make([]string, 0, len(counts))
+	for path := range counts {
+		paths = append(paths, path)
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		if counts[paths[i]] != counts[paths[j]] {
+			return counts[paths[i]] > counts[paths[j]]
+		}
+		return paths[i] < paths[j]
+	})
+
+	var result strings.Builder
+	for _, path := range paths {
+		result.WriteString(fmt.Sprintf(budgetLine, counts[path], path))
+	}
+	result.WriteString(fmt.Sprintf(budgetTotal, total))
+
+	return strings.TrimSuffix(result.String(), "\n")
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/api/mcp"
+	"github.com/kardolus/chatgpt-cli/config"
+	"go.uber.org/zap"
+)
+
+const (
+	mcpToolSeparator   = "__"
+	mcpResourcePrefix  = "resource:"
+	mcpPromptPrefix    = "prompt:"
+	maxToolNameLength  = 64
+	errMCPToolFailed   = "error: %s"
+	errInvalidToolArgs = "error: invalid arguments: %v"
+)
+
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// mcpTool maps the name a tool is offered under to the server that provides it.
+type mcpTool struct {
+	server string
+	name   string
+}
+
+func (c *Client) WithMCPFactory(factory mcp.ClientFactory) *Client {
+	c.mcpFactory = factory
+	return c
+}
+
+// Close waits for the thread title that is requested in the background when
+// auto_title is enabled, for at most the title timeout, and ends the sessions
+// with the MCP servers that were used.
+func (c *Client) Close() error {
+	c.background.Wait()
+
+	var errs []error
+	for name, session := range c.mcpSessions {
+		if err := session.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("mcp server %q: %w", name, err))
+		}
+	}
+	c.mcpSessions = nil
+	return errors.Join(errs...)
+}
+
+// mcpServer returns the configured MCP server with the given name.
+func (c *Client) mcpServer(name string) (config.MCPServerConfig, bool) {
+	for _, server := range c.Config.MCPServers {
+		if server.Name == name {
+			return server, true
+		}
+	}
+	return config.MCPServerConfig{}, false
+}
+
+// mcpSession returns the session with server, connecting on first use.
+func (c *Client) mcpSession(server config.MCPServerConfig) (mcp.Client, error) {
+	if session, ok := c.mcpSessions[server.Name]; ok {
+		return session, nil
+	}
+
+	session, err := c.mcpFactory(server)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.mcpSessions == nil {
+		c.mcpSessions = make(map[string]mcp.Client)
+	}
+	c.mcpSessions[server.Name] = session
+
+	return session, nil
+}
+
+// mcpToolDefinitions lists the tools of every configured MCP server, named
+// <server>__<tool>. The list is fetched once per client. A server that cannot be
+// reached is skipped with a warning, so the query still goes ahead without it.
+func (c *Client) mcpToolDefinitions() []api.FunctionDefinition {
+	if c.mcpTools != nil {
+		return c.mcpDefinitions
+	}
+
+	c.mcpTools = make(map[string]mcpTool)
+
+	for _, server := range c.Config.MCPServers {
+		session, err := c.mcpSession(server)
+		if err != nil {
+			zap.S().Warnf("Skipping MCP server %s: %v", server.Name, err)
+			continue
+		}
+
+		tools, err := session.ListTools()
+		if err != nil {
+			zap.S().Warnf("Skipping MCP server %s: %v", server.Name, err)
+			continue
+		}
+
+		for _, tool := range tools {
+			name := mcpToolName(server.Name, tool.Name)
+			c.mcpTools[name] = mcpTool{server: server.Name, name: tool.Name}
+			c.mcpDefinitions = append(c.mcpDefinitions, api.FunctionDefinition{
+				Name:        name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			})
+		}
+	}
+
+	return c.mcpDefinitions
+}
+
+// callMCPTool runs a tool call on the MCP server that offered the tool. It
+// reports false if no server offers a tool with that name.
+func (c *Client) callMCPTool(call api.ToolCall) (string, bool) {
+	tool, ok := c.mcpTools[call.Function.Name]
+	if !ok {
+		return "", false
+	}
+
+	var arguments map[string]interface{}
+	if strings.TrimSpace(call.Function.Arguments) != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &arguments); err != nil {
+			return fmt.Sprintf(errInvalidToolArgs, err), true
+		}
+	}
+
+	server, _ := c.mcpServer(tool.server)
+	session, err := c.mcpSession(server)
+	if err != nil {
+		return fmt.Sprintf(errMCPToolFailed, err), true
+	}
+
+	zap.S().Debugf("Calling MCP tool %s/%s with arguments %s", tool.server, tool.name, call.Function.Arguments)
+
+	result, err := session.CallTool(tool.name, arguments)
+	if err != nil {
+		return fmt.Sprintf(errMCPToolFailed, err), true
+	}
+
+	output := formatMCPContent(result.Content)
+	if result.IsError {
+		return fmt.Sprintf(errMCPToolFailed, output), true
+	}
+	return output, true
+}
+
+// injectServerContext fetches a tool result, resource or prompt from a configured
+// MCP server and returns the messages to add to the history.
+func (c *Client) injectServerContext(server config.MCPServerConfig, request api.MCPRequest) ([]api.Message, error) {
+	session, err := c.mcpSession(server)
+	if err != nil {
+		return nil, err
+	}
+
+	label := request.Provider + "/" + request.Function
+	name := mcpToolName(request.Provider, request.Function)
+
+	switch {
+	case strings.HasPrefix(request.Function, mcpResourcePrefix):
+		uri := strings.TrimPrefix(request.Function, mcpResourcePrefix)
+
+		result, err := session.ReadResource(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		var parts []string
+		for _, content := range result.Contents {
+			parts = append(parts, formatMCPResource(content))
+		}
+
+		return []api.Message{{
+			Role:    FunctionRole,
+			Name:    mcpToolName(request.Provider, "resource"),
+			Content: fmt.Sprintf("[MCP: %s]\n%s", label, strings.Join(parts, "\n")),
+		}}, nil
+	case strings.HasPrefix(request.Function, mcpPromptPrefix):
+		arguments := make(map[string]string)
+		for key, value := range request.Params {
+			if s, ok := value.(string); ok {
+				arguments[key] = s
+			} else {
+				arguments[key] = fmt.Sprint(value)
+			}
+		}
+
+		result, err := session.GetPrompt(strings.TrimPrefix(request.Function, mcpPromptPrefix), arguments)
+		if err != nil {
+			return nil, err
+		}
+
+		var messages []api.Message
+		for _, message := range result.Messages {
+			messages = append(messages, api.Message{
+				Role:    message.Role,
+				Content: formatMCPContent([]api.MCPContent{message.Content}),
+			})
+		}
+		return messages, nil
+	default:
+		result, err := session.CallTool(request.Function, request.Params)
+		if err != nil {
+			return nil, err
+		}
+		if result.IsError {
+			return nil, fmt.Errorf("[MCP: %s] %s", label, formatMCPContent(result.Content))
+		}
+
+		return []api.Message{{
+			Role:    FunctionRole,
+			Name:    name,
+			Content: fmt.Sprintf("[MCP: %s]\n%s", label, formatMCPContent(result.Content)),
+		}}, nil
+	}
+}
+
+// mcpToolName builds a tool name that is valid for every provider out of the
+// server and tool names.
+func mcpToolName(server, tool string) string {
+	name := invalidToolNameChars.ReplaceAllString(server+mcpToolSeparator+tool, "_")
+	if len(name) > maxToolNameLength {
+		name = name[:maxToolNameLength]
+	}
+	return name
+}
+
+// formatMCPContent renders the content blocks of a result as text. Binary
+// blocks are replaced by a short placeholder.
+func formatMCPContent(content []api.MCPContent) string {
+	var parts []string
+	for _, block := range content {
+		switch {
+		case block.Type == textType:
+			parts = append(parts, block.Text)
+		case block.Resource != nil:
+			parts = append(parts, formatMCPResource(*block.Resource))
+		default:
+			parts = append(parts, fmt.Sprintf("[%s content: %s]", block.Type, block.MimeType))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func formatMCPResource(resource api.MCPResourceContents) string {
+	if resource.Blob != "" {
+		return fmt.Sprintf("[binary resource %s: %s]", resource.URI, resource.MimeType)
+	}
+	return resource.Text
+}
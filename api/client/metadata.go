@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/history"
+)
+
+const (
+	titleTimeout      = 20 * time.Second
+	titleMaxTokens    = 24
+	titleMaxMessages  = 4
+	titleMaxRunes     = 1000
+	errNoMetadata     = "the history store does not keep metadata"
+	titleInstructions = "Write a title of at most six words for the conversation below. " +
+		"Answer with the title only, without quotes or punctuation at the end."
+)
+
+// recordTurn updates the metadata of the thread after an answer was added to
+// it. A thread without a title is named by the model in the background, see
+// Close.
+func (c *Client) recordTurn(tokens int) {
+	metadata, err := c.updateMetadata(func(metadata *history.Metadata) {
+		now := c.timer.Now()
+		if metadata.Created.IsZero() {
+			metadata.Created = now
+		}
+		metadata.Updated = now
+		metadata.Model = c.Config.Model
+		metadata.Tokens += tokens
+		metadata.Role, _ = c.History[0].Content.(string)
+	})
+
+	if err != nil || metadata.Title != "" || !c.Config.AutoTitle {
+		return
+	}
+
+	thread := c.historyStore.GetThread()
+	if c.titled[thread] {
+		return
+	}
+
+	if c.titled == nil {
+		c.titled = make(map[string]bool)
+	}
+	c.titled[thread] = true
+
+	messages := []api.Message{{Role: UserRole, Content: titleInstructions + "\n\n" + titleTranscript(c.History)}}
+	cfg := c.Config
+	cfg.MaxTokens = titleMaxTokens
+	provider := c.getProvider()
+	endpoint := c.getChatEndpoint()
+
+	store := c.historyStore.(history.MetadataStore)
+
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+		defer cancel()
+
+		title, err := c.requestTitle(ctx, provider, cfg, endpoint, messages)
+		if err != nil {
+			return
+		}
+
+		c.metadataMu.Lock()
+		defer c.metadataMu.Unlock()
+
+		_, _ = store.UpdateMetadata(thread, func(metadata *history.Metadata) {
+			if metadata.Title == "" {
+				metadata.Title = title
+			}
+		})
+	}()
+}
+
+// updateMetadata applies update to the metadata of the thread and stores the
+// number of messages in the history. It fails when the history is omitted or
+// the store keeps no metadata.
+func (c *Client) updateMetadata(update func(*history.Metadata)) (history.Metadata, error) {
+	if c.Config.OmitHistory {
+		return history.Metadata{}, errors.New(ErrHistoryTracking)
+	}
+
+	store, ok := c.historyStore.(history.MetadataStore)
+	if !ok {
+		return history.Metadata{}, errors.New(errNoMetadata)
+	}
+
+	thread := c.historyStore.GetThread()
+
+	c.metadataMu.Lock()
+	defer c.metadataMu.Unlock()
+
+	return store.UpdateMetadata(thread, func(metadata *history.Metadata) {
+		update(metadata)
+		metadata.Messages = len(c.History)
+	})
+}
+
+// requestTitle asks the model for a title. It runs in the background, so
+// nothing is printed, not even in debug mode.
+func (c *Client) requestTitle(ctx context.Context, provider Provider, cfg config.Config, endpoint string, messages []api.Message) (string, error) {
+	body, err := provider.BuildRequest(cfg, messages, nil, false)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := c.caller.Post(ctx, endpoint, body)
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := provider.ParseResponse(raw)
+	if err != nil {
+		return "", err
+	}
+
+	title := history.CleanTitle(reply.Text)
+	if title == "" {
+		return "", errors.New(ErrEmptyResponse)
+	}
+
+	return title, nil
+}
+
+// titleTranscript renders the first messages of a conversation, leaving out the
+// system prompt and any attachments.
+func titleTranscript(entries []history.History) string {
+	var (
+		b     strings.Builder
+		count int
+	)
+
+	for _, entry := range entries {
+		if count == titleMaxMessages {
+			break
+		}
+		content, _ := contentText(entry.Content)
+		if content == "" || (entry.Role != UserRole && entry.Role != AssistantRole) {
+			continue
+		}
+		if runes := []rune(content); len(runes) > titleMaxRunes {
+			content = string(runes[:titleMaxRunes])
+		}
+		_, _ = fmt.Fprintf(&b, "%s: %s\n\n", strings.ToUpper(entry.Role), content)
+		count++
+	}
+
+	return strings.TrimSpace(b.String())
+}
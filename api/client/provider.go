@@ -0,0 +1,217 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/history"
+	"go.uber.org/zap"
+)
+
+const (
+	CompletionsProvider   = "completions"
+	ResponsesProvider     = "responses"
+	AnthropicProvider     = "anthropic"
+	ErrUnknownProvider    = "unknown provider %q, expected one of: %s"
+	sseBufferSize         = 64 * 1024
+	sseMaxTokenSize       = 1024 * 1024
+	sseDataPrefix         = "data:"
+	sseEventPrefix        = "event:"
+	sseCommentPrefix      = ":"
+	sseDoneMarker         = "[DONE]"
+	errNoResponseReturned = "no response returned"
+)
+
+// Provider encapsulates everything that differs between chat APIs: how a request
+// is built, where it is sent, how the reply is parsed and how a stream is decoded.
+type Provider interface {
+	// Name returns the value of the provider config key that selects this provider.
+	Name() string
+	// Path returns the endpoint path chat requests are posted to.
+	Path(cfg config.Config) string
+	// BuildRequest serializes the conversation and the tools on offer into a request body.
+	BuildRequest(cfg config.Config, messages []api.Message, tools []api.FunctionDefinition, stream bool) ([]byte, error)
+	// ParseResponse extracts the reply from a response body.
+	ParseResponse(raw []byte) (Reply, error)
+	// DecodeStream hands streamed text, reasoning summaries and errors to emit as
+	// they arrive and returns the reply. Tool calls and usage are only reported in
+	// the reply.
+	DecodeStream(reader io.Reader, emit EventHandler) Reply
+}
+
+// Reply is what the model answered in a single round trip: text, tool calls or both.
+type Reply struct {
+	Text      string
+	Tokens    int
+	ToolCalls []api.ToolCall
+	// Usage breaks Tokens down as far as the API reports it.
+	Usage        history.Usage
+	Model        string
+	ResponseID   string
+	FinishReason string
+}
+
+var providers = map[string]Provider{
+	CompletionsProvider: &completionsProvider{},
+	ResponsesProvider:   &responsesProvider{},
+	AnthropicProvider:   &anthropicProvider{},
+}
+
+// NewProvider returns the provider selected by the provider config key. When the
+// key is empty the provider is inferred from the capabilities of the model, which
+// keeps configs written before the key existed working.
+func NewProvider(cfg config.Config) (Provider, error) {
+	if cfg.Provider == "" {
+		return inferProvider(cfg.Capabilities()), nil
+	}
+
+	if p, ok := providers[strings.ToLower(cfg.Provider)]; ok {
+		return p, nil
+	}
+
+	return nil, fmt.Errorf(ErrUnknownProvider, cfg.Provider, strings.Join([]string{CompletionsProvider, ResponsesProvider, AnthropicProvider}, ", "))
+}
+
+func inferProvider(caps config.ModelCapabilities) Provider {
+	switch {
+	case caps.MessagesAPI:
+		return providers[AnthropicProvider]
+	case caps.ResponsesAPI:
+		return providers[ResponsesProvider]
+	default:
+		return providers[CompletionsProvider]
+	}
+}
+
+func decodeResponse(raw []byte, v interface{}) error {
+	if raw == nil {
+		return errors.New(ErrEmptyResponse)
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// readSSE scans a server-sent event stream and hands every data line, together with
+// the most recent event name, to handle until handle reports that the stream is done.
+// In debug mode the raw lines are logged instead.
+func readSSE(reader io.Reader, handle func(event, payload string) bool) {
+	var (
+		curEvent string
+		sugar    = zap.S()
+	)
+
+	sugar.Debugln("\nResponse\n")
+
+	scanner := bufio.NewScanner(reader)
+	buf := make([]byte, 0, sseBufferSize)
+	scanner.Buffer(buf, sseMaxTokenSize)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if zap.L().Core().Enabled(zap.DebugLevel) {
+			sugar.Debugln(line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, sseCommentPrefix):
+			continue
+		case strings.HasPrefix(line, sseEventPrefix):
+			curEvent = strings.TrimSpace(strings.TrimPrefix(line, sseEventPrefix))
+		case strings.HasPrefix(line, sseDataPrefix):
+			payload := strings.TrimSpace(strings.TrimPrefix(line, sseDataPrefix))
+			if payload == "" {
+				continue
+			}
+			if handle(curEvent, payload) {
+				return
+			}
+		}
+	}
+}
+
+// streamedToolCalls assembles tool calls whose arguments arrive in pieces, keyed
+// by the index the API assigns to each call.
+type streamedToolCalls struct {
+	order []int
+	calls map[int]*api.ToolCall
+}
+
+func (s *streamedToolCalls) add(index int, id, name, arguments string) {
+	if s.calls == nil {
+		s.calls = make(map[int]*api.ToolCall)
+	}
+
+	call, ok := s.calls[index]
+	if !ok {
+		call = &api.ToolCall{Type: functionType}
+		s.calls[index] = call
+		s.order = append(s.order, index)
+	}
+
+	if id != "" {
+		call.ID = id
+	}
+	if name != "" {
+		call.Function.Name = name
+	}
+	call.Function.Arguments += arguments
+}
+
+func (s *streamedToolCalls) list() []api.ToolCall {
+	var result []api.ToolCall
+	for _, index := range s.order {
+		result = append(result, *s.calls[index])
+	}
+	return result
+}
+
+// finishStream ends the answer with a newline, unless the round only produced
+// tool calls and there is no text.
+func finishStream(emit EventHandler, result []byte, calls []api.ToolCall) []byte {
+	if len(calls) > 0 && len(result) == 0 {
+		return result
+	}
+	return terminateLine(emit, result)
+}
+
+// writeDelta emits a chunk of streamed text and appends it to the result.
+func writeDelta(emit EventHandler, result []byte, delta string) []byte {
+	if delta == "" {
+		return result
+	}
+	emit(Event{Type: EventTextDelta, Text: delta})
+	return append(result, delta...)
+}
+
+// writeReasoning emits a chunk of the reasoning summary. It is not part of the answer.
+func writeReasoning(emit EventHandler, delta string) {
+	if delta != "" {
+		emit(Event{Type: EventReasoningSummary, Text: delta})
+	}
+}
+
+// terminateLine makes sure a finished stream ends with a newline.
+func terminateLine(emit EventHandler, result []byte) []byte {
+	if len(result) == 0 || result[len(result)-1] != '\n' {
+		emit(Event{Type: EventTextDelta, Text: "\n"})
+		result = append(result, '\n')
+	}
+	return result
+}
+
+// streamError emits an error reported within the stream.
+func streamError(emit EventHandler, err error) {
+	emit(Event{Type: EventError, Err: err})
+}
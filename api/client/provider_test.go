@@ -0,0 +1,346 @@
+package client_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/api/client"
+	config2 "github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/history"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitProvider(t *testing.T) {
+	spec.Run(t, "Testing the chat API providers", testProvider, spec.Report(report.Terminal{}))
+}
+
+func testProvider(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	newProvider := func(name string) client.Provider {
+		p, err := client.NewProvider(config2.Config{Provider: name})
+		Expect(err).NotTo(HaveOccurred())
+		return p
+	}
+
+	when("NewProvider()", func() {
+		it("infers the provider from the model when the provider key is empty", func() {
+			tests := []struct {
+				model    string
+				provider string
+			}{
+				{"gpt-4o", client.CompletionsProvider},
+				{"o1-mini", client.CompletionsProvider},
+				{"o1-pro", client.ResponsesProvider},
+				{"gpt-5", client.ResponsesProvider},
+				{"claude-sonnet-4-5", client.AnthropicProvider},
+			}
+
+			for _, tt := range tests {
+				p, err := client.NewProvider(config2.Config{Model: tt.model})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(p.Name()).To(Equal(tt.provider), tt.model)
+			}
+		})
+		it("uses the provider key over the model name", func() {
+			p, err := client.NewProvider(config2.Config{Model: "claude-sonnet-4-5", Provider: "Completions"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(p.Name()).To(Equal(client.CompletionsProvider))
+		})
+		it("returns an error for an unknown provider", func() {
+			_, err := client.NewProvider(config2.Config{Provider: "bogus"})
+			Expect(err).To(MatchError(`unknown provider "bogus", expected one of: completions, responses, anthropic`))
+		})
+	})
+
+	when("BuildRequest()", func() {
+		it("sends tool calls and their results as Anthropic content blocks", func() {
+			messages := []api.Message{
+				{Role: client.SystemRole, Content: "be brief"},
+				{Role: client.UserRole, Content: "weather in Oslo and Bergen?"},
+				{Role: client.AssistantRole, ToolCalls: []api.ToolCall{
+					{ID: "toolu_1", Type: "function", Function: api.FunctionCall{Name: "get_weather", Arguments: `{"city":"Oslo"}`}},
+					{ID: "toolu_2", Type: "function", Function: api.FunctionCall{Name: "get_weather", Arguments: `{"city":"Bergen"}`}},
+				}},
+				{Role: client.ToolRole, ToolCallID: "toolu_1", Content: "12 degrees"},
+				{Role: client.ToolRole, ToolCallID: "toolu_2", Content: "9 degrees"},
+			}
+			tools := []api.FunctionDefinition{{Name: "get_weather", Description: "Get the weather"}}
+
+			body, err := newProvider(client.AnthropicProvider).BuildRequest(config2.Config{Model: "claude-sonnet-4-5"}, messages, tools, false)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(body).To(MatchJSON(`{
+				"model": "claude-sonnet-4-5",
+				"system": "be brief",
+				"max_tokens": 0,
+				"stream": false,
+				"tools": [{"name": "get_weather", "description": "Get the weather", "input_schema": {"type": "object"}}],
+				"messages": [
+					{"role": "user", "content": "weather in Oslo and Bergen?"},
+					{"role": "assistant", "content": [
+						{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Oslo"}},
+						{"type": "tool_use", "id": "toolu_2", "name": "get_weather", "input": {"city": "Bergen"}}
+					]},
+					{"role": "user", "content": [
+						{"type": "tool_result", "tool_use_id": "toolu_1", "content": "12 degrees"},
+						{"type": "tool_result", "tool_use_id": "toolu_2", "content": "9 degrees"}
+					]}
+				]
+			}`))
+		})
+	})
+
+	when("ParseResponse()", func() {
+		it("keeps the usage, model, id and finish reason of a completion", func() {
+			raw := `{"id":"chatcmpl-1","model":"gpt-4o-2024-08-06","choices":[{"message":{"role":"assistant","content":"hi"},"finish_reason":"length"}],` +
+				`"usage":{"prompt_tokens":20,"completion_tokens":10,"total_tokens":30,"prompt_tokens_details":{"cached_tokens":8},"completion_tokens_details":{"reasoning_tokens":4}}}`
+
+			reply, err := newProvider(client.CompletionsProvider).ParseResponse([]byte(raw))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reply.Model).To(Equal("gpt-4o-2024-08-06"))
+			Expect(reply.ResponseID).To(Equal("chatcmpl-1"))
+			Expect(reply.FinishReason).To(Equal("length"))
+			Expect(reply.Usage).To(Equal(history.Usage{PromptTokens: 20, CompletionTokens: 10, CachedTokens: 8, ReasoningTokens: 4, TotalTokens: 30}))
+		})
+		it("reports why a response is incomplete", func() {
+			raw := `{"id":"resp_1","model":"gpt-5","status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},` +
+				`"output":[{"type":"message","content":[{"type":"output_text","text":"hi"}]}],` +
+				`"usage":{"input_tokens":20,"input_tokens_details":{"cached_tokens":8},"output_tokens":10,"output_tokens_details":{"reasoning_tokens":4},"total_tokens":30}}`
+
+			reply, err := newProvider(client.ResponsesProvider).ParseResponse([]byte(raw))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reply.Model).To(Equal("gpt-5"))
+			Expect(reply.ResponseID).To(Equal("resp_1"))
+			Expect(reply.FinishReason).To(Equal("max_output_tokens"))
+			Expect(reply.Usage).To(Equal(history.Usage{PromptTokens: 20, CompletionTokens: 10, CachedTokens: 8, ReasoningTokens: 4, TotalTokens: 30}))
+		})
+		it("counts the cached tokens of an Anthropic message as prompt tokens", func() {
+			raw := `{"id":"msg_1","model":"claude-sonnet-4-5","stop_reason":"end_turn","content":[{"type":"text","text":"hi"}],` +
+				`"usage":{"input_tokens":5,"output_tokens":10,"cache_read_input_tokens":12,"cache_creation_input_tokens":3}}`
+
+			reply, err := newProvider(client.AnthropicProvider).ParseResponse([]byte(raw))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reply.Model).To(Equal("claude-sonnet-4-5"))
+			Expect(reply.ResponseID).To(Equal("msg_1"))
+			Expect(reply.FinishReason).To(Equal("end_turn"))
+			Expect(reply.Usage).To(Equal(history.Usage{PromptTokens: 20, CompletionTokens: 10, CachedTokens: 12, TotalTokens: 30}))
+		})
+	})
+
+	when("DecodeStream()", func() {
+		it("parses a completions stream", func() {
+			buf := &eventRecorder{}
+			result := newProvider(client.CompletionsProvider).DecodeStream(strings.NewReader(legacyStream), buf.handle)
+			Expect(buf.String()).To(Equal("a b c\n"))
+			Expect(result.Text).To(Equal("a b c\n"))
+			Expect(result.Model).To(Equal("model-1"))
+			Expect(result.FinishReason).To(Equal("stop"))
+		})
+		it("parses a responses stream", func() {
+			buf := &eventRecorder{}
+			// deltas are "a", " b", " c" then response.completed -> newline
+			newProvider(client.ResponsesProvider).DecodeStream(strings.NewReader(gpt5Stream), buf.handle)
+			Expect(buf.String()).To(Equal("a b c\n"))
+		})
+		it("parses a legacy stream returned by a responses compatible server", func() {
+			buf := &eventRecorder{}
+			newProvider(client.ResponsesProvider).DecodeStream(strings.NewReader(legacyStream), buf.handle)
+			Expect(buf.String()).To(Equal("a b c\n"))
+		})
+		it("parses an Anthropic messages stream", func() {
+			buf := &eventRecorder{}
+			result := newProvider(client.AnthropicProvider).DecodeStream(strings.NewReader(messagesStream), buf.handle)
+			Expect(buf.String()).To(Equal("a b c\n"))
+			Expect(result.Text).To(Equal("a b c\n"))
+			Expect(result.Model).To(Equal("claude-sonnet-4-5"))
+			Expect(result.ResponseID).To(Equal("msg_1"))
+			Expect(result.FinishReason).To(Equal("end_turn"))
+			Expect(result.Usage).To(Equal(history.Usage{PromptTokens: 10, CompletionTokens: 3, TotalTokens: 13}))
+		})
+		it("writes the error of an Anthropic error event", func() {
+			input := "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n"
+
+			buf := &eventRecorder{}
+			newProvider(client.AnthropicProvider).DecodeStream(strings.NewReader(input), buf.handle)
+			Expect(buf.String()).To(Equal("Error: Overloaded\n"))
+		})
+		it("collects the tool calls of a responses stream", func() {
+			input := "event: response.output_item.done\n" +
+				`data: {"type":"response.output_item.done","item":{"id":"fc_1","type":"function_call","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"Oslo\"}"}}` + "\n\n" +
+				"event: response.completed\n" +
+				`data: {"type":"response.completed"}` + "\n"
+
+			buf := &eventRecorder{}
+			result := newProvider(client.ResponsesProvider).DecodeStream(strings.NewReader(input), buf.handle)
+			Expect(buf.String()).To(BeEmpty())
+			Expect(result.ToolCalls).To(Equal([]api.ToolCall{{
+				ID:       "call_1",
+				Type:     "function",
+				Function: api.FunctionCall{Name: "get_weather", Arguments: `{"city":"Oslo"}`},
+			}}))
+		})
+		it("assembles the tool calls of an Anthropic messages stream", func() {
+			input := "event: content_block_delta\n" +
+				`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking"}}` + "\n\n" +
+				"event: content_block_start\n" +
+				`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}` + "\n\n" +
+				"event: content_block_delta\n" +
+				`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}` + "\n\n" +
+				"event: content_block_delta\n" +
+				`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Oslo\"}"}}` + "\n\n" +
+				"event: message_stop\n" +
+				`data: {"type":"message_stop"}` + "\n"
+
+			buf := &eventRecorder{}
+			result := newProvider(client.AnthropicProvider).DecodeStream(strings.NewReader(input), buf.handle)
+			Expect(buf.String()).To(Equal("Checking\n"))
+			Expect(result.ToolCalls).To(Equal([]api.ToolCall{{
+				ID:       "toolu_1",
+				Type:     "function",
+				Function: api.FunctionCall{Name: "get_weather", Arguments: `{"city":"Oslo"}`},
+			}}))
+		})
+		it("reports the usage of a completions stream", func() {
+			input := `data: {"choices":[{"delta":{"content":"a"}}]}` + "\n\n" +
+				`data: {"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6}}` + "\n\n" +
+				"data: [DONE]\n"
+
+			result := newProvider(client.CompletionsProvider).DecodeStream(strings.NewReader(input), (&eventRecorder{}).handle)
+			Expect(result.Tokens).To(Equal(6))
+		})
+		it("emits the reasoning summary and reports the usage of a responses stream", func() {
+			input := "event: response.reasoning_summary_text.delta\n" +
+				`data: {"type":"response.reasoning_summary_text.delta","delta":"thinking"}` + "\n\n" +
+				"event: response.output_text.delta\n" +
+				`data: {"type":"response.output_text.delta","delta":"a"}` + "\n\n" +
+				"event: response.completed\n" +
+				`data: {"type":"response.completed","response":{"id":"resp_1","model":"gpt-5","status":"completed","usage":{"input_tokens":5,"output_tokens":2,"output_tokens_details":{"reasoning_tokens":1},"total_tokens":7}}}` + "\n"
+
+			buf := &eventRecorder{}
+			result := newProvider(client.ResponsesProvider).DecodeStream(strings.NewReader(input), buf.handle)
+			Expect(buf.events).To(Equal([]client.Event{
+				{Type: client.EventReasoningSummary, Text: "thinking"},
+				{Type: client.EventTextDelta, Text: "a"},
+				{Type: client.EventTextDelta, Text: "\n"},
+			}))
+			Expect(result.Text).To(Equal("a\n"))
+			Expect(result.Tokens).To(Equal(7))
+			Expect(result.Usage).To(Equal(history.Usage{PromptTokens: 5, CompletionTokens: 2, ReasoningTokens: 1, TotalTokens: 7}))
+			Expect(result.ResponseID).To(Equal("resp_1"))
+			Expect(result.FinishReason).To(Equal("completed"))
+		})
+		it("emits the thinking and adds up the usage of an Anthropic messages stream", func() {
+			input := "event: content_block_delta\n" +
+				`data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hmm"}}` + "\n" +
+				messagesStream
+
+			buf := &eventRecorder{}
+			result := newProvider(client.AnthropicProvider).DecodeStream(strings.NewReader(input), buf.handle)
+			Expect(buf.events[0]).To(Equal(client.Event{Type: client.EventReasoningSummary, Text: "hmm"}))
+			Expect(buf.String()).To(Equal("a b c\n"))
+			Expect(result.Tokens).To(Equal(13))
+		})
+		it("throws an error when the legacy json is invalid", func() {
+			input := `data: {"invalid":"json"` // missing closing brace
+
+			buf := &eventRecorder{}
+			newProvider(client.CompletionsProvider).DecodeStream(strings.NewReader(input), buf.handle)
+			Expect(buf.String()).To(Equal("Error: unexpected end of JSON input\n"))
+		})
+	})
+}
+
+const legacyStream = `
+data: {"id":"id-1","object":"chat.completion.chunk","created":1,"model":"model-1","choices":[{"delta":{"role":"assistant"},"index":0,"finish_reason":null}]}
+
+data: {"id":"id-2","object":"chat.completion.chunk","created":2,"model":"model-1","choices":[{"delta":{"content":"a"},"index":0,"finish_reason":null}]}
+
+data: {"id":"id-3","object":"chat.completion.chunk","created":3,"model":"model-1","choices":[{"delta":{"content":" b"},"index":0,"finish_reason":null}]}
+
+data: {"id":"id-4","object":"chat.completion.chunk","created":4,"model":"model-1","choices":[{"delta":{"content":" c"},"index":0,"finish_reason":null}]}
+
+data: {"id":"id-5","object":"chat.completion.chunk","created":5,"model":"model-1","choices":[{"delta":{},"index":0,"finish_reason":"stop"}]}
+
+data: [DONE]
+`
+
+// Minimal GPT-5 SSE that your new parser should handle
+const gpt5Stream = `
+event: response.created
+data: {"type":"response.created"}
+
+event: response.output_item.added
+data: {"type":"response.output_item.added","output_index":0,"item":{"id":"msg_1","type":"message","status":"in_progress","content":[],"role":"assistant"}}
+
+event: response.content_part.added
+data: {"type":"response.content_part.added","item_id":"msg_1","output_index":0,"content_index":0,"part":{"type":"output_text","annotations":[],"logprobs":[],"text":""}}
+
+event: response.output_text.delta
+data: {"type":"response.output_text.delta","item_id":"msg_1","output_index":0,"content_index":0,"delta":"a"}
+
+event: response.output_text.delta
+data: {"type":"response.output_text.delta","item_id":"msg_1","output_index":0,"content_index":0,"delta":" b"}
+
+event: response.output_text.delta
+data: {"type":"response.output_text.delta","item_id":"msg_1","output_index":0,"content_index":0,"delta":" c"}
+
+event: response.completed
+data: {"type":"response.completed","response":{"status":"completed"}}
+`
+
+const messagesStream = `
+event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude-sonnet-4-5","stop_reason":null,"usage":{"input_tokens":10,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: ping
+data: {"type":"ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"a"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" b"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":" c"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":3}}
+
+event: message_stop
+data: {"type":"message_stop"}
+`
+
+// eventRecorder collects the events of a stream and renders them the way Stream prints them.
+type eventRecorder struct {
+	events []client.Event
+}
+
+func (r *eventRecorder) handle(event client.Event) {
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) String() string {
+	var sb strings.Builder
+	for _, event := range r.events {
+		switch event.Type {
+		case client.EventTextDelta:
+			sb.WriteString(event.Text)
+		case client.EventError:
+			sb.WriteString("Error: " + event.Err.Error() + "\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,267 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/history"
+)
+
+// responsesProvider talks to the OpenAI responses API used by reasoning models
+// such as gpt-5 and o1-pro.
+type responsesProvider struct{}
+
+func (p *responsesProvider) Name() string {
+	return ResponsesProvider
+}
+
+func (p *responsesProvider) Path(cfg config.Config) string {
+	return cfg.ResponsesPath
+}
+
+func (p *responsesProvider) BuildRequest(cfg config.Config, messages []api.Message, tools []api.FunctionDefinition, stream bool) ([]byte, error) {
+	input, err := toResponsesInput(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &api.ResponsesRequest{
+		Model:           cfg.Model,
+		Input:           input,
+		MaxOutputTokens: cfg.MaxTokens,
+		Reasoning: api.Reasoning{
+			Effort: cfg.Effort,
+		},
+		Stream: stream,
+		TopP:   cfg.TopP,
+	}
+
+	if cfg.Capabilities().Temperature {
+		req.Temperature = cfg.Temperature
+	}
+
+	for _, tool := range tools {
+		req.Tools = append(req.Tools, api.ResponsesTool{
+			Type:        functionType,
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		})
+	}
+
+	return json.Marshal(req)
+}
+
+func (p *responsesProvider) ParseResponse(raw []byte) (Reply, error) {
+	var res api.ResponsesResponse
+
+	if err := decodeResponse(raw, &res); err != nil {
+		return Reply{}, err
+	}
+
+	reply := Reply{
+		Tokens:       res.Usage.TotalTokens,
+		Usage:        responsesUsage(res.Usage),
+		Model:        res.Model,
+		ResponseID:   res.ID,
+		FinishReason: responsesFinishReason(res.Status, res.IncompleteDetails),
+	}
+
+	for _, output := range res.Output {
+		switch output.Type {
+		case messageType:
+			for _, content := range output.Content {
+				if content.Type == outputTextType {
+					reply.Text = content.Text
+					break
+				}
+			}
+		case functionCallType:
+			reply.ToolCalls = append(reply.ToolCalls, toToolCall(output))
+		}
+	}
+
+	if reply.Text == "" && len(reply.ToolCalls) == 0 {
+		return reply, errors.New(errNoResponseReturned)
+	}
+
+	return reply, nil
+}
+
+// DecodeStream handles the typed responses events and, for compatible servers that
+// answer with untyped chunks, the legacy completions chunk format.
+func (p *responsesProvider) DecodeStream(reader io.Reader, emit EventHandler) Reply {
+	var (
+		result []byte
+		calls  []api.ToolCall
+		reply  Reply
+	)
+
+	readSSE(reader, func(event, payload string) bool {
+		if event == "" {
+			if payload == sseDoneMarker {
+				result = terminateLine(emit, result)
+				return true
+			}
+			var legacy struct {
+				Choices []struct {
+					Delta map[string]any `json:"delta"`
+				} `json:"choices"`
+			}
+			if err := json.Unmarshal([]byte(payload), &legacy); err != nil {
+				streamError(emit, err)
+				return false
+			}
+			for _, ch := range legacy.Choices {
+				if s, ok := ch.Delta["content"].(string); ok {
+					result = writeDelta(emit, result, s)
+				}
+			}
+			return false
+		}
+
+		var env struct {
+			Type     string     `json:"type"`
+			Delta    string     `json:"delta"` // response.output_text.delta
+			Item     api.Output `json:"item"`  // response.output_item.done
+			Response struct {
+				ID                string         `json:"id"`
+				Model             string         `json:"model"`
+				Status            string         `json:"status"`
+				IncompleteDetails any            `json:"incomplete_details"`
+				Usage             api.TokenUsage `json:"usage"`
+			} `json:"response"` // response.completed and response.incomplete
+		}
+		if err := json.Unmarshal([]byte(payload), &env); err != nil {
+			streamError(emit, err)
+			return false
+		}
+
+		switch env.Type {
+		case "response.output_text.delta":
+			result = writeDelta(emit, result, env.Delta)
+		case "response.reasoning_summary_text.delta":
+			writeReasoning(emit, env.Delta)
+		case "response.output_item.done":
+			if env.Item.Type == functionCallType {
+				calls = append(calls, toToolCall(env.Item))
+			}
+		case "response.completed", "response.incomplete":
+			reply.Tokens = env.Response.Usage.TotalTokens
+			reply.Usage = responsesUsage(env.Response.Usage)
+			reply.Model = env.Response.Model
+			reply.ResponseID = env.Response.ID
+			reply.FinishReason = responsesFinishReason(env.Response.Status, env.Response.IncompleteDetails)
+			result = finishStream(emit, result, calls)
+			return true
+		default:
+			// ignore other SSE types
+		}
+		return false
+	})
+
+	reply.Text = string(result)
+	reply.ToolCalls = calls
+
+	return reply
+}
+
+func responsesUsage(usage api.TokenUsage) history.Usage {
+	return history.Usage{
+		PromptTokens:     usage.InputTokens,
+		CompletionTokens: usage.OutputTokens,
+		CachedTokens:     usage.InputTokensDetails.CachedTokens,
+		ReasoningTokens:  usage.OutputTokensDetails.ReasoningTokens,
+		TotalTokens:      usage.TotalTokens,
+	}
+}
+
+// responsesFinishReason returns the status of a response, or the reason it is
+// incomplete, like max_output_tokens.
+func responsesFinishReason(status string, details any) string {
+	if incomplete, ok := details.(map[string]any); ok {
+		if reason, ok := incomplete["reason"].(string); ok && reason != "" {
+			return reason
+		}
+	}
+	return status
+}
+
+// toResponsesInput converts the conversation to input items. Tool calls and their
+// results are separate items in the responses API rather than message fields.
+func toResponsesInput(messages []api.Message) ([]interface{}, error) {
+	var result []interface{}
+
+	for _, message := range messages {
+		switch {
+		case len(message.ToolCalls) > 0:
+			if message.Content != nil {
+				result = append(result, api.Message{Role: message.Role, Content: message.Content})
+			}
+			for _, call := range message.ToolCalls {
+				result = append(result, api.ResponsesFunctionCall{
+					Type:      functionCallType,
+					CallID:    call.ID,
+					Name:      call.Function.Name,
+					Arguments: call.Function.Arguments,
+				})
+			}
+		case message.Role == ToolRole:
+			output, _ := message.Content.(string)
+			result = append(result, api.ResponsesFunctionCallOutput{
+				Type:   functionCallOutputType,
+				CallID: message.ToolCallID,
+				Output: output,
+			})
+		default:
+			if parts, ok := message.Content.([]api.ContentPart); ok {
+				content, err := toResponsesContent(parts)
+				if err != nil {
+					return nil, err
+				}
+				message.Content = content
+			}
+			result = append(result, message)
+		}
+	}
+
+	return result, nil
+}
+
+// toResponsesContent converts content parts to the input_text and input_image
+// parts of the responses API.
+func toResponsesContent(parts []api.ContentPart) ([]api.ResponsesInputContent, error) {
+	var result []api.ResponsesInputContent
+
+	for _, part := range parts {
+		switch part.Type {
+		case imageURLType:
+			if part.ImageURL != nil {
+				result = append(result, api.ResponsesInputContent{Type: inputImageType, ImageURL: part.ImageURL.URL})
+			}
+		case audioType:
+			return nil, fmt.Errorf(errAudioUnsupported, ResponsesProvider)
+		default:
+			result = append(result, api.ResponsesInputContent{Type: inputTextType, Text: part.Text})
+		}
+	}
+
+	return result, nil
+}
+
+// toToolCall converts a function_call output item. The call_id, not the item id,
+// is what the function_call_output has to refer to.
+func toToolCall(output api.Output) api.ToolCall {
+	return api.ToolCall{
+		ID:   output.CallID,
+		Type: functionType,
+		Function: api.FunctionCall{
+			Name:      output.Name,
+			Arguments: output.Arguments,
+		},
+	}
+}
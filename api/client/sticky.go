@@ -0,0 +1,72 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/history"
+)
+
+const (
+	stickyTemplate  = "The file %s, as it is now:\n\n%s"
+	errStickyFile   = "failed to read the sticky file %s, remove it with --unstick: %w"
+	errStickyBudget = "the sticky files take %d tokens, which leaves no room for the thread in a context window of %d tokens"
+)
+
+// loadSticky reads the sticky files of the thread from disk. They are sent
+// after the system role on every turn, with their contents as they are at that
+// moment, and are never stored in the thread.
+func (c *Client) loadSticky() error {
+	c.sticky = nil
+
+	if c.Config.OmitHistory {
+		return nil
+	}
+
+	store, ok := c.historyStore.(history.MetadataStore)
+	if !ok {
+		return nil
+	}
+
+	metadata, err := store.ReadMetadata(c.historyStore.GetThread())
+	if err != nil {
+		return err
+	}
+
+	role := SystemRole
+	if c.Capabilities().OmitSystemRole {
+		role = UserRole
+	}
+
+	for _, path := range metadata.Sticky {
+		data, err := c.reader.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf(errStickyFile, path, err)
+		}
+
+		c.sticky = append(c.sticky, api.Message{
+			Role:    role,
+			Content: fmt.Sprintf(stickyTemplate, path, data),
+		})
+	}
+
+	budget := calculateEffectiveContextWindow(c.contextWindow(), MaxTokenBufferPercentage)
+	if tokens := c.stickyTokens(); tokens >= budget {
+		return fmt.Errorf(errStickyBudget, tokens, c.contextWindow())
+	}
+
+	return nil
+}
+
+// stickyTokens returns the tokens the sticky files take, which are reserved
+// before the thread is truncated.
+func (c *Client) stickyTokens() int {
+	t := c.getTokenizer()
+
+	var result int
+	for _, message := range c.sticky {
+		result += countContent(t, message.Content) + tokensPerMessage
+	}
+
+	return result
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/history"
+	"go.uber.org/zap"
+)
+
+const (
+	ContextStrategyTruncate  = "truncate"
+	ContextStrategySummarize = "summarize"
+	// summaryBudgetDivisor reserves this fraction of the effective context window for the summary
+	summaryBudgetDivisor = 4
+	summaryPrefix        = "Summary of the earlier conversation:\n"
+	summaryInstructions  = "The following messages are the beginning of a conversation that no longer fits the " +
+		"context window. Condense them into a summary that keeps every fact, decision, name, number and open " +
+		"question the rest of the conversation may rely on. Answer with the summary only, in at most %d tokens."
+)
+
+// summarizeHistory replaces the oldest messages after the system prompt with a
+// summary written by the model, so facts established early on survive. Pinned
+// messages are kept as they are, after the summary. The
+// summary is written to the thread right away. It reports false when the model
+// could not be asked, leaving the history to be truncated instead.
+func (c *Client) summarizeHistory(ctx context.Context, tokens int, rolling []int, effectiveTokenSize int) bool {
+	budget := effectiveTokenSize / summaryBudgetDivisor
+	diff := tokens - effectiveTokenSize + budget
+
+	index := len(rolling) - 1
+	var total int
+	for i := 1; i < len(rolling); i++ {
+		if c.History[i].Pinned {
+			continue
+		}
+		total += rolling[i]
+		if total > diff {
+			index = i
+			break
+		}
+	}
+
+	// the message that triggered the truncation is kept
+	if index >= len(c.History)-1 {
+		index = len(c.History) - 2
+	}
+	if index < 1 {
+		return false
+	}
+
+	var condensed []history.History
+	for _, entry := range c.History[1 : index+1] {
+		if !entry.Pinned {
+			condensed = append(condensed, entry)
+		}
+	}
+	if len(condensed) == 0 {
+		return false
+	}
+
+	summary, err := c.summarize(ctx, condensed, budget)
+	if err != nil {
+		zap.S().Warnf("Warning: failed to summarize the history, dropping the oldest messages instead: %v", err)
+		return false
+	}
+
+	kept := append([]history.History{c.History[0], summary}, pinned(c.History[1:index+1])...)
+	c.History = append(kept, c.History[index+1:]...)
+
+	if !c.Config.OmitHistory {
+		_ = c.historyStore.Write(c.History)
+	}
+
+	return true
+}
+
+// summarize asks the model to condense entries into at most budget tokens.
+func (c *Client) summarize(ctx context.Context, entries []history.History, budget int) (history.History, error) {
+	var transcript strings.Builder
+	for _, entry := range entries {
+		content, _ := contentText(entry.Content)
+		if content == "" {
+			continue
+		}
+		role := strings.ToUpper(entry.Role)
+		if entry.Summary {
+			role = "SUMMARY"
+		}
+		_, _ = fmt.Fprintf(&transcript, "%s: %s\n\n", role, content)
+	}
+
+	// a single user message works with every provider, including models without a system role
+	messages := []api.Message{{
+		Role:    UserRole,
+		Content: fmt.Sprintf(summaryInstructions, budget) + "\n\n" + transcript.String(),
+	}}
+
+	cfg := c.Config
+	cfg.MaxTokens = budget
+
+	body, err := c.getProvider().BuildRequest(cfg, messages, nil, false)
+	if err != nil {
+		return history.History{}, err
+	}
+
+	endpoint := c.getChatEndpoint()
+
+	c.printRequestDebugInfo(endpoint, body, nil)
+
+	raw, err := c.caller.Post(ctx, endpoint, body)
+	c.printResponseDebugInfo(raw)
+
+	if err != nil {
+		return history.History{}, err
+	}
+
+	reply, err := c.getProvider().ParseResponse(raw)
+	if err != nil {
+		return history.History{}, err
+	}
+
+	text := strings.TrimSpace(reply.Text)
+	if text == "" {
+		return history.History{}, errors.New(ErrEmptyResponse)
+	}
+
+	return history.History{
+		Message: api.Message{
+			Role:    SystemRole,
+			Content: summaryPrefix + text,
+		},
+		Timestamp: c.timer.Now(),
+		Summary:   true,
+	}, nil
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"go.uber.org/zap"
+)
+
+const (
+	ErrToolRounds          = "the model kept calling tools after %d rounds without answering"
+	toolArgumentsEnv       = "TOOL_ARGUMENTS"
+	functionType           = "function"
+	functionCallType       = "function_call"
+	functionCallOutputType = "function_call_output"
+	toolUseType            = "tool_use"
+	toolResultType         = "tool_result"
+)
+
+// ToolRunner runs the local command behind a tool.
+type ToolRunner interface {
+	Run(command, arguments string) (string, error)
+}
+
+type RealToolRunner struct{}
+
+// Run executes command with the shell. The arguments chosen by the model are
+// passed as JSON on stdin and in the TOOL_ARGUMENTS environment variable.
+func (r *RealToolRunner) Run(command, arguments string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = strings.NewReader(arguments)
+	cmd.Env = append(os.Environ(), toolArgumentsEnv+"="+arguments)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+
+	return stdout.String(), nil
+}
+
+// toolDefinitions returns the tools offered to the model: the configured shell
+// tools followed by the tools of the configured MCP servers.
+func (c *Client) toolDefinitions() []api.FunctionDefinition {
+	var result []api.FunctionDefinition
+	for _, tool := range c.Config.Tools {
+		result = append(result, api.FunctionDefinition{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		})
+	}
+	return append(result, c.mcpToolDefinitions()...)
+}
+
+// runToolCalls runs the tools the model asked for. It returns the assistant
+// message carrying the calls followed by one tool message per result, ready to
+// be sent back to the model. Failures are reported to the model as the result,
+// so it can correct itself or answer without the tool.
+func (c *Client) runToolCalls(reply Reply) []api.Message {
+	assistant := api.Message{
+		Role:      AssistantRole,
+		ToolCalls: reply.ToolCalls,
+	}
+	if reply.Text != "" {
+		assistant.Content = reply.Text
+	}
+
+	result := []api.Message{assistant}
+
+	for _, call := range reply.ToolCalls {
+		result = append(result, api.Message{
+			Role:       ToolRole,
+			ToolCallID: call.ID,
+			Content:    c.runToolCall(call),
+		})
+	}
+
+	return result
+}
+
+func (c *Client) runToolCall(call api.ToolCall) string {
+	sugar := zap.S()
+
+	for _, tool := range c.Config.Tools {
+		if tool.Name != call.Function.Name {
+			continue
+		}
+
+		sugar.Debugf("Running tool %s with arguments %s", call.Function.Name, call.Function.Arguments)
+
+		output, err := c.toolRunner.Run(tool.Command, call.Function.Arguments)
+		if err != nil {
+			return fmt.Sprintf("error: %v", err)
+		}
+		return output
+	}
+
+	if output, ok := c.callMCPTool(call); ok {
+		return output
+	}
+
+	return fmt.Sprintf("error: unknown tool %q", call.Function.Name)
+}
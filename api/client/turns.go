@@ -0,0 +1,81 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kardolus/chatgpt-cli/history"
+)
+
+const (
+	ErrNoExchange      = "thread %s has no query to take back"
+	ErrUnsupportedTurn = "the last query of thread %s is not text"
+)
+
+// LastQuery returns the last user message of the thread.
+func (c *Client) LastQuery() (string, error) {
+	index, err := c.lastQueryIndex()
+	if err != nil {
+		return "", err
+	}
+
+	query, _ := contentText(c.History[index].Content)
+	return query, nil
+}
+
+// RemoveLastExchange removes the last user message and everything after it
+// from the history and returns that message. The history store is only updated
+// when the next answer is stored, so a failed retry leaves the thread as it was.
+// The attachments of the message are kept for the next query.
+func (c *Client) RemoveLastExchange() (string, error) {
+	index, err := c.lastQueryIndex()
+	if err != nil {
+		return "", err
+	}
+
+	query, _ := contentText(c.History[index].Content)
+	c.pending = contentAttachments(c.History[index].Content)
+	c.History = c.History[:index]
+
+	return query, nil
+}
+
+// Undo removes the last exchange from the thread and returns the query that
+// started it.
+func (c *Client) Undo() (string, error) {
+	query, err := c.RemoveLastExchange()
+	if err != nil {
+		return "", err
+	}
+	c.pending = nil
+
+	if err := c.historyStore.Write(c.History); err != nil {
+		return "", err
+	}
+
+	_, _ = c.updateMetadata(func(*history.Metadata) {})
+
+	return query, nil
+}
+
+func (c *Client) lastQueryIndex() (int, error) {
+	if c.Config.OmitHistory {
+		return 0, errors.New(ErrHistoryTracking)
+	}
+
+	if err := c.initHistory(); err != nil {
+		return 0, err
+	}
+
+	for i := len(c.History) - 1; i > 0; i-- {
+		if c.History[i].Role != UserRole {
+			continue
+		}
+		if _, ok := contentText(c.History[i].Content); !ok {
+			return 0, fmt.Errorf(ErrUnsupportedTurn, c.historyStore.GetThread())
+		}
+		return i, nil
+	}
+
+	return 0, fmt.Errorf(ErrNoExchange, c.historyStore.GetThread())
+}
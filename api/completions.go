@@ -1,6 +1,9 @@
 package api
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 // Float64 is a custom type that wraps float64 and implements a custom YAML marshaller.
 type Float64 float64
@@ -14,21 +17,35 @@ func (f Float64) MarshalJSON() ([]byte, error) {
 }
 
 type CompletionsRequest struct {
-	Model            string    `json:"model"`
-	Temperature      float64   `json:"temperature,omitempty"`
-	TopP             float64   `json:"top_p,omitempty"`
-	FrequencyPenalty float64   `json:"frequency_penalty,omitempty"`
-	MaxTokens        int       `json:"max_completion_tokens"`
-	PresencePenalty  float64   `json:"presence_penalty,omitempty"`
-	Messages         []Message `json:"messages"`
-	Stream           bool      `json:"stream"`
-	Seed             int       `json:"seed,omitempty"`
+	Model            string            `json:"model"`
+	Temperature      float64           `json:"temperature,omitempty"`
+	TopP             float64           `json:"top_p,omitempty"`
+	FrequencyPenalty float64           `json:"frequency_penalty,omitempty"`
+	MaxTokens        int               `json:"max_completion_tokens"`
+	PresencePenalty  float64           `json:"presence_penalty,omitempty"`
+	Messages         []Message         `json:"messages"`
+	Stream           bool              `json:"stream"`
+	StreamOptions    *StreamOptions    `json:"stream_options,omitempty"`
+	Seed             int               `json:"seed,omitempty"`
+	Tools            []CompletionsTool `json:"tools,omitempty"`
+}
+
+// StreamOptions asks for a final chunk with the usage of a streamed completion.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type CompletionsTool struct {
+	Type     string             `json:"type"`
+	Function FunctionDefinition `json:"function"`
 }
 
 type Message struct {
-	Role    string      `json:"role"`
-	Name    string      `json:"name,omitempty"`
-	Content interface{} `json:"content"`
+	Role       string      `json:"role"`
+	Name       string      `json:"name,omitempty"`
+	Content    interface{} `json:"content"`
+	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
 }
 
 type AudioContent struct {
@@ -49,6 +66,67 @@ type ImageContent struct {
 	} `json:"image_url"`
 }
 
+// ContentPart is a typed part of a message: text, an image or audio. In history,
+// an attachment refers to its data by the hash of a blob instead of embedding
+// it, which is resolved before the message is sent. Blob, MediaType and Name
+// are never sent to an API.
+type ContentPart struct {
+	Type       string      `json:"type"`
+	Text       string      `json:"text,omitempty"`
+	ImageURL   *ImageURL   `json:"image_url,omitempty"`
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
+	Blob       string      `json:"blob,omitempty"`
+	MediaType  string      `json:"media_type,omitempty"`
+	Name       string      `json:"name,omitempty"`
+}
+
+type ImageURL struct {
+	URL string `json:"url"`
+}
+
+// ContentParts returns the parts of the content of a message, which is a string,
+// a list of parts or nil. Lists that were read back from JSON, and the image and
+// audio contents of older versions, are converted to parts.
+func ContentParts(content interface{}) []ContentPart {
+	switch c := content.(type) {
+	case nil:
+		return nil
+	case string:
+		return []ContentPart{{Type: "text", Text: c}}
+	case []ContentPart:
+		return c
+	}
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		return []ContentPart{{Type: "text", Text: fmt.Sprint(content)}}
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return []ContentPart{{Type: "text", Text: string(data)}}
+	}
+
+	var result []ContentPart
+	for _, item := range raw {
+		var part ContentPart
+		if err := json.Unmarshal(item, &part); err != nil {
+			// the responses API sends image_url as a plain string
+			var alt struct {
+				Type     string `json:"type"`
+				ImageURL string `json:"image_url"`
+			}
+			if json.Unmarshal(item, &alt) != nil {
+				continue
+			}
+			part = ContentPart{Type: alt.Type, ImageURL: &ImageURL{URL: alt.ImageURL}}
+		}
+		result = append(result, part)
+	}
+
+	return result
+}
+
 type CompletionsResponse struct {
 	ID      string   `json:"id"`
 	Object  string   `json:"object"`
@@ -59,9 +137,15 @@ type CompletionsResponse struct {
 }
 
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+	CompletionTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
 }
 
 type Choice struct {
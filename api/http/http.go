@@ -1,20 +1,20 @@
 package http
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/kardolus/chatgpt-cli/api"
 	"github.com/kardolus/chatgpt-cli/config"
 	"github.com/kardolus/chatgpt-cli/internal"
-	"go.uber.org/zap"
 )
 
 const (
@@ -23,259 +23,145 @@ const (
 	errFailedToMakeRequest   = "failed to make request: %w"
 	errHTTP                  = "http status %d: %s"
 	errHTTPStatus            = "http status: %d"
+	keepAlive                = 30 * time.Second
 )
 
+// Caller sends requests to the API. Every request is bound to ctx, so cancelling
+// the context aborts it, including a stream that is still being read.
 type Caller interface {
-	Post(url string, body []byte, stream bool) ([]byte, error)
-	PostWithHeaders(url string, body []byte, headers map[string]string) ([]byte, error)
-	Get(url string) ([]byte, error)
+	Post(ctx context.Context, url string, body []byte) ([]byte, error)
+	PostStream(ctx context.Context, url string, body []byte) (io.ReadCloser, error)
+	PostWithHeaders(ctx context.Context, url string, body []byte, headers map[string]string) ([]byte, error)
+	Get(ctx context.Context, url string) ([]byte, error)
 }
 
 type RestCaller struct {
-	client *http.Client
-	config config.Config
+	client       *http.Client
+	streamClient *http.Client
+	config       config.Config
+	sleep        func(ctx context.Context, d time.Duration) error
 }
 
 // Ensure RestCaller implements Caller interface
 var _ Caller = &RestCaller{}
 
+// New creates a caller for cfg. The request_timeout bounds a request from sending
+// it until its answer was read, and the connect_timeout bounds establishing the
+// connection. A stream is only bound by the request_timeout until the response
+// headers arrive, so a long answer is not cut off. A timeout of zero means no
+// limit.
 func New(cfg config.Config) *RestCaller {
-	var client *http.Client
+	transport := http.DefaultTransport.(*http.Transport).Clone()
 	if cfg.SkipTLSVerify {
-		transport := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		client = &http.Client{
-			Transport: transport,
-		}
-	} else {
-		client = &http.Client{}
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
+	if cfg.ConnectTimeout > 0 {
+		timeout := time.Duration(cfg.ConnectTimeout) * time.Second
+		transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: keepAlive}).DialContext
+		transport.TLSHandshakeTimeout = timeout
+	}
+
+	timeout := time.Duration(cfg.RequestTimeout) * time.Second
+	streamTransport := transport.Clone()
+	streamTransport.ResponseHeaderTimeout = timeout
 
 	return &RestCaller{
-		client: client,
-		config: cfg,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+		streamClient: &http.Client{Transport: streamTransport},
+		config:       cfg,
+		sleep:        sleepContext,
 	}
 }
 
+// WithSleep replaces the function used to wait between retries. It returns an
+// error when ctx ends before the wait is over.
+func (r *RestCaller) WithSleep(sleep func(ctx context.Context, d time.Duration) error) *RestCaller {
+	r.sleep = sleep
+	return r
+}
+
 type CallerFactory func(cfg config.Config) Caller
 
 func RealCallerFactory(cfg config.Config) Caller {
 	return New(cfg)
 }
 
-func (r *RestCaller) Get(url string) ([]byte, error) {
-	return r.doRequest(http.MethodGet, url, nil, false)
+func (r *RestCaller) Get(ctx context.Context, url string) ([]byte, error) {
+	return r.doRequest(ctx, http.MethodGet, url, nil)
 }
 
-func (r *RestCaller) Post(url string, body []byte, stream bool) ([]byte, error) {
-	return r.doRequest(http.MethodPost, url, body, stream)
+func (r *RestCaller) Post(ctx context.Context, url string, body []byte) ([]byte, error) {
+	return r.doRequest(ctx, http.MethodPost, url, body)
 }
 
-func (r *RestCaller) PostWithHeaders(url string, body []byte, headers map[string]string) ([]byte, error) {
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, fmt.Errorf(errFailedToCreateRequest, err)
+// PostStream sends the request and hands back the open response body so the caller
+// can decode the event stream as it arrives. The caller must close the body. A
+// stream that breaks before its first byte is sent again.
+func (r *RestCaller) PostStream(ctx context.Context, url string, body []byte) (io.ReadCloser, error) {
+	newRequest := func() (*http.Request, error) {
+		return r.newRequest(ctx, http.MethodPost, url, body)
 	}
 
-	// Add custom headers
-	for k, v := range headers {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := r.client.Do(req)
+	response, err := r.sendStream(ctx, newRequest)
 	if err != nil {
-		return nil, fmt.Errorf(errFailedToMakeRequest, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		errorResponse, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf(errHTTPStatus, resp.StatusCode)
-		}
-
-		var errorData api.ErrorResponse
-		if err := json.Unmarshal(errorResponse, &errorData); err != nil {
-			return nil, fmt.Errorf(errHTTPStatus, resp.StatusCode)
-		}
-
-		return errorResponse, fmt.Errorf(errHTTP, resp.StatusCode, errorData.Error.Message)
+		return nil, err
 	}
 
-	return io.ReadAll(resp.Body)
-}
-
-func (r *RestCaller) ProcessResponse(reader io.Reader, writer io.Writer, endpoint string) []byte {
-	if strings.Contains(endpoint, r.config.ResponsesPath) {
-		return r.processResponsesSSE(reader, writer)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		defer response.Body.Close()
+		_, err := readErrorResponse(response)
+		return nil, err
 	}
-	return r.processLegacy(reader, writer)
-}
-
-func (r *RestCaller) processLegacy(reader io.Reader, writer io.Writer) []byte {
-	var result []byte
-	sugar := zap.S()
-	sugar.Debugln("\nResponse\n")
-
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		line := scanner.Text()
 
-		if zap.L().Core().Enabled(zap.DebugLevel) {
-			sugar.Debugln(line)
-			continue
-		}
-
-		if strings.HasPrefix(line, "data:") {
-			line = line[6:] // Skip the "data: " prefix
-			if len(line) < 6 {
-				continue
-			}
-			if line == "[DONE]" {
-				_, _ = writer.Write([]byte("\n"))
-				result = append(result, '\n')
-				break
-			}
-			var data api.Data
-			if err := json.Unmarshal([]byte(line), &data); err != nil {
-				_, _ = fmt.Fprintf(writer, "Error: %s\n", err.Error())
-				continue
-			}
-			for _, choice := range data.Choices {
-				if content, ok := choice.Delta["content"].(string); ok {
-					_, _ = writer.Write([]byte(content))
-					result = append(result, content...)
-				}
-			}
-		}
-	}
-	return result
+	return &retryingStream{
+		ctx:        ctx,
+		caller:     r,
+		newRequest: newRequest,
+		body:       response.Body,
+		retries:    r.maxAttempts() - 1,
+	}, nil
 }
 
-func (r *RestCaller) processResponsesSSE(reader io.Reader, writer io.Writer) []byte {
-	var (
-		result   []byte
-		curEvent string
-		done     bool
-		sugar    = zap.S()
-	)
-
-	sugar.Debugln("\nResponse\n")
-
-	scanner := bufio.NewScanner(reader)
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		if zap.L().Core().Enabled(zap.DebugLevel) {
-			sugar.Debugln(line)
-			continue
+func (r *RestCaller) PostWithHeaders(ctx context.Context, url string, body []byte, headers map[string]string) ([]byte, error) {
+	resp, err := r.send(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
+		if err != nil {
+			return nil, err
 		}
 
-		if strings.HasPrefix(line, ":") {
-			continue
+		// Add custom headers
+		for k, v := range headers {
+			req.Header.Set(k, v)
 		}
 
-		switch {
-		case strings.HasPrefix(line, "event:"):
-			curEvent = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
-			continue
-
-		case strings.HasPrefix(line, "data:"):
-			payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
-
-			if curEvent == "" {
-				if payload == "[DONE]" {
-					_, _ = writer.Write([]byte("\n"))
-					result = append(result, '\n')
-					done = true
-					break
-				}
-				var legacy struct {
-					Choices []struct {
-						Delta map[string]any `json:"delta"`
-					} `json:"choices"`
-				}
-				if err := json.Unmarshal([]byte(payload), &legacy); err != nil {
-					_, _ = fmt.Fprintf(writer, "Error: %s\n", err.Error())
-					continue
-				}
-				for _, ch := range legacy.Choices {
-					if s, ok := ch.Delta["content"].(string); ok && s != "" {
-						_, _ = writer.Write([]byte(s))
-						result = append(result, s...)
-					}
-				}
-				continue
-			}
-
-			var env struct {
-				Type     string `json:"type"`
-				Delta    string `json:"delta"` // response.output_text.delta
-				Text     string `json:"text"`  // response.output_text.done/content_part.done (optional)
-				Response struct {
-					Status string `json:"status"`
-				} `json:"response"`
-			}
-			if err := json.Unmarshal([]byte(payload), &env); err != nil {
-				_, _ = fmt.Fprintf(writer, "Error: %s\n", err.Error())
-				continue
-			}
-
-			switch env.Type {
-			case "response.output_text.delta":
-				if env.Delta != "" {
-					_, _ = writer.Write([]byte(env.Delta))
-					result = append(result, env.Delta...)
-				}
-			case "response.completed":
-				if len(result) == 0 || !bytes.HasSuffix(result, []byte("\n")) {
-					_, _ = writer.Write([]byte("\n"))
-					result = append(result, '\n')
-				}
-				done = true
-			default:
-				// ignore other SSE types
-			}
-		}
+		return req, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-		if done {
-			break
-		}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return readErrorResponse(resp)
 	}
-	return result
+
+	return io.ReadAll(resp.Body)
 }
-func (r *RestCaller) doRequest(method, url string, body []byte, stream bool) ([]byte, error) {
-	req, err := r.newRequest(method, url, body)
-	if err != nil {
-		return nil, fmt.Errorf(errFailedToCreateRequest, err)
-	}
 
-	response, err := r.client.Do(req)
+func (r *RestCaller) doRequest(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	response, err := r.send(ctx, func() (*http.Request, error) {
+		return r.newRequest(ctx, method, url, body)
+	})
 	if err != nil {
-		return nil, fmt.Errorf(errFailedToMakeRequest, err)
+		return nil, err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		errorResponse, err := io.ReadAll(response.Body)
-		if err != nil {
-			return nil, fmt.Errorf(errHTTPStatus, response.StatusCode)
-		}
-
-		var errorData api.ErrorResponse
-		if err := json.Unmarshal(errorResponse, &errorData); err != nil {
-			return nil, fmt.Errorf(errHTTPStatus, response.StatusCode)
-		}
-
-		return errorResponse, fmt.Errorf(errHTTP, response.StatusCode, errorData.Error.Message)
-	}
-
-	if stream {
-		return r.ProcessResponse(response.Body, os.Stdout, url), nil
+		return readErrorResponse(response)
 	}
 
 	result, err := io.ReadAll(response.Body)
@@ -286,13 +172,38 @@ func (r *RestCaller) doRequest(method, url string, body []byte, stream bool) ([]
 	return result, nil
 }
 
-func (r *RestCaller) newRequest(method, url string, body []byte) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+func (r *RestCaller) send(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	return r.sendWith(ctx, r.client, newRequest)
+}
+
+// sendStream sends a request whose answer is streamed, see New.
+func (r *RestCaller) sendStream(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	return r.sendWith(ctx, r.streamClient, newRequest)
+}
+
+func (r *RestCaller) sendWith(ctx context.Context, client *http.Client, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	return r.do(ctx, client, func() (*http.Request, error) {
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf(errFailedToCreateRequest, err)
+		}
+		return req, nil
+	})
+}
+
+func (r *RestCaller) newRequest(ctx context.Context, method, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
 
-	if r.config.APIKey != "" {
+	if r.isMessagesEndpoint(url) {
+		// the messages API authenticates with x-api-key instead of the configured auth header
+		if r.config.APIKey != "" {
+			req.Header.Set(internal.HeaderAPIKeyKey, r.config.APIKey)
+		}
+		req.Header.Set(internal.HeaderAnthropicVersionKey, r.config.AnthropicVersion)
+	} else if r.config.APIKey != "" {
 		req.Header.Set(r.config.AuthHeader, r.config.AuthTokenPrefix+r.config.APIKey)
 	}
 	req.Header.Set(internal.HeaderContentTypeKey, internal.HeaderContentTypeValue)
@@ -304,3 +215,21 @@ func (r *RestCaller) newRequest(method, url string, body []byte) (*http.Request,
 
 	return req, nil
 }
+
+func (r *RestCaller) isMessagesEndpoint(endpoint string) bool {
+	return r.config.MessagesPath != "" && strings.Contains(endpoint, r.config.MessagesPath)
+}
+
+func readErrorResponse(response *http.Response) ([]byte, error) {
+	errorResponse, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf(errHTTPStatus, response.StatusCode)
+	}
+
+	var errorData api.ErrorResponse
+	if err := json.Unmarshal(errorResponse, &errorData); err != nil {
+		return nil, fmt.Errorf(errHTTPStatus, response.StatusCode)
+	}
+
+	return errorResponse, fmt.Errorf(errHTTP, response.StatusCode, errorData.Error.Message)
+}
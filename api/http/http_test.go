@@ -1,11 +1,12 @@
 package http_test
 
 import (
-	"bytes"
+	"context"
+	"io"
 	stdhttp "net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
+	"time"
 
 	"github.com/kardolus/chatgpt-cli/api/http"
 	chatgpthttp "github.com/kardolus/chatgpt-cli/api/http"
@@ -20,82 +21,328 @@ func TestUnitHTTP(t *testing.T) {
 }
 
 func testHTTP(t *testing.T, when spec.G, it spec.S) {
-	var subject http.RestCaller
-
-	const responsesPath = "/v1/responses" // use http.ResponsesPath if you export it
-
 	it.Before(func() {
 		RegisterTestingT(t)
-		subject = http.RestCaller{}
 	})
 
-	when("ProcessResponse()", func() {
-		it("parses a legacy stream as expected (any endpoint)", func() {
-			buf := &bytes.Buffer{}
-			// legacy works via both branches; use a non-responses endpoint to
-			// ensure we exercise the original/legacy code path.
-			subject.ProcessResponse(strings.NewReader(legacyStream), buf, "/v1/chat/completions")
-			output := buf.String()
-			Expect(output).To(Equal("a b c\n"))
+	when("PostStream()", func() {
+		it("hands back the open response body", func() {
+			server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				w.WriteHeader(stdhttp.StatusOK)
+				_, _ = w.Write([]byte("data: [DONE]\n"))
+			}))
+			defer server.Close()
+
+			subject := http.New(config.Config{})
+			body, err := subject.PostStream(context.Background(), server.URL, []byte(`{"stream": true}`))
+			Expect(err).NotTo(HaveOccurred())
+			defer body.Close()
+
+			raw, err := io.ReadAll(body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(raw)).To(Equal("data: [DONE]\n"))
 		})
 
-		it("parses a GPT-5 SSE stream when endpoint is /v1/responses", func() {
-			buf := &bytes.Buffer{}
-			subject.ProcessResponse(strings.NewReader(gpt5Stream), buf, responsesPath)
-			output := buf.String()
-			// deltas are "a", " b", " c" then response.completed -> newline
-			Expect(output).To(Equal("a b c\n"))
+		it("returns the API error when the status is not successful", func() {
+			server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				w.WriteHeader(stdhttp.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":{"message":"bad request"}}`))
+			}))
+			defer server.Close()
+
+			subject := http.New(config.Config{})
+			body, err := subject.PostStream(context.Background(), server.URL, []byte(`{"stream": true}`))
+			Expect(body).To(BeNil())
+			Expect(err).To(MatchError("http status 400: bad request"))
 		})
+	})
 
-		it("throws an error when the legacy json is invalid", func() {
-			input := `data: {"invalid":"json"` // missing closing brace
-			expectedOutput := "Error: unexpected end of JSON input\n"
+	when("the context ends", func() {
+		it("aborts a request that is in flight", func() {
+			release := make(chan struct{})
+			server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				<-release
+			}))
+			defer server.Close()
+			defer close(release)
 
-			var buf bytes.Buffer
-			subject.ProcessResponse(strings.NewReader(input), &buf, "/v1/chat/completions")
-			output := buf.String()
-			Expect(output).To(Equal(expectedOutput))
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+
+			_, err := http.New(config.Config{}).Post(ctx, server.URL, nil)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		it("aborts a stream that is being read", func() {
+			release := make(chan struct{})
+			server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				_, _ = w.Write([]byte("data: partial\n"))
+				w.(stdhttp.Flusher).Flush()
+				<-release
+			}))
+			defer server.Close()
+			defer close(release)
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			body, err := http.New(config.Config{MaxAttempts: 3}).PostStream(ctx, server.URL, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer body.Close()
+
+			buf := make([]byte, 64)
+			n, err := body.Read(buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(buf[:n])).To(Equal("data: partial\n"))
+
+			cancel()
+			_, err = io.ReadAll(body)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		it("stops waiting for a retry", func() {
+			server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(stdhttp.StatusTooManyRequests)
+			}))
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+
+			_, err := http.New(config.Config{MaxAttempts: 3}).Get(ctx, server.URL)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		it("gives up when the request timeout is reached", func() {
+			release := make(chan struct{})
+			server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				<-release
+			}))
+			defer server.Close()
+			defer close(release)
+
+			_, err := http.New(config.Config{RequestTimeout: 1}).Post(context.Background(), server.URL, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("Client.Timeout exceeded"))
+		})
+
+		it("gives up on a stream whose response headers do not arrive in time", func() {
+			release := make(chan struct{})
+			server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				<-release
+			}))
+			defer server.Close()
+			defer close(release)
+
+			_, err := http.New(config.Config{RequestTimeout: 1}).PostStream(context.Background(), server.URL, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("timeout awaiting response headers"))
+		})
+
+		it("keeps reading a stream that takes longer than the request timeout", func() {
+			server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				for _, line := range []string{"data: first\n", "data: second\n"} {
+					_, _ = w.Write([]byte(line))
+					w.(stdhttp.Flusher).Flush()
+					time.Sleep(700 * time.Millisecond)
+				}
+			}))
+			defer server.Close()
+
+			body, err := http.New(config.Config{RequestTimeout: 1}).PostStream(context.Background(), server.URL, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer body.Close()
+
+			raw, err := io.ReadAll(body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(raw)).To(Equal("data: first\ndata: second\n"))
 		})
 	})
-}
 
-const legacyStream = `
-data: {"id":"id-1","object":"chat.completion.chunk","created":1,"model":"model-1","choices":[{"delta":{"role":"assistant"},"index":0,"finish_reason":null}]}
+	when("retrying", func() {
+		var sleeps []time.Duration
+
+		record := func(_ context.Context, d time.Duration) error {
+			sleeps = append(sleeps, d)
+			return nil
+		}
+
+		// failing answers the first n requests with status and then succeeds
+		failing := func(n, status int, header map[string]string, calls *int) *httptest.Server {
+			return httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				*calls++
+				if *calls <= n {
+					for key, value := range header {
+						w.Header().Set(key, value)
+					}
+					w.WriteHeader(status)
+					_, _ = w.Write([]byte(`{"error":{"message":"try again"}}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"success": true}`))
+			}))
+		}
 
-data: {"id":"id-2","object":"chat.completion.chunk","created":2,"model":"model-1","choices":[{"delta":{"content":"a"},"index":0,"finish_reason":null}]}
+		it.Before(func() {
+			sleeps = nil
+		})
 
-data: {"id":"id-3","object":"chat.completion.chunk","created":3,"model":"model-1","choices":[{"delta":{"content":" b"},"index":0,"finish_reason":null}]}
+		it("waits as long as Retry-After asks", func() {
+			var calls int
+			server := failing(1, stdhttp.StatusTooManyRequests, map[string]string{"Retry-After": "2"}, &calls)
+			defer server.Close()
 
-data: {"id":"id-4","object":"chat.completion.chunk","created":4,"model":"model-1","choices":[{"delta":{"content":" c"},"index":0,"finish_reason":null}]}
+			subject := http.New(config.Config{MaxAttempts: 3}).WithSleep(record)
+			result, err := subject.Post(context.Background(), server.URL, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(result)).To(Equal(`{"success": true}`))
+			Expect(calls).To(Equal(2))
+			Expect(sleeps).To(Equal([]time.Duration{2 * time.Second}))
+		})
 
-data: {"id":"id-5","object":"chat.completion.chunk","created":5,"model":"model-1","choices":[{"delta":{},"index":0,"finish_reason":"stop"}]}
+		it("waits for the later of the rate limit resets", func() {
+			var calls int
+			server := failing(1, stdhttp.StatusTooManyRequests, map[string]string{
+				"x-ratelimit-reset-requests": "1s",
+				"x-ratelimit-reset-tokens":   "1m30s",
+			}, &calls)
+			defer server.Close()
 
-data: [DONE]
-`
+			subject := http.New(config.Config{MaxAttempts: 3}).WithSleep(record)
+			_, err := subject.Get(context.Background(), server.URL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sleeps).To(Equal([]time.Duration{90 * time.Second}))
+		})
 
-// Minimal GPT-5 SSE that your new parser should handle
-const gpt5Stream = `
-event: response.created
-data: {"type":"response.created"}
+		it("caps the delay the server asks for when there is no retry deadline", func() {
+			var calls int
+			server := failing(1, stdhttp.StatusTooManyRequests, map[string]string{
+				"Retry-After":              "86400",
+				"x-ratelimit-reset-tokens": "6m0s",
+			}, &calls)
+			defer server.Close()
 
-event: response.output_item.added
-data: {"type":"response.output_item.added","output_index":0,"item":{"id":"msg_1","type":"message","status":"in_progress","content":[],"role":"assistant"}}
+			subject := http.New(config.Config{MaxAttempts: 3}).WithSleep(record)
+			_, err := subject.Post(context.Background(), server.URL, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(Equal(2))
+			Expect(sleeps).To(Equal([]time.Duration{2 * time.Minute}))
+		})
 
-event: response.content_part.added
-data: {"type":"response.content_part.added","item_id":"msg_1","output_index":0,"content_index":0,"part":{"type":"output_text","annotations":[],"logprobs":[],"text":""}}
+		it("backs off with jitter on server errors", func() {
+			var calls int
+			server := failing(2, stdhttp.StatusServiceUnavailable, nil, &calls)
+			defer server.Close()
 
-event: response.output_text.delta
-data: {"type":"response.output_text.delta","item_id":"msg_1","output_index":0,"content_index":0,"delta":"a"}
+			subject := http.New(config.Config{MaxAttempts: 3}).WithSleep(record)
+			_, err := subject.PostWithHeaders(context.Background(), server.URL, nil, map[string]string{"X-Test": "1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(Equal(3))
+			Expect(sleeps).To(HaveLen(2))
+			Expect(sleeps[0]).To(BeNumerically("~", 375*time.Millisecond, 125*time.Millisecond))
+			Expect(sleeps[1]).To(BeNumerically("~", 750*time.Millisecond, 250*time.Millisecond))
+		})
 
-event: response.output_text.delta
-data: {"type":"response.output_text.delta","item_id":"msg_1","output_index":0,"content_index":0,"delta":" b"}
+		it("returns the last error once the attempts are used up", func() {
+			var calls int
+			server := failing(5, stdhttp.StatusInternalServerError, nil, &calls)
+			defer server.Close()
 
-event: response.output_text.delta
-data: {"type":"response.output_text.delta","item_id":"msg_1","output_index":0,"content_index":0,"delta":" c"}
+			subject := http.New(config.Config{MaxAttempts: 2}).WithSleep(record)
+			_, err := subject.Post(context.Background(), server.URL, nil)
+			Expect(err).To(MatchError("http status 500: try again"))
+			Expect(calls).To(Equal(2))
+			Expect(sleeps).To(HaveLen(1))
+		})
 
-event: response.completed
-data: {"type":"response.completed","response":{"status":"completed"}}
-`
+		it("does not wait past the retry deadline", func() {
+			var calls int
+			server := failing(1, stdhttp.StatusTooManyRequests, map[string]string{"Retry-After": "120"}, &calls)
+			defer server.Close()
+
+			subject := http.New(config.Config{MaxAttempts: 3, RetryDeadline: 60}).WithSleep(record)
+			_, err := subject.Post(context.Background(), server.URL, nil)
+			Expect(err).To(MatchError("http status 429: try again"))
+			Expect(calls).To(Equal(1))
+			Expect(sleeps).To(BeEmpty())
+		})
+
+		it("does not retry client errors", func() {
+			var calls int
+			server := failing(1, stdhttp.StatusBadRequest, nil, &calls)
+			defer server.Close()
+
+			subject := http.New(config.Config{MaxAttempts: 3}).WithSleep(record)
+			_, err := subject.Post(context.Background(), server.URL, nil)
+			Expect(err).To(MatchError("http status 400: try again"))
+			Expect(calls).To(Equal(1))
+		})
+
+		it("retries a stream that was rejected", func() {
+			var calls int
+			server := failing(1, stdhttp.StatusServiceUnavailable, nil, &calls)
+			defer server.Close()
+
+			subject := http.New(config.Config{MaxAttempts: 3}).WithSleep(record)
+			body, err := subject.PostStream(context.Background(), server.URL, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer body.Close()
+
+			raw, err := io.ReadAll(body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(raw)).To(Equal(`{"success": true}`))
+			Expect(calls).To(Equal(2))
+		})
+
+		when("the connection drops", func() {
+			// dropping breaks the connection after announcing more bytes than it sent
+			dropping := func(sent string, calls *int) *httptest.Server {
+				return httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+					*calls++
+					if *calls > 1 {
+						_, _ = w.Write([]byte("data: [DONE]\n"))
+						return
+					}
+					conn, _, _ := w.(stdhttp.Hijacker).Hijack()
+					_, _ = conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n" + sent))
+					_ = conn.Close()
+				}))
+			}
+
+			it("re-sends the stream when nothing was received", func() {
+				var calls int
+				server := dropping("", &calls)
+				defer server.Close()
+
+				subject := http.New(config.Config{MaxAttempts: 2}).WithSleep(record)
+				body, err := subject.PostStream(context.Background(), server.URL, nil)
+				Expect(err).NotTo(HaveOccurred())
+				defer body.Close()
+
+				raw, err := io.ReadAll(body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(raw)).To(Equal("data: [DONE]\n"))
+				Expect(calls).To(Equal(2))
+			})
+
+			it("does not re-send the stream once data was received", func() {
+				var calls int
+				server := dropping("data: partial", &calls)
+				defer server.Close()
+
+				subject := http.New(config.Config{MaxAttempts: 2}).WithSleep(record)
+				body, err := subject.PostStream(context.Background(), server.URL, nil)
+				Expect(err).NotTo(HaveOccurred())
+				defer body.Close()
+
+				raw, err := io.ReadAll(body)
+				Expect(err).To(HaveOccurred())
+				Expect(string(raw)).To(Equal("data: partial"))
+				Expect(calls).To(Equal(1))
+			})
+		})
+	})
+}
 
 func TestUnitCustomHeaders(t *testing.T) {
 	spec.Run(t, "Testing Custom Headers", testCustomHeaders, spec.Report(report.Terminal{}))
@@ -126,7 +373,7 @@ func testCustomHeaders(t *testing.T, when spec.G, it spec.S) {
 			}
 
 			subject := chatgpthttp.New(cfg)
-			_, err := subject.Post(server.URL, []byte(`{"test": "data"}`), false)
+			_, err := subject.Post(context.Background(), server.URL, []byte(`{"test": "data"}`))
 
 			Expect(err).ToNot(HaveOccurred())
 			Expect(receivedHeaders.Get("X-Custom-Header")).To(Equal("custom-value"))
@@ -152,7 +399,7 @@ func testCustomHeaders(t *testing.T, when spec.G, it spec.S) {
 			}
 
 			subject := chatgpthttp.New(cfg)
-			_, err := subject.Get(server.URL)
+			_, err := subject.Get(context.Background(), server.URL)
 
 			Expect(err).ToNot(HaveOccurred())
 			Expect(receivedHeaders.Get("X-API-Version")).To(Equal("v2"))
@@ -175,7 +422,7 @@ func testCustomHeaders(t *testing.T, when spec.G, it spec.S) {
 			}
 
 			subject := chatgpthttp.New(cfg)
-			_, err := subject.Post(server.URL, []byte(`{"test": "data"}`), false)
+			_, err := subject.Post(context.Background(), server.URL, []byte(`{"test": "data"}`))
 
 			Expect(err).ToNot(HaveOccurred())
 			Expect(receivedHeaders).ToNot(BeNil())
@@ -197,7 +444,7 @@ func testCustomHeaders(t *testing.T, when spec.G, it spec.S) {
 			}
 
 			subject := chatgpthttp.New(cfg)
-			_, err := subject.Post(server.URL, []byte(`{"test": "data"}`), false)
+			_, err := subject.Post(context.Background(), server.URL, []byte(`{"test": "data"}`))
 
 			Expect(err).ToNot(HaveOccurred())
 			Expect(receivedHeaders).ToNot(BeNil())
@@ -225,12 +472,44 @@ func testCustomHeaders(t *testing.T, when spec.G, it spec.S) {
 			}
 
 			subject := chatgpthttp.New(cfg)
-			_, err := subject.Post(server.URL, []byte(`{"test": "data"}`), false)
+			_, err := subject.Post(context.Background(), server.URL, []byte(`{"test": "data"}`))
 
 			Expect(err).ToNot(HaveOccurred())
 			Expect(receivedHeaders.Get("Authorization")).To(Equal("Bearer test-key"))
 			Expect(receivedHeaders.Get("User-Agent")).To(Equal("TestAgent/1.0"))
 			Expect(receivedHeaders.Get("X-Custom-Header")).To(Equal("custom-value"))
 		})
+
+		it("sends x-api-key and anthropic-version to the messages endpoint", func() {
+			t.Parallel()
+
+			var receivedHeaders stdhttp.Header
+			server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				receivedHeaders = r.Header
+				w.WriteHeader(stdhttp.StatusOK)
+				_, _ = w.Write([]byte(`{"success": true}`))
+			}))
+			defer server.Close()
+
+			cfg := config.Config{
+				APIKey:           "test-key",
+				AuthHeader:       "Authorization",
+				AuthTokenPrefix:  "Bearer ",
+				MessagesPath:     "/v1/messages",
+				AnthropicVersion: "2023-06-01",
+				CustomHeaders: map[string]string{
+					"X-Custom-Header": "custom-value",
+				},
+			}
+
+			subject := chatgpthttp.New(cfg)
+			_, err := subject.Post(context.Background(), server.URL+"/v1/messages", []byte(`{"test": "data"}`))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(receivedHeaders.Get("x-api-key")).To(Equal("test-key"))
+			Expect(receivedHeaders.Get("anthropic-version")).To(Equal("2023-06-01"))
+			Expect(receivedHeaders.Get("Authorization")).To(BeEmpty())
+			Expect(receivedHeaders.Get("X-Custom-Header")).To(Equal("custom-value"))
+		})
 	})
 }
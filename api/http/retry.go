@@ -0,0 +1,193 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	baseBackoff            = 500 * time.Millisecond
+	maxBackoff             = 30 * time.Second
+	maxRetryDelay          = 2 * time.Minute
+	headerRetryAfter       = "Retry-After"
+	headerRetryAfterMs     = "Retry-After-Ms"
+	headerRateLimitReset   = "X-Ratelimit-Reset-"
+	rateLimitResetRequests = headerRateLimitReset + "Requests"
+	rateLimitResetTokens   = headerRateLimitReset + "Tokens"
+)
+
+// do sends the request built by newRequest. Rate limits and server errors are
+// retried with jittered exponential backoff, or after the delay the server asks
+// for, until max_attempts is reached or the next attempt would start after the
+// retry_deadline. The last response is returned as is.
+func (r *RestCaller) do(ctx context.Context, client *http.Client, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf(errFailedToMakeRequest, err)
+		}
+
+		if !isRetryable(response.StatusCode) || attempt >= r.maxAttempts() {
+			return response, nil
+		}
+
+		delay := retryDelay(response.Header, attempt)
+		if !r.withinDeadline(start, delay) {
+			return response, nil
+		}
+
+		_, _ = io.Copy(io.Discard, response.Body)
+		_ = response.Body.Close()
+
+		zap.S().Debugf("Retrying in %s after http status %d (attempt %d of %d)", delay, response.StatusCode, attempt, r.maxAttempts())
+		if err := r.sleep(ctx, delay); err != nil {
+			return nil, fmt.Errorf(errFailedToMakeRequest, err)
+		}
+	}
+}
+
+// sleepContext waits for d or until ctx ends, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (r *RestCaller) maxAttempts() int {
+	if r.config.MaxAttempts < 1 {
+		return 1
+	}
+	return r.config.MaxAttempts
+}
+
+// withinDeadline reports whether an attempt after delay still starts before the
+// retry deadline. A deadline of zero means no deadline.
+func (r *RestCaller) withinDeadline(start time.Time, delay time.Duration) bool {
+	if r.config.RetryDeadline <= 0 {
+		return true
+	}
+	deadline := start.Add(time.Duration(r.config.RetryDeadline) * time.Second)
+	return !time.Now().Add(delay).After(deadline)
+}
+
+func isRetryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// retryDelay returns the delay the server asked for in the Retry-After and
+// x-ratelimit-reset-* headers, or a jittered exponential backoff when there is
+// none. The delay never exceeds maxRetryDelay, so a request does not hang for
+// as long as the server asks when there is no retry deadline.
+func retryDelay(header http.Header, attempt int) time.Duration {
+	if delay, ok := requestedDelay(header); ok {
+		return min(delay, maxRetryDelay)
+	}
+
+	backoff := baseBackoff << (attempt - 1)
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+
+	// pick a delay between half and the full backoff so that parallel callers spread out
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func requestedDelay(header http.Header) (time.Duration, bool) {
+	if value := header.Get(headerRetryAfterMs); value != "" {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil && ms >= 0 {
+			return time.Duration(ms * float64(time.Millisecond)), true
+		}
+	}
+
+	if value := header.Get(headerRetryAfter); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(time.Until(date), 0), true
+		}
+	}
+
+	// OpenAI reports when the request and token limits reset, e.g. "1s" or "6m0s";
+	// wait for the later of the two as it is not known which one was hit
+	var (
+		result time.Duration
+		found  bool
+	)
+	for _, key := range []string{rateLimitResetRequests, rateLimitResetTokens} {
+		if delay, err := time.ParseDuration(strings.TrimSpace(header.Get(key))); err == nil {
+			result, found = max(result, delay), true
+		}
+	}
+
+	return result, found
+}
+
+// retryingStream re-sends a streaming request when the connection fails before
+// the first byte arrived. Once data was received the request is not repeated,
+// since the answer is already being consumed.
+type retryingStream struct {
+	ctx        context.Context
+	caller     *RestCaller
+	newRequest func() (*http.Request, error)
+	body       io.ReadCloser
+	received   bool
+	retries    int
+}
+
+func (s *retryingStream) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	if n > 0 {
+		s.received = true
+	}
+
+	if err == nil || errors.Is(err, io.EOF) || s.received || s.retries <= 0 || s.ctx.Err() != nil {
+		return n, err
+	}
+
+	s.retries--
+	_ = s.body.Close()
+
+	zap.S().Debugf("Retrying the stream after %v", err)
+
+	response, retryErr := s.caller.sendStream(s.ctx, s.newRequest)
+	if retryErr != nil {
+		return n, err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		defer response.Body.Close()
+		_, statusErr := readErrorResponse(response)
+		s.body = io.NopCloser(strings.NewReader(""))
+		return n, statusErr
+	}
+
+	s.body = response.Body
+	return s.Read(p)
+}
+
+func (s *retryingStream) Close() error {
+	return s.body.Close()
+}
@@ -1,5 +1,7 @@
 package api
 
+import "encoding/json"
+
 type MCPRequest struct {
 	Provider string
 	Function string
@@ -10,3 +12,101 @@ type MCPRequest struct {
 type ProxyConfiguration struct {
 	UseApifyProxy bool `json:"useApifyProxy"`
 }
+
+type JSONRPCRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      *int        `json:"id,omitempty"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// JSONRPCResponse is any message read from a server: a response, or a request or
+// notification sent by the server. ID is kept raw because servers may use strings.
+type JSONRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+}
+
+type JSONRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type MCPInitializeParams struct {
+	ProtocolVersion string                 `json:"protocolVersion"`
+	Capabilities    map[string]interface{} `json:"capabilities"`
+	ClientInfo      MCPImplementation      `json:"clientInfo"`
+}
+
+type MCPInitializeResult struct {
+	ProtocolVersion string                 `json:"protocolVersion"`
+	Capabilities    map[string]interface{} `json:"capabilities"`
+	ServerInfo      MCPImplementation      `json:"serverInfo"`
+}
+
+type MCPImplementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type MCPTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+type MCPListToolsResult struct {
+	Tools      []MCPTool `json:"tools"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+type MCPCallToolParams struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments,omitempty"`
+}
+
+type MCPCallToolResult struct {
+	Content []MCPContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+type MCPContent struct {
+	Type     string               `json:"type"`
+	Text     string               `json:"text,omitempty"`
+	Data     string               `json:"data,omitempty"`
+	MimeType string               `json:"mimeType,omitempty"`
+	Resource *MCPResourceContents `json:"resource,omitempty"`
+}
+
+type MCPReadResourceParams struct {
+	URI string `json:"uri"`
+}
+
+type MCPReadResourceResult struct {
+	Contents []MCPResourceContents `json:"contents"`
+}
+
+type MCPResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+type MCPGetPromptParams struct {
+	Name      string            `json:"name"`
+	Arguments map[string]string `json:"arguments,omitempty"`
+}
+
+type MCPGetPromptResult struct {
+	Description string             `json:"description,omitempty"`
+	Messages    []MCPPromptMessage `json:"messages"`
+}
+
+type MCPPromptMessage struct {
+	Role    string     `json:"role"`
+	Content MCPContent `json:"content"`
+}
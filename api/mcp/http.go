@@ -0,0 +1,202 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/internal"
+)
+
+const (
+	headerSessionID       = "Mcp-Session-Id"
+	headerProtocolVersion = "MCP-Protocol-Version"
+	headerAccept          = "Accept"
+	acceptValue           = "application/json, text/event-stream"
+	eventStreamType       = "text/event-stream"
+	sseDataPrefix         = "data:"
+	errHTTP               = "http status %d: %s"
+	errNoResponse         = "mcp server sent no response"
+)
+
+// httpTransport speaks the streamable HTTP transport: every message is POSTed to
+// the server URL, which answers with either a JSON body or an event stream that
+// carries the response.
+type httpTransport struct {
+	client          *http.Client
+	url             string
+	headers         map[string]string
+	sessionID       string
+	protocolVersion string
+}
+
+func newHTTPTransport(server config.MCPServerConfig) *httpTransport {
+	return &httpTransport{
+		client:  &http.Client{},
+		url:     server.URL,
+		headers: server.Headers,
+	}
+}
+
+func (t *httpTransport) send(id int, message []byte) (api.JSONRPCResponse, error) {
+	response, err := t.post(message)
+	if err != nil {
+		return api.JSONRPCResponse{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusAccepted {
+		return api.JSONRPCResponse{}, errors.New(errNoResponse)
+	}
+
+	if strings.HasPrefix(response.Header.Get(internal.HeaderContentTypeKey), eventStreamType) {
+		return t.readEventStream(response.Body, id)
+	}
+
+	var result api.JSONRPCResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return api.JSONRPCResponse{}, fmt.Errorf("failed to decode mcp response: %w", err)
+	}
+
+	return result, nil
+}
+
+func (t *httpTransport) notify(message []byte) error {
+	response, err := t.post(message)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (t *httpTransport) setProtocolVersion(version string) {
+	t.protocolVersion = version
+}
+
+// close ends the session on the server, if it handed one out.
+func (t *httpTransport) close() error {
+	if t.sessionID == "" {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, t.url, nil)
+	if err != nil {
+		return err
+	}
+	t.setHeaders(req)
+
+	response, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+func (t *httpTransport) post(message []byte) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(message))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set(internal.HeaderContentTypeKey, internal.HeaderContentTypeValue)
+	req.Header.Set(headerAccept, acceptValue)
+	t.setHeaders(req)
+
+	response, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf(errHTTP, response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if sessionID := response.Header.Get(headerSessionID); sessionID != "" {
+		t.sessionID = sessionID
+	}
+
+	return response, nil
+}
+
+func (t *httpTransport) setHeaders(req *http.Request) {
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	if t.sessionID != "" {
+		req.Header.Set(headerSessionID, t.sessionID)
+	}
+	if t.protocolVersion != "" {
+		req.Header.Set(headerProtocolVersion, t.protocolVersion)
+	}
+}
+
+// readEventStream reads events until the response to id arrives. Requests the
+// server sends along the way are answered with a separate POST.
+func (t *httpTransport) readEventStream(reader io.Reader, id int) (api.JSONRPCResponse, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, stdioBufferSize), stdioMaxLineSize)
+
+	var data []string
+
+	dispatch := func() (api.JSONRPCResponse, bool, error) {
+		if len(data) == 0 {
+			return api.JSONRPCResponse{}, false, nil
+		}
+		payload := strings.Join(data, "\n")
+		data = nil
+
+		var message api.JSONRPCResponse
+		if err := json.Unmarshal([]byte(payload), &message); err != nil {
+			return api.JSONRPCResponse{}, false, nil
+		}
+
+		if isResponseTo(message, id) {
+			return message, true, nil
+		}
+
+		if message.Method != "" && len(message.ID) > 0 {
+			reply, err := replyToServer(message)
+			if err != nil {
+				return api.JSONRPCResponse{}, false, err
+			}
+			if err := t.notify(reply); err != nil {
+				return api.JSONRPCResponse{}, false, err
+			}
+		}
+		return api.JSONRPCResponse{}, false, nil
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			if message, ok, err := dispatch(); ok || err != nil {
+				return message, err
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, sseDataPrefix) {
+			data = append(data, strings.TrimSpace(strings.TrimPrefix(line, sseDataPrefix)))
+		}
+	}
+
+	if message, ok, err := dispatch(); ok || err != nil {
+		return message, err
+	}
+
+	if err := scanner.Err(); err != nil {
+		return api.JSONRPCResponse{}, err
+	}
+
+	return api.JSONRPCResponse{}, errors.New(errNoResponse)
+}
@@ -0,0 +1,233 @@
+package mcp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/config"
+)
+
+const (
+	ProtocolVersion       = "2025-06-18"
+	clientName            = "chatgpt-cli"
+	clientVersion         = "1.0.0"
+	jsonRPCVersion        = "2.0"
+	methodInitialize      = "initialize"
+	methodInitialized     = "notifications/initialized"
+	methodListTools       = "tools/list"
+	methodCallTool        = "tools/call"
+	methodReadResource    = "resources/read"
+	methodGetPrompt       = "prompts/get"
+	methodPing            = "ping"
+	errMethodNotFound     = -32601
+	errMissingTransport   = "mcp server %q needs either a command or a url"
+	errRPC                = "mcp error %d: %s"
+	errFailedToDecode     = "failed to decode %s result: %w"
+	errFailedToInitialize = "failed to initialize mcp server %q: %w"
+)
+
+// Client speaks the Model Context Protocol to a single server.
+type Client interface {
+	ListTools() ([]api.MCPTool, error)
+	CallTool(name string, arguments map[string]interface{}) (api.MCPCallToolResult, error)
+	ReadResource(uri string) (api.MCPReadResourceResult, error)
+	GetPrompt(name string, arguments map[string]string) (api.MCPGetPromptResult, error)
+	Close() error
+}
+
+// ClientFactory connects to the server and runs the initialize handshake.
+type ClientFactory func(server config.MCPServerConfig) (Client, error)
+
+func RealClientFactory(server config.MCPServerConfig) (Client, error) {
+	var (
+		t   transport
+		err error
+	)
+
+	switch {
+	case server.Command != "":
+		t, err = newStdioTransport(server)
+	case server.URL != "":
+		t = newHTTPTransport(server)
+	default:
+		return nil, fmt.Errorf(errMissingTransport, server.Name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := newRPCClient(t)
+	if _, err := c.Initialize(); err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf(errFailedToInitialize, server.Name, err)
+	}
+
+	return c, nil
+}
+
+// transport carries JSON-RPC messages to a server. send delivers a request and
+// returns the response with the same id; notify delivers a notification, which
+// has no response.
+type transport interface {
+	send(id int, message []byte) (api.JSONRPCResponse, error)
+	notify(message []byte) error
+	setProtocolVersion(version string)
+	close() error
+}
+
+type RPCClient struct {
+	transport transport
+	mu        sync.Mutex
+	nextID    int
+}
+
+// Ensure RPCClient implements Client interface
+var _ Client = &RPCClient{}
+
+func newRPCClient(t transport) *RPCClient {
+	return &RPCClient{transport: t}
+}
+
+// Initialize negotiates the protocol version and announces the client.
+func (c *RPCClient) Initialize() (api.MCPInitializeResult, error) {
+	var result api.MCPInitializeResult
+
+	params := api.MCPInitializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]interface{}{},
+		ClientInfo: api.MCPImplementation{
+			Name:    clientName,
+			Version: clientVersion,
+		},
+	}
+
+	if err := c.call(methodInitialize, params, &result); err != nil {
+		return result, err
+	}
+
+	c.transport.setProtocolVersion(result.ProtocolVersion)
+
+	notification, err := json.Marshal(api.JSONRPCRequest{
+		JSONRPC: jsonRPCVersion,
+		Method:  methodInitialized,
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, c.transport.notify(notification)
+}
+
+// ListTools returns every tool of the server, following the pagination cursor.
+func (c *RPCClient) ListTools() ([]api.MCPTool, error) {
+	var (
+		tools  []api.MCPTool
+		cursor string
+	)
+
+	for {
+		var params interface{}
+		if cursor != "" {
+			params = map[string]string{"cursor": cursor}
+		}
+
+		var result api.MCPListToolsResult
+		if err := c.call(methodListTools, params, &result); err != nil {
+			return nil, err
+		}
+
+		tools = append(tools, result.Tools...)
+
+		if result.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+func (c *RPCClient) CallTool(name string, arguments map[string]interface{}) (api.MCPCallToolResult, error) {
+	var result api.MCPCallToolResult
+	err := c.call(methodCallTool, api.MCPCallToolParams{Name: name, Arguments: arguments}, &result)
+	return result, err
+}
+
+func (c *RPCClient) ReadResource(uri string) (api.MCPReadResourceResult, error) {
+	var result api.MCPReadResourceResult
+	err := c.call(methodReadResource, api.MCPReadResourceParams{URI: uri}, &result)
+	return result, err
+}
+
+func (c *RPCClient) GetPrompt(name string, arguments map[string]string) (api.MCPGetPromptResult, error) {
+	var result api.MCPGetPromptResult
+	err := c.call(methodGetPrompt, api.MCPGetPromptParams{Name: name, Arguments: arguments}, &result)
+	return result, err
+}
+
+func (c *RPCClient) Close() error {
+	return c.transport.close()
+}
+
+func (c *RPCClient) call(method string, params interface{}, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	id := c.nextID
+
+	message, err := json.Marshal(api.JSONRPCRequest{
+		JSONRPC: jsonRPCVersion,
+		ID:      &id,
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := c.transport.send(id, message)
+	if err != nil {
+		return err
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf(errRPC, response.Error.Code, response.Error.Message)
+	}
+
+	if len(response.Result) == 0 {
+		return errors.New("empty " + method + " result")
+	}
+
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf(errFailedToDecode, method, err)
+	}
+
+	return nil
+}
+
+// replyToServer answers a request the server sent to the client. Only ping is
+// supported; anything else is reported as an unknown method.
+func replyToServer(request api.JSONRPCResponse) ([]byte, error) {
+	reply := map[string]interface{}{
+		"jsonrpc": jsonRPCVersion,
+		"id":      request.ID,
+	}
+
+	if request.Method == methodPing {
+		reply["result"] = map[string]interface{}{}
+	} else {
+		reply["error"] = api.JSONRPCError{Code: errMethodNotFound, Message: "method not found: " + request.Method}
+	}
+
+	return json.Marshal(reply)
+}
+
+// isResponseTo reports whether message is the response to the request with id,
+// as opposed to a notification or a request of the server.
+func isResponseTo(message api.JSONRPCResponse, id int) bool {
+	return message.Method == "" && string(message.ID) == strconv.Itoa(id)
+}
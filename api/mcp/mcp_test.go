@@ -0,0 +1,304 @@
+package mcp_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	stdhttp "net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/api/mcp"
+	"github.com/kardolus/chatgpt-cli/config"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+const fakeServerEnv = "CHATGPT_CLI_FAKE_MCP_SERVER"
+
+// rpcMessage is what the fake servers read: a request, a notification or the
+// response of the client to a request of the server.
+type rpcMessage struct {
+	ID     json.RawMessage        `json:"id"`
+	Method string                 `json:"method"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// TestMain turns the test binary into a fake stdio server when the stdio tests
+// launch it with fakeServerEnv set.
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeServerEnv) != "" {
+		runFakeStdioServer()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestUnitMCP(t *testing.T) {
+	spec.Run(t, "Testing the MCP client", testMCP, spec.Report(report.Terminal{}))
+}
+
+func testMCP(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("RealClientFactory()", func() {
+		it("throws an error when the server has neither a command nor a url", func() {
+			_, err := mcp.RealClientFactory(config.MCPServerConfig{Name: "empty"})
+			Expect(err).To(MatchError(`mcp server "empty" needs either a command or a url`))
+		})
+		it("throws an error when the command cannot be started", func() {
+			_, err := mcp.RealClientFactory(config.MCPServerConfig{Name: "missing", Command: "/does/not/exist"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`failed to start mcp server "missing"`))
+		})
+	})
+
+	when("the server speaks streamable HTTP", func() {
+		var (
+			mu       sync.Mutex
+			requests []*stdhttp.Request
+			methods  []string
+		)
+
+		newServer := func(handle func(w stdhttp.ResponseWriter, request rpcMessage)) *httptest.Server {
+			mu.Lock()
+			requests, methods = nil, nil
+			mu.Unlock()
+
+			return httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				mu.Lock()
+				requests = append(requests, r)
+				mu.Unlock()
+
+				if r.Method == stdhttp.MethodDelete {
+					w.WriteHeader(stdhttp.StatusOK)
+					return
+				}
+
+				var request rpcMessage
+				Expect(json.NewDecoder(r.Body).Decode(&request)).To(Succeed())
+
+				mu.Lock()
+				methods = append(methods, request.Method)
+				mu.Unlock()
+
+				if request.ID == nil || request.Method == "" {
+					w.WriteHeader(stdhttp.StatusAccepted)
+					return
+				}
+
+				if request.Method == "initialize" {
+					w.Header().Set("Mcp-Session-Id", "session-1")
+					writeResult(w, request.ID, map[string]interface{}{
+						"protocolVersion": "2025-03-26",
+						"capabilities":    map[string]interface{}{},
+						"serverInfo":      map[string]string{"name": "fake", "version": "1"},
+					})
+					return
+				}
+
+				handle(w, request)
+			}))
+		}
+
+		it("runs the handshake and lists tools across pages", func() {
+			server := newServer(func(w stdhttp.ResponseWriter, request rpcMessage) {
+				if request.Params["cursor"] == "page-2" {
+					writeResult(w, request.ID, map[string]interface{}{
+						"tools": []map[string]interface{}{{"name": "second"}},
+					})
+					return
+				}
+				writeResult(w, request.ID, map[string]interface{}{
+					"tools":      []map[string]interface{}{{"name": "first", "description": "The first tool"}},
+					"nextCursor": "page-2",
+				})
+			})
+			defer server.Close()
+
+			subject, err := mcp.RealClientFactory(config.MCPServerConfig{
+				Name:    "remote",
+				URL:     server.URL,
+				Headers: map[string]string{"Authorization": "Bearer token"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			tools, err := subject.ListTools()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tools).To(Equal([]api.MCPTool{
+				{Name: "first", Description: "The first tool"},
+				{Name: "second"},
+			}))
+
+			Expect(subject.Close()).To(Succeed())
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			Expect(methods).To(Equal([]string{"initialize", "notifications/initialized", "tools/list", "tools/list"}))
+			Expect(requests[0].Header.Get("Accept")).To(Equal("application/json, text/event-stream"))
+			Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer token"))
+			Expect(requests[0].Header.Get("Mcp-Session-Id")).To(BeEmpty())
+
+			Expect(requests[2].Header.Get("Mcp-Session-Id")).To(Equal("session-1"))
+			Expect(requests[2].Header.Get("MCP-Protocol-Version")).To(Equal("2025-03-26"))
+
+			last := requests[len(requests)-1]
+			Expect(last.Method).To(Equal(stdhttp.MethodDelete))
+			Expect(last.Header.Get("Mcp-Session-Id")).To(Equal("session-1"))
+		})
+		it("reads responses sent as an event stream and answers pings on the way", func() {
+			server := newServer(func(w stdhttp.ResponseWriter, request rpcMessage) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = fmt.Fprint(w, "event: message\n")
+				_, _ = fmt.Fprint(w, `data: {"jsonrpc":"2.0","method":"notifications/progress","params":{}}`+"\n\n")
+				_, _ = fmt.Fprint(w, `data: {"jsonrpc":"2.0","id":"srv-1","method":"ping"}`+"\n\n")
+				_, _ = fmt.Fprintf(w, `data: {"jsonrpc":"2.0","id":%s,"result":{"contents":[{"uri":"file:///a.md","text":"# A"}]}}`+"\n\n", request.ID)
+			})
+			defer server.Close()
+
+			subject, err := mcp.RealClientFactory(config.MCPServerConfig{Name: "remote", URL: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := subject.ReadResource("file:///a.md")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Contents).To(Equal([]api.MCPResourceContents{{URI: "file:///a.md", Text: "# A"}}))
+
+			mu.Lock()
+			defer mu.Unlock()
+			// initialize, initialized, resources/read and the answer to the ping
+			Expect(methods).To(Equal([]string{"initialize", "notifications/initialized", "resources/read", ""}))
+		})
+		it("returns the errors of the server", func() {
+			server := newServer(func(w stdhttp.ResponseWriter, request rpcMessage) {
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"jsonrpc": "2.0",
+					"id":      request.ID,
+					"error":   map[string]interface{}{"code": -32602, "message": "unknown prompt"},
+				})
+			})
+			defer server.Close()
+
+			subject, err := mcp.RealClientFactory(config.MCPServerConfig{Name: "remote", URL: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = subject.GetPrompt("missing", nil)
+			Expect(err).To(MatchError("mcp error -32602: unknown prompt"))
+		})
+		it("throws an error on http failures", func() {
+			server := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+				w.WriteHeader(stdhttp.StatusUnauthorized)
+				_, _ = w.Write([]byte("missing token"))
+			}))
+			defer server.Close()
+
+			_, err := mcp.RealClientFactory(config.MCPServerConfig{Name: "remote", URL: server.URL})
+			Expect(err).To(MatchError(`failed to initialize mcp server "remote": http status 401: missing token`))
+		})
+	})
+
+	when("the server runs over stdio", func() {
+		newSubject := func() mcp.Client {
+			subject, err := mcp.RealClientFactory(config.MCPServerConfig{
+				Name:    "local",
+				Command: os.Args[0],
+				Env:     map[string]string{fakeServerEnv: "1"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			return subject
+		}
+
+		it("calls tools and skips the output that is not a response", func() {
+			subject := newSubject()
+			defer subject.Close()
+
+			result, err := subject.CallTool("echo", map[string]interface{}{"text": "hello"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Content).To(Equal([]api.MCPContent{{Type: "text", Text: "hello"}}))
+		})
+		it("gets prompts", func() {
+			subject := newSubject()
+			defer subject.Close()
+
+			result, err := subject.GetPrompt("greet", map[string]string{"name": "Ada"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Messages).To(Equal([]api.MCPPromptMessage{
+				{Role: "user", Content: api.MCPContent{Type: "text", Text: "Say hello to Ada"}},
+			}))
+		})
+		it("throws an error when the server exits", func() {
+			subject := newSubject()
+			defer subject.Close()
+
+			_, err := subject.CallTool("exit", nil)
+			Expect(err).To(MatchError("mcp server closed its output"))
+		})
+	})
+}
+
+func writeResult(w stdhttp.ResponseWriter, id json.RawMessage, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"result":  result,
+	})
+}
+
+// runFakeStdioServer answers initialize, tools/call and prompts/get on stdin and
+// stdout. Before answering a tool call it logs a line, sends a notification and
+// pings the client, the way real servers interleave messages.
+func runFakeStdioServer() {
+	scanner := bufio.NewScanner(os.Stdin)
+	encoder := json.NewEncoder(os.Stdout)
+
+	reply := func(id *int, result interface{}) {
+		_ = encoder.Encode(map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": result})
+	}
+
+	for scanner.Scan() {
+		var request struct {
+			ID     *int                   `json:"id"`
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil || request.ID == nil {
+			continue
+		}
+
+		switch request.Method {
+		case "initialize":
+			reply(request.ID, map[string]interface{}{"protocolVersion": mcp.ProtocolVersion})
+		case "tools/call":
+			if request.Params["name"] == "exit" {
+				return
+			}
+
+			fmt.Println("starting echo")
+			_ = encoder.Encode(map[string]interface{}{"jsonrpc": "2.0", "method": "notifications/message"})
+			_ = encoder.Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 99, "method": "ping"})
+			if !scanner.Scan() {
+				return
+			}
+
+			arguments, _ := request.Params["arguments"].(map[string]interface{})
+			reply(request.ID, map[string]interface{}{
+				"content": []map[string]interface{}{{"type": "text", "text": arguments["text"]}},
+			})
+		case "prompts/get":
+			arguments, _ := request.Params["arguments"].(map[string]interface{})
+			reply(request.ID, map[string]interface{}{
+				"messages": []map[string]interface{}{{
+					"role":    "user",
+					"content": map[string]interface{}{"type": "text", "text": fmt.Sprintf("Say hello to %v", arguments["name"])},
+				}},
+			})
+		}
+	}
+}
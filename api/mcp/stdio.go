@@ -0,0 +1,136 @@
+package mcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/config"
+	"go.uber.org/zap"
+)
+
+const (
+	stdioBufferSize   = 64 * 1024
+	stdioMaxLineSize  = 16 * 1024 * 1024
+	stdioCloseTimeout = 2 * time.Second
+	errServerExited   = "mcp server closed its output"
+)
+
+// stdioTransport launches the server as a child process and exchanges newline
+// delimited JSON-RPC messages over its stdin and stdout.
+type stdioTransport struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	scanner *bufio.Scanner
+}
+
+func newStdioTransport(server config.MCPServerConfig) (*stdioTransport, error) {
+	cmd := exec.Command(server.Command, server.Args...)
+	cmd.Env = os.Environ()
+	for key, value := range server.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start mcp server %q: %w", server.Name, err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, stdioBufferSize), stdioMaxLineSize)
+
+	return &stdioTransport{
+		cmd:     cmd,
+		stdin:   stdin,
+		scanner: scanner,
+	}, nil
+}
+
+func (t *stdioTransport) send(id int, message []byte) (api.JSONRPCResponse, error) {
+	if err := t.write(message); err != nil {
+		return api.JSONRPCResponse{}, err
+	}
+
+	for t.scanner.Scan() {
+		line := t.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var response api.JSONRPCResponse
+		if err := json.Unmarshal(line, &response); err != nil {
+			zap.S().Debugf("Skipping unexpected mcp output: %s", line)
+			continue
+		}
+
+		if isResponseTo(response, id) {
+			return response, nil
+		}
+
+		if response.Method != "" && len(response.ID) > 0 {
+			reply, err := replyToServer(response)
+			if err != nil {
+				return api.JSONRPCResponse{}, err
+			}
+			if err := t.write(reply); err != nil {
+				return api.JSONRPCResponse{}, err
+			}
+		}
+	}
+
+	if err := t.scanner.Err(); err != nil {
+		return api.JSONRPCResponse{}, err
+	}
+
+	return api.JSONRPCResponse{}, errors.New(errServerExited)
+}
+
+func (t *stdioTransport) notify(message []byte) error {
+	return t.write(message)
+}
+
+func (t *stdioTransport) setProtocolVersion(string) {}
+
+// close shuts the server down the way the protocol asks: close its stdin, give
+// it a moment to exit and kill it if it does not.
+func (t *stdioTransport) close() error {
+	_ = t.stdin.Close()
+
+	if t.cmd == nil || t.cmd.Process == nil {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- t.cmd.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(stdioCloseTimeout):
+		_ = t.cmd.Process.Kill()
+		<-done
+	}
+
+	return nil
+}
+
+func (t *stdioTransport) write(message []byte) error {
+	_, err := t.stdin.Write(append(message, '\n'))
+	return err
+}
@@ -0,0 +1,75 @@
+package api
+
+import "encoding/json"
+
+type MessagesRequest struct {
+	Model       string         `json:"model"`
+	System      string         `json:"system,omitempty"`
+	Messages    []Message      `json:"messages"`
+	MaxTokens   int            `json:"max_tokens"`
+	Stream      bool           `json:"stream"`
+	Temperature float64        `json:"temperature,omitempty"`
+	Tools       []MessagesTool `json:"tools,omitempty"`
+}
+
+type MessagesTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type MessagesTextContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type MessagesToolUse struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+type MessagesToolResult struct {
+	Type      string `json:"type"`
+	ToolUseID string `json:"tool_use_id"`
+	Content   string `json:"content"`
+}
+
+type MessagesImageContent struct {
+	Type   string              `json:"type"`
+	Source MessagesImageSource `json:"source"`
+}
+
+type MessagesImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type MessagesResponse struct {
+	ID           string            `json:"id"`
+	Type         string            `json:"type"`
+	Role         string            `json:"role"`
+	Model        string            `json:"model"`
+	Content      []MessagesContent `json:"content"`
+	StopReason   string            `json:"stop_reason"`
+	StopSequence *string           `json:"stop_sequence"`
+	Usage        MessagesUsage     `json:"usage"`
+}
+
+type MessagesContent struct {
+	Type  string          `json:"type"`
+	Text  string          `json:"text,omitempty"`
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+type MessagesUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
@@ -1,13 +1,43 @@
 package api
 
 type ResponsesRequest struct {
-	Model           string    `json:"model"`
-	Input           []Message `json:"input"`
-	MaxOutputTokens int       `json:"max_output_tokens"`
-	Reasoning       Reasoning `json:"reasoning"`
-	Stream          bool      `json:"stream"`
-	Temperature     float64   `json:"temperature,omitempty"`
-	TopP            float64   `json:"top_p,omitempty"`
+	Model           string          `json:"model"`
+	Input           []interface{}   `json:"input"`
+	MaxOutputTokens int             `json:"max_output_tokens"`
+	Reasoning       Reasoning       `json:"reasoning"`
+	Stream          bool            `json:"stream"`
+	Temperature     float64         `json:"temperature,omitempty"`
+	TopP            float64         `json:"top_p,omitempty"`
+	Tools           []ResponsesTool `json:"tools,omitempty"`
+}
+
+type ResponsesTool struct {
+	Type        string                 `json:"type"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ResponsesFunctionCall replays a tool call of the model as an input item.
+type ResponsesFunctionCall struct {
+	Type      string `json:"type"`
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ResponsesFunctionCallOutput hands the result of a tool call back to the model.
+type ResponsesFunctionCallOutput struct {
+	Type   string `json:"type"`
+	CallID string `json:"call_id"`
+	Output string `json:"output"`
+}
+
+// ResponsesInputContent is a part of the content of an input message.
+type ResponsesInputContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
 }
 
 type Reasoning struct {
@@ -61,12 +91,15 @@ type TokenUsage struct {
 }
 
 type Output struct {
-	ID      string    `json:"id"`
-	Type    string    `json:"type"`
-	Summary []any     `json:"summary,omitempty"`
-	Status  string    `json:"status,omitempty"`
-	Content []Content `json:"content,omitempty"`
-	Role    string    `json:"role,omitempty"`
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Summary   []any     `json:"summary,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Content   []Content `json:"content,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	CallID    string    `json:"call_id,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Arguments string    `json:"arguments,omitempty"`
 }
 
 type Content struct {
@@ -0,0 +1,22 @@
+package api
+
+// FunctionDefinition describes a tool the model may call. Parameters holds the
+// JSON schema of the arguments.
+type FunctionDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ToolCall is a request of the model to run a tool. It uses the chat completions
+// shape, which is also how tool calls are kept in between requests.
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
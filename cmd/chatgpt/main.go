@@ -1,18 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/kardolus/chatgpt-cli/api/client"
 	"github.com/kardolus/chatgpt-cli/api/http"
 	"github.com/kardolus/chatgpt-cli/cmd/chatgpt/utils"
 	"github.com/kardolus/chatgpt-cli/internal"
+	"github.com/kardolus/chatgpt-cli/tokenizer"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/yaml.v3"
@@ -38,15 +44,40 @@ var (
 	interactiveMode bool
 	listModels      bool
 	listThreads     bool
+	pruneThreads    bool
+	dryRun          bool
+	retryLast       bool
+	undoLast        bool
+	editLast        bool
+	migrateHistory  bool
+	encryptHistory  bool
+	decryptHistory  bool
+	countTokens     bool
 	hasPipe         bool
 	useSpeak        bool
 	useDraw         bool
 	promptFile      string
+	contextFiles    []string
 	roleFile        string
 	imageFile       string
 	audioFile       string
 	outputFile      string
 	threadName      string
+	searchQuery     string
+	exportThread    string
+	exportFormat    string
+	threadSort      string
+	threadTag       string
+	threadSince     string
+	threadTitle     string
+	threadTags      string
+	threadRole      string
+	pinMessage      int
+	unpinMessage    int
+	stickFiles      []string
+	unstickFiles    []string
+	importFile      string
+	forkSource      string
 	ServiceURL      string
 	shell           string
 	mcpTarget       string
@@ -54,6 +85,23 @@ var (
 	paramsList      []string
 	paramsJSON      string
 	cfg             config.Config
+	cachedCipher    *history.Cipher
+)
+
+const (
+	searchLimit          = 20
+	searchTimeLayout     = "2006-01-02 15:04"
+	retryCommand         = "/retry"
+	undoCommand          = "/undo"
+	editCommand          = "/edit"
+	pruneInterval        = 24 * time.Hour
+	pruneStampFile       = "last-prune"
+	blobGracePeriod      = time.Hour
+	errNoHistoryKey      = "history encryption needs a key: set history_key_file, or the " + historyKeyEnv + " environment variable"
+	errEncryptedDatabase = "history encryption is only supported by the file backend"
+	historyKeyEnv        = "%s_HISTORY_KEY"
+	historyKeySetting    = "history_key"
+	redacted             = "[redacted]"
 )
 
 type ConfigMetadata struct {
@@ -65,8 +113,18 @@ type ConfigMetadata struct {
 
 var configMetadata = []ConfigMetadata{
 	{"model", "set-model", "gpt-4o", "Set a new default model by specifying the model name"},
+	{"provider", "set-provider", "", "Set the chat API provider (completions, responses or anthropic)"},
 	{"max_tokens", "set-max-tokens", 4096, "Set a new default max token size"},
 	{"context_window", "set-context-window", 8192, "Set a new default context window size"},
+	{"max_tool_rounds", "set-max-tool-rounds", 10, "Set the maximum number of tool call rounds per query"},
+	{"context_strategy", "set-context-strategy", "truncate", "Set how history beyond the context window is handled (truncate or summarize)"},
+	{"max_attempts", "set-max-attempts", 3, "Set the maximum number of attempts for rate limited or failed requests"},
+	{"retry_deadline", "set-retry-deadline", 60, "Set the number of seconds after which failed requests are no longer retried"},
+	{"request_timeout", "set-request-timeout", 0, "Set the number of seconds a request may take, or a stream may wait for its response to start (0 for no limit)"},
+	{"connect_timeout", "set-connect-timeout", 10, "Set the number of seconds allowed for connecting to the API (0 for no limit)"},
+	{"history_backend", "set-history-backend", "file", "Set where the history is stored (file or database)"},
+	{"history_encryption", "set-history-encryption", false, "Encrypt the history files with the history key"},
+	{"history_key_file", "set-history-key-file", "", "Set the path to a file holding the secret the history files are encrypted with"},
 	{"thread", "set-thread", "default", "Set a new active thread by specifying the thread name"},
 	{"api_key", "set-api-key", "", "Set the API key for authentication"},
 	{"apify_api_key", "set-apify-api-key", "", "Configure Apify API key for MCP"},
@@ -74,6 +132,7 @@ var configMetadata = []ConfigMetadata{
 	{"url", "set-url", "https://api.openai.com", "Set the API base URL"},
 	{"completions_path", "set-completions-path", "/v1/chat/completions", "Set the completions API endpoint"},
 	{"responses_path", "set-responses-path", "/v1/responses", "Set the responses API endpoint"},
+	{"messages_path", "set-messages-path", "/v1/messages", "Set the Anthropic messages API endpoint"},
 	{"transcriptions_path", "set-transcriptions-path", "/v1/audio/transcriptions", "Set the transcriptions API endpoint"},
 	{"speech_path", "set-speech-path", "/v1/audio/speech", "Set the speech API endpoint"},
 	{"image_generations_path", "set-image-generations-path", "/v1/images/generations", "Set the image generation API endpoint"},
@@ -81,6 +140,7 @@ var configMetadata = []ConfigMetadata{
 	{"models_path", "set-models-path", "/v1/models", "Set the models API endpoint"},
 	{"auth_header", "set-auth-header", "Authorization", "Set the authorization header"},
 	{"auth_token_prefix", "set-auth-token-prefix", "Bearer ", "Set the authorization token prefix"},
+	{"anthropic_version", "set-anthropic-version", "2023-06-01", "Set the anthropic-version header sent to the messages API"},
 	{"command_prompt", "set-command-prompt", "[%datetime] [Q%counter] [%usage]", "Set the command prompt format for interactive mode"},
 	{"command_prompt_color", "set-command-prompt-color", "", "Set the command prompt color"},
 	{"output_prompt", "set-output-prompt", "", "Set the output prompt format for interactive mode"},
@@ -90,7 +150,9 @@ var configMetadata = []ConfigMetadata{
 	{"frequency_penalty", "set-frequency-penalty", 0.0, "Set the frequency penalty"},
 	{"presence_penalty", "set-presence-penalty", 0.0, "Set the presence penalty"},
 	{"omit_history", "set-omit-history", false, "Omit history in the conversation"},
+	{"fence_context", "set-fence-context", false, "Wrap piped and prompt file context in fenced blocks labeled with their source"},
 	{"auto_create_new_thread", "set-auto-create-new-thread", true, "Create a new thread for each interactive session"},
+	{"auto_title", "set-auto-title", false, "Let the model name new threads with a short title, which costs an extra request per thread"},
 	{"track_token_usage", "set-track-token-usage", true, "Track token usage"},
 	{"skip_tls_verify", "set-skip-tls-verify", false, "Skip TLS certificate verification"},
 	{"multiline", "set-multiline", false, "Enables multiline mode while in interactive mode"},
@@ -147,7 +209,7 @@ func run(cmd *cobra.Command, args []string) error {
 		changedFlags[f.Name] = true
 	})
 
-	if err := utils.ValidateFlags(cfg.Model, changedFlags); err != nil {
+	if err := utils.ValidateFlags(cfg.Capabilities(), changedFlags); err != nil {
 		return err
 	}
 
@@ -176,6 +238,14 @@ func run(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	if cmd.Flag("delete-thread").Changed && cfg.HistoryBackend == history.DatabaseBackend {
+		if err := deleteDatabaseThreads(threadName); err != nil {
+			return err
+		}
+		sugar.Infof("Successfully deleted thread %s", threadName)
+		return nil
+	}
+
 	if cmd.Flag("delete-thread").Changed {
 		cm := config.NewManager(config.NewStore())
 
@@ -187,23 +257,108 @@ func run(cmd *cobra.Command, args []string) error {
 	}
 
 	if listThreads {
-		cm := config.NewManager(config.NewStore())
+		store, err := openHistory()
+		if store == nil {
+			return err
+		}
+		store.SetThread(cfg.Thread)
+
+		filter := history.ThreadFilter{Tag: threadTag}
+		if cmd.Flag("since").Changed {
+			age, err := history.ParseAge(threadSince)
+			if err != nil {
+				return err
+			}
+			filter.Since = time.Now().Add(-age)
+		}
 
-		threads, err := cm.ListThreads()
+		threads, err := history.NewHistory(store).ListThreads(filter, threadSort)
 		if err != nil {
 			return err
 		}
+
 		sugar.Infoln("Available threads:")
-		for _, thread := range threads {
-			sugar.Infoln(thread)
+		printThreads(threads)
+		return nil
+	}
+
+	if cmd.Flag("thread-title").Changed || cmd.Flag("thread-tags").Changed || cmd.Flag("thread-role").Changed {
+		store, err := openHistory()
+		if err != nil {
+			return err
 		}
+
+		hm := history.NewHistory(store)
+		if cmd.Flag("thread-title").Changed {
+			if err := hm.SetTitle(cfg.Thread, threadTitle); err != nil {
+				return err
+			}
+		}
+		if cmd.Flag("thread-tags").Changed {
+			if err := hm.SetTags(cfg.Thread, history.ParseTags(threadTags)); err != nil {
+				return err
+			}
+		}
+		if cmd.Flag("thread-role").Changed {
+			if err := hm.SetRole(cfg.Thread, threadRole); err != nil {
+				return err
+			}
+		}
+
+		sugar.Infof("Updated the metadata of thread %s", cfg.Thread)
 		return nil
 	}
 
+	if cmd.Flag("pin").Changed || cmd.Flag("unpin").Changed || cmd.Flag("stick").Changed || cmd.Flag("unstick").Changed {
+		store, err := openHistory()
+		if err != nil {
+			return err
+		}
+
+		hm := history.NewHistory(store)
+		if cmd.Flag("pin").Changed {
+			if err := hm.Pin(cfg.Thread, pinMessage, true); err != nil {
+				return err
+			}
+			sugar.Infof("Pinned message %d of thread %s", pinMessage, cfg.Thread)
+		}
+		if cmd.Flag("unpin").Changed {
+			if err := hm.Pin(cfg.Thread, unpinMessage, false); err != nil {
+				return err
+			}
+			sugar.Infof("Unpinned message %d of thread %s", unpinMessage, cfg.Thread)
+		}
+		if cmd.Flag("stick").Changed {
+			if err := hm.Stick(cfg.Thread, stickFiles); err != nil {
+				return err
+			}
+			sugar.Infof("Added %d sticky files to thread %s", len(stickFiles), cfg.Thread)
+		}
+		if cmd.Flag("unstick").Changed {
+			if err := hm.Unstick(cfg.Thread, unstickFiles); err != nil {
+				return err
+			}
+			sugar.Infof("Removed %d sticky files from thread %s", len(unstickFiles), cfg.Thread)
+		}
+		return nil
+	}
+
+	if pruneThreads {
+		if !cfg.Retention.Enabled() {
+			return errors.New("no retention policy is configured, add a retention section to the config")
+		}
+		return pruneHistory(dryRun, true)
+	}
+
 	if clearHistory {
 		cm := config.NewManager(config.NewStore())
 
-		if err := cm.DeleteThread(cfg.Thread); err != nil {
+		deleteThread := cm.DeleteThread
+		if cfg.HistoryBackend == history.DatabaseBackend {
+			deleteThread = deleteDatabaseThreads
+		}
+
+		if err := deleteThread(cfg.Thread); err != nil {
 			var fileNotFoundError *config.FileNotFoundError
 			if errors.As(err, &fileNotFoundError) {
 				sugar.Infoln("Thread history does not exist; nothing to clear.")
@@ -224,7 +379,7 @@ func run(cmd *cobra.Command, args []string) error {
 			targetThread = cfg.Thread
 		}
 
-		store, err := history.New()
+		store, err := openHistory()
 		if err != nil {
 			return err
 		}
@@ -240,6 +395,110 @@ func run(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	if cmd.Flag("search-history").Changed {
+		return printSearchResults(searchQuery)
+	}
+
+	if cmd.Flag("import-history").Changed {
+		data, err := os.ReadFile(importFile)
+		if err != nil {
+			return err
+		}
+
+		threads, err := history.ParseImport(data, importFile)
+		if err != nil {
+			return err
+		}
+
+		store, err := openHistory()
+		if err != nil {
+			return err
+		}
+
+		names, err := history.NewHistory(store).Import(threads)
+		for _, name := range names {
+			sugar.Infof("- %s", name)
+		}
+		if err != nil {
+			return err
+		}
+
+		sugar.Infof("Imported %d threads", len(names))
+		return nil
+	}
+
+	if cmd.Flag("export-thread").Changed {
+		store, err := openHistory()
+		if err != nil {
+			return err
+		}
+
+		hm := history.NewHistory(store)
+		if blobs, err := openBlobs(); err == nil {
+			hm.WithBlobs(blobs)
+		}
+
+		output, err := hm.Export(exportThread, exportFormat)
+		if err != nil {
+			return err
+		}
+
+		if cmd.Flag("output").Changed {
+			return os.WriteFile(outputFile, []byte(output), 0644)
+		}
+
+		_, err = io.WriteString(os.Stdout, output)
+		return err
+	}
+
+	if migrateHistory {
+		files, err := history.New()
+		if err != nil {
+			return err
+		}
+
+		db, err := history.OpenDatabase(files)
+		if err != nil {
+			return err
+		}
+
+		count, err := db.Import(files)
+		if err != nil {
+			return err
+		}
+
+		sugar.Infof("Imported %d threads into the history database", count)
+		return nil
+	}
+
+	if encryptHistory || decryptHistory {
+		return convertHistory(encryptHistory)
+	}
+
+	if cmd.Flag("fork-thread").Changed {
+		if !cmd.Flag("thread").Changed {
+			return errors.New("--fork-thread requires --thread with the name of the new thread")
+		}
+
+		source, index, err := history.ParseForkSource(forkSource)
+		if err != nil {
+			return err
+		}
+
+		store, err := openHistory()
+		if err != nil {
+			return err
+		}
+
+		count, err := history.NewHistory(store).Fork(source, index, cfg.Thread)
+		if err != nil {
+			return err
+		}
+
+		sugar.Infof("Forked %d messages of thread %s into thread %s", count, source, cfg.Thread)
+		return nil
+	}
+
 	if showDebug {
 		internal.SetAllowedLogLevels(zapcore.InfoLevel, zapcore.DebugLevel)
 	}
@@ -255,6 +514,9 @@ func run(cmd *cobra.Command, args []string) error {
 
 	if showConfig {
 		allSettings := viper.AllSettings()
+		if _, ok := allSettings[historyKeySetting]; ok {
+			allSettings[historyKeySetting] = redacted
+		}
 
 		configBytes, err := yaml.Marshal(allSettings)
 		if err != nil {
@@ -265,14 +527,36 @@ func run(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	if countTokens {
+		return printTokenCount(args)
+	}
+
 	if viper.GetString("api_key") == "" {
 		return errors.New("API key is required. Please set it using the --set-api-key flag, with the runtime flag --api-key or via environment variables")
 	}
 
+	if _, err := client.NewProvider(cfg); err != nil {
+		return err
+	}
+
 	ctx := context.Background()
 
-	hs, _ := history.New() // do not error out
+	if cfg.Retention.Enabled() && pruneDue() {
+		if err := pruneHistory(false, false); err != nil {
+			sugar.Warnf("Failed to prune threads: %v", err)
+		}
+	}
+
+	hs, err := openHistory() // a missing history directory does not error out
+	if hs == nil {
+		return err
+	}
 	c := client.New(http.RealCallerFactory, hs, &client.RealTime{}, &client.RealFileReader{}, &client.RealFileWriter{}, cfg, interactiveMode)
+	defer c.Close()
+
+	if blobs, err := openBlobs(); err == nil {
+		c = c.WithBlobs(blobs)
+	}
 
 	if ServiceURL != "" {
 		c = c.WithServiceURL(ServiceURL)
@@ -293,7 +577,9 @@ func run(cmd *cobra.Command, args []string) error {
 		if err != nil {
 			return err
 		}
-		c.ProvideContext(prompt)
+		if err := c.ProvideContextFrom(promptFile, prompt); err != nil {
+			return err
+		}
 	}
 
 	if cmd.Flag("image").Changed {
@@ -305,7 +591,7 @@ func run(cmd *cobra.Command, args []string) error {
 	}
 
 	if cmd.Flag("transcribe").Changed {
-		text, err := c.Transcribe(audioFile)
+		text, err := c.Transcribe(ctx, audioFile)
 		if err != nil {
 			return err
 		}
@@ -332,12 +618,29 @@ func run(cmd *cobra.Command, args []string) error {
 				hasPipe = true
 			}
 
-			c.ProvideContext(chatContext)
+			if err := c.ProvideContextFrom("stdin", chatContext); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the files come last, so the older context is dropped before them
+	if cmd.Flag("file").Changed {
+		paths, err := utils.ExpandFiles(contextFiles)
+		if err != nil {
+			return err
+		}
+		skipped, err := c.ProvideFiles(paths, strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		for _, path := range skipped {
+			sugar.Warnf("Skipping binary file %s", path)
 		}
 	}
 
 	if listModels {
-		models, err := c.ListModels()
+		models, err := c.ListModels(ctx)
 		if err != nil {
 			return err
 		}
@@ -352,12 +655,12 @@ func run(cmd *cobra.Command, args []string) error {
 		sugar.Warnf("Warning: config.yaml doesn't exist in %s, create it\n", tmp)
 	}
 
-	if !client.GetCapabilities(c.Config.Model).SupportsStreaming {
+	if !c.Capabilities().Streaming {
 		queryMode = true
 	}
 
 	if cmd.Flag("mcp").Changed {
-		mcp, err := utils.ParseMCPPlugin(mcpTarget)
+		mcp, err := utils.ParseMCPPlugin(mcpTarget, mcpServerNames(cfg.MCPServers)...)
 		if err != nil {
 			return err
 		}
@@ -379,7 +682,7 @@ func run(cmd *cobra.Command, args []string) error {
 				mcp.Params = newParams
 			}
 		}
-		if err := c.InjectMCPContext(mcp); err != nil {
+		if err := c.InjectMCPContext(ctx, mcp); err != nil {
 			return err
 		}
 		if len(args) == 0 && !hasPipe && !interactiveMode {
@@ -389,7 +692,8 @@ func run(cmd *cobra.Command, args []string) error {
 	}
 
 	if interactiveMode {
-		sugar.Infof("Entering interactive mode. Using thread '%s'. Type 'clear' to clear the screen, 'exit' to quit, or press Ctrl+C.\n\n", hs.GetThread())
+		sugar.Infof("Entering interactive mode. Using thread '%s'. Type 'clear' to clear the screen, 'exit' to quit, or press Ctrl+C.", hs.GetThread())
+		sugar.Infof("Type '%s' to regenerate the last answer, '%s' to edit the last query or '%s' to remove the last exchange.\n\n", retryCommand, editCommand, undoCommand)
 
 		var readlineCfg *readline.Config
 		if cfg.OmitHistory || cfg.AutoCreateNewThread || newThread {
@@ -397,12 +701,7 @@ func run(cmd *cobra.Command, args []string) error {
 				Prompt: "",
 			}
 		} else {
-			store, err := history.New()
-			if err != nil {
-				return err
-			}
-
-			h := history.NewHistory(store)
+			h := history.NewHistory(hs)
 			userHistory, err := h.ParseUserHistory(cfg.Thread)
 			if err != nil {
 				return err
@@ -445,10 +744,28 @@ func run(cmd *cobra.Command, args []string) error {
 				return nil
 			}
 
+			switch strings.TrimSpace(input) {
+			case undoCommand:
+				if _, err := c.Undo(); err != nil {
+					sugar.Infoln("Error:", err)
+				} else {
+					sugar.Infoln("Removed the last exchange.")
+				}
+				continue
+			case retryCommand, editCommand:
+				if input, err = lastTurnInput(c, strings.TrimSpace(input) == editCommand); err != nil {
+					sugar.Infoln("Error:", err)
+					continue
+				}
+			}
+
 			fmtOutputPrompt := utils.FormatPrompt(c.Config.OutputPrompt, qNum, usage, time.Now())
 
+			// Ctrl+C aborts the request in flight instead of ending the session
+			queryCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+
 			if queryMode {
-				result, qUsage, err := c.Query(ctx, input)
+				result, qUsage, err := c.Query(queryCtx, input)
 				if err != nil {
 					sugar.Infoln("Error:", err)
 				} else {
@@ -458,7 +775,7 @@ func run(cmd *cobra.Command, args []string) error {
 				}
 			} else {
 				fmt.Print(outputColor + fmtOutputPrompt)
-				if err := c.Stream(ctx, input); err != nil {
+				if err := c.Stream(queryCtx, input); err != nil && !errors.Is(err, context.Canceled) {
 					_, _ = fmt.Fprintln(os.Stderr, "Error:", err)
 				} else {
 					sugar.Infoln()
@@ -466,26 +783,53 @@ func run(cmd *cobra.Command, args []string) error {
 				}
 				fmt.Print(outPutReset)
 			}
+
+			stop()
 		}
 	} else {
+		if undoLast {
+			query, err := c.Undo()
+			if err != nil {
+				return err
+			}
+			sugar.Infof("Removed the last exchange, which started with: %s", query)
+			return nil
+		}
+
+		if retryLast || editLast {
+			input, err := lastTurnInput(c, editLast)
+			if err != nil {
+				return err
+			}
+			args = []string{input}
+		}
+
 		if len(args) == 0 && !hasPipe {
 			return errors.New("you must specify your query or provide input via a pipe")
 		}
 
 		if cmd.Flag("speak").Changed && cmd.Flag("output").Changed {
-			return c.SynthesizeSpeech(chatContext+strings.Join(args, " "), outputFile)
+			return c.SynthesizeSpeech(ctx, chatContext+strings.Join(args, " "), outputFile)
 		}
 
 		if cmd.Flag("draw").Changed && cmd.Flag("output").Changed {
 			if cmd.Flag("image").Changed {
-				return c.EditImage(chatContext+strings.Join(args, " "), imageFile, outputFile)
+				return c.EditImage(ctx, chatContext+strings.Join(args, " "), imageFile, outputFile)
 			}
-			return c.GenerateImage(chatContext+strings.Join(args, " "), outputFile)
+			return c.GenerateImage(ctx, chatContext+strings.Join(args, " "), outputFile)
 		}
 
+		// Ctrl+C ends a stream early but keeps what was received in the history
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+
 		if queryMode {
 			result, usage, err := c.Query(ctx, strings.Join(args, " "))
 			if err != nil {
+				// an answer that could not be stored is still shown
+				if result != "" {
+					sugar.Infoln(result)
+				}
 				return err
 			}
 			sugar.Infoln(result)
@@ -493,7 +837,7 @@ func run(cmd *cobra.Command, args []string) error {
 			if c.Config.TrackTokenUsage {
 				sugar.Infof("\n[Token Usage: %d]\n", usage)
 			}
-		} else if err := c.Stream(ctx, strings.Join(args, " ")); err != nil {
+		} else if err := c.Stream(ctx, strings.Join(args, " ")); err != nil && !errors.Is(err, context.Canceled) {
 			return err
 		}
 	}
@@ -535,6 +879,13 @@ func initConfig(rootCmd *cobra.Command) (config.Config, error) {
 	// Bind variables without prefix manually
 	_ = viper.BindEnv("apify_api_key", "APIFY_API_KEY")
 
+	// the history key protects the history, so it is never read from the config
+	// file next to it, only from the environment
+	if viper.InConfig(historyKeySetting) {
+		zap.S().Warnf("Ignoring %s in the config, set history_key_file or the %s environment variable instead",
+			historyKeySetting, fmt.Sprintf(historyKeyEnv, strings.ToUpper(envPrefix)))
+	}
+
 	// Now, set up the flags using the fully loaded configuration metadata.
 	for _, meta := range configMetadata {
 		setupConfigFlags(rootCmd, meta)
@@ -727,14 +1078,39 @@ func setCustomHelp(rootCmd *cobra.Command) {
 		printFlagWithPadding("-q, --query", "Use query mode instead of stream mode")
 		printFlagWithPadding("-i, --interactive", "Use interactive mode")
 		printFlagWithPadding("-p, --prompt", "Provide a prompt file for context")
+		printFlagWithPadding("--file", "Provide files, globs or directories for context, honoring .gitignore")
 		printFlagWithPadding("-n, --new-thread", "Create a new thread with a random name and target it")
 		printFlagWithPadding("-c, --config", "Display the configuration")
 		printFlagWithPadding("-v, --version", "Display the version information")
 		printFlagWithPadding("-l, --list-models", "List available models")
-		printFlagWithPadding("--list-threads", "List available threads")
+		printFlagWithPadding("--list-threads", "List available threads with their title, tags, size, model and age")
+		printFlagWithPadding("--sort", "Sort the listed threads by name, updated, created, tokens or messages")
+		printFlagWithPadding("--tag", "List only the threads with the specified tag")
+		printFlagWithPadding("--since", "List only the threads updated within the specified age, like 36h, 7d or 2w")
+		printFlagWithPadding("--thread-title", "Set the title of the current thread")
+		printFlagWithPadding("--thread-tags", "Set the comma separated tags of the current thread")
+		printFlagWithPadding("--thread-role", "Set the system role of the current thread")
+		printFlagWithPadding("--pin", "Pin a message of the current thread by number, -1 is the last message")
+		printFlagWithPadding("--unpin", "Unpin a message of the current thread by number")
+		printFlagWithPadding("--stick", "Send the specified file with every turn of the current thread")
+		printFlagWithPadding("--unstick", "Stop sending the specified file with the current thread")
 		printFlagWithPadding("--delete-thread", "Delete the specified thread (supports wildcards)")
+		printFlagWithPadding("--prune-threads", "Delete the threads that exceed the retention policy of the config")
+		printFlagWithPadding("--dry-run", "Show what --prune-threads would delete without deleting it")
 		printFlagWithPadding("--clear-history", "Clear the history of the current thread")
 		printFlagWithPadding("--show-history [thread]", "Show the human-readable conversation history")
+		printFlagWithPadding("--search-history", "Search the messages of all threads")
+		printFlagWithPadding("--migrate-history", "Import the thread files into the history database")
+		printFlagWithPadding("--encrypt-history", "Encrypt the existing thread files in place with the history key")
+		printFlagWithPadding("--decrypt-history", "Decrypt the existing thread files in place with the history key")
+		printFlagWithPadding("--retry", "Regenerate the last answer of the thread")
+		printFlagWithPadding("--undo", "Remove the last exchange from the thread")
+		printFlagWithPadding("--edit-last", "Edit the last query of the thread in $EDITOR and regenerate the answer")
+		printFlagWithPadding("--fork-thread", "Copy a thread up to a message (<thread>[@<number>]) into the thread set with --thread")
+		printFlagWithPadding("--import-history", "Import a ChatGPT conversations.json export or a JSON list of messages")
+		printFlagWithPadding("--export-thread", "Export the specified thread to stdout or the --output file")
+		printFlagWithPadding("--format", "The export format: md, html, json or jsonl")
+		printFlagWithPadding("--count-tokens", "Count the tokens of the piped input with the tokenizer of the model")
 		printFlagWithPadding("--image", "Upload an image from the specified local path or URL")
 		printFlagWithPadding("--audio", "Upload an audio file (mp3 or wav)")
 		printFlagWithPadding("--transcribe", "Transcribe an audio file")
@@ -744,7 +1120,7 @@ func setCustomHelp(rootCmd *cobra.Command) {
 		printFlagWithPadding("--role-file", "Set the system role from the specified file")
 		printFlagWithPadding("--debug", "Print debug messages")
 		printFlagWithPadding("--target", "Load configuration from config.<target>.yaml")
-		printFlagWithPadding("--mcp", "Specify the MCP plugin in the form <provider>/<plugin>@<version>")
+		printFlagWithPadding("--mcp", "Specify the MCP plugin in the form <provider>/<plugin>@<version> or <server>/<tool>")
 		printFlagWithPadding("--param", "Key-value pair as key=value. Can be specified multiple times")
 		printFlagWithPadding("--params", "Provide parameters as a raw JSON string")
 		printFlagWithPadding("--set-completions", "Generate autocompletion script for your current shell")
@@ -789,17 +1165,42 @@ func setupFlags(rootCmd *cobra.Command) {
 	rootCmd.PersistentFlags().BoolVarP(&useSpeak, "speak", "", false, "Use text-to-speak")
 	rootCmd.PersistentFlags().BoolVarP(&useDraw, "draw", "", false, "Draw an image")
 	rootCmd.PersistentFlags().StringVarP(&promptFile, "prompt", "p", "", "Provide a prompt file")
+	rootCmd.PersistentFlags().StringArrayVar(&contextFiles, "file", nil, "Provide files, globs or directories for context, honoring .gitignore")
 	rootCmd.PersistentFlags().StringVarP(&roleFile, "role-file", "", "", "Provide a role file")
 	rootCmd.PersistentFlags().StringVarP(&imageFile, "image", "", "", "Provide an image from a local path or URL")
 	rootCmd.PersistentFlags().StringVarP(&outputFile, "output", "", "", "Provide an output file for text-to-speech")
 	rootCmd.PersistentFlags().StringVarP(&audioFile, "audio", "", "", "Provide an audio file from a local path")
 	rootCmd.PersistentFlags().StringVarP(&audioFile, "transcribe", "", "", "Provide an audio file from a local path")
-	rootCmd.PersistentFlags().BoolVarP(&listThreads, "list-threads", "", false, "List available threads")
+	rootCmd.PersistentFlags().BoolVarP(&listThreads, "list-threads", "", false, "List available threads with their title, tags, size, model and age")
+	rootCmd.PersistentFlags().StringVar(&threadSort, "sort", history.SortName, "Sort the listed threads by name, updated, created, tokens or messages")
+	rootCmd.PersistentFlags().StringVar(&threadTag, "tag", "", "List only the threads with the specified tag")
+	rootCmd.PersistentFlags().StringVar(&threadSince, "since", "", "List only the threads updated within the specified age, like 36h, 7d or 2w")
+	rootCmd.PersistentFlags().StringVar(&threadTitle, "thread-title", "", "Set the title of the current thread")
+	rootCmd.PersistentFlags().StringVar(&threadTags, "thread-tags", "", "Set the comma separated tags of the current thread")
+	rootCmd.PersistentFlags().StringVar(&threadRole, "thread-role", "", "Set the system role of the current thread")
+	rootCmd.PersistentFlags().IntVar(&pinMessage, "pin", 0, "Pin a message of the current thread by number, -1 is the last message")
+	rootCmd.PersistentFlags().IntVar(&unpinMessage, "unpin", 0, "Unpin a message of the current thread by number")
+	rootCmd.PersistentFlags().StringArrayVar(&stickFiles, "stick", nil, "Send the specified file with every turn of the current thread")
+	rootCmd.PersistentFlags().StringArrayVar(&unstickFiles, "unstick", nil, "Stop sending the specified file with the current thread")
 	rootCmd.PersistentFlags().StringVar(&threadName, "delete-thread", "", "Delete the specified thread")
+	rootCmd.PersistentFlags().BoolVar(&pruneThreads, "prune-threads", false, "Delete the threads that exceed the retention policy of the config")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Show what --prune-threads would delete without deleting it")
 	rootCmd.PersistentFlags().BoolVar(&showHistory, "show-history", false, "Show the human-readable conversation history")
+	rootCmd.PersistentFlags().StringVar(&searchQuery, "search-history", "", "Search the messages of all threads")
+	rootCmd.PersistentFlags().BoolVar(&migrateHistory, "migrate-history", false, "Import the thread files into the history database")
+	rootCmd.PersistentFlags().BoolVar(&encryptHistory, "encrypt-history", false, "Encrypt the existing thread files in place with the history key")
+	rootCmd.PersistentFlags().BoolVar(&decryptHistory, "decrypt-history", false, "Decrypt the existing thread files in place with the history key")
+	rootCmd.PersistentFlags().BoolVar(&retryLast, "retry", false, "Regenerate the last answer of the thread")
+	rootCmd.PersistentFlags().BoolVar(&undoLast, "undo", false, "Remove the last exchange from the thread")
+	rootCmd.PersistentFlags().BoolVar(&editLast, "edit-last", false, "Edit the last query of the thread in $EDITOR and regenerate the answer")
+	rootCmd.PersistentFlags().StringVar(&forkSource, "fork-thread", "", "Copy a thread up to a message (<thread>[@<number>]) into the thread set with --thread")
+	rootCmd.PersistentFlags().StringVar(&importFile, "import-history", "", "Import a ChatGPT conversations.json export or a JSON list of messages")
+	rootCmd.PersistentFlags().StringVar(&exportThread, "export-thread", "", "Export the specified thread to stdout or the --output file")
+	rootCmd.PersistentFlags().StringVar(&exportFormat, "format", history.FormatMarkdown, "The export format: md, html, json or jsonl")
+	rootCmd.PersistentFlags().BoolVar(&countTokens, "count-tokens", false, "Count the tokens of the piped input with the tokenizer of the model")
 	rootCmd.PersistentFlags().StringVar(&shell, "set-completions", "", "Generate autocompletion script for your current shell")
 	rootCmd.PersistentFlags().StringVar(&modelTarget, "target", "", "Specify the model to target")
-	rootCmd.PersistentFlags().StringVar(&mcpTarget, "mcp", "", "Specify the MCP plugin in the form <provider>/<plugin>@<version>")
+	rootCmd.PersistentFlags().StringVar(&mcpTarget, "mcp", "", "Specify the MCP plugin in the form <provider>/<plugin>@<version> or <server>/<tool>")
 	rootCmd.PersistentFlags().StringArrayVar(&paramsList, "param", []string{}, "Key-value pair as key=value. Can be specified multiple times")
 	rootCmd.PersistentFlags().StringVar(&paramsJSON, "params", "", "Provide parameters as a raw JSON string")
 }
@@ -841,10 +1242,35 @@ func isGeneralFlag(name string) bool {
 		"new-thread":      true,
 		"list-models":     true,
 		"list-threads":    true,
+		"sort":            true,
+		"tag":             true,
+		"since":           true,
+		"thread-title":    true,
+		"thread-tags":     true,
+		"thread-role":     true,
+		"pin":             true,
+		"unpin":           true,
+		"stick":           true,
+		"unstick":         true,
 		"clear-history":   true,
 		"delete-thread":   true,
+		"prune-threads":   true,
+		"dry-run":         true,
 		"show-history":    true,
+		"search-history":  true,
+		"migrate-history": true,
+		"encrypt-history": true,
+		"decrypt-history": true,
+		"import-history":  true,
+		"fork-thread":     true,
+		"retry":           true,
+		"undo":            true,
+		"edit-last":       true,
+		"export-thread":   true,
+		"format":          true,
+		"count-tokens":    true,
 		"prompt":          true,
+		"file":            true,
 		"set-completions": true,
 		"help":            true,
 		"role-file":       true,
@@ -923,13 +1349,26 @@ func syncFlag(cmd *cobra.Command, meta ConfigMetadata, alias string) error {
 }
 
 func createConfigFromViper() config.Config {
+	sections := readConfigSections()
+
 	return config.Config{
 		Name:                 viper.GetString("name"),
 		APIKey:               viper.GetString("api_key"),
 		ApifyAPIKey:          viper.GetString("apify_api_key"),
 		Model:                viper.GetString("model"),
+		Provider:             viper.GetString("provider"),
 		MaxTokens:            viper.GetInt("max_tokens"),
 		ContextWindow:        viper.GetInt("context_window"),
+		MaxToolRounds:        viper.GetInt("max_tool_rounds"),
+		ContextStrategy:      viper.GetString("context_strategy"),
+		MaxAttempts:          viper.GetInt("max_attempts"),
+		RetryDeadline:        viper.GetInt("retry_deadline"),
+		RequestTimeout:       viper.GetInt("request_timeout"),
+		ConnectTimeout:       viper.GetInt("connect_timeout"),
+		HistoryBackend:       viper.GetString("history_backend"),
+		HistoryEncryption:    viper.GetBool("history_encryption"),
+		HistoryKey:           os.Getenv(fmt.Sprintf(historyKeyEnv, strings.ToUpper(viper.GetEnvPrefix()))),
+		HistoryKeyFile:       viper.GetString("history_key_file"),
 		Role:                 viper.GetString("role"),
 		Temperature:          viper.GetFloat64("temperature"),
 		TopP:                 viper.GetFloat64("top_p"),
@@ -940,6 +1379,7 @@ func createConfigFromViper() config.Config {
 		URL:                  viper.GetString("url"),
 		CompletionsPath:      viper.GetString("completions_path"),
 		ResponsesPath:        viper.GetString("responses_path"),
+		MessagesPath:         viper.GetString("messages_path"),
 		TranscriptionsPath:   viper.GetString("transcriptions_path"),
 		SpeechPath:           viper.GetString("speech_path"),
 		ImageGenerationsPath: viper.GetString("image_generations_path"),
@@ -947,11 +1387,14 @@ func createConfigFromViper() config.Config {
 		ModelsPath:           viper.GetString("models_path"),
 		AuthHeader:           viper.GetString("auth_header"),
 		AuthTokenPrefix:      viper.GetString("auth_token_prefix"),
+		AnthropicVersion:     viper.GetString("anthropic_version"),
 		CommandPrompt:        viper.GetString("command_prompt"),
 		CommandPromptColor:   viper.GetString("command_prompt_color"),
 		OutputPrompt:         viper.GetString("output_prompt"),
 		OutputPromptColor:    viper.GetString("output_prompt_color"),
 		AutoCreateNewThread:  viper.GetBool("auto_create_new_thread"),
+		AutoTitle:            viper.GetBool("auto_title"),
+		FenceContext:         viper.GetBool("fence_context"),
 		TrackTokenUsage:      viper.GetBool("track_token_usage"),
 		SkipTLSVerify:        viper.GetBool("skip_tls_verify"),
 		Multiline:            viper.GetBool("multiline"),
@@ -960,7 +1403,506 @@ func createConfigFromViper() config.Config {
 		Voice:                viper.GetString("voice"),
 		UserAgent:            viper.GetString("user_agent"),
 		CustomHeaders:        viper.GetStringMapString("custom_headers"),
+		Models:               readModels(),
+		Tools:                sections.Tools,
+		MCPServers:           sections.MCPServers,
+		Retention:            sections.Retention,
+	}
+}
+
+// configSections holds the sections that are decoded straight from the config
+// file, because viper lowercases map keys and would mangle the property names in
+// the tool schemas and the environment variables and headers of MCP servers.
+type configSections struct {
+	Tools      []config.ToolConfig      `yaml:"tools"`
+	MCPServers []config.MCPServerConfig `yaml:"mcp_servers"`
+	Retention  config.RetentionConfig   `yaml:"retention"`
+}
+
+func readConfigSections() configSections {
+	var sections configSections
+
+	configFile := viper.ConfigFileUsed()
+	if configFile == "" {
+		return sections
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return sections
+	}
+
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		zap.S().Warnf("Ignoring the tools, mcp_servers and retention sections of the config: %v", err)
+		return configSections{}
+	}
+	return sections
+}
+
+func mcpServerNames(servers []config.MCPServerConfig) []string {
+	var result []string
+	for _, server := range servers {
+		result = append(result, server.Name)
+	}
+	return result
+}
+
+// readModels decodes the models section of the config. Viper lowercases keys, so
+// model names are matched case-insensitively.
+func readModels() map[string]config.ModelSpec {
+	var models map[string]config.ModelSpec
+	if err := viper.UnmarshalKey("models", &models); err != nil {
+		zap.S().Warnf("Ignoring the models section of the config: %v", err)
+		return nil
+	}
+	return models
+}
+
+// lastTurnInput takes the last exchange back from the thread and returns its
+// query, after opening it in the editor when edit is set.
+func lastTurnInput(c *client.Client, edit bool) (string, error) {
+	query, err := c.LastQuery()
+	if err != nil {
+		return "", err
+	}
+
+	if edit {
+		if query, err = utils.EditText(query); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := c.RemoveLastExchange(); err != nil {
+		return "", err
+	}
+
+	return query, nil
+}
+
+// printSearchResults prints the messages of all threads that contain every word
+// of query, newest first.
+func printSearchResults(query string) error {
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+
+	results, err := store.(history.Searcher).Search(query, searchLimit)
+	if err != nil {
+		return err
+	}
+
+	if len(results) == 0 {
+		zap.S().Infof("No messages match %q", query)
+		return nil
+	}
+
+	for _, result := range results {
+		zap.S().Infof("- %s [%s] %s\n  %s\n", result.Thread, result.Role, result.Timestamp.Format(searchTimeLayout), result.Snippet)
+	}
+
+	return nil
+}
+
+// openHistory opens the history store of the config, which encrypts the thread
+// files when history_encryption is set.
+func openHistory() (history.Store, error) {
+	if !cfg.HistoryEncryption {
+		return history.NewStore(cfg.HistoryBackend)
+	}
+
+	if cfg.HistoryBackend == history.DatabaseBackend {
+		return nil, errors.New(errEncryptedDatabase)
+	}
+
+	key, err := historyCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := history.NewStore(cfg.HistoryBackend)
+	if files, ok := store.(*history.FileIO); ok {
+		files.WithCipher(key)
+	}
+	return store, err
+}
+
+// openBlobs opens the directory the attachments of queries are stored in, which
+// is encrypted along with the threads.
+func openBlobs() (*history.Blobs, error) {
+	blobs, err := history.NewBlobs()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.HistoryEncryption {
+		key, err := historyCipher()
+		if err != nil {
+			return nil, err
+		}
+		blobs.WithCipher(key)
+	}
+
+	return blobs, nil
+}
+
+// historyCipher returns the cipher for the history key. The key is the contents
+// of history_key_file, or else the HISTORY_KEY environment variable, or else a
+// passphrase that is asked for on the terminal. It is only asked for once.
+func historyCipher() (*history.Cipher, error) {
+	if cachedCipher != nil {
+		return cachedCipher, nil
+	}
+
+	var (
+		secret []byte
+		err    error
+	)
+
+	switch {
+	case cfg.HistoryKeyFile != "":
+		if secret, err = os.ReadFile(cfg.HistoryKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to read the history key file: %w", err)
+		}
+		secret = bytes.TrimRight(secret, "\r\n")
+	case cfg.HistoryKey != "":
+		secret = []byte(cfg.HistoryKey)
+	case readline.IsTerminal(int(os.Stdin.Fd())):
+		if secret, err = readline.Password("History passphrase: "); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf(errNoHistoryKey, strings.ToUpper(viper.GetEnvPrefix()))
+	}
+
+	cachedCipher, err = history.NewCipher(secret)
+	return cachedCipher, err
+}
+
+// convertHistory encrypts or decrypts the thread files in place.
+func convertHistory(encrypt bool) error {
+	sugar := zap.S()
+
+	if cfg.HistoryBackend == history.DatabaseBackend {
+		return errors.New(errEncryptedDatabase)
+	}
+
+	key, err := historyCipher()
+	if err != nil {
+		return err
+	}
+
+	files, err := history.New()
+	if err != nil {
+		return err
+	}
+	files.WithCipher(key)
+
+	blobs, err := history.NewBlobs()
+	if err != nil {
+		return err
+	}
+	blobs.WithCipher(key)
+
+	if encrypt {
+		count, err := files.SetEncryption(key)
+		if err != nil {
+			return err
+		}
+		attachments, err := blobs.SetEncryption(key)
+		if err != nil {
+			return err
+		}
+		sugar.Infof("Encrypted %d threads and %d attachments", count, attachments)
+		if !cfg.HistoryEncryption {
+			sugar.Infoln("Set history_encryption to true to encrypt new threads as well.")
+		}
+		return nil
+	}
+
+	count, err := files.SetEncryption(nil)
+	if err != nil {
+		return err
+	}
+	attachments, err := blobs.SetEncryption(nil)
+	if err != nil {
+		return err
+	}
+	sugar.Infof("Decrypted %d threads and %d attachments", count, attachments)
+	if cfg.HistoryEncryption {
+		sugar.Infoln("Set history_encryption to false, or threads are encrypted again when they are written.")
+	}
+	return nil
+}
+
+// pruneHistory deletes the threads that exceed the retention policy. With
+// verbose set, every thread is printed with the reason it is removed, and with
+// dryRun set, with the files that would be removed, while nothing is deleted.
+func pruneHistory(dryRun, verbose bool) error {
+	sugar := zap.S()
+
+	store, err := openHistory()
+	if store == nil {
+		return err
+	}
+	store.SetThread(cfg.Thread)
+
+	candidates, err := history.NewHistory(store).Prunable(cfg.Retention, time.Now())
+	if err != nil {
+		if os.IsNotExist(err) && !verbose {
+			return nil
+		}
+		return err
+	}
+
+	if !dryRun {
+		defer markPruned()
+	}
+
+	if len(candidates) == 0 {
+		if verbose {
+			sugar.Infoln("No threads exceed the retention policy.")
+		}
+		return nil
+	}
+
+	files := config.NewStore()
+	db, isDatabase := store.(*history.DBStore)
+
+	for _, candidate := range candidates {
+		var paths []string
+		if !isDatabase {
+			if paths, err = files.ThreadFiles(candidate.Thread); err != nil {
+				return err
+			}
+		}
+
+		if !dryRun {
+			if isDatabase {
+				err = db.DeleteThread(candidate.Thread)
+			} else {
+				err = files.DeleteThread(candidate.Thread)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if !verbose {
+			continue
+		}
+
+		sugar.Infof("- %s: %s", candidate.Thread, candidate.Reason)
+		if dryRun {
+			for _, path := range paths {
+				sugar.Infof("    %s", path)
+			}
+		}
+	}
+
+	if verbose {
+		if dryRun {
+			sugar.Infof("Would delete %d threads.", len(candidates))
+		} else {
+			sugar.Infof("Deleted %d threads.", len(candidates))
+		}
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	removed, err := collectBlobs(store)
+	if err != nil {
+		return fmt.Errorf("failed to remove the attachments of the deleted threads: %w", err)
+	}
+	if verbose && removed > 0 {
+		sugar.Infof("Removed %d attachments no thread refers to.", removed)
+	}
+
+	return nil
+}
+
+// collectBlobs removes the attachments that no thread refers to anymore. The
+// threads of both backends are read, so switching between them loses nothing.
+func collectBlobs(store history.Store) (int, error) {
+	blobs, err := openBlobs()
+	if err != nil {
+		return 0, err
+	}
+
+	stores := []history.Store{store}
+	if _, isDatabase := store.(*history.DBStore); isDatabase {
+		files, err := history.New()
+		if err != nil {
+			return 0, err
+		}
+		stores = append(stores, files)
+	} else if db, err := openExistingDatabase(); err != nil {
+		return 0, err
+	} else if db != nil {
+		stores = append(stores, db)
+	}
+
+	referenced := make(map[string]bool)
+	for _, s := range stores {
+		hashes, err := history.NewHistory(s).ReferencedBlobs()
+		if err != nil {
+			return 0, err
+		}
+		for hash := range hashes {
+			referenced[hash] = true
+		}
+	}
+
+	return blobs.Collect(referenced, time.Now().Add(-blobGracePeriod))
+}
+
+// openExistingDatabase opens the history database when one was created, and
+// returns nil otherwise.
+func openExistingDatabase() (*history.DBStore, error) {
+	configHome, err := internal.GetConfigHome()
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(configHome, history.DatabaseFile)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return history.NewDB(path)
+}
+
+// pruneDue reports whether the threads were last pruned more than a day ago,
+// according to the modification time of a stamp file in the config home.
+func pruneDue() bool {
+	configHome, err := internal.GetConfigHome()
+	if err != nil {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(configHome, pruneStampFile))
+	if err != nil {
+		return true
+	}
+
+	return time.Since(info.ModTime()) > pruneInterval
+}
+
+func markPruned() {
+	configHome, err := internal.GetConfigHome()
+	if err != nil {
+		return
+	}
+
+	_ = os.WriteFile(filepath.Join(configHome, pruneStampFile), nil, 0644)
+}
+
+// printThreads prints the threads as a table, marking the current thread with
+// an asterisk. Unknown values are shown as a dash.
+func printThreads(threads []history.ThreadInfo) {
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "  THREAD\tTITLE\tTAGS\tMESSAGES\tTOKENS\tMODEL\tUPDATED")
+
+	for _, thread := range threads {
+		name := fmt.Sprintf("- %s", thread.Name)
+		if thread.Current {
+			name = fmt.Sprintf("* %s (current)", thread.Name)
+		}
+
+		updated := ""
+		if !thread.Updated.IsZero() {
+			updated = thread.Updated.Format(searchTimeLayout)
+		}
+
+		tokens := ""
+		if thread.Tokens > 0 {
+			tokens = strconv.Itoa(thread.Tokens)
+		}
+
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", name, orDash(thread.Title), orDash(strings.Join(thread.Tags, ",")),
+			thread.Messages, orDash(tokens), orDash(thread.Model), orDash(updated))
+	}
+	_ = w.Flush()
+
+	for _, line := range strings.Split(strings.TrimRight(b.String(), "\n"), "\n") {
+		zap.S().Infoln(strings.TrimRight(line, " "))
+	}
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// deleteDatabaseThreads deletes the threads of the history database that match
+// pattern, which supports wildcards like --delete-thread does for thread files.
+func deleteDatabaseThreads(pattern string) error {
+	files, err := history.New()
+	if err != nil {
+		return err
+	}
+
+	db, err := history.OpenDatabase(files)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := db.Delete(pattern)
+	if err != nil {
+		return err
 	}
+
+	if len(deleted) == 0 {
+		return &config.FileNotFoundError{Path: pattern}
+	}
+
+	return nil
+}
+
+// printTokenCount prints the number of tokens of the piped input and the
+// arguments, counted with the tokenizer of the configured model.
+func printTokenCount(args []string) error {
+	var input []string
+
+	stat, _ := os.Stdin.Stat()
+	if (stat.Mode() & os.ModeCharDevice) == 0 {
+		pipeContent, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read from pipe: %w", err)
+		}
+		if len(pipeContent) > 0 {
+			input = append(input, string(pipeContent))
+		}
+	}
+
+	if len(args) > 0 {
+		input = append(input, strings.Join(args, " "))
+	}
+
+	if len(input) == 0 {
+		return errors.New("the --count-tokens flag needs piped input or a prompt")
+	}
+
+	encoding := cfg.Capabilities().Encoding
+
+	var t tokenizer.Tokenizer
+
+	bpe, err := tokenizer.Load(encoding)
+	if err != nil {
+		zap.S().Warnf("Warning: the %s vocabulary is not available (%v), the count is an estimate", encoding, err)
+		t = tokenizer.NewEstimator(encoding)
+	} else {
+		t = bpe
+	}
+
+	zap.S().Infoln(t.Count(strings.Join(input, "\n")))
+	return nil
 }
 
 func fileExists(filename string) bool {
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	gitDir        = ".git"
+	gitIgnoreFile = ".gitignore"
+	NoFilesMatch  = "no files match %s"
+)
+
+// ExpandFiles resolves paths, glob patterns and directories to the files they
+// name, in the order they are given and without duplicates. Directories are
+// walked recursively. Files matched by a glob or found in a directory are
+// skipped when a .gitignore of their repository ignores them, while files and
+// directories that are named explicitly are always kept.
+func ExpandFiles(patterns []string) ([]string, error) {
+	var (
+		result []string
+		seen   = make(map[string]bool)
+		rules  = make(map[string][]ignoreRule)
+	)
+
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			result = append(result, path)
+		}
+	}
+
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		explicit := true
+
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(pattern); err != nil {
+				return nil, err
+			}
+			explicit = false
+		}
+
+		var found int
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+
+			if !explicit {
+				ignored, err := isIgnored(rules, match, info.IsDir())
+				if err != nil {
+					return nil, err
+				}
+				if ignored {
+					continue
+				}
+			}
+
+			if !info.IsDir() {
+				add(match)
+				found++
+				continue
+			}
+
+			err = filepath.WalkDir(match, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if path == match {
+					return nil
+				}
+				if entry.IsDir() && entry.Name() == gitDir {
+					return filepath.SkipDir
+				}
+
+				ignored, err := isIgnored(rules, path, entry.IsDir())
+				if err != nil {
+					return err
+				}
+
+				switch {
+				case ignored && entry.IsDir():
+					return filepath.SkipDir
+				case ignored || entry.IsDir() || !entry.Type().IsRegular():
+					return nil
+				}
+
+				add(path)
+				found++
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if found == 0 {
+			return nil, fmt.Errorf(NoFilesMatch, pattern)
+		}
+	}
+
+	return result, nil
+}
+
+// ignoreRule is a single pattern of a .gitignore file.
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// isIgnored tells if path is ignored by the .gitignore files of the
+// directories above it, up to the root of its repository. The rules of a
+// directory are read once and cached in rules. Like git, a path is ignored when
+// one of its parent directories is, and a negated pattern cannot bring it back.
+func isIgnored(rules map[string][]ignoreRule, path string, isDir bool) (bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+
+	root := filepath.Dir(abs)
+	for {
+		if _, err := os.Stat(filepath.Join(root, gitDir)); err == nil {
+			break
+		}
+		if root == filepath.Dir(root) {
+			// like git itself, .gitignore files only count inside a repository
+			return false, nil
+		}
+		root = filepath.Dir(root)
+	}
+
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return false, err
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+
+	// every directory on the way down is checked against the .gitignore files
+	// above it, with the rules of deeper directories applied last
+	for j := range parts {
+		candidateIsDir := j < len(parts)-1 || isDir
+
+		ignored := false
+		for k := 0; k <= j; k++ {
+			dirRules, err := readIgnoreRules(rules, filepath.Join(root, filepath.FromSlash(strings.Join(parts[:k], "/"))))
+			if err != nil {
+				return false, err
+			}
+
+			candidate := strings.Join(parts[k:j+1], "/")
+			for _, rule := range dirRules {
+				if rule.dirOnly && !candidateIsDir {
+					continue
+				}
+				if rule.pattern.MatchString(candidate) {
+					ignored = !rule.negate
+				}
+			}
+		}
+
+		if ignored {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func readIgnoreRules(rules map[string][]ignoreRule, dir string) ([]ignoreRule, error) {
+	if cached, ok := rules[dir]; ok {
+		return cached, nil
+	}
+
+	file, err := os.Open(filepath.Join(dir, gitIgnoreFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		rules[dir] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var result []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			result = append(result, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	rules[dir] = result
+	return result, nil
+}
+
+// parseIgnoreRule parses a line of a .gitignore file. Patterns without a slash
+// match at any depth, while the others are relative to the directory of the
+// .gitignore file.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, `\`)
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expression := globToRegexp(line)
+	if !anchored {
+		expression = "(.*/)?" + expression
+	}
+
+	pattern, err := regexp.Compile("^" + expression + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.pattern = pattern
+
+	return rule, true
+}
+
+// globToRegexp translates a gitignore glob, where ** also matches slashes.
+func globToRegexp(glob string) string {
+	var result strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			result.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			result.WriteString(".*")
+			i++
+		case c == '*':
+			result.WriteString("[^/]*")
+		case c == '?':
+			result.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				result.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			result.WriteString("[" + class + "]")
+			i += end
+		default:
+			result.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return result.String()
+}
@@ -0,0 +1,91 @@
+package utils_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kardolus/chatgpt-cli/cmd/chatgpt/utils"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitFiles(t *testing.T) {
+	spec.Run(t, "Testing the expansion of files", testFiles, spec.Report(report.Terminal{}))
+}
+
+func testFiles(t *testing.T, when spec.G, it spec.S) {
+	var dir string
+
+	write := func(path, content string) string {
+		path = filepath.Join(dir, path)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		dir = t.TempDir()
+		Expect(os.Mkdir(filepath.Join(dir, ".git"), 0755)).To(Succeed())
+
+		write(".gitignore", "*.log\n/build/\nvendor/\n!keep.log\n!build/out.go\n")
+		write("main.go", "package main")
+		write("debug.log", "log")
+		write("keep.log", "log")
+		write("build/out.go", "package build")
+		write("api/client.go", "package api")
+		write("api/vendor/lib.go", "package lib")
+		write("api/.gitignore", "generated_*.go\n")
+		write("api/generated_mocks.go", "package api")
+		write(".git/config", "[core]")
+	})
+
+	when("ExpandFiles()", func() {
+		it("walks directories and skips what .gitignore ignores", func() {
+			files, err := utils.ExpandFiles([]string{dir})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(ConsistOf(
+				filepath.Join(dir, ".gitignore"),
+				filepath.Join(dir, "main.go"),
+				filepath.Join(dir, "keep.log"),
+				filepath.Join(dir, "api", ".gitignore"),
+				filepath.Join(dir, "api", "client.go"),
+			))
+		})
+
+		it("filters globs but keeps files that are named explicitly", func() {
+			files, err := utils.ExpandFiles([]string{
+				filepath.Join(dir, "*.log"),
+				filepath.Join(dir, "api", "generated_mocks.go"),
+				filepath.Join(dir, "main.go"),
+				filepath.Join(dir, "*.go"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(Equal([]string{
+				filepath.Join(dir, "keep.log"),
+				filepath.Join(dir, "api", "generated_mocks.go"),
+				filepath.Join(dir, "main.go"),
+			}))
+		})
+
+		it("does not bring back files of an ignored directory with a negated pattern", func() {
+			_, err := utils.ExpandFiles([]string{filepath.Join(dir, "build", "*.go")})
+			Expect(err).To(MatchError(ContainSubstring("no files match")))
+
+			files, err := utils.ExpandFiles([]string{filepath.Join(dir, "*", "*.go")})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(Equal([]string{filepath.Join(dir, "api", "client.go")}))
+		})
+
+		it("fails when a pattern matches nothing", func() {
+			_, err := utils.ExpandFiles([]string{filepath.Join(dir, "*.md")})
+			Expect(err).To(MatchError(ContainSubstring("no files match")))
+
+			_, err = utils.ExpandFiles([]string{filepath.Join(dir, "missing.go")})
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+}
@@ -5,28 +5,26 @@ import (
 	"errors"
 	"fmt"
 	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/config"
 	"github.com/kardolus/chatgpt-cli/internal"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 	"unicode/utf8"
 )
 
 const (
-	AudioPattern           = "-audio"
-	TranscribePattern      = "-transcribe"
-	TTSPattern             = "-tts"
-	ImagePattern           = "-image"
-	O1ProPattern           = "o1-pro"
-	GPT5Pattern            = "gpt-5"
 	InvalidMCPPatter       = "the MCP pattern has to be of the form <provider>/<plugin>[@<version>]"
 	ApifyProvider          = "apify"
-	UnsupportedProvider    = "only apify is currently supported"
+	UnsupportedProvider    = "only apify and the servers in mcp_servers are supported"
 	LatestVersion          = "latest"
 	InvalidParams          = "params need to be pairs or a JSON object"
 	InvalidApifyFunction   = "apify functions need to be of the form user~actor"
 	InteractiveHistoryFile = "interactive_history.txt"
+	EmptyEdit              = "the edited message is empty"
 )
 
 func ColorToAnsi(color string) (string, string) {
@@ -70,6 +68,57 @@ func CreateHistoryFile(history []string) (string, error) {
 	return fullPath, nil
 }
 
+// EditText opens text in the editor set by $VISUAL or $EDITOR and returns the
+// text once the editor exits.
+func EditText(text string) (string, error) {
+	file, err := os.CreateTemp("", "chatgpt-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.WriteString(text); err != nil {
+		_ = file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	// the editor may come with arguments, like "code --wait"
+	editor := strings.Fields(editorCommand())
+	cmd := exec.Command(editor[0], append(editor[1:], file.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run the editor %s: %w", editor[0], err)
+	}
+
+	edited, err := FileToString(file.Name())
+	if err != nil {
+		return "", err
+	}
+
+	edited = strings.TrimRight(edited, "\r\n")
+	if strings.TrimSpace(edited) == "" {
+		return "", errors.New(EmptyEdit)
+	}
+
+	return edited, nil
+}
+
+func editorCommand() string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.TrimSpace(os.Getenv(name)); editor != "" {
+			return editor
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return "notepad"
+	}
+	return "vi"
+}
+
 func FileToString(fileName string) (string, error) {
 	bytes, err := os.ReadFile(fileName)
 	if err != nil {
@@ -136,7 +185,9 @@ func IsBinary(data []byte) bool {
 	return binaryCount > threshold
 }
 
-func ValidateFlags(model string, flags map[string]bool) error {
+// ValidateFlags checks that the flags can be combined and that the model, as
+// described by its capabilities, supports the features they ask for.
+func ValidateFlags(caps config.ModelCapabilities, flags map[string]bool) error {
 	if flags["new-thread"] && (flags["set-thread"] || flags["thread"]) {
 		return errors.New("the --new-thread flag cannot be used with the --set-thread or --thread flags")
 	}
@@ -155,62 +206,73 @@ func ValidateFlags(model string, flags map[string]bool) error {
 	if !flags["mcp"] && flags["params"] {
 		return errors.New("the --params flag cannot be used without the --mcp flag")
 	}
-	if flags["audio"] && !strings.Contains(model, AudioPattern) {
+	if flags["audio"] && !caps.Audio {
 		return errors.New("the --audio flag cannot be used without a compatible model, ie gpt-4o-audio-preview (see --list-models)")
 	}
-	if flags["transcribe"] && !strings.Contains(model, TranscribePattern) {
+	if flags["transcribe"] && !caps.Transcription {
 		return errors.New("the --transcribe flag cannot be used without a compatible model, ie gpt-4o-transcribe (see --list-models)")
 	}
-	if flags["speak"] && flags["output"] && !strings.Contains(model, TTSPattern) {
+	if flags["speak"] && flags["output"] && !caps.TTS {
 		return errors.New("the --speak and --output flags cannot be used without a compatible model, ie gpt-4o-mini-tts (see --list-models)")
 	}
-	if flags["draw"] && flags["output"] && !strings.Contains(model, ImagePattern) {
+	if flags["draw"] && flags["output"] && !caps.Image {
 		return errors.New("the --draw and --output flags cannot be used without a compatible model, ie gpt-image-1 (see --list-models)")
 	}
-	if flags["voice"] && !strings.Contains(model, TTSPattern) {
+	if flags["voice"] && !caps.TTS {
 		return errors.New("the --voice flag cannot be used without a compatible model, ie gpt-4o-mini-tts (see --list-models)")
 	}
-	if flags["effort"] && !(strings.Contains(model, O1ProPattern) || strings.Contains(model, GPT5Pattern)) {
+	if flags["effort"] && !caps.Reasoning {
 		return errors.New("the --effort flag cannot be used with non o1-pro or gpt-5 models (see --list-models)")
 	}
+	if flags["image"] && !flags["draw"] && !caps.Vision {
+		return errors.New("the --image flag cannot be used without a vision capable model, ie gpt-4o (see --list-models)")
+	}
 
 	return nil
 }
 
 // ParseMCPPlugin expects input for the apify provider of the form [provider]/[user]~[actor]@[version]
-func ParseMCPPlugin(input string) (api.MCPRequest, error) {
+// and input for a configured MCP server of the form [server]/[tool], [server]/resource:[uri]
+// or [server]/prompt:[name]. Servers are only accepted when they are listed in servers.
+func ParseMCPPlugin(input string, servers ...string) (api.MCPRequest, error) {
 	var result api.MCPRequest
 
-	fields := strings.Split(input, "/")
-	if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+	provider, function, found := strings.Cut(input, "/")
+	if !found || provider == "" || function == "" {
 		return api.MCPRequest{}, errors.New(InvalidMCPPatter)
 	}
 
-	validProviders := map[string]bool{
-		ApifyProvider: true,
+	for _, server := range servers {
+		if server == provider {
+			result.Provider = provider
+			result.Function = function
+			return result, nil
+		}
 	}
 
-	if validProviders[strings.ToLower(fields[0])] {
-		result.Provider = fields[0]
-	} else {
+	if strings.ToLower(provider) != ApifyProvider {
 		return api.MCPRequest{}, errors.New(UnsupportedProvider)
 	}
 
-	function := strings.Split(fields[1], "@")
+	if strings.Contains(function, "/") {
+		return api.MCPRequest{}, errors.New(InvalidMCPPatter)
+	}
+
+	result.Provider = provider
 
-	result.Function = function[0]
+	fields := strings.Split(function, "@")
 
-	if result.Provider == ApifyProvider {
-		parts := strings.Split(result.Function, "~")
-		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-			return api.MCPRequest{}, errors.New(InvalidApifyFunction)
-		}
+	result.Function = fields[0]
+
+	parts := strings.Split(result.Function, "~")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return api.MCPRequest{}, errors.New(InvalidApifyFunction)
 	}
 
-	if len(function) == 1 {
+	if len(fields) == 1 {
 		result.Version = LatestVersion
-	} else if len(function) == 2 {
-		result.Version = function[1]
+	} else if len(fields) == 2 {
+		result.Version = fields[1]
 	}
 
 	return result, nil
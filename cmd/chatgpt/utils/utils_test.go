@@ -3,6 +3,7 @@ package utils_test
 import (
 	"fmt"
 	"github.com/kardolus/chatgpt-cli/cmd/chatgpt/utils"
+	"github.com/kardolus/chatgpt-cli/config"
 	"testing"
 	"time"
 
@@ -142,6 +143,43 @@ func testUtils(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	when("EditText()", func() {
+		it.Before(func() {
+			t.Setenv("VISUAL", "")
+		})
+
+		it("returns the text as the editor left it", func() {
+			t.Setenv("EDITOR", "sed -i s/world/there/")
+
+			result, err := utils.EditText("hello world\n")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("hello there"))
+		})
+
+		it("prefers VISUAL over EDITOR", func() {
+			t.Setenv("VISUAL", "sed -i s/hello/bye/")
+			t.Setenv("EDITOR", "false")
+
+			result, err := utils.EditText("hello")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal("bye"))
+		})
+
+		it("returns an error when the editor fails", func() {
+			t.Setenv("EDITOR", "false")
+
+			_, err := utils.EditText("hello")
+			Expect(err).To(MatchError(ContainSubstring("failed to run the editor false")))
+		})
+
+		it("returns an error when the text was removed", func() {
+			t.Setenv("EDITOR", "sed -i d")
+
+			_, err := utils.EditText("hello")
+			Expect(err).To(MatchError(utils.EmptyEdit))
+		})
+	})
+
 	when("IsBinary()", func() {
 		it("should return false for a regular string", func() {
 			Expect(utils.IsBinary([]byte("regular string"))).To(BeFalse())
@@ -199,90 +237,90 @@ func testUtils(t *testing.T, when spec.G, it spec.S) {
 		})
 
 		it("doesn't throw an error when no flags are provided", func() {
-			Expect(utils.ValidateFlags(defaultModel, flags)).To(Succeed())
+			Expect(utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)).To(Succeed())
 		})
 		it("should return an error when --new-thread and --set-thread are both used", func() {
 			flags["new-thread"] = true
 			flags["set-thread"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should return an error when --new-thread and --thread are both used", func() {
 			flags["new-thread"] = true
 			flags["thread"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should return an error when --speak is used but --output is omitted", func() {
 			flags["speak"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should return an error when --draw is used but --output is omitted", func() {
 			flags["draw"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should return an error when --output is used but --speak or --draw are omitted", func() {
 			flags["output"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should return an error when --audio is used with an incompatible model", func() {
 			flags["audio"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should NOT return an error when --audio is used with a compatible model", func() {
 			flags["audio"] = true
 
-			err := utils.ValidateFlags(defaultModel+utils.AudioPattern, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel+"-audio", nil), flags)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("should return an error when --transcribe is used with an incompatible model", func() {
 			flags["transcribe"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should NOT return an error when --transcribe is used with a compatible model", func() {
 			flags["transcribe"] = true
 
-			err := utils.ValidateFlags(defaultModel+utils.TranscribePattern, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel+"-transcribe", nil), flags)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("should return an error when --speak and --output flags are used with an incompatible model", func() {
 			flags["speak"] = true
 			flags["output"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should NOT return an error when --speak and --output flags are used with a compatible model", func() {
 			flags["speak"] = true
 			flags["output"] = true
 
-			err := utils.ValidateFlags(defaultModel+utils.TTSPattern, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel+"-tts", nil), flags)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("should return an error when --draw and --output flags are used with an incompatible model", func() {
 			flags["draw"] = true
 			flags["output"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should NOT return an error when --draw and --output flags are used with a compatible model", func() {
 			flags["draw"] = true
 			flags["output"] = true
 
-			err := utils.ValidateFlags(defaultModel+utils.ImagePattern, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel+"-image", nil), flags)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("should NOT return an error when --draw, --image and --output flags are used with a compatible model", func() {
@@ -290,41 +328,59 @@ func testUtils(t *testing.T, when spec.G, it spec.S) {
 			flags["output"] = true
 			flags["image"] = true
 
-			err := utils.ValidateFlags(defaultModel+utils.ImagePattern, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel+"-image", nil), flags)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("should return an error when --voice is used with an incompatible model", func() {
 			flags["voice"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should NOT return an error when --voice is used with a compatible model", func() {
 			flags["voice"] = true
 
-			err := utils.ValidateFlags(defaultModel+utils.TTSPattern, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel+"-tts", nil), flags)
 			Expect(err).NotTo(HaveOccurred())
 		})
 		it("should return an error when --effort is used with an incompatible model", func() {
 			flags["effort"] = true
 
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should NOT return an error when --effort is used with a compatible model", func() {
 			flags["effort"] = true
 
-			Expect(utils.ValidateFlags(defaultModel+utils.O1ProPattern, flags)).To(Succeed())
-			Expect(utils.ValidateFlags(defaultModel+utils.GPT5Pattern, flags)).To(Succeed())
+			Expect(utils.ValidateFlags(config.GetCapabilities(defaultModel+"o1-pro", nil), flags)).To(Succeed())
+			Expect(utils.ValidateFlags(config.GetCapabilities(defaultModel+"gpt-5", nil), flags)).To(Succeed())
+		})
+		it("should NOT return an error when --effort is used with a model declared as reasoning in the models section", func() {
+			flags["effort"] = true
+
+			reasoning := true
+			models := map[string]config.ModelSpec{"corp-*": {Reasoning: &reasoning}}
+
+			Expect(utils.ValidateFlags(config.GetCapabilities("corp-alias", models), flags)).To(Succeed())
+		})
+		it("should return an error when --image is used with a model without vision", func() {
+			flags["image"] = true
+
+			vision := false
+			models := map[string]config.ModelSpec{defaultModel: {Vision: &vision}}
+
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, models), flags)
+			Expect(err).To(HaveOccurred())
+			Expect(utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)).To(Succeed())
 		})
 		it("should return an error when the --param flag is used without --mcp", func() {
 			flags["param"] = true
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 		it("should return an error when the --params flag is used without --mcp", func() {
 			flags["params"] = true
-			err := utils.ValidateFlags(defaultModel, flags)
+			err := utils.ValidateFlags(config.GetCapabilities(defaultModel, nil), flags)
 			Expect(err).To(HaveOccurred())
 		})
 	})
@@ -394,6 +450,23 @@ func testUtils(t *testing.T, when spec.G, it spec.S) {
 			Expect(result.Function).To(Equal(function))
 			Expect(result.Version).To(Equal(version))
 		})
+		it("accepts the configured mcp servers", func() {
+			result, err := utils.ParseMCPPlugin("github/search_issues", "files", "github")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Provider).To(Equal("github"))
+			Expect(result.Function).To(Equal("search_issues"))
+			Expect(result.Version).To(BeEmpty())
+		})
+		it("keeps the slashes in resource uris of configured servers", func() {
+			result, err := utils.ParseMCPPlugin("files/resource:file:///tmp/notes.md@v2", "files")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Provider).To(Equal("files"))
+			Expect(result.Function).To(Equal("resource:file:///tmp/notes.md@v2"))
+		})
+		it("throws an error for servers that are not configured", func() {
+			_, err := utils.ParseMCPPlugin("github/search_issues", "files")
+			Expect(err).To(MatchError(utils.UnsupportedProvider))
+		})
 	})
 
 	when("ParseParams()", func() {
@@ -1,40 +1,59 @@
 package config
 
 type Config struct {
-	Name                 string            `yaml:"name"`
-	APIKey               string            `yaml:"api_key"`
-	Model                string            `yaml:"model"`
-	MaxTokens            int               `yaml:"max_tokens"`
-	ContextWindow        int               `yaml:"context_window"`
-	Role                 string            `yaml:"role"`
-	Temperature          float64           `yaml:"temperature"`
-	TopP                 float64           `yaml:"top_p"`
-	FrequencyPenalty     float64           `yaml:"frequency_penalty"`
-	PresencePenalty      float64           `yaml:"presence_penalty"`
-	Thread               string            `yaml:"thread"`
-	OmitHistory          bool              `yaml:"omit_history"`
-	URL                  string            `yaml:"url"`
-	CompletionsPath      string            `yaml:"completions_path"`
-	ModelsPath           string            `yaml:"models_path"`
-	ResponsesPath        string            `yaml:"responses_path"`
-	SpeechPath           string            `yaml:"speech_path"`
-	ImageGenerationsPath string            `yaml:"image_generations_path"`
-	ImageEditsPath       string            `yaml:"image_edits_path"`
-	TranscriptionsPath   string            `yaml:"transcriptions_path"`
-	AuthHeader           string            `yaml:"auth_header"`
-	AuthTokenPrefix      string            `yaml:"auth_token_prefix"`
-	CommandPrompt        string            `yaml:"command_prompt"`
-	CommandPromptColor   string            `yaml:"command_prompt_color"`
-	OutputPrompt         string            `yaml:"output_prompt"`
-	OutputPromptColor    string            `yaml:"output_prompt_color"`
-	AutoCreateNewThread  bool              `yaml:"auto_create_new_thread"`
-	TrackTokenUsage      bool              `yaml:"track_token_usage"`
-	SkipTLSVerify        bool              `yaml:"skip_tls_verify"`
-	Multiline            bool              `yaml:"multiline"`
-	Seed                 int               `yaml:"seed"`
-	Effort               string            `yaml:"effort"`
-	Voice                string            `yaml:"voice"`
-	ApifyAPIKey          string            `yaml:"apify_api_key"`
-	UserAgent            string            `yaml:"user_agent"`
-	CustomHeaders        map[string]string `yaml:"custom_headers"`
+	Name                 string               `yaml:"name"`
+	APIKey               string               `yaml:"api_key"`
+	Model                string               `yaml:"model"`
+	Provider             string               `yaml:"provider"`
+	MaxTokens            int                  `yaml:"max_tokens"`
+	ContextWindow        int                  `yaml:"context_window"`
+	Role                 string               `yaml:"role"`
+	Temperature          float64              `yaml:"temperature"`
+	TopP                 float64              `yaml:"top_p"`
+	FrequencyPenalty     float64              `yaml:"frequency_penalty"`
+	PresencePenalty      float64              `yaml:"presence_penalty"`
+	Thread               string               `yaml:"thread"`
+	OmitHistory          bool                 `yaml:"omit_history"`
+	URL                  string               `yaml:"url"`
+	CompletionsPath      string               `yaml:"completions_path"`
+	ModelsPath           string               `yaml:"models_path"`
+	ResponsesPath        string               `yaml:"responses_path"`
+	MessagesPath         string               `yaml:"messages_path"`
+	SpeechPath           string               `yaml:"speech_path"`
+	ImageGenerationsPath string               `yaml:"image_generations_path"`
+	ImageEditsPath       string               `yaml:"image_edits_path"`
+	TranscriptionsPath   string               `yaml:"transcriptions_path"`
+	AuthHeader           string               `yaml:"auth_header"`
+	AuthTokenPrefix      string               `yaml:"auth_token_prefix"`
+	AnthropicVersion     string               `yaml:"anthropic_version"`
+	CommandPrompt        string               `yaml:"command_prompt"`
+	CommandPromptColor   string               `yaml:"command_prompt_color"`
+	OutputPrompt         string               `yaml:"output_prompt"`
+	OutputPromptColor    string               `yaml:"output_prompt_color"`
+	AutoCreateNewThread  bool                 `yaml:"auto_create_new_thread"`
+	AutoTitle            bool                 `yaml:"auto_title"`
+	FenceContext         bool                 `yaml:"fence_context"`
+	TrackTokenUsage      bool                 `yaml:"track_token_usage"`
+	SkipTLSVerify        bool                 `yaml:"skip_tls_verify"`
+	Multiline            bool                 `yaml:"multiline"`
+	Seed                 int                  `yaml:"seed"`
+	Effort               string               `yaml:"effort"`
+	Voice                string               `yaml:"voice"`
+	ApifyAPIKey          string               `yaml:"apify_api_key"`
+	UserAgent            string               `yaml:"user_agent"`
+	CustomHeaders        map[string]string    `yaml:"custom_headers"`
+	Models               map[string]ModelSpec `yaml:"models"`
+	Tools                []ToolConfig         `yaml:"tools"`
+	MCPServers           []MCPServerConfig    `yaml:"mcp_servers"`
+	Retention            RetentionConfig      `yaml:"retention"`
+	MaxToolRounds        int                  `yaml:"max_tool_rounds"`
+	ContextStrategy      string               `yaml:"context_strategy"`
+	MaxAttempts          int                  `yaml:"max_attempts"`
+	RetryDeadline        int                  `yaml:"retry_deadline"`
+	RequestTimeout       int                  `yaml:"request_timeout"`
+	ConnectTimeout       int                  `yaml:"connect_timeout"`
+	HistoryBackend       string               `yaml:"history_backend"`
+	HistoryEncryption    bool                 `yaml:"history_encryption"`
+	HistoryKey           string               `yaml:"-"`
+	HistoryKeyFile       string               `yaml:"history_key_file"`
 }
@@ -0,0 +1,13 @@
+package config
+
+// MCPServerConfig declares a Model Context Protocol server. A server with a
+// Command is launched and spoken to over stdio; otherwise URL points to a server
+// that speaks streamable HTTP.
+type MCPServerConfig struct {
+	Name    string            `yaml:"name"`
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+}
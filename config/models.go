@@ -0,0 +1,183 @@
+package config
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/tokenizer"
+)
+
+// ModelCapabilities describes what a model supports. It is resolved from the
+// built-in defaults and the models section of the config.
+type ModelCapabilities struct {
+	Streaming      bool
+	Temperature    bool
+	ResponsesAPI   bool
+	MessagesAPI    bool
+	Reasoning      bool
+	Audio          bool
+	Transcription  bool
+	Vision         bool
+	TTS            bool
+	Image          bool
+	OmitSystemRole bool
+	// ContextWindow overrides the context_window setting when it is greater than zero.
+	ContextWindow int
+	// Encoding names the tokenizer vocabulary of the model, e.g. o200k_base.
+	Encoding string
+}
+
+// ModelSpec is an entry in the models section of the config. Fields that are not
+// set keep the value of the built-in defaults.
+type ModelSpec struct {
+	Streaming      *bool   `yaml:"streaming,omitempty" mapstructure:"streaming"`
+	Temperature    *bool   `yaml:"temperature,omitempty" mapstructure:"temperature"`
+	ResponsesAPI   *bool   `yaml:"responses_api,omitempty" mapstructure:"responses_api"`
+	MessagesAPI    *bool   `yaml:"messages_api,omitempty" mapstructure:"messages_api"`
+	Reasoning      *bool   `yaml:"reasoning,omitempty" mapstructure:"reasoning"`
+	Audio          *bool   `yaml:"audio,omitempty" mapstructure:"audio"`
+	Transcription  *bool   `yaml:"transcription,omitempty" mapstructure:"transcription"`
+	Vision         *bool   `yaml:"vision,omitempty" mapstructure:"vision"`
+	TTS            *bool   `yaml:"tts,omitempty" mapstructure:"tts"`
+	Image          *bool   `yaml:"image,omitempty" mapstructure:"image"`
+	OmitSystemRole *bool   `yaml:"omit_system_role,omitempty" mapstructure:"omit_system_role"`
+	ContextWindow  *int    `yaml:"context_window,omitempty" mapstructure:"context_window"`
+	Encoding       *string `yaml:"encoding,omitempty" mapstructure:"encoding"`
+}
+
+type modelRule struct {
+	pattern string
+	spec    ModelSpec
+}
+
+// builtinModels is applied top to bottom, so later rules refine earlier ones.
+// Patterns may contain "*" wildcards and are matched against the lowercase model name.
+var builtinModels = []modelRule{
+	{"*", ModelSpec{Streaming: on(), Temperature: on(), Vision: on(), Encoding: encoding(tokenizer.O200kBase)}},
+	{"*gpt-3.5*", ModelSpec{Encoding: encoding(tokenizer.Cl100kBase)}},
+	{"*gpt-4*", ModelSpec{Encoding: encoding(tokenizer.Cl100kBase)}},
+	{"*gpt-4o*", ModelSpec{Encoding: encoding(tokenizer.O200kBase)}},
+	{"*gpt-4.1*", ModelSpec{Encoding: encoding(tokenizer.O200kBase)}},
+	{"*gpt-4.5*", ModelSpec{Encoding: encoding(tokenizer.O200kBase)}},
+	{"*text-embedding*", ModelSpec{Encoding: encoding(tokenizer.Cl100kBase)}},
+	{"*-search*", ModelSpec{Temperature: off()}},
+	{"*-audio*", ModelSpec{Audio: on(), Vision: off()}},
+	{"*-transcribe*", ModelSpec{Transcription: on(), Vision: off()}},
+	{"*-tts*", ModelSpec{TTS: on(), Vision: off()}},
+	{"*-image*", ModelSpec{Image: on()}},
+	{"o1*", ModelSpec{OmitSystemRole: on()}},
+	{"*o1-pro*", ModelSpec{ResponsesAPI: on(), Reasoning: on(), Streaming: off(), OmitSystemRole: off()}},
+	{"*gpt-5*", ModelSpec{ResponsesAPI: on(), Reasoning: on()}},
+	{"claude*", ModelSpec{MessagesAPI: on()}},
+}
+
+// GetCapabilities resolves the capabilities of model. The built-in defaults are
+// applied first, then every matching entry of models: wildcard patterns from the
+// shortest to the longest, and an entry with the exact model name last.
+func GetCapabilities(model string, models map[string]ModelSpec) ModelCapabilities {
+	var caps ModelCapabilities
+
+	name := strings.ToLower(model)
+
+	for _, rule := range builtinModels {
+		if matchModel(rule.pattern, name) {
+			rule.spec.applyTo(&caps)
+		}
+	}
+
+	var patterns []string
+	for key := range models {
+		if strings.ToLower(key) != name && matchModel(key, name) {
+			patterns = append(patterns, key)
+		}
+	}
+
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) < len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	for _, pattern := range patterns {
+		models[pattern].applyTo(&caps)
+	}
+
+	for key, spec := range models {
+		if strings.ToLower(key) == name {
+			spec.applyTo(&caps)
+		}
+	}
+
+	return caps
+}
+
+// Capabilities resolves the capabilities of the configured model.
+func (c Config) Capabilities() ModelCapabilities {
+	return GetCapabilities(c.Model, c.Models)
+}
+
+func (s ModelSpec) applyTo(caps *ModelCapabilities) {
+	setBool(&caps.Streaming, s.Streaming)
+	setBool(&caps.Temperature, s.Temperature)
+	setBool(&caps.ResponsesAPI, s.ResponsesAPI)
+	setBool(&caps.MessagesAPI, s.MessagesAPI)
+	setBool(&caps.Reasoning, s.Reasoning)
+	setBool(&caps.Audio, s.Audio)
+	setBool(&caps.Transcription, s.Transcription)
+	setBool(&caps.Vision, s.Vision)
+	setBool(&caps.TTS, s.TTS)
+	setBool(&caps.Image, s.Image)
+	setBool(&caps.OmitSystemRole, s.OmitSystemRole)
+
+	if s.ContextWindow != nil {
+		caps.ContextWindow = *s.ContextWindow
+	}
+	if s.Encoding != nil {
+		caps.Encoding = *s.Encoding
+	}
+}
+
+// matchModel reports whether name matches pattern, in which "*" matches any
+// sequence of characters, including the slashes used by routers and proxies.
+func matchModel(pattern, name string) bool {
+	parts := strings.Split(strings.ToLower(pattern), "*")
+	if len(parts) == 1 {
+		return parts[0] == name
+	}
+
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	rest := name[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		index := strings.Index(rest, part)
+		if index < 0 {
+			return false
+		}
+		rest = rest[index+len(part):]
+	}
+
+	return strings.HasSuffix(rest, parts[len(parts)-1])
+}
+
+func setBool(dst *bool, src *bool) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func on() *bool {
+	b := true
+	return &b
+}
+
+func off() *bool {
+	b := false
+	return &b
+}
+
+func encoding(name string) *string {
+	return &name
+}
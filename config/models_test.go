@@ -0,0 +1,101 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/kardolus/chatgpt-cli/config"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"gopkg.in/yaml.v3"
+)
+
+func TestUnitModels(t *testing.T) {
+	spec.Run(t, "Model Capabilities", testModels, spec.Report(report.Terminal{}))
+}
+
+func testModels(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("GetCapabilities()", func() {
+		it("falls back to a plain chat model for unknown names", func() {
+			caps := config.GetCapabilities("my-private-model", nil)
+
+			Expect(caps).To(Equal(config.ModelCapabilities{
+				Streaming:   true,
+				Temperature: true,
+				Vision:      true,
+				Encoding:    "o200k_base",
+			}))
+		})
+		it("ships built-in defaults for the known model families", func() {
+			Expect(config.GetCapabilities("gpt-4o-search-preview", nil).Temperature).To(BeFalse())
+			Expect(config.GetCapabilities("gpt-4o-audio-preview", nil).Audio).To(BeTrue())
+			Expect(config.GetCapabilities("gpt-4o-transcribe", nil).Transcription).To(BeTrue())
+			Expect(config.GetCapabilities("gpt-4o-mini-tts", nil).TTS).To(BeTrue())
+			Expect(config.GetCapabilities("gpt-image-1", nil).Image).To(BeTrue())
+			Expect(config.GetCapabilities("o1-mini", nil).OmitSystemRole).To(BeTrue())
+			Expect(config.GetCapabilities("claude-sonnet-4-5", nil).MessagesAPI).To(BeTrue())
+
+			o1Pro := config.GetCapabilities("o1-pro", nil)
+			Expect(o1Pro.ResponsesAPI).To(BeTrue())
+			Expect(o1Pro.Reasoning).To(BeTrue())
+			Expect(o1Pro.Streaming).To(BeFalse())
+			Expect(o1Pro.OmitSystemRole).To(BeFalse())
+
+			Expect(config.GetCapabilities("gpt-4-turbo", nil).Encoding).To(Equal("cl100k_base"))
+			Expect(config.GetCapabilities("gpt-3.5-turbo", nil).Encoding).To(Equal("cl100k_base"))
+			Expect(config.GetCapabilities("gpt-4o-mini", nil).Encoding).To(Equal("o200k_base"))
+			Expect(config.GetCapabilities("gpt-4.1", nil).Encoding).To(Equal("o200k_base"))
+
+			gpt5 := config.GetCapabilities("openrouter/openai/gpt-5", nil)
+			Expect(gpt5.ResponsesAPI).To(BeTrue())
+			Expect(gpt5.Streaming).To(BeTrue())
+		})
+		it("applies the models section over the built-in defaults", func() {
+			var cfg config.Config
+			Expect(yaml.Unmarshal([]byte(`
+model: corp-gpt5-alias
+models:
+  corp-*:
+    responses_api: true
+    reasoning: true
+    context_window: 64000
+  corp-gpt5-alias:
+    context_window: 128000
+  ft:gpt-4o*:
+    vision: false
+    encoding: cl100k_base
+`), &cfg)).To(Succeed())
+
+			caps := cfg.Capabilities()
+			Expect(caps.ResponsesAPI).To(BeTrue())
+			Expect(caps.Reasoning).To(BeTrue())
+			Expect(caps.Streaming).To(BeTrue())
+			Expect(caps.Vision).To(BeTrue())
+			Expect(caps.ContextWindow).To(Equal(128000))
+
+			Expect(config.GetCapabilities("corp-other", cfg.Models).ContextWindow).To(Equal(64000))
+			Expect(config.GetCapabilities("ft:gpt-4o:acme::abc123", cfg.Models).Vision).To(BeFalse())
+			Expect(config.GetCapabilities("ft:gpt-4o:acme::abc123", cfg.Models).Encoding).To(Equal("cl100k_base"))
+		})
+		it("applies longer patterns after shorter ones", func() {
+			on, off := true, false
+			models := map[string]config.ModelSpec{
+				"*":          {Streaming: &off},
+				"*-stream-*": {Streaming: &on},
+			}
+
+			Expect(config.GetCapabilities("proxy-stream-model", models).Streaming).To(BeTrue())
+			Expect(config.GetCapabilities("proxy-model", models).Streaming).To(BeFalse())
+		})
+		it("matches model names case-insensitively", func() {
+			on := true
+			models := map[string]config.ModelSpec{"corp-alias": {TTS: &on}}
+
+			Expect(config.GetCapabilities("Corp-Alias", models).TTS).To(BeTrue())
+		})
+	})
+}
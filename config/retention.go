@@ -0,0 +1,32 @@
+package config
+
+// RetentionConfig limits how many threads are kept and for how long. MaxAge is
+// an age like "36h", "30d" or "8w". A thread follows the rule with the longest
+// prefix its name starts with, and the top-level limits when no rule matches.
+// A limit of zero keeps threads forever.
+type RetentionConfig struct {
+	MaxAge   string          `yaml:"max_age"`
+	MaxCount int             `yaml:"max_count"`
+	Rules    []RetentionRule `yaml:"rules"`
+}
+
+// RetentionRule limits the threads whose names start with Prefix, like the
+// "int_" threads of interactive sessions.
+type RetentionRule struct {
+	Prefix   string `yaml:"prefix"`
+	MaxAge   string `yaml:"max_age"`
+	MaxCount int    `yaml:"max_count"`
+}
+
+// Enabled reports whether any limit is set.
+func (r RetentionConfig) Enabled() bool {
+	if r.MaxAge != "" || r.MaxCount > 0 {
+		return true
+	}
+	for _, rule := range r.Rules {
+		if rule.MaxAge != "" || rule.MaxCount > 0 {
+			return true
+		}
+	}
+	return false
+}
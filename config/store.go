@@ -19,6 +19,8 @@ const (
 	openAIURL                  = "https://api.openai.com"
 	openAICompletionsPath      = "/v1/chat/completions"
 	openAIResponsesPath        = "/v1/responses"
+	anthropicMessagesPath      = "/v1/messages"
+	anthropicVersion           = "2023-06-01"
 	openAITranscriptionsPath   = "/v1/audio/transcriptions"
 	openAISpeechPath           = "/v1/audio/speech"
 	openAIImageGenerationsPath = "/v1/images/generations"
@@ -35,6 +37,12 @@ const (
 	openAICommandPrompt        = "[%datetime] [Q%counter]"
 	openAIEffort               = "low"
 	openAIVoice                = "voice"
+	maxToolRounds              = 10
+	contextStrategy            = "truncate"
+	maxAttempts                = 3
+	retryDeadline              = 60
+	connectTimeout             = 10
+	historyBackend             = "file"
 )
 
 type Store interface {
@@ -83,6 +91,40 @@ func (f *FileIO) WithHistoryPath(historyPath string) *FileIO {
 }
 
 func (f *FileIO) Delete(pattern string) error {
+	matches, err := f.Matches(pattern)
+	if err != nil {
+		return err
+	}
+
+	return removeFiles(matches)
+}
+
+// DeleteThread removes the file of thread and the files next to it. Unlike
+// Delete, thread is a name and not a pattern, so a name like "notes[1]" only
+// deletes its own thread.
+func (f *FileIO) DeleteThread(thread string) error {
+	paths, err := f.ThreadFiles(thread)
+	if err != nil {
+		return err
+	}
+
+	return removeFiles(paths)
+}
+
+// ThreadFiles returns the files DeleteThread removes for thread.
+func (f *FileIO) ThreadFiles(thread string) ([]string, error) {
+	path := filepath.Join(f.historyFilePath, thread+internal.ThreadExtension)
+	if _, err := os.Stat(path); err != nil {
+		return nil, &FileNotFoundError{Path: path}
+	}
+
+	return withSidecars(path), nil
+}
+
+// Matches returns the files Delete removes for pattern: every matching thread
+// file, followed by its backup, corrupt copy, metadata and lock files when they
+// exist.
+func (f *FileIO) Matches(pattern string) ([]string, error) {
 	if !strings.HasSuffix(pattern, "*") && !strings.HasSuffix(pattern, ".json") {
 		pattern += ".json"
 	} else if strings.HasSuffix(pattern, "*") {
@@ -91,21 +133,42 @@ func (f *FileIO) Delete(pattern string) error {
 
 	fullPattern := filepath.Join(f.historyFilePath, pattern)
 
-	matches, err := filepath.Glob(fullPattern)
+	threads, err := filepath.Glob(fullPattern)
 	if err != nil {
-		return fmt.Errorf("failed to process pattern %s: %w", fullPattern, err)
+		return nil, fmt.Errorf("failed to process pattern %s: %w", fullPattern, err)
+	}
+
+	if len(threads) == 0 {
+		return nil, &FileNotFoundError{Path: fullPattern}
+	}
+
+	var result []string
+	for _, path := range threads {
+		result = append(result, withSidecars(path)...)
 	}
 
-	if len(matches) == 0 {
-		return &FileNotFoundError{Path: fullPattern}
+	return result, nil
+}
+
+// withSidecars returns the thread file at path followed by the files next to it
+// that exist. The backup would otherwise be read when the thread is corrupted
+// later.
+func withSidecars(path string) []string {
+	result := []string{path}
+	for _, sidecar := range internal.ThreadSidecars(path) {
+		if _, err := os.Stat(sidecar); err == nil {
+			result = append(result, sidecar)
+		}
 	}
+	return result
+}
 
-	for _, path := range matches {
+func removeFiles(paths []string) error {
+	for _, path := range paths {
 		if err := os.Remove(path); err != nil {
 			return fmt.Errorf("failed to delete file %s: %w", path, err)
 		}
 	}
-
 	return nil
 }
 
@@ -117,8 +180,11 @@ func (f *FileIO) List() ([]string, error) {
 		return nil, err
 	}
 
+	// skip the backup, lock and temporary files next to the threads
 	for _, file := range files {
-		result = append(result, file.Name())
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			result = append(result, file.Name())
+		}
 	}
 
 	return result, nil
@@ -143,6 +209,7 @@ func (f *FileIO) ReadDefaults() Config {
 		URL:                  openAIURL,
 		CompletionsPath:      openAICompletionsPath,
 		ResponsesPath:        openAIResponsesPath,
+		MessagesPath:         anthropicMessagesPath,
 		TranscriptionsPath:   openAITranscriptionsPath,
 		SpeechPath:           openAISpeechPath,
 		ImageGenerationsPath: openAIImageGenerationsPath,
@@ -150,6 +217,7 @@ func (f *FileIO) ReadDefaults() Config {
 		ModelsPath:           openAIModelsPath,
 		AuthHeader:           openAIAuthHeader,
 		AuthTokenPrefix:      openAIAuthTokenPrefix,
+		AnthropicVersion:     anthropicVersion,
 		Thread:               openAIThread,
 		Temperature:          openAITemperature,
 		TopP:                 openAITopP,
@@ -158,6 +226,12 @@ func (f *FileIO) ReadDefaults() Config {
 		CommandPrompt:        openAICommandPrompt,
 		Effort:               openAIEffort,
 		Voice:                openAIVoice,
+		MaxToolRounds:        maxToolRounds,
+		ContextStrategy:      contextStrategy,
+		MaxAttempts:          maxAttempts,
+		RetryDeadline:        retryDeadline,
+		ConnectTimeout:       connectTimeout,
+		HistoryBackend:       historyBackend,
 	}
 }
 
@@ -0,0 +1,12 @@
+package config
+
+// ToolConfig declares a tool the model may call. Parameters is the JSON schema of
+// the arguments, and Command is the shell command that runs the tool. The command
+// receives the arguments as JSON on stdin and in the TOOL_ARGUMENTS environment
+// variable, and its output is handed back to the model.
+type ToolConfig struct {
+	Name        string                 `yaml:"name"`
+	Description string                 `yaml:"description"`
+	Parameters  map[string]interface{} `yaml:"parameters"`
+	Command     string                 `yaml:"command"`
+}
@@ -0,0 +1,207 @@
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kardolus/chatgpt-cli/internal"
+)
+
+const (
+	BlobDir        = "blobs"
+	errInvalidBlob = "invalid blob %q"
+	errMissingBlob = "the attachment %s is missing from %s: %w"
+	errBlobHash    = "the attachment %s does not match its hash"
+)
+
+// Blobs keeps the attachments of messages, like images and audio, in a
+// directory under the data home where every file is named after the SHA-256
+// hash of its contents. Messages refer to their attachments by hash, so threads
+// stay small and an attachment that is sent twice is stored once.
+type Blobs struct {
+	dir    string
+	cipher *Cipher
+}
+
+// NewBlobs returns the blob directory of the data home.
+func NewBlobs() (*Blobs, error) {
+	dataHome, err := internal.GetDataHome()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Blobs{dir: filepath.Join(dataHome, BlobDir)}, nil
+}
+
+func (b *Blobs) WithDirectory(dir string) *Blobs {
+	b.dir = dir
+	return b
+}
+
+// WithCipher encrypts the blobs that are written with c.
+func (b *Blobs) WithCipher(c *Cipher) *Blobs {
+	b.cipher = c
+	return b
+}
+
+// Put stores data and returns its hash. A blob that is stored already is
+// touched, so Collect does not remove it before its new message is written.
+func (b *Blobs) Put(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	path := filepath.Join(b.dir, hash)
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		return hash, os.Chtimes(path, now, now)
+	}
+
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return "", err
+	}
+
+	encoded, err := encode(b.cipher, data)
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeAtomic(path, encoded, b.fileMode()); err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// Get returns the data of the blob with hash.
+func (b *Blobs) Get(hash string) ([]byte, error) {
+	if !validHash(hash) {
+		return nil, fmt.Errorf(errInvalidBlob, hash)
+	}
+
+	path := filepath.Join(b.dir, hash)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf(errMissingBlob, hash, b.dir, err)
+		}
+		return nil, err
+	}
+
+	if data, err = decode(b.cipher, path, data); err != nil {
+		return nil, err
+	}
+
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf(errBlobHash, hash)
+	}
+
+	return data, nil
+}
+
+// Collect removes the blobs that are not in referenced and were last written
+// before cutoff. The cutoff spares the blobs of messages that are being written
+// while the threads are read. It returns the number of removed blobs.
+func (b *Blobs) Collect(referenced map[string]bool, cutoff time.Time) (int, error) {
+	entries, err := os.ReadDir(b.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !validHash(entry.Name()) || referenced[entry.Name()] {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return count, err
+		}
+		if !info.ModTime().Before(cutoff) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(b.dir, entry.Name())); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// SetEncryption rewrites the blobs encrypted with target, or as plaintext when
+// target is nil, like FileIO.SetEncryption does for threads. It returns the
+// number of blobs that were rewritten.
+func (b *Blobs) SetEncryption(target *Cipher) (int, error) {
+	entries, err := os.ReadDir(b.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	mode := os.FileMode(0644)
+	if target != nil {
+		mode = 0600
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !validHash(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(b.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return count, err
+		}
+
+		if Encrypted(data) == (target != nil) {
+			continue
+		}
+
+		plaintext, err := decode(b.cipher, path, data)
+		if err != nil {
+			return count, err
+		}
+
+		if data, err = encode(target, plaintext); err != nil {
+			return count, err
+		}
+
+		if err := writeAtomic(path, data, mode); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	b.cipher = target
+	return count, nil
+}
+
+func (b *Blobs) fileMode() os.FileMode {
+	if b.cipher != nil {
+		return 0600
+	}
+	return 0644
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
@@ -0,0 +1,123 @@
+package history_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/kardolus/chatgpt-cli/history"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnitBlobs(t *testing.T) {
+	spec.Run(t, "Testing the blob directory", testBlobs, spec.Report(report.Terminal{}))
+}
+
+func testBlobs(t *testing.T, when spec.G, it spec.S) {
+	var (
+		dir     string
+		subject *history.Blobs
+		data    = []byte("\x89PNG\r\n\x1a\n")
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		dir = t.TempDir()
+		subject = (&history.Blobs{}).WithDirectory(dir)
+	})
+
+	it("stores data under its hash once", func() {
+		hash, err := subject.Put(data)
+		Expect(err).NotTo(HaveOccurred())
+
+		sum := sha256.Sum256(data)
+		Expect(hash).To(Equal(hex.EncodeToString(sum[:])))
+
+		again, err := subject.Put(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(Equal(hash))
+
+		entries, err := os.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entries).To(HaveLen(1))
+
+		result, err := subject.Get(hash)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(data))
+	})
+
+	it("rejects hashes that are not a blob", func() {
+		_, err := subject.Get("../config.yaml")
+		Expect(err).To(MatchError(`invalid blob "../config.yaml"`))
+	})
+
+	it("reports a missing or modified blob", func() {
+		hash, err := subject.Put(data)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(os.WriteFile(filepath.Join(dir, hash), []byte("changed"), 0644)).To(Succeed())
+		_, err = subject.Get(hash)
+		Expect(err).To(MatchError(ContainSubstring("does not match its hash")))
+
+		Expect(os.Remove(filepath.Join(dir, hash))).To(Succeed())
+		_, err = subject.Get(hash)
+		Expect(err).To(MatchError(ContainSubstring("is missing")))
+	})
+
+	it("collects the old blobs that are not referenced", func() {
+		referenced, err := subject.Put(data)
+		Expect(err).NotTo(HaveOccurred())
+		orphan, err := subject.Put([]byte("orphan"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(subject.Collect(map[string]bool{referenced: true}, time.Now().Add(-time.Hour))).To(Equal(0))
+
+		old := time.Now().Add(-2 * time.Hour)
+		for _, hash := range []string{referenced, orphan} {
+			Expect(os.Chtimes(filepath.Join(dir, hash), old, old)).To(Succeed())
+		}
+
+		Expect(subject.Collect(map[string]bool{referenced: true}, time.Now().Add(-time.Hour))).To(Equal(1))
+		Expect(filepath.Join(dir, referenced)).To(BeAnExistingFile())
+		Expect(filepath.Join(dir, orphan)).NotTo(BeAnExistingFile())
+
+		// storing a blob again makes it new
+		Expect(os.Chtimes(filepath.Join(dir, referenced), old, old)).To(Succeed())
+		_, err = subject.Put(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(subject.Collect(nil, time.Now().Add(-time.Hour))).To(Equal(0))
+	})
+
+	it("encrypts and decrypts existing blobs", func() {
+		hash, err := subject.Put(data)
+		Expect(err).NotTo(HaveOccurred())
+
+		key, err := history.NewCipher([]byte("correct horse battery staple"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(subject.SetEncryption(key)).To(Equal(1))
+
+		raw, err := os.ReadFile(filepath.Join(dir, hash))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(history.Encrypted(raw)).To(BeTrue())
+
+		_, err = (&history.Blobs{}).WithDirectory(dir).Get(hash)
+		Expect(errors.Is(err, history.ErrKey)).To(BeTrue())
+
+		result, err := (&history.Blobs{}).WithDirectory(dir).WithCipher(key).Get(hash)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(data))
+
+		Expect(subject.SetEncryption(nil)).To(Equal(1))
+
+		result, err = (&history.Blobs{}).WithDirectory(dir).Get(hash)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(data))
+	})
+}
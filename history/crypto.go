@@ -0,0 +1,231 @@
+package history
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	encryptionMagic = "CGPTENC1"
+	saltSize        = 16
+	keySize         = 32
+	kdfIterations   = 600000
+	errEmptyKey     = "the history encryption key is empty"
+	errDecrypt      = "failed to decrypt %s: %w"
+	errEncrypted    = "%s is encrypted, enable history_encryption to read it: %w"
+)
+
+// ErrKey is returned for an encrypted file that cannot be read with the key at
+// hand, or without one.
+var ErrKey = errors.New("wrong or missing history encryption key")
+
+// Cipher encrypts history files with AES-256-GCM. The key is derived from a
+// secret, like a passphrase or the contents of a key file, with PBKDF2 and a
+// random salt that is stored in the header of every file. Derived keys are
+// cached per salt, and new files reuse the salt of the first file that was read,
+// so a run derives a single key however many threads it reads.
+type Cipher struct {
+	secret []byte
+	mu     sync.Mutex
+	keys   map[string][]byte
+	salt   []byte
+}
+
+// NewCipher returns a Cipher for secret.
+func NewCipher(secret []byte) (*Cipher, error) {
+	if len(bytes.TrimSpace(secret)) == 0 {
+		return nil, errors.New(errEmptyKey)
+	}
+
+	return &Cipher{secret: secret, keys: make(map[string][]byte)}, nil
+}
+
+// Encrypted reports whether data was written by a Cipher.
+func Encrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptionMagic))
+}
+
+// Seal encrypts data. The header, made of a magic string and the salt, is
+// authenticated along with the data.
+func (c *Cipher) Seal(data []byte) ([]byte, error) {
+	c.mu.Lock()
+	if c.salt == nil {
+		c.salt = make([]byte, saltSize)
+		if _, err := rand.Read(c.salt); err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
+	}
+	salt := c.salt
+	c.mu.Unlock()
+
+	aead, err := c.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(encryptionMagic), salt...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	result := append(header, nonce...)
+	return aead.Seal(result, nonce, data, header), nil
+}
+
+// Open decrypts data that was sealed by Seal. name is only used in errors.
+func (c *Cipher) Open(name string, data []byte) ([]byte, error) {
+	headerSize := len(encryptionMagic) + saltSize
+	if !Encrypted(data) || len(data) < headerSize {
+		return nil, fmt.Errorf(errDecrypt, name, ErrKey)
+	}
+
+	header, salt := data[:headerSize], data[len(encryptionMagic):headerSize]
+
+	c.mu.Lock()
+	if c.salt == nil {
+		c.salt = append([]byte(nil), salt...)
+	}
+	c.mu.Unlock()
+
+	aead, err := c.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := data[headerSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf(errDecrypt, name, ErrKey)
+	}
+
+	result, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf(errDecrypt, name, ErrKey)
+	}
+
+	return result, nil
+}
+
+func (c *Cipher) aead(salt []byte) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, ok := c.keys[string(salt)]
+	if !ok {
+		var err error
+		if key, err = pbkdf2.Key(sha256.New, string(c.secret), salt, kdfIterations, keySize); err != nil {
+			return nil, err
+		}
+		c.keys[string(salt)] = key
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// decode returns the plaintext of the file at path. Without a cipher,
+// encrypted files cannot be read, while plaintext files are returned as they
+// are either way, so encryption can be turned on for existing threads.
+func decode(c *Cipher, path string, data []byte) ([]byte, error) {
+	if !Encrypted(data) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, fmt.Errorf(errEncrypted, path, ErrKey)
+	}
+	return c.Open(path, data)
+}
+
+// encode encrypts data when c is set.
+func encode(c *Cipher, data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	return c.Seal(data)
+}
+
+// SetEncryption rewrites the threads of the store, with their backups and
+// metadata, encrypted with target, or as plaintext when target is nil. The
+// store must be able to read them, so its cipher is needed to decrypt. Files
+// that are already encrypted, or already plaintext, are left alone. It returns
+// the number of threads that were rewritten, after which the store writes with
+// target.
+func (f *FileIO) SetEncryption(target *Cipher) (int, error) {
+	threads, err := f.Threads()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, thread := range threads {
+		changed, err := f.setThreadEncryption(thread, target)
+		if err != nil {
+			return count, err
+		}
+		if changed {
+			count++
+		}
+	}
+
+	f.cipher = target
+	return count, nil
+}
+
+func (f *FileIO) setThreadEncryption(thread string, target *Cipher) (bool, error) {
+	path := f.getPath(thread)
+
+	lock, err := acquireLock(path + lockExtension)
+	if err != nil {
+		return false, err
+	}
+	defer lock.release()
+
+	mode := os.FileMode(0644)
+	if target != nil {
+		mode = 0600
+	}
+
+	changed := false
+	for _, file := range []string{path, path + backupExtension, f.getMetadataPath(thread)} {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return changed, err
+		}
+
+		if Encrypted(data) == (target != nil) {
+			continue
+		}
+
+		plaintext, err := decode(f.cipher, file, data)
+		if err != nil {
+			return changed, err
+		}
+
+		if data, err = encode(target, plaintext); err != nil {
+			return changed, err
+		}
+
+		if err := writeAtomic(file, data, mode); err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,140 @@
+package history_test
+
+import (
+	"errors"
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/history"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnitCrypto(t *testing.T) {
+	spec.Run(t, "Testing the history encryption", testCrypto, spec.Report(report.Terminal{}))
+}
+
+func testCrypto(t *testing.T, when spec.G, it spec.S) {
+	const thread = "thread"
+
+	var (
+		dir     string
+		key     *history.Cipher
+		entries []history.History
+	)
+
+	newStore := func(c *history.Cipher) *history.FileIO {
+		result := (&history.FileIO{}).WithDirectory(dir).WithCipher(c)
+		result.SetThread(thread)
+		return result
+	}
+
+	readRaw := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		Expect(err).NotTo(HaveOccurred())
+		return data
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		dir = t.TempDir()
+
+		var err error
+		key, err = history.NewCipher([]byte("correct horse battery staple"))
+		Expect(err).NotTo(HaveOccurred())
+
+		entries = []history.History{{Message: api.Message{Role: "user", Content: "customer 4711 called about the invoice"}}}
+	})
+
+	it("rejects an empty key", func() {
+		_, err := history.NewCipher([]byte(" \n"))
+		Expect(err).To(MatchError(ContainSubstring("empty")))
+	})
+
+	it("encrypts threads and metadata transparently", func() {
+		store := newStore(key)
+		Expect(store.Write(entries)).To(Succeed())
+		Expect(store.WriteMetadata(thread, history.Metadata{Title: "Invoice 4711"})).To(Succeed())
+
+		for _, name := range []string{thread + ".json", thread + ".meta"} {
+			data := readRaw(name)
+			Expect(history.Encrypted(data)).To(BeTrue())
+			Expect(string(data)).NotTo(ContainSubstring("4711"))
+
+			info, err := os.Stat(filepath.Join(dir, name))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		}
+
+		other, err := history.NewCipher([]byte("correct horse battery staple"))
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := newStore(other).Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(entries))
+
+		metadata, err := newStore(other).ReadMetadata(thread)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(metadata.Title).To(Equal("Invoice 4711"))
+	})
+
+	it("refuses to read or overwrite a thread with the wrong key or none", func() {
+		Expect(newStore(key).Write(entries)).To(Succeed())
+
+		wrong, err := history.NewCipher([]byte("wrong"))
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, store := range []*history.FileIO{newStore(wrong), newStore(nil)} {
+			_, err = store.Read()
+			Expect(errors.Is(err, history.ErrKey)).To(BeTrue())
+
+			err = store.Write(entries)
+			Expect(errors.Is(err, history.ErrKey)).To(BeTrue())
+		}
+
+		_, err = os.Stat(filepath.Join(dir, thread+".json.corrupt"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		result, err := newStore(key).Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(entries))
+	})
+
+	it("detects tampering", func() {
+		Expect(newStore(key).Write(entries)).To(Succeed())
+
+		data := readRaw(thread + ".json")
+		data[len(data)-1] ^= 1
+		Expect(os.WriteFile(filepath.Join(dir, thread+".json"), data, 0600)).To(Succeed())
+
+		_, err := newStore(key).Read()
+		Expect(errors.Is(err, history.ErrKey)).To(BeTrue())
+	})
+
+	it("encrypts and decrypts existing threads in place", func() {
+		plain := newStore(nil)
+		Expect(plain.Write(entries)).To(Succeed())
+		Expect(plain.Write(append(entries, entries...))).To(Succeed())
+		Expect(plain.WriteMetadata(thread, history.Metadata{Title: "Invoice"})).To(Succeed())
+
+		store := newStore(key)
+		Expect(store.SetEncryption(key)).To(Equal(1))
+		for _, name := range []string{thread + ".json", thread + ".json.bak", thread + ".meta"} {
+			Expect(history.Encrypted(readRaw(name))).To(BeTrue(), name)
+		}
+
+		Expect(store.SetEncryption(key)).To(Equal(0))
+
+		Expect(store.SetEncryption(nil)).To(Equal(1))
+		for _, name := range []string{thread + ".json", thread + ".json.bak", thread + ".meta"} {
+			Expect(history.Encrypted(readRaw(name))).To(BeFalse(), name)
+		}
+
+		result, err := newStore(nil).Read()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(HaveLen(2))
+	})
+}
@@ -0,0 +1,512 @@
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	DatabaseFile      = "history.db"
+	databaseFileMode  = 0600
+	errThreadNotFound = "thread %q: %w"
+	errOpenDatabase   = "failed to open the history database %s: %w"
+	indexSeparator    = 0
+)
+
+var (
+	threadsBucket  = []byte("threads")
+	metadataBucket = []byte("metadata")
+	indexBucket    = []byte("index")
+	metaBucket     = []byte("meta")
+	importedKey    = []byte("imported")
+)
+
+// Ensure DBStore implements the Store and Searcher interfaces
+var (
+	_ Store    = &DBStore{}
+	_ Searcher = &DBStore{}
+)
+
+// DBStore keeps every thread in a single embedded bbolt database. Every thread
+// is a bucket with one key per message, so a turn only stores the new messages
+// instead of rewriting the thread. Every message is indexed by the words it
+// contains in the same transaction, so searches do not need to read the threads.
+//
+// The database is opened for every operation and closed right after it. bbolt
+// locks the file while it is open, so concurrent processes take turns instead of
+// one interactive session blocking all others.
+type DBStore struct {
+	path   string
+	thread string
+	mu     sync.Mutex
+	// snapshots holds the version of each thread this store last read or wrote,
+	// to keep the messages other processes appended in the meantime
+	snapshots map[string][]History
+}
+
+// NewDB opens the database at path, creating it when it does not exist yet.
+func NewDB(path string) (*DBStore, error) {
+	d := &DBStore{
+		path:      path,
+		snapshots: make(map[string][]History),
+	}
+
+	err := d.update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{threadsBucket, metadataBucket, indexBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *DBStore) GetThread() string {
+	return d.thread
+}
+
+func (d *DBStore) SetThread(thread string) {
+	d.thread = thread
+}
+
+func (d *DBStore) Read() ([]History, error) {
+	return d.ReadThread(d.thread)
+}
+
+func (d *DBStore) ReadThread(thread string) ([]History, error) {
+	var (
+		result []History
+		exists bool
+	)
+
+	err := d.view(func(tx *bolt.Tx) error {
+		var err error
+		result, exists, err = readMessages(tx, thread)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf(errThreadNotFound, thread, os.ErrNotExist)
+	}
+
+	d.setSnapshot(thread, result)
+	return append([]History(nil), result...), nil
+}
+
+// Write stores historyEntries as the current thread. The stored thread is read
+// in the same transaction, so messages that were added by another process since
+// this store read the thread are kept. Only the messages that differ from the
+// stored thread are written.
+func (d *DBStore) Write(historyEntries []History) error {
+	err := d.update(func(tx *bolt.Tx) error {
+		stored, _, err := readMessages(tx, d.thread)
+		if err != nil {
+			return err
+		}
+
+		merged := historyEntries
+		if snapshot, ok := d.snapshot(d.thread); ok {
+			merged = merge(snapshot, stored, historyEntries)
+		}
+
+		messages, err := tx.Bucket(threadsBucket).CreateBucketIfNotExists([]byte(d.thread))
+		if err != nil {
+			return err
+		}
+
+		keep := commonPrefix(stored, merged)
+		for i := keep; i < len(stored); i++ {
+			if err := messages.Delete(messageKey(i)); err != nil {
+				return err
+			}
+			if err := unindex(tx, d.thread, i, stored[i]); err != nil {
+				return err
+			}
+		}
+
+		for i := keep; i < len(merged); i++ {
+			data, err := json.Marshal(merged[i])
+			if err != nil {
+				return err
+			}
+			if err := messages.Put(messageKey(i), data); err != nil {
+				return err
+			}
+			if err := index(tx, d.thread, i, merged[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	d.setSnapshot(d.thread, historyEntries)
+	return nil
+}
+
+// Threads returns the names of all threads, sorted alphabetically.
+func (d *DBStore) Threads() ([]string, error) {
+	var result []string
+
+	err := d.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(threadsBucket).ForEachBucket(func(name []byte) error {
+			result = append(result, string(name))
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// Delete removes the threads matching pattern, which may use the syntax of
+// filepath.Match. It returns the deleted threads.
+func (d *DBStore) Delete(pattern string) ([]string, error) {
+	var deleted []string
+
+	err := d.update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(threadsBucket).ForEachBucket(func(name []byte) error {
+			ok, err := filepath.Match(pattern, string(name))
+			if ok {
+				deleted = append(deleted, string(name))
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		return deleteThreads(tx, deleted)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.forget(deleted)
+	return deleted, nil
+}
+
+// DeleteThread removes thread. Unlike Delete, thread is a name and not a
+// pattern. It returns a not exist error when there is no such thread.
+func (d *DBStore) DeleteThread(thread string) error {
+	err := d.update(func(tx *bolt.Tx) error {
+		if tx.Bucket(threadsBucket).Bucket([]byte(thread)) == nil {
+			return fmt.Errorf(errThreadNotFound, thread, os.ErrNotExist)
+		}
+		return deleteThreads(tx, []string{thread})
+	})
+	if err != nil {
+		return err
+	}
+
+	d.forget([]string{thread})
+	return nil
+}
+
+// Import copies every thread of the file store into the database, replacing
+// threads with the same name. It returns the number of imported threads.
+func (d *DBStore) Import(files *FileIO) (int, error) {
+	return d.importFiles(files, nil)
+}
+
+// Imported reports whether the thread files were imported into the database
+// completely at least once.
+func (d *DBStore) Imported() (bool, error) {
+	var result bool
+
+	err := d.view(func(tx *bolt.Tx) error {
+		result = tx.Bucket(metaBucket).Get(importedKey) != nil
+		return nil
+	})
+
+	return result, err
+}
+
+// importFiles copies the threads of the file store into the database, except
+// the ones in skip. Once all of them are copied, the database is marked as
+// imported.
+func (d *DBStore) importFiles(files *FileIO, skip map[string]bool) (int, error) {
+	names, err := files.Threads()
+	if err != nil {
+		return 0, err
+	}
+
+	current := d.thread
+	defer d.SetThread(current)
+
+	count := 0
+	for _, name := range names {
+		if skip[name] {
+			continue
+		}
+
+		entries, err := files.ReadThread(name)
+		if err != nil {
+			return count, fmt.Errorf("failed to read thread %s: %w", name, err)
+		}
+
+		// the thread file replaces whatever the database holds
+		d.forget([]string{name})
+
+		d.SetThread(name)
+		if err := d.Write(entries); err != nil {
+			return count, err
+		}
+
+		metadata, err := files.ReadMetadata(name)
+		if err != nil {
+			return count, fmt.Errorf("failed to read the metadata of thread %s: %w", name, err)
+		}
+		if !metadata.empty() {
+			if err := d.WriteMetadata(name, metadata); err != nil {
+				return count, err
+			}
+		}
+		count++
+	}
+
+	err = d.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(importedKey, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+
+	return count, err
+}
+
+// Search returns the messages that contain every word of query, newest first.
+// A limit of zero or less returns all of them.
+func (d *DBStore) Search(query string, limit int) ([]SearchResult, error) {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var result []SearchResult
+
+	err := d.view(func(tx *bolt.Tx) error {
+		postings := tx.Bucket(indexBucket)
+		threads := tx.Bucket(threadsBucket)
+
+		prefix := termPrefix(terms[0])
+		cursor := postings.Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			ref := key[len(prefix):]
+			if !indexed(postings, ref, terms[1:]) {
+				continue
+			}
+
+			thread, i := parseRef(ref)
+			messages := threads.Bucket([]byte(thread))
+			if messages == nil {
+				continue
+			}
+			data := messages.Get(messageKey(i))
+			if data == nil {
+				continue
+			}
+
+			var entry History
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			result = append(result, newSearchResult(thread, entry, terms))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sortResults(result, limit), nil
+}
+
+// forget drops the snapshots of threads that were deleted or replaced.
+func (d *DBStore) forget(threads []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, thread := range threads {
+		delete(d.snapshots, thread)
+	}
+}
+
+func (d *DBStore) snapshot(thread string) ([]History, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result, ok := d.snapshots[thread]
+	return result, ok
+}
+
+func (d *DBStore) setSnapshot(thread string, entries []History) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.snapshots[thread] = entries
+}
+
+// update runs fn in a read-write transaction of the database.
+func (d *DBStore) update(fn func(*bolt.Tx) error) error {
+	db, err := d.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(fn)
+}
+
+// view runs fn in a read-only transaction of the database.
+func (d *DBStore) view(fn func(*bolt.Tx) error) error {
+	db, err := d.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(fn)
+}
+
+func (d *DBStore) open(readOnly bool) (*bolt.DB, error) {
+	db, err := bolt.Open(d.path, databaseFileMode, &bolt.Options{Timeout: lockTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return nil, fmt.Errorf(errOpenDatabase, d.path, err)
+	}
+	return db, nil
+}
+
+// readMessages returns the messages of thread in order. exists reports whether
+// the thread is stored at all.
+func readMessages(tx *bolt.Tx, thread string) (result []History, exists bool, err error) {
+	messages := tx.Bucket(threadsBucket).Bucket([]byte(thread))
+	if messages == nil {
+		return nil, false, nil
+	}
+
+	err = messages.ForEach(func(_, data []byte) error {
+		var entry History
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return err
+		}
+		result = append(result, entry)
+		return nil
+	})
+
+	return result, true, err
+}
+
+// deleteThreads removes threads with their metadata and their messages from the
+// index.
+func deleteThreads(tx *bolt.Tx, threads []string) error {
+	for _, thread := range threads {
+		stored, _, err := readMessages(tx, thread)
+		if err != nil {
+			return err
+		}
+		for i, entry := range stored {
+			if err := unindex(tx, thread, i, entry); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Bucket(threadsBucket).DeleteBucket([]byte(thread)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(metadataBucket).Delete([]byte(thread)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// index adds the message at position i of thread to the postings of every word
+// it contains.
+func index(tx *bolt.Tx, thread string, i int, entry History) error {
+	postings := tx.Bucket(indexBucket)
+	for _, term := range tokenize(contentText(entry.Content)) {
+		if err := postings.Put(postingKey(term, thread, i), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindex removes the message at position i of thread from the postings.
+func unindex(tx *bolt.Tx, thread string, i int, entry History) error {
+	postings := tx.Bucket(indexBucket)
+	for _, term := range tokenize(contentText(entry.Content)) {
+		if err := postings.Delete(postingKey(term, thread, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexed(postings *bolt.Bucket, ref []byte, terms []string) bool {
+	for _, term := range terms {
+		if postings.Get(append(termPrefix(term), ref...)) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// messageKey is the key of the message at position i. Big endian keys keep the
+// messages of a thread in order.
+func messageKey(i int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(i))
+}
+
+// postingKey is the key of the message at position i of thread in the postings
+// of term: the term, the thread and the message key, separated by a zero byte.
+func postingKey(term, thread string, i int) []byte {
+	key := append(termPrefix(term), thread...)
+	key = append(key, indexSeparator)
+	return append(key, messageKey(i)...)
+}
+
+func termPrefix(term string) []byte {
+	return append([]byte(term), indexSeparator)
+}
+
+// parseRef splits the part of a posting key after its term into the thread and
+// the position of the message.
+func parseRef(ref []byte) (string, int) {
+	split := len(ref) - len(messageKey(0))
+	return string(ref[:split-1]), int(binary.BigEndian.Uint64(ref[split:]))
+}
+
+// commonPrefix returns how many leading messages a and b have in common.
+func commonPrefix(a, b []History) int {
+	n := 0
+	for n < len(a) && n < len(b) {
+		left, err := json.Marshal(a[n])
+		if err != nil {
+			break
+		}
+		right, err := json.Marshal(b[n])
+		if err != nil || !bytes.Equal(left, right) {
+			break
+		}
+		n++
+	}
+	return n
+}
@@ -0,0 +1,399 @@
+package history_test
+
+import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/history"
+	"github.com/kardolus/chatgpt-cli/internal"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnitDatabase(t *testing.T) {
+	spec.Run(t, "Testing the history database", testDatabase, spec.Report(report.Terminal{}))
+}
+
+func testDatabase(t *testing.T, when spec.G, it spec.S) {
+	var (
+		dir    string
+		dbPath string
+		db     *history.DBStore
+	)
+
+	message := func(role, content string, minute int) history.History {
+		return history.History{
+			Message:   api.Message{Role: role, Content: content},
+			Timestamp: time.Date(2024, 1, 1, 12, minute, 0, 0, time.UTC),
+		}
+	}
+
+	reopen := func() *history.DBStore {
+		result, err := history.NewDB(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		result.SetThread(db.GetThread())
+		return result
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		dir = t.TempDir()
+		dbPath = filepath.Join(dir, history.DatabaseFile)
+
+		var err error
+		db, err = history.NewDB(dbPath)
+		Expect(err).NotTo(HaveOccurred())
+		db.SetThread("default")
+	})
+
+	when("Write()", func() {
+		it("stores the thread so it can be read after reopening", func() {
+			entries := []history.History{message("system", "be brief", 0), message("user", "hello", 1)}
+			Expect(db.Write(entries)).To(Succeed())
+
+			Expect(db.Read()).To(Equal(entries))
+			Expect(reopen().Read()).To(Equal(entries))
+		})
+
+		it("keeps the turns another process appended since the thread was read", func() {
+			Expect(db.Write([]history.History{message("system", "be brief", 0)})).To(Succeed())
+
+			first, second := reopen(), reopen()
+			firstHistory, err := first.Read()
+			Expect(err).NotTo(HaveOccurred())
+			secondHistory, err := second.Read()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(first.Write(append(firstHistory, message("user", "first", 1)))).To(Succeed())
+			Expect(second.Write(append(secondHistory, message("user", "second", 2)))).To(Succeed())
+
+			Expect(reopen().Read()).To(Equal([]history.History{
+				message("system", "be brief", 0), message("user", "first", 1), message("user", "second", 2),
+			}))
+
+			results, err := reopen().Search("second", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+		})
+
+		it("does not lose turns of concurrent writers", func() {
+			const writers = 8
+
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+
+					store := reopen()
+					entries, _ := store.Read()
+					Expect(store.Write(append(entries, message("user", fmt.Sprintf("turn %d", i), i)))).To(Succeed())
+				}(i)
+			}
+			wg.Wait()
+
+			Expect(reopen().Read()).To(HaveLen(writers))
+		})
+
+		it("truncates the thread when messages were dropped", func() {
+			entries := []history.History{message("system", "be brief", 0), message("user", "hello", 1), message("assistant", "hi", 2)}
+			Expect(db.Write(entries)).To(Succeed())
+
+			entries = []history.History{entries[0], message("user", "bye", 3)}
+			Expect(db.Write(entries)).To(Succeed())
+
+			Expect(reopen().Read()).To(Equal(entries))
+		})
+
+		it("stores an empty thread", func() {
+			Expect(db.Write(nil)).To(Succeed())
+
+			Expect(reopen().Threads()).To(Equal([]string{"default"}))
+		})
+	})
+
+	when("ReadThread()", func() {
+		it("returns a not exist error for an unknown thread", func() {
+			_, err := db.ReadThread("unknown")
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+
+		it("returns a copy of the thread", func() {
+			Expect(db.Write([]history.History{message("user", "hello", 1)})).To(Succeed())
+
+			entries, err := db.ReadThread("default")
+			Expect(err).NotTo(HaveOccurred())
+			entries[0].Content = "changed"
+
+			Expect(db.Read()).To(Equal([]history.History{message("user", "hello", 1)}))
+		})
+	})
+
+	when("NewDB()", func() {
+		it("creates a database that only its owner can read", func() {
+			info, err := os.Stat(dbPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+
+		it("returns an error for a file that is not a database", func() {
+			corrupt := filepath.Join(dir, "corrupt.db")
+			Expect(os.WriteFile(corrupt, []byte(strings.Repeat("not a database\n", 1000)), 0600)).To(Succeed())
+
+			_, err := history.NewDB(corrupt)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to open the history database"))
+		})
+	})
+
+	when("UpdateMetadata()", func() {
+		it("does not lose metadata changes of concurrent writers", func() {
+			const writers = 8
+
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					_, err := reopen().UpdateMetadata("default", func(metadata *history.Metadata) {
+						metadata.Tokens++
+					})
+					Expect(err).NotTo(HaveOccurred())
+				}()
+			}
+			wg.Wait()
+
+			metadata, err := reopen().ReadMetadata("default")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata.Tokens).To(Equal(writers))
+		})
+	})
+
+	when("WriteMetadata()", func() {
+		it("keeps the metadata until the thread is deleted", func() {
+			Expect(db.Write([]history.History{message("user", "hello", 1)})).To(Succeed())
+
+			metadata := history.Metadata{Parent: "main", ForkPoint: 3}
+			Expect(db.WriteMetadata("default", metadata)).To(Succeed())
+			Expect(reopen().ReadMetadata("default")).To(Equal(metadata))
+
+			_, err := db.Delete("default")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reopen().ReadMetadata("default")).To(Equal(history.Metadata{}))
+		})
+	})
+
+	when("Delete()", func() {
+		it("deletes the threads that match the pattern", func() {
+			for _, thread := range []string{"work-a", "work-b", "home"} {
+				db.SetThread(thread)
+				Expect(db.Write([]history.History{message("user", thread, 1)})).To(Succeed())
+			}
+
+			deleted, err := db.Delete("work-*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deleted).To(Equal([]string{"work-a", "work-b"}))
+
+			Expect(db.Threads()).To(Equal([]string{"home"}))
+			Expect(reopen().Threads()).To(Equal([]string{"home"}))
+
+			results, err := db.Search("work", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(BeEmpty())
+		})
+	})
+
+	when("DeleteThread()", func() {
+		it("deletes only the thread with the name, which is not a pattern", func() {
+			for _, thread := range []string{"work[1]", "work1"} {
+				db.SetThread(thread)
+				Expect(db.Write([]history.History{message("user", thread, 1)})).To(Succeed())
+			}
+
+			Expect(db.DeleteThread("work[1]")).To(Succeed())
+			Expect(reopen().Threads()).To(Equal([]string{"work1"}))
+
+			Expect(db.DeleteThread("work[1]")).To(MatchError(os.ErrNotExist))
+		})
+	})
+
+	when("Search()", func() {
+		it.Before(func() {
+			db.SetThread("go")
+			Expect(db.Write([]history.History{
+				message("user", "How do I read a file in Go?", 1),
+				message("assistant", "Use os.ReadFile to read the whole file.", 2),
+			})).To(Succeed())
+
+			db.SetThread("rust")
+			Expect(db.Write([]history.History{
+				message("user", "How do I read a file in Rust?", 3),
+			})).To(Succeed())
+		})
+
+		it("returns the messages containing every word, newest first", func() {
+			results, err := db.Search("Read FILE", 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(results).To(HaveLen(3))
+			Expect(results[0].Thread).To(Equal("rust"))
+			Expect(results[1].Role).To(Equal("assistant"))
+			Expect(results[1].Snippet).To(Equal("Use os.ReadFile to read the whole file."))
+			Expect(results[2].Timestamp).To(Equal(time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC)))
+		})
+
+		it("intersects the words", func() {
+			results, err := db.Search("read go", 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Thread).To(Equal("go"))
+		})
+
+		it("applies the limit", func() {
+			results, err := db.Search("file", 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+		})
+
+		it("finds nothing for an empty query", func() {
+			results, err := db.Search(" ? ", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(BeEmpty())
+		})
+
+		it("no longer finds truncated messages", func() {
+			db.SetThread("go")
+			Expect(db.Write([]history.History{message("user", "How do I read a file in Go?", 1)})).To(Succeed())
+
+			results, err := reopen().Search("whole", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(BeEmpty())
+		})
+
+		it("shortens the snippet around the match", func() {
+			db.SetThread("long")
+			text := strings.Repeat("lorem ", 20) + "needle" + strings.Repeat(" ipsum", 30)
+			Expect(db.Write([]history.History{message("user", text, 9)})).To(Succeed())
+
+			results, err := db.Search("needle", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Snippet).To(HavePrefix("…"))
+			Expect(results[0].Snippet).To(HaveSuffix("…"))
+			Expect(results[0].Snippet).To(ContainSubstring("needle"))
+		})
+	})
+
+	when("Import()", func() {
+		var files *history.FileIO
+
+		it.Before(func() {
+			historyDir := filepath.Join(dir, "history")
+			Expect(os.Mkdir(historyDir, 0755)).To(Succeed())
+			files = (&history.FileIO{}).WithDirectory(historyDir)
+
+			files.SetThread("first")
+			Expect(files.Write([]history.History{message("user", "imported question", 1)})).To(Succeed())
+			files.SetThread("second")
+			Expect(files.Write([]history.History{message("user", "another one", 2)})).To(Succeed())
+		})
+
+		it("copies every thread file into the database", func() {
+			count, err := db.Import(files)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(2))
+
+			Expect(db.GetThread()).To(Equal("default"))
+			Expect(reopen().Threads()).To(Equal([]string{"first", "second"}))
+			Expect(db.ReadThread("first")).To(Equal([]history.History{message("user", "imported question", 1)}))
+		})
+
+		it("does not duplicate messages when importing twice", func() {
+			_, err := db.Import(files)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = db.Import(files)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(db.ReadThread("first")).To(HaveLen(1))
+			Expect(db.ReadThread("second")).To(HaveLen(1))
+		})
+
+		it("can be searched like the file store", func() {
+			_, err := db.Import(files)
+			Expect(err).NotTo(HaveOccurred())
+
+			expected, err := files.Search("imported", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(expected).To(HaveLen(1))
+			Expect(db.Search("imported", 0)).To(Equal(expected))
+		})
+	})
+
+	when("OpenDatabase()", func() {
+		var (
+			files  *history.FileIO
+			broken string
+		)
+
+		it.Before(func() {
+			t.Setenv(internal.ConfigHomeEnv, dir)
+			Expect(os.Remove(dbPath)).To(Succeed())
+
+			historyDir := filepath.Join(dir, "history")
+			Expect(os.Mkdir(historyDir, 0755)).To(Succeed())
+			files = (&history.FileIO{}).WithDirectory(historyDir)
+
+			files.SetThread("first")
+			Expect(files.Write([]history.History{message("user", "imported question", 1)})).To(Succeed())
+			files.SetThread("second")
+			Expect(files.Write([]history.History{message("user", "another one", 2)})).To(Succeed())
+
+			// a corrupt thread file without a backup makes the import fail
+			broken = filepath.Join(historyDir, "third.json")
+			Expect(os.WriteFile(broken, []byte("not json"), 0600)).To(Succeed())
+		})
+
+		it("resumes an import that failed without replacing the imported threads", func() {
+			_, err := history.OpenDatabase(files)
+			Expect(err).To(HaveOccurred())
+
+			db, err = history.NewDB(dbPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.Imported()).To(BeFalse())
+
+			db.SetThread("first")
+			Expect(db.Write([]history.History{
+				message("user", "imported question", 1),
+				message("user", "new question", 3),
+			})).To(Succeed())
+
+			Expect(os.Remove(broken)).To(Succeed())
+			db, err = history.OpenDatabase(files)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(db.Imported()).To(BeTrue())
+			Expect(db.Threads()).To(Equal([]string{"first", "second"}))
+			Expect(db.ReadThread("first")).To(HaveLen(2))
+		})
+
+		it("does not import again once the import succeeded", func() {
+			Expect(os.Remove(broken)).To(Succeed())
+			db, err := history.OpenDatabase(files)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.DeleteThread("second")).To(Succeed())
+
+			db, err = history.OpenDatabase(files)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(db.Threads()).To(Equal([]string{"first"}))
+		})
+	})
+}
@@ -0,0 +1,319 @@
+package history
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/kardolus/chatgpt-cli/api"
+)
+
+const (
+	FormatMarkdown   = "md"
+	FormatHTML       = "html"
+	FormatJSON       = "json"
+	FormatJSONL      = "jsonl"
+	errUnknownFormat = "unknown export format %q, expected md, html, json or jsonl"
+	exportTimeLayout = "2006-01-02 15:04:05"
+	dataURLPrefix    = "data:"
+	dataURL          = "data:%s;base64,%s"
+	errNoBlobs       = "the attachment %s is stored as a blob, but no blob directory was given"
+)
+
+const htmlStyle = `body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 860px; margin: 2rem auto; padding: 0 1rem; color: #1f2328; background: #fff; }
+h1 { font-size: 1.5rem; border-bottom: 1px solid #d0d7de; padding-bottom: .5rem; }
+.message { border: 1px solid #d0d7de; border-radius: 8px; margin: 1rem 0; padding: .75rem 1rem; }
+.message header { font-weight: 600; margin-bottom: .5rem; }
+.message header time { font-weight: normal; color: #656d76; margin-left: .5rem; }
+.user { background: #f6f8fa; }
+.system, .summary { background: #fff8c5; }
+.tool, .function { background: #f0f6ff; }
+.text { white-space: pre-wrap; word-wrap: break-word; }
+pre { background: #f6f8fa; border-radius: 6px; padding: .5rem; overflow-x: auto; }
+img { max-width: 100%; }`
+
+// contentPart is a part of a message in a form the exports can render.
+type contentPart struct {
+	Type   string
+	Text   string
+	URL    string
+	Data   string
+	Format string
+	Name   string
+}
+
+// Export renders a thread as Markdown, a self-contained HTML page, JSON or a
+// JSONL line in the chat format used for fine-tuning.
+func (h *Manager) Export(thread, format string) (string, error) {
+	switch format {
+	case FormatMarkdown, FormatHTML, FormatJSON, FormatJSONL:
+	default:
+		return "", fmt.Errorf(errUnknownFormat, format)
+	}
+
+	historyEntries, err := h.store.ReadThread(thread)
+	if err != nil {
+		return "", err
+	}
+
+	// the HTML page and the JSONL example have to stand on their own, the JSON
+	// export is a copy of the thread and Markdown only names the attachments
+	if format == FormatHTML || format == FormatJSONL {
+		if historyEntries, err = h.embedBlobs(historyEntries, format == FormatJSONL); err != nil {
+			return "", err
+		}
+	}
+
+	switch format {
+	case FormatMarkdown:
+		return exportMarkdown(thread, historyEntries), nil
+	case FormatHTML:
+		return exportHTML(thread, historyEntries), nil
+	case FormatJSON:
+		return exportJSON(thread, historyEntries)
+	default:
+		return exportJSONL(historyEntries)
+	}
+}
+
+func exportMarkdown(thread string, historyEntries []History) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n", thread)
+
+	for _, entry := range historyEntries {
+		fmt.Fprintf(&b, "\n## %s", roleLabel(entry))
+		if !entry.Timestamp.IsZero() {
+			fmt.Fprintf(&b, " (%s)", entry.Timestamp.Format(exportTimeLayout))
+		}
+		b.WriteString("\n\n")
+
+		for _, part := range contentParts(entry.Content) {
+			switch {
+			case isTextPart(part) && isToolResult(entry):
+				fmt.Fprintf(&b, "```\n%s\n```\n\n", part.Text)
+			case isTextPart(part):
+				fmt.Fprintf(&b, "%s\n\n", part.Text)
+			case part.Type == imageURLType && part.URL != "" && !strings.HasPrefix(part.URL, dataURLPrefix):
+				fmt.Fprintf(&b, "![image](%s)\n\n", part.URL)
+			default:
+				fmt.Fprintf(&b, "*[%s]*\n\n", partLabel(part))
+			}
+		}
+
+		for _, call := range entry.ToolCalls {
+			fmt.Fprintf(&b, "Tool call `%s`:\n\n```json\n%s\n```\n\n", call.Function.Name, call.Function.Arguments)
+		}
+	}
+
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+func exportHTML(thread string, historyEntries []History) string {
+	var b strings.Builder
+
+	title := html.EscapeString(thread)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>\n%s\n</style>\n</head>\n<body>\n<h1>%s</h1>\n", title, htmlStyle, title)
+
+	for _, entry := range historyEntries {
+		class := entry.Role
+		if entry.Summary {
+			class = "summary"
+		}
+		fmt.Fprintf(&b, "<section class=\"message %s\">\n<header>%s", html.EscapeString(class), html.EscapeString(roleLabel(entry)))
+		if !entry.Timestamp.IsZero() {
+			fmt.Fprintf(&b, "<time datetime=\"%s\">%s</time>", entry.Timestamp.Format("2006-01-02T15:04:05Z07:00"), entry.Timestamp.Format(exportTimeLayout))
+		}
+		b.WriteString("</header>\n")
+
+		for _, part := range contentParts(entry.Content) {
+			switch {
+			case isTextPart(part) && isToolResult(entry):
+				fmt.Fprintf(&b, "<pre>%s</pre>\n", html.EscapeString(part.Text))
+			case isTextPart(part):
+				fmt.Fprintf(&b, "<div class=\"text\">%s</div>\n", html.EscapeString(part.Text))
+			case part.Type == imageURLType && part.URL != "":
+				fmt.Fprintf(&b, "<img src=\"%s\" alt=\"image\">\n", html.EscapeString(part.URL))
+			case part.Type == audioType && part.Data != "":
+				fmt.Fprintf(&b, "<audio controls src=\"data:audio/%s;base64,%s\"></audio>\n", html.EscapeString(part.Format), html.EscapeString(part.Data))
+			default:
+				fmt.Fprintf(&b, "<p><em>[%s]</em></p>\n", html.EscapeString(partLabel(part)))
+			}
+		}
+
+		for _, call := range entry.ToolCalls {
+			fmt.Fprintf(&b, "<p>Tool call <code>%s</code>:</p>\n<pre>%s</pre>\n", html.EscapeString(call.Function.Name), html.EscapeString(call.Function.Arguments))
+		}
+
+		b.WriteString("</section>\n")
+	}
+
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+func exportJSON(thread string, historyEntries []History) (string, error) {
+	if historyEntries == nil {
+		historyEntries = []History{}
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Thread   string    `json:"thread"`
+		Messages []History `json:"messages"`
+	}{thread, historyEntries}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(data) + "\n", nil
+}
+
+// exportJSONL writes the thread as a single training example. Summaries become
+// system messages and timestamps are left out.
+func exportJSONL(historyEntries []History) (string, error) {
+	messages := make([]api.Message, 0, len(historyEntries))
+	for _, entry := range historyEntries {
+		messages = append(messages, entry.Message)
+	}
+
+	data, err := json.Marshal(struct {
+		Messages []api.Message `json:"messages"`
+	}{messages})
+	if err != nil {
+		return "", err
+	}
+
+	return string(data) + "\n", nil
+}
+
+// embedBlobs returns historyEntries with the attachments that are stored as
+// blobs embedded in their messages. strip also leaves out the fields only the
+// history knows about, so the messages are in the format of the API.
+func (h *Manager) embedBlobs(historyEntries []History, strip bool) ([]History, error) {
+	result := make([]History, len(historyEntries))
+
+	for i, entry := range historyEntries {
+		result[i] = entry
+		if _, ok := entry.Content.(string); ok || entry.Content == nil {
+			continue
+		}
+
+		parts := api.ContentParts(entry.Content)
+		if !hasBlobs(parts, strip) {
+			continue
+		}
+
+		embedded := make([]api.ContentPart, 0, len(parts))
+		for _, part := range parts {
+			if part.Blob != "" {
+				if h.blobs == nil {
+					return nil, fmt.Errorf(errNoBlobs, part.Blob)
+				}
+				data, err := h.blobs.Get(part.Blob)
+				if err != nil {
+					return nil, err
+				}
+				part = embed(part, data)
+			}
+			if strip {
+				part.MediaType, part.Name = "", ""
+			}
+			embedded = append(embedded, part)
+		}
+		result[i].Content = embedded
+	}
+
+	return result, nil
+}
+
+// hasBlobs reports whether parts refer to a blob, or when strip is set, have
+// any of the fields only the history knows about.
+func hasBlobs(parts []api.ContentPart, strip bool) bool {
+	for _, part := range parts {
+		if part.Blob != "" || strip && (part.MediaType != "" || part.Name != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// embed puts data into an image part as a data URL or into an audio part, the
+// way the client sends attachments.
+func embed(part api.ContentPart, data []byte) api.ContentPart {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	part.Blob = ""
+
+	if part.Type == audioType {
+		audio := api.InputAudio{Data: encoded}
+		if part.InputAudio != nil {
+			audio.Format = part.InputAudio.Format
+		}
+		part.InputAudio = &audio
+		return part
+	}
+
+	part.ImageURL = &api.ImageURL{URL: fmt.Sprintf(dataURL, part.MediaType, encoded)}
+	return part
+}
+
+// contentParts converts the content of a message, which is either a string or a
+// list of typed parts, into parts.
+func contentParts(content interface{}) []contentPart {
+	var result []contentPart
+	for _, p := range api.ContentParts(content) {
+		part := contentPart{Type: p.Type, Text: p.Text, Name: p.Name}
+		if p.ImageURL != nil {
+			part.URL = p.ImageURL.URL
+		}
+		if p.InputAudio != nil {
+			part.Data = p.InputAudio.Data
+			part.Format = p.InputAudio.Format
+		}
+		result = append(result, part)
+	}
+
+	return result
+}
+
+func isTextPart(part contentPart) bool {
+	return part.Type == textType || part.Type == "input_text" || part.Type == "output_text"
+}
+
+func isToolResult(entry History) bool {
+	return entry.Role == toolRole || entry.Role == functionRole
+}
+
+func partLabel(part contentPart) string {
+	switch {
+	case part.Type == audioType && part.Format != "":
+		return "audio: " + part.Format
+	case part.Type == audioType:
+		return "audio"
+	case part.Type == imageURLType && part.Name != "":
+		return "image: " + part.Name
+	case part.Type == imageURLType:
+		return "image"
+	case part.Type == "":
+		return "content"
+	default:
+		return part.Type
+	}
+}
+
+func roleLabel(entry History) string {
+	role := entry.Role
+	if entry.Summary {
+		role = "summary"
+	}
+	if role == "" {
+		return ""
+	}
+
+	label := strings.ToUpper(role[:1]) + role[1:]
+	if isToolResult(entry) && entry.Name != "" {
+		label += " " + entry.Name
+	}
+	return label
+}
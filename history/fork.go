@@ -0,0 +1,139 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	forkSeparator    = "@"
+	errInvalidFork   = "invalid fork point %q: expected <thread>[@<message number>]"
+	errForkRange     = "thread %s has %d messages, cannot fork at message %d"
+	errThreadExists  = "thread %s already exists"
+	errSameThread    = "cannot fork thread %s into itself"
+	maxLineageLength = 32
+)
+
+// ParseForkSource splits "thread@index" into the thread and the index. The
+// index is 0 when it is left out, which forks the whole thread.
+func ParseForkSource(source string) (string, int, error) {
+	thread, index, found := strings.Cut(source, forkSeparator)
+	if thread == "" {
+		return "", 0, fmt.Errorf(errInvalidFork, source)
+	}
+	if !found {
+		return thread, 0, nil
+	}
+
+	n, err := strconv.Atoi(index)
+	if err != nil || n == 0 {
+		return "", 0, fmt.Errorf(errInvalidFork, source)
+	}
+
+	return thread, n, nil
+}
+
+// Fork copies the messages of source up to and including message index, which
+// is numbered as messageIndex numbers it, into the new thread target. So -3
+// leaves out the last exchange. An index of 0 copies the whole thread. The fork
+// point is recorded in the metadata of target. It returns the number of copied
+// messages.
+func (h *Manager) Fork(source string, index int, target string) (int, error) {
+	if source == target {
+		return 0, fmt.Errorf(errSameThread, source)
+	}
+
+	historyEntries, err := h.store.ReadThread(source)
+	if err != nil {
+		return 0, err
+	}
+
+	count := len(historyEntries)
+	if index != 0 {
+		last, ok := messageIndex(historyEntries, index)
+		if !ok {
+			return 0, fmt.Errorf(errForkRange, source, len(historyEntries), index)
+		}
+		count = last + 1
+	}
+
+	if _, err := h.store.ReadThread(target); !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf(errThreadExists, target)
+	}
+
+	current := h.store.GetThread()
+	defer h.store.SetThread(current)
+
+	h.store.SetThread(target)
+	if err := h.store.Write(historyEntries[:count]); err != nil {
+		return 0, err
+	}
+
+	if metadataStore, ok := h.store.(MetadataStore); ok {
+		metadata := Metadata{Parent: source, ForkPoint: count, Created: time.Now()}
+		if err := metadataStore.WriteMetadata(target, metadata); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+// messageIndex returns the position in entries of the message with number.
+// Messages are numbered from 1 in the order they are stored, starting with the
+// system role, as --show-history prints them. Negative numbers count from the
+// end, so -1 is the last message.
+func messageIndex(entries []History, number int) (int, bool) {
+	index := number - 1
+	if number < 0 {
+		index = len(entries) + number
+	}
+	return index, number != 0 && index >= 0 && index < len(entries)
+}
+
+// Lineage returns the forks that led to thread, starting with the thread that
+// was forked first and ending with the parent of thread. It is empty when the
+// thread was not forked or the store keeps no metadata.
+func (h *Manager) Lineage(thread string) ([]Metadata, error) {
+	metadataStore, ok := h.store.(MetadataStore)
+	if !ok {
+		return nil, nil
+	}
+
+	var (
+		result  []Metadata
+		visited = map[string]bool{thread: true}
+	)
+
+	for len(result) < maxLineageLength {
+		metadata, err := metadataStore.ReadMetadata(thread)
+		if err != nil {
+			return nil, err
+		}
+		if metadata.Parent == "" || visited[metadata.Parent] {
+			break
+		}
+
+		result = append([]Metadata{metadata}, result...)
+		visited[metadata.Parent] = true
+		thread = metadata.Parent
+	}
+
+	return result, nil
+}
+
+// formatLineage describes the forks that led to thread, like
+// "main@4 → experiment@6 → thread".
+func formatLineage(lineage []Metadata, thread string) string {
+	var parts []string
+	for _, fork := range lineage {
+		parts = append(parts, fmt.Sprintf("%s%s%d", fork.Parent, forkSeparator, fork.ForkPoint))
+	}
+	parts = append(parts, thread)
+
+	return fmt.Sprintf("**LINEAGE** 🌱:\n%s\n", strings.Join(parts, " → "))
+}
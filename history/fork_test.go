@@ -0,0 +1,163 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/history"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"testing"
+)
+
+func TestUnitFork(t *testing.T) {
+	spec.Run(t, "Testing thread forks", testFork, spec.Report(report.Terminal{}))
+}
+
+func testFork(t *testing.T, when spec.G, it spec.S) {
+	var (
+		store   *history.FileIO
+		manager *history.Manager
+		entries []history.History
+	)
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		store = (&history.FileIO{}).WithDirectory(t.TempDir())
+		manager = history.NewHistory(store)
+
+		entries = []history.History{
+			{Message: api.Message{Role: "system", Content: "be brief"}},
+			{Message: api.Message{Role: "user", Content: "question"}},
+			{Message: api.Message{Role: "assistant", Content: "answer"}},
+			{Message: api.Message{Role: "user", Content: "follow-up"}},
+			{Message: api.Message{Role: "assistant", Content: "second answer"}},
+		}
+
+		store.SetThread("main")
+		Expect(store.Write(entries)).To(Succeed())
+	})
+
+	when("ParseForkSource()", func() {
+		it("parses the thread and the message number", func() {
+			thread, index, err := history.ParseForkSource("main@3")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(thread).To(Equal("main"))
+			Expect(index).To(Equal(3))
+
+			thread, index, err = history.ParseForkSource("main")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(thread).To(Equal("main"))
+			Expect(index).To(Equal(0))
+
+			_, index, err = history.ParseForkSource("main@-2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(index).To(Equal(-2))
+		})
+
+		it("rejects invalid fork points", func() {
+			for _, source := range []string{"", "@3", "main@", "main@x", "main@0"} {
+				_, _, err := history.ParseForkSource(source)
+				Expect(err).To(MatchError(ContainSubstring("invalid fork point")), source)
+			}
+		})
+	})
+
+	when("Fork()", func() {
+		it("copies the thread up to the message and records the fork point", func() {
+			count, err := manager.Fork("main", 3, "alt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(3))
+
+			Expect(store.ReadThread("alt")).To(Equal(entries[:3]))
+			Expect(store.ReadThread("main")).To(Equal(entries))
+			Expect(store.GetThread()).To(Equal("main"))
+
+			metadata, err := store.ReadMetadata("alt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata.Parent).To(Equal("main"))
+			Expect(metadata.ForkPoint).To(Equal(3))
+			Expect(metadata.Created.IsZero()).To(BeFalse())
+		})
+
+		it("counts negative message numbers from the end", func() {
+			count, err := manager.Fork("main", -3, "alt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(3))
+		})
+
+		it("copies the whole thread without a message number", func() {
+			count, err := manager.Fork("main", 0, "alt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(len(entries)))
+		})
+
+		it("rejects message numbers outside the thread", func() {
+			_, err := manager.Fork("main", 6, "alt")
+			Expect(err).To(MatchError("thread main has 5 messages, cannot fork at message 6"))
+
+			_, err = manager.Fork("main", -6, "alt")
+			Expect(err).To(HaveOccurred())
+		})
+
+		it("does not overwrite an existing thread", func() {
+			_, err := manager.Fork("main", 2, "alt")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = manager.Fork("main", 4, "alt")
+			Expect(err).To(MatchError("thread alt already exists"))
+			Expect(store.ReadThread("alt")).To(HaveLen(2))
+
+			_, err = manager.Fork("main", 4, "main")
+			Expect(err).To(MatchError("cannot fork thread main into itself"))
+		})
+
+		it("returns an error when the source does not exist", func() {
+			_, err := manager.Fork("missing", 0, "alt")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	when("Lineage()", func() {
+		it("follows the forks back to the first thread", func() {
+			_, err := manager.Fork("main", 3, "alt")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = manager.Fork("alt", 2, "alt-2")
+			Expect(err).NotTo(HaveOccurred())
+
+			lineage, err := manager.Lineage("alt-2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lineage).To(HaveLen(2))
+			Expect(lineage[0].Parent).To(Equal("main"))
+			Expect(lineage[1].Parent).To(Equal("alt"))
+
+			lineage, err = manager.Lineage("main")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lineage).To(BeEmpty())
+		})
+
+		it("stops at a cycle", func() {
+			Expect(store.WriteMetadata("main", history.Metadata{Parent: "alt", ForkPoint: 1})).To(Succeed())
+			Expect(store.WriteMetadata("alt", history.Metadata{Parent: "main", ForkPoint: 1})).To(Succeed())
+
+			lineage, err := manager.Lineage("main")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lineage).To(HaveLen(1))
+		})
+
+		it("is shown by Print()", func() {
+			_, err := manager.Fork("main", 3, "alt")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = manager.Fork("alt", 2, "alt-2")
+			Expect(err).NotTo(HaveOccurred())
+
+			result, err := manager.Print("alt-2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HavePrefix("**LINEAGE** 🌱:\nmain@3 → alt@2 → alt-2\n"))
+
+			result, err = manager.Print("main")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).NotTo(ContainSubstring("LINEAGE"))
+		})
+	})
+}
@@ -8,4 +8,35 @@ import (
 type History struct {
 	api.Message
 	Timestamp time.Time `json:"timestamp,omitempty"`
+	// Summary marks a message that condenses earlier messages of the thread.
+	Summary bool `json:"summary,omitempty"`
+	// Pinned marks a message that is kept when the thread is truncated.
+	Pinned bool `json:"pinned,omitempty"`
+	// Model is the model that wrote an answer, as reported by the API.
+	Model        string `json:"model,omitempty"`
+	ResponseID   string `json:"response_id,omitempty"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	// LatencyMS is the time from sending the query to storing the answer,
+	// including the rounds of tool calls in between.
+	LatencyMS int64  `json:"latency_ms,omitempty"`
+	Usage     *Usage `json:"usage,omitempty"`
+}
+
+// Usage is the number of tokens an answer took. Cached tokens are part of the
+// prompt tokens and reasoning tokens are part of the completion tokens.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens,omitempty"`
+	CompletionTokens int `json:"completion_tokens,omitempty"`
+	CachedTokens     int `json:"cached_tokens,omitempty"`
+	ReasoningTokens  int `json:"reasoning_tokens,omitempty"`
+	TotalTokens      int `json:"total_tokens,omitempty"`
+}
+
+// Add adds the tokens of other to u.
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.CachedTokens += other.CachedTokens
+	u.ReasoningTokens += other.ReasoningTokens
+	u.TotalTokens += other.TotalTokens
 }
@@ -0,0 +1,283 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/kardolus/chatgpt-cli/api"
+)
+
+const (
+	defaultImportName  = "conversation"
+	errUnknownImport   = "unrecognized import format: expected a ChatGPT conversations.json export or a list of messages"
+	errEmptyImport     = "nothing to import"
+	recipientAll       = "all"
+	contentTypeText    = "text"
+	contentTypeCode    = "code"
+	contentTypeMixed   = "multimodal_text"
+	maxThreadNameRunes = 64
+)
+
+// ImportedThread is a conversation that was read from an export.
+type ImportedThread struct {
+	Name     string
+	Messages []History
+}
+
+// chatGPTConversation is a conversation of the conversations.json file that is
+// part of the data export of the ChatGPT web app. Its messages form a tree,
+// because every edited prompt and regenerated answer starts a new branch.
+type chatGPTConversation struct {
+	Title       string                 `json:"title"`
+	CreateTime  float64                `json:"create_time"`
+	CurrentNode string                 `json:"current_node"`
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	Message *chatGPTMessage `json:"message"`
+	Parent  string          `json:"parent"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Language    string            `json:"language"`
+		Text        string            `json:"text"`
+	} `json:"content"`
+	Recipient string `json:"recipient"`
+	Metadata  struct {
+		Hidden bool `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// messagesFile is a list of messages wrapped in an object, as written by
+// --export-thread.
+type messagesFile struct {
+	Thread   string    `json:"thread"`
+	Title    string    `json:"title"`
+	Messages []History `json:"messages"`
+}
+
+// ParseImport reads a ChatGPT conversations.json export, a JSON array of
+// messages in the OpenAI format or an object with a messages array. fileName
+// names the thread of a list of messages that does not carry a name itself.
+func ParseImport(data []byte, fileName string) ([]ImportedThread, error) {
+	data = bytes.TrimSpace(data)
+	fallback := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+
+	if bytes.HasPrefix(data, []byte("{")) {
+		var file messagesFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		if file.Messages == nil {
+			return nil, errors.New(errUnknownImport)
+		}
+		return []ImportedThread{{Name: firstNonEmpty(file.Thread, file.Title, fallback), Messages: file.Messages}}, nil
+	}
+
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.New(errEmptyImport)
+	}
+
+	switch {
+	case items[0]["mapping"] != nil:
+		var conversations []chatGPTConversation
+		if err := json.Unmarshal(data, &conversations); err != nil {
+			return nil, err
+		}
+
+		var result []ImportedThread
+		for _, conversation := range conversations {
+			if messages := conversation.currentBranch(); len(messages) > 0 {
+				result = append(result, ImportedThread{Name: conversation.Title, Messages: messages})
+			}
+		}
+		return result, nil
+	case items[0]["role"] != nil:
+		var messages []History
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, err
+		}
+		return []ImportedThread{{Name: fallback, Messages: messages}}, nil
+	default:
+		return nil, errors.New(errUnknownImport)
+	}
+}
+
+// Import writes threads to the store. A thread whose name is taken gets a
+// numbered suffix, so no existing thread is overwritten. It returns the names
+// of the written threads.
+func (h *Manager) Import(threads []ImportedThread) ([]string, error) {
+	current := h.store.GetThread()
+	defer h.store.SetThread(current)
+
+	taken := make(map[string]bool)
+
+	var result []string
+	for _, thread := range threads {
+		name := h.availableName(threadName(thread.Name), taken)
+		taken[name] = true
+
+		h.store.SetThread(name)
+		if err := h.store.Write(thread.Messages); err != nil {
+			return result, fmt.Errorf("failed to write thread %s: %w", name, err)
+		}
+
+		result = append(result, name)
+	}
+
+	return result, nil
+}
+
+func (h *Manager) availableName(name string, taken map[string]bool) string {
+	candidate := name
+	for i := 2; ; i++ {
+		if !taken[candidate] {
+			if _, err := h.store.ReadThread(candidate); errors.Is(err, os.ErrNotExist) {
+				return candidate
+			}
+		}
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+}
+
+// currentBranch returns the messages on the path from the root of the tree to
+// the node that was shown last. Hidden messages and the exchanges with the
+// built-in tools are left out.
+func (c chatGPTConversation) currentBranch() []History {
+	var (
+		result  []History
+		visited = make(map[string]bool)
+	)
+
+	for id := c.CurrentNode; id != "" && !visited[id]; id = c.Mapping[id].Parent {
+		visited[id] = true
+
+		node, ok := c.Mapping[id]
+		if !ok || node.Message == nil {
+			continue
+		}
+
+		entry, ok := node.Message.toHistory(c.CreateTime)
+		if ok {
+			result = append(result, entry)
+		}
+	}
+
+	// the branch was collected from the leaf up
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return result
+}
+
+func (m *chatGPTMessage) toHistory(conversationTime float64) (History, bool) {
+	role := m.Author.Role
+	if role != userRole && role != assistantRole && role != systemRole {
+		return History{}, false
+	}
+	if m.Metadata.Hidden || (m.Recipient != "" && m.Recipient != recipientAll) {
+		return History{}, false
+	}
+
+	var text string
+	switch m.Content.ContentType {
+	case contentTypeText, contentTypeMixed:
+		var parts []string
+		for _, raw := range m.Content.Parts {
+			var part string
+			if err := json.Unmarshal(raw, &part); err != nil {
+				// uploaded files and generated images are not part of the export
+				parts = append(parts, "[attachment]")
+				continue
+			}
+			parts = append(parts, part)
+		}
+		text = strings.Join(parts, "\n")
+	case contentTypeCode:
+		text = fmt.Sprintf("```%s\n%s\n```", m.Content.Language, m.Content.Text)
+	default:
+		return History{}, false
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return History{}, false
+	}
+
+	created := m.CreateTime
+	if created == 0 {
+		created = conversationTime
+	}
+
+	var timestamp time.Time
+	if created > 0 {
+		seconds, fraction := math.Modf(created)
+		timestamp = time.Unix(int64(seconds), int64(fraction*float64(time.Second)))
+	}
+
+	return History{
+		Message:   api.Message{Role: role, Content: text},
+		Timestamp: timestamp,
+	}, true
+}
+
+// threadName turns a title into a thread name that is safe to use as a file
+// name, like "Fixing the build" into "fixing-the-build".
+func threadName(title string) string {
+	var (
+		b    strings.Builder
+		dash bool
+		n    int
+	)
+
+	for _, r := range strings.ToLower(title) {
+		if n == maxThreadNameRunes {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			if dash && b.Len() > 0 {
+				b.WriteRune('-')
+				n++
+			}
+			b.WriteRune(r)
+			n++
+			dash = false
+			continue
+		}
+		dash = true
+	}
+
+	if b.Len() == 0 {
+		return defaultImportName
+	}
+	return b.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
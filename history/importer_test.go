@@ -0,0 +1,122 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/history"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"testing"
+	"time"
+)
+
+func TestUnitImporter(t *testing.T) {
+	spec.Run(t, "Testing the history importer", testImporter, spec.Report(report.Terminal{}))
+}
+
+func testImporter(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("ParseImport()", func() {
+		it("follows the current branch of a ChatGPT conversation", func() {
+			data := `[{
+				"title": "Fixing the build!",
+				"create_time": 1700000000.5,
+				"current_node": "answer-2",
+				"mapping": {
+					"root": {"message": null, "parent": null},
+					"system": {"message": {"author": {"role": "system"}, "content": {"content_type": "text", "parts": [""]}, "metadata": {"is_visually_hidden_from_conversation": true}}, "parent": "root"},
+					"question": {"message": {"author": {"role": "user"}, "create_time": 1700000001.25, "content": {"content_type": "multimodal_text", "parts": [{"content_type": "image_asset_pointer"}, "why does it fail?"]}, "recipient": "all"}, "parent": "system"},
+					"answer-1": {"message": {"author": {"role": "assistant"}, "create_time": 1700000002, "content": {"content_type": "text", "parts": ["regenerated away"]}}, "parent": "question"},
+					"tool-call": {"message": {"author": {"role": "assistant"}, "create_time": 1700000003, "content": {"content_type": "code", "language": "python", "text": "print(1)"}, "recipient": "python"}, "parent": "question"},
+					"tool-output": {"message": {"author": {"role": "tool"}, "content": {"content_type": "execution_output", "text": "1"}}, "parent": "tool-call"},
+					"code": {"message": {"author": {"role": "assistant"}, "create_time": 1700000004, "content": {"content_type": "code", "language": "go", "text": "go build ./..."}, "recipient": "all"}, "parent": "tool-output"},
+					"answer-2": {"message": {"author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["it compiles now"]}}, "parent": "code"}
+				}
+			}]`
+
+			threads, err := history.ParseImport([]byte(data), "conversations.json")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(1))
+			Expect(threads[0].Name).To(Equal("Fixing the build!"))
+
+			messages := threads[0].Messages
+			Expect(messages).To(HaveLen(3))
+			Expect(messages[0].Message).To(Equal(api.Message{Role: "user", Content: "[attachment]\nwhy does it fail?"}))
+			Expect(messages[0].Timestamp).To(Equal(time.Unix(1700000001, int64(250*time.Millisecond))))
+			Expect(messages[1].Content).To(Equal("```go\ngo build ./...\n```"))
+			Expect(messages[2].Content).To(Equal("it compiles now"))
+			Expect(messages[2].Timestamp).To(Equal(time.Unix(1700000000, int64(500*time.Millisecond))))
+		})
+
+		it("skips conversations without messages", func() {
+			data := `[{"title": "empty", "current_node": "root", "mapping": {"root": {"message": null}}}]`
+
+			threads, err := history.ParseImport([]byte(data), "conversations.json")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(BeEmpty())
+		})
+
+		it("reads a list of messages and names the thread after the file", func() {
+			data := `[{"role": "system", "content": "be brief"}, {"role": "user", "content": "hi"}]`
+
+			threads, err := history.ParseImport([]byte(data), "/tmp/notes.json")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(Equal([]history.ImportedThread{{
+				Name: "notes",
+				Messages: []history.History{
+					{Message: api.Message{Role: "system", Content: "be brief"}},
+					{Message: api.Message{Role: "user", Content: "hi"}},
+				},
+			}}))
+		})
+
+		it("reads an exported thread", func() {
+			data := `{"thread": "work", "messages": [{"role": "user", "content": "hi", "timestamp": "2024-01-02T03:04:05Z"}]}`
+
+			threads, err := history.ParseImport([]byte(data), "export.json")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(threads).To(HaveLen(1))
+			Expect(threads[0].Name).To(Equal("work"))
+			Expect(threads[0].Messages[0].Timestamp).To(Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+		})
+
+		it("rejects other JSON", func() {
+			_, err := history.ParseImport([]byte(`[{"name": "x"}]`), "other.json")
+			Expect(err).To(MatchError(ContainSubstring("unrecognized import format")))
+
+			_, err = history.ParseImport([]byte(`{"name": "x"}`), "other.json")
+			Expect(err).To(MatchError(ContainSubstring("unrecognized import format")))
+
+			_, err = history.ParseImport([]byte(`[]`), "other.json")
+			Expect(err).To(MatchError("nothing to import"))
+		})
+	})
+
+	when("Import()", func() {
+		it("writes each thread under a free name", func() {
+			store := (&history.FileIO{}).WithDirectory(t.TempDir())
+			store.SetThread("current")
+			Expect(store.Write([]history.History{{Message: api.Message{Role: "user", Content: "existing"}}})).To(Succeed())
+
+			store.SetThread("fixing-the-build")
+			Expect(store.Write(nil)).To(Succeed())
+			store.SetThread("current")
+
+			message := history.History{Message: api.Message{Role: "user", Content: "imported"}}
+			names, err := history.NewHistory(store).Import([]history.ImportedThread{
+				{Name: "Fixing the build!", Messages: []history.History{message}},
+				{Name: "Fixing: the build", Messages: []history.History{message}},
+				{Name: "???", Messages: []history.History{message}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names).To(Equal([]string{"fixing-the-build-2", "fixing-the-build-3", "conversation"}))
+
+			Expect(store.GetThread()).To(Equal("current"))
+			Expect(store.ReadThread("fixing-the-build-2")).To(Equal([]history.History{message}))
+			Expect(store.ReadThread("current")).To(HaveLen(1))
+		})
+	})
+}
@@ -0,0 +1,62 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kardolus/chatgpt-cli/internal"
+)
+
+const (
+	lockExtension     = internal.LockExtension
+	lockTimeout       = 10 * time.Second
+	lockRetryInterval = 20 * time.Millisecond
+	errLockTimeout    = "timed out after %s waiting for the lock on %s"
+)
+
+// fileLock is an advisory lock that serializes the writes of concurrent chatgpt
+// processes. It is taken on a separate file, because the locked thread file is
+// replaced by every write.
+type fileLock struct {
+	file *os.File
+}
+
+// acquireLock blocks until it holds the lock on path or lockTimeout passed. The
+// directory of path is created, so the first write of a thread can lock it.
+func acquireLock(path string) (*fileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		ok, err := tryLock(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		if ok {
+			return &fileLock{file: file}, nil
+		}
+		if time.Now().After(deadline) {
+			_ = file.Close()
+			return nil, fmt.Errorf(errLockTimeout, lockTimeout, path)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func (l *fileLock) release() error {
+	if err := unlock(l.file); err != nil {
+		_ = l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -13,16 +13,27 @@ const (
 	systemRole    = "system"
 	userRole      = "user"
 	functionRole  = "function"
+	toolRole      = "tool"
+	textType      = "text"
+	imageURLType  = "image_url"
+	audioType     = "input_audio"
 )
 
 type Manager struct {
 	store Store
+	blobs *Blobs
 }
 
 func NewHistory(store Store) *Manager {
 	return &Manager{store: store}
 }
 
+// WithBlobs reads the attachments that messages refer to by hash from b.
+func (h *Manager) WithBlobs(b *Blobs) *Manager {
+	h.blobs = b
+	return h
+}
+
 func (h *Manager) ParseUserHistory(thread string) ([]string, error) {
 	var result []string
 
@@ -38,7 +49,7 @@ func (h *Manager) ParseUserHistory(thread string) ([]string, error) {
 
 	for _, entry := range historyEntries {
 		if entry.Role == userRole {
-			if s, ok := entry.Content.(string); ok {
+			if s := contentText(entry.Content); s != "" {
 				result = append(result, s)
 			}
 		}
@@ -55,30 +66,50 @@ func (h *Manager) Print(thread string) (string, error) {
 		return "", err
 	}
 
+	lineage, err := h.Lineage(thread)
+	if err != nil {
+		return "", err
+	}
+	if len(lineage) > 0 {
+		result += formatLineage(lineage, thread)
+	}
+	if store, ok := h.store.(MetadataStore); ok {
+		metadata, err := store.ReadMetadata(thread)
+		if err != nil {
+			return "", err
+		}
+		if len(metadata.Sticky) > 0 {
+			result += formatSticky(metadata.Sticky)
+		}
+	}
+
 	var (
 		lastRole            string
 		concatenatedMessage string
+		concatenatedPinned  bool
+		concatenatedFirst   int
 	)
 
-	for _, entry := range historyEntries {
+	for i, entry := range historyEntries {
 		if entry.Role == userRole && lastRole == userRole {
-			concatenatedMessage += entry.Content.(string)
+			concatenatedMessage += renderContent(entry.Content)
+			concatenatedPinned = concatenatedPinned || entry.Pinned
 		} else {
 			if lastRole == userRole && concatenatedMessage != "" {
-				result += formatHistory(History{
+				result += formatHistory(messageNumbers(concatenatedFirst, i-1), History{
 					Message:   api.Message{Role: userRole, Content: concatenatedMessage},
 					Timestamp: entry.Timestamp,
+					Pinned:    concatenatedPinned,
 				})
 				concatenatedMessage = ""
 			}
 
 			if entry.Role == userRole {
-				concatenatedMessage = entry.Content.(string)
+				concatenatedMessage = renderContent(entry.Content)
+				concatenatedPinned = entry.Pinned
+				concatenatedFirst = i
 			} else {
-				result += formatHistory(History{
-					Message:   entry.Message,
-					Timestamp: entry.Timestamp,
-				})
+				result += formatHistory(messageNumbers(i, i), entry)
 			}
 		}
 
@@ -87,38 +118,103 @@ func (h *Manager) Print(thread string) (string, error) {
 
 	// Handle the case where the last entry is a user entry and was concatenated
 	if lastRole == userRole && concatenatedMessage != "" {
-		result += formatHistory(History{
+		result += formatHistory(messageNumbers(concatenatedFirst, len(historyEntries)-1), History{
 			Message: api.Message{Role: userRole, Content: concatenatedMessage},
+			Pinned:  concatenatedPinned,
 		})
 	}
 
 	return result, nil
 }
 
-func formatHistory(entry History) string {
+// messageNumbers labels the messages from index first to index last with the
+// numbers --pin and --fork-thread take.
+func messageNumbers(first, last int) string {
+	if first == last {
+		return fmt.Sprintf("#%d", first+1)
+	}
+	return fmt.Sprintf("#%d-%d", first+1, last+1)
+}
+
+func formatHistory(number string, entry History) string {
 	var (
 		emoji     string
 		prefix    string
 		timestamp string
 	)
 
-	switch entry.Role {
-	case systemRole:
+	switch {
+	case entry.Summary:
+		return fmt.Sprintf("\n%s **SUMMARY** 📝:\n%s\n", number, renderContent(entry.Content))
+	case entry.Role == systemRole:
 		emoji = "💻"
 		prefix = "\n"
-	case userRole:
+	case entry.Role == userRole:
 		emoji = "👤"
 		prefix = "---\n"
 		if !entry.Timestamp.IsZero() {
 			timestamp = fmt.Sprintf(" [%s]", entry.Timestamp.Format("2006-01-02 15:04:05"))
 		}
-	case functionRole:
+	case entry.Role == functionRole:
 		emoji = "🔌"
 		prefix = "---\n"
-	case assistantRole:
+	case entry.Role == assistantRole:
 		emoji = "🤖"
 		prefix = "\n"
+		if details := answerDetails(entry); details != "" {
+			timestamp = fmt.Sprintf(" [%s]", details)
+		}
+	}
+
+	if entry.Pinned {
+		emoji += " 📌"
+	}
+
+	return fmt.Sprintf("%s%s **%s** %s%s:\n%s\n", prefix, number, strings.ToUpper(entry.Role), emoji, timestamp, renderContent(entry.Content))
+}
+
+// renderContent renders the content of a message as text, with a label like
+// [image: cat.png] in place of each attachment.
+func renderContent(content interface{}) string {
+	if s, ok := content.(string); ok {
+		return s
+	}
+
+	var parts []string
+	for _, part := range contentParts(content) {
+		if isTextPart(part) {
+			parts = append(parts, part.Text)
+		} else {
+			parts = append(parts, "["+partLabel(part)+"]")
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// answerDetails describes the model, usage and latency of an answer, and why it
+// ended when that was not the natural end of the answer.
+func answerDetails(entry History) string {
+	var details []string
+
+	if entry.Model != "" {
+		details = append(details, entry.Model)
+	}
+	if entry.Usage != nil && entry.Usage.TotalTokens > 0 {
+		tokens := fmt.Sprintf("%d tokens", entry.Usage.TotalTokens)
+		if entry.Usage.CachedTokens > 0 {
+			tokens += fmt.Sprintf(" (%d cached)", entry.Usage.CachedTokens)
+		}
+		details = append(details, tokens)
+	}
+	if entry.LatencyMS > 0 {
+		details = append(details, fmt.Sprintf("%.1fs", float64(entry.LatencyMS)/1000))
+	}
+	switch entry.FinishReason {
+	case "", "stop", "end_turn", "completed":
+	default:
+		details = append(details, entry.FinishReason)
 	}
 
-	return fmt.Sprintf("%s**%s** %s%s:\n%s\n", prefix, strings.ToUpper(entry.Role), emoji, timestamp, entry.Content)
+	return strings.Join(details, ", ")
 }
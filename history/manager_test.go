@@ -1,6 +1,7 @@
 package history_test
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/kardolus/chatgpt-cli/api"
@@ -9,7 +10,9 @@ import (
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 //go:generate mockgen -destination=historymocks_test.go -package=history_test github.com/kardolus/chatgpt-cli/history Store
@@ -123,8 +126,8 @@ func testHistory(t *testing.T, when spec.G, it spec.S) {
 
 			result, err := subject.Print(threadName)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(result).To(ContainSubstring("**USER** 👤:\nfirst message second message\n"))
-			Expect(result).To(ContainSubstring("**ASSISTANT** 🤖:\nresponse\n"))
+			Expect(result).To(ContainSubstring("#1-2 **USER** 👤:\nfirst message second message\n"))
+			Expect(result).To(ContainSubstring("#3 **ASSISTANT** 🤖:\nresponse\n"))
 		})
 
 		it("prints all roles correctly", func() {
@@ -148,11 +151,84 @@ func testHistory(t *testing.T, when spec.G, it spec.S) {
 			result, err := subject.Print(threadName)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(result).To(ContainSubstring("**SYSTEM** 💻:\nsystem message\n"))
-			Expect(result).To(ContainSubstring("\n---\n**FUNCTION** 🔌:\nfunction message\n"))
-			Expect(result).To(ContainSubstring("\n---\n**USER** 👤:\nuser message\n"))
+			Expect(result).To(ContainSubstring("\n---\n#2 **FUNCTION** 🔌:\nfunction message\n"))
+			Expect(result).To(ContainSubstring("\n---\n#3 **USER** 👤:\nuser message\n"))
 			Expect(result).To(ContainSubstring("**ASSISTANT** 🤖:\nassistant message\n"))
 		})
 
+		it("shows summaries apart from the system prompt", func() {
+			historyEntries := []history.History{
+				{
+					Message: api.Message{Role: "system", Content: "system message"},
+				},
+				{
+					Message: api.Message{Role: "system", Content: "the user asked about Go"},
+					Summary: true,
+				},
+				{
+					Message: api.Message{Role: "assistant", Content: "assistant message"},
+				},
+			}
+
+			mockHistoryStore.EXPECT().ReadThread(threadName).Return(historyEntries, nil).Times(1)
+
+			result, err := subject.Print(threadName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring("**SYSTEM** 💻:\nsystem message\n"))
+			Expect(result).To(ContainSubstring("\n#2 **SUMMARY** 📝:\nthe user asked about Go\n"))
+			Expect(result).NotTo(ContainSubstring("**SYSTEM** 💻:\nthe user asked about Go"))
+		})
+
+		it("shows the model, usage and latency of answers", func() {
+			historyEntries := []history.History{
+				{
+					Message:      api.Message{Role: "assistant", Content: "complete"},
+					Model:        "gpt-4o",
+					Usage:        &history.Usage{PromptTokens: 100, CompletionTokens: 20, CachedTokens: 64, TotalTokens: 120},
+					LatencyMS:    2345,
+					FinishReason: "stop",
+				},
+				{
+					Message:      api.Message{Role: "assistant", Content: "cut off"},
+					Model:        "gpt-4o",
+					FinishReason: "length",
+				},
+			}
+
+			mockHistoryStore.EXPECT().ReadThread(threadName).Return(historyEntries, nil).Times(1)
+
+			result, err := subject.Print(threadName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring("**ASSISTANT** 🤖 [gpt-4o, 120 tokens (64 cached), 2.3s]:\ncomplete\n"))
+			Expect(result).To(ContainSubstring("**ASSISTANT** 🤖 [gpt-4o, length]:\ncut off\n"))
+		})
+
+		it("labels the attachments of messages", func() {
+			historyEntries := []history.History{
+				{
+					Message: api.Message{Role: "user", Content: []api.ContentPart{
+						{Type: "text", Text: "what is this?"},
+						{Type: "image_url", Blob: "abc", MediaType: "image/png", Name: "cat.png"},
+					}},
+				},
+				{
+					Message: api.Message{Role: "assistant", Content: "A cat."},
+				},
+				{
+					Message: api.Message{Role: "user", Content: []interface{}{
+						map[string]interface{}{"type": "input_audio", "input_audio": map[string]interface{}{"format": "wav"}},
+					}},
+				},
+			}
+
+			mockHistoryStore.EXPECT().ReadThread(threadName).Return(historyEntries, nil).Times(1)
+
+			result, err := subject.Print(threadName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring("**USER** 👤:\nwhat is this? [image: cat.png]\n"))
+			Expect(result).To(ContainSubstring("**USER** 👤:\n[audio: wav]\n"))
+		})
+
 		it("handles the final user message concatenation", func() {
 			historyEntries := []history.History{
 				{
@@ -170,4 +246,132 @@ func testHistory(t *testing.T, when spec.G, it spec.S) {
 			Expect(result).To(ContainSubstring("**USER** 👤:\nfirst message second message\n"))
 		})
 	})
+
+	when("Export()", func() {
+		const threadName = "threadName"
+
+		var historyEntries []history.History
+
+		it.Before(func() {
+			image := api.ImageContent{Type: "image_url"}
+			image.ImageURL.URL = "https://example.com/cat.png"
+
+			historyEntries = []history.History{
+				{Message: api.Message{Role: "system", Content: "be brief"}},
+				{
+					Message:   api.Message{Role: "user", Content: []interface{}{map[string]interface{}{"type": "text", "text": "what is <this>?"}}},
+					Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				},
+				{Message: api.Message{Role: "user", Content: []api.ImageContent{image}}},
+				{Message: api.Message{Role: "user", Content: []api.AudioContent{{Type: "input_audio", InputAudio: api.InputAudio{Data: "AAAA", Format: "mp3"}}}}},
+				{Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{{ID: "call_1", Type: "function", Function: api.FunctionCall{Name: "lookup", Arguments: `{"q":"cat"}`}}}}},
+				{Message: api.Message{Role: "tool", Name: "lookup", Content: "a cat", ToolCallID: "call_1"}},
+				{Message: api.Message{Role: "assistant", Content: "It is a cat."}},
+			}
+		})
+
+		it("rejects an unknown format without reading the thread", func() {
+			_, err := subject.Export(threadName, "pdf")
+			Expect(err).To(MatchError(`unknown export format "pdf", expected md, html, json or jsonl`))
+		})
+
+		it("returns the error of the store", func() {
+			mockHistoryStore.EXPECT().ReadThread(threadName).Return(nil, errors.New("nope")).Times(1)
+
+			_, err := subject.Export(threadName, history.FormatJSON)
+			Expect(err).To(MatchError("nope"))
+		})
+
+		it("exports Markdown", func() {
+			mockHistoryStore.EXPECT().ReadThread(threadName).Return(historyEntries, nil).Times(1)
+
+			result, err := subject.Export(threadName, history.FormatMarkdown)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HavePrefix("# threadName\n\n## System\n\nbe brief\n"))
+			Expect(result).To(ContainSubstring("## User (2024-01-02 03:04:05)\n\nwhat is <this>?\n"))
+			Expect(result).To(ContainSubstring("![image](https://example.com/cat.png)"))
+			Expect(result).To(ContainSubstring("*[audio: mp3]*"))
+			Expect(result).To(ContainSubstring("Tool call `lookup`:\n\n```json\n{\"q\":\"cat\"}\n```"))
+			Expect(result).To(ContainSubstring("## Tool lookup\n\n```\na cat\n```"))
+			Expect(result).To(HaveSuffix("## Assistant\n\nIt is a cat.\n"))
+		})
+
+		it("exports a self-contained HTML page", func() {
+			mockHistoryStore.EXPECT().ReadThread(threadName).Return(historyEntries, nil).Times(1)
+
+			result, err := subject.Export(threadName, history.FormatHTML)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HavePrefix("<!DOCTYPE html>"))
+			Expect(result).To(ContainSubstring("<style>"))
+			Expect(result).To(ContainSubstring(`<div class="text">what is &lt;this&gt;?</div>`))
+			Expect(result).To(ContainSubstring(`<img src="https://example.com/cat.png" alt="image">`))
+			Expect(result).To(ContainSubstring(`<audio controls src="data:audio/mp3;base64,AAAA"></audio>`))
+			Expect(result).To(ContainSubstring(`<section class="message tool">`))
+			Expect(result).To(HaveSuffix("</html>\n"))
+		})
+
+		it("exports JSON", func() {
+			mockHistoryStore.EXPECT().ReadThread(threadName).Return(historyEntries[:1], nil).Times(1)
+
+			result, err := subject.Export(threadName, history.FormatJSON)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(MatchJSON(`{"thread":"threadName","messages":[{"role":"system","content":"be brief","timestamp":"0001-01-01T00:00:00Z"}]}`))
+		})
+
+		it("exports a single fine-tuning example as JSONL", func() {
+			mockHistoryStore.EXPECT().ReadThread(threadName).Return(historyEntries, nil).Times(1)
+
+			result, err := subject.Export(threadName, history.FormatJSONL)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strings.Count(result, "\n")).To(Equal(1))
+			Expect(result).NotTo(ContainSubstring("timestamp"))
+
+			var example struct {
+				Messages []api.Message `json:"messages"`
+			}
+			Expect(json.Unmarshal([]byte(result), &example)).To(Succeed())
+			Expect(example.Messages).To(HaveLen(len(historyEntries)))
+			Expect(example.Messages[4].ToolCalls[0].Function.Name).To(Equal("lookup"))
+			Expect(example.Messages[5].ToolCallID).To(Equal("call_1"))
+		})
+
+		when("a message refers to its image by blob", func() {
+			var blobs *history.Blobs
+
+			it.Before(func() {
+				blobs = (&history.Blobs{}).WithDirectory(t.TempDir())
+				hash, err := blobs.Put([]byte("png"))
+				Expect(err).NotTo(HaveOccurred())
+
+				historyEntries = []history.History{{Message: api.Message{Role: "user", Content: []api.ContentPart{
+					{Type: "text", Text: "what is this?"},
+					{Type: "image_url", Blob: hash, MediaType: "image/png", Name: "cat.png"},
+				}}}}
+			})
+
+			it("embeds the image in the HTML page", func() {
+				mockHistoryStore.EXPECT().ReadThread(threadName).Return(historyEntries, nil).Times(1)
+
+				result, err := subject.WithBlobs(blobs).Export(threadName, history.FormatHTML)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(ContainSubstring(`<img src="data:image/png;base64,cG5n" alt="image">`))
+				Expect(result).NotTo(ContainSubstring("[image: cat.png]"))
+			})
+
+			it("exports the image in the format of the API as JSONL", func() {
+				mockHistoryStore.EXPECT().ReadThread(threadName).Return(historyEntries, nil).Times(1)
+
+				result, err := subject.WithBlobs(blobs).Export(threadName, history.FormatJSONL)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(MatchJSON(`{"messages":[{"role":"user","content":[{"type":"text","text":"what is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,cG5n"}}]}]}`))
+			})
+
+			it("returns an error without the blobs", func() {
+				mockHistoryStore.EXPECT().ReadThread(threadName).Return(historyEntries, nil).Times(1)
+
+				_, err := subject.Export(threadName, history.FormatJSONL)
+				Expect(err).To(MatchError(ContainSubstring("is stored as a blob")))
+			})
+		})
+	})
 }
@@ -0,0 +1,178 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/kardolus/chatgpt-cli/internal"
+	bolt "go.etcd.io/bbolt"
+)
+
+const metadataExtension = internal.MetadataExtension
+
+// Metadata describes a thread apart from its messages.
+type Metadata struct {
+	// Parent is the thread this thread was forked from.
+	Parent string `json:"parent,omitempty"`
+	// ForkPoint is the number of messages that were copied from the parent.
+	ForkPoint int       `json:"fork_point,omitempty"`
+	Created   time.Time `json:"created,omitempty"`
+	Updated   time.Time `json:"updated,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	// Model is the model that wrote the latest answer.
+	Model string `json:"model,omitempty"`
+	// Messages is the number of messages after the latest answer.
+	Messages int `json:"messages,omitempty"`
+	// Tokens is the total of the tokens used by the queries of the thread.
+	Tokens int `json:"tokens,omitempty"`
+	// Role is the system role the thread runs under.
+	Role string `json:"role,omitempty"`
+	// Sticky are the files that are read again and sent with every turn.
+	Sticky []string `json:"sticky,omitempty"`
+}
+
+func (m Metadata) empty() bool {
+	return reflect.DeepEqual(m, Metadata{})
+}
+
+// MetadataStore is implemented by stores that keep metadata next to threads.
+// UpdateMetadata reads, changes and writes the metadata of a thread as one
+// step, so concurrent processes cannot lose each other's changes.
+type MetadataStore interface {
+	ReadMetadata(thread string) (Metadata, error)
+	WriteMetadata(thread string, metadata Metadata) error
+	UpdateMetadata(thread string, update func(*Metadata)) (Metadata, error)
+}
+
+// Ensure FileIO and DBStore implement the MetadataStore interface
+var (
+	_ MetadataStore = &FileIO{}
+	_ MetadataStore = &DBStore{}
+)
+
+// ReadMetadata returns the metadata of thread, which is empty when none was
+// written.
+func (f *FileIO) ReadMetadata(thread string) (Metadata, error) {
+	var result Metadata
+
+	data, err := f.readFile(f.getMetadataPath(thread))
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return Metadata{}, err
+	}
+
+	return result, nil
+}
+
+// WriteMetadata stores the metadata of thread in a file next to the thread. The
+// thread is locked while the file is written.
+func (f *FileIO) WriteMetadata(thread string, metadata Metadata) error {
+	lock, err := acquireLock(f.getPath(thread) + lockExtension)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	return f.writeMetadata(thread, metadata)
+}
+
+// UpdateMetadata applies update to the metadata of thread and stores it. The
+// thread is locked from reading the metadata until it is written.
+func (f *FileIO) UpdateMetadata(thread string, update func(*Metadata)) (Metadata, error) {
+	lock, err := acquireLock(f.getPath(thread) + lockExtension)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer lock.release()
+
+	metadata, err := f.ReadMetadata(thread)
+	if err != nil {
+		return metadata, err
+	}
+
+	update(&metadata)
+
+	return metadata, f.writeMetadata(thread, metadata)
+}
+
+func (f *FileIO) writeMetadata(thread string, metadata Metadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	return f.writeFile(f.getMetadataPath(thread), data)
+}
+
+func (f *FileIO) getMetadataPath(thread string) string {
+	return filepath.Join(f.historyDir, thread+metadataExtension)
+}
+
+// ReadMetadata returns the metadata of thread, which is empty when none was
+// written.
+func (d *DBStore) ReadMetadata(thread string) (Metadata, error) {
+	var metadata Metadata
+
+	err := d.view(func(tx *bolt.Tx) error {
+		var err error
+		metadata, err = readMetadata(tx, thread)
+		return err
+	})
+
+	return metadata, err
+}
+
+// WriteMetadata stores the metadata of thread.
+func (d *DBStore) WriteMetadata(thread string, metadata Metadata) error {
+	return d.update(func(tx *bolt.Tx) error {
+		return writeMetadata(tx, thread, metadata)
+	})
+}
+
+// UpdateMetadata applies update to the metadata of thread and stores it. Both
+// happen in one transaction, so concurrent updates are not lost.
+func (d *DBStore) UpdateMetadata(thread string, update func(*Metadata)) (Metadata, error) {
+	var metadata Metadata
+
+	err := d.update(func(tx *bolt.Tx) error {
+		var err error
+		if metadata, err = readMetadata(tx, thread); err != nil {
+			return err
+		}
+		update(&metadata)
+		return writeMetadata(tx, thread, metadata)
+	})
+
+	return metadata, err
+}
+
+func readMetadata(tx *bolt.Tx, thread string) (Metadata, error) {
+	var metadata Metadata
+
+	data := tx.Bucket(metadataBucket).Get([]byte(thread))
+	if data == nil {
+		return metadata, nil
+	}
+
+	return metadata, json.Unmarshal(data, &metadata)
+}
+
+func writeMetadata(tx *bolt.Tx, thread string, metadata Metadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(metadataBucket).Put([]byte(thread), data)
+}
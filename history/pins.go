@@ -0,0 +1,129 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	errNoMessage = "thread %s has no message %d"
+	errPinSystem = "message %d of thread %s is the system role, which is always kept"
+	errNotSticky = "%s is not a sticky file of thread %s"
+	errStickyDir = "%s is a directory, only files can be sticky"
+)
+
+// Pin marks a message of thread as pinned, or unpins it, so it is kept when
+// the thread no longer fits the context window. Messages are numbered as
+// messageIndex numbers them.
+func (h *Manager) Pin(thread string, number int, pinned bool) error {
+	entries, err := h.store.ReadThread(thread)
+	if err != nil {
+		return err
+	}
+
+	index, ok := messageIndex(entries, number)
+	if !ok {
+		return fmt.Errorf(errNoMessage, thread, number)
+	}
+	if index == 0 && entries[0].Role == systemRole {
+		return fmt.Errorf(errPinSystem, number, thread)
+	}
+
+	// entries is the snapshot of the store, so it is not changed in place
+	entries = append([]History(nil), entries...)
+	entries[index].Pinned = pinned
+
+	current := h.store.GetThread()
+	defer h.store.SetThread(current)
+
+	h.store.SetThread(thread)
+	return h.store.Write(entries)
+}
+
+// Stick adds files to the sticky files of thread, which may be a thread that
+// has no messages yet. Their contents are read again on every turn and sent
+// along with the thread. Paths are stored as absolute paths.
+func (h *Manager) Stick(thread string, paths []string) error {
+	paths, err := absPaths(paths)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf(errStickyDir, path)
+		}
+	}
+
+	return h.writeMetadata(thread, func(metadata *Metadata) {
+		for _, path := range paths {
+			if !contains(metadata.Sticky, path) {
+				metadata.Sticky = append(metadata.Sticky, path)
+			}
+		}
+	})
+}
+
+// Unstick removes files from the sticky files of thread.
+func (h *Manager) Unstick(thread string, paths []string) error {
+	paths, err := absPaths(paths)
+	if err != nil {
+		return err
+	}
+
+	var missing error
+	err = h.updateMetadata(thread, func(metadata *Metadata) {
+		for _, path := range paths {
+			if !contains(metadata.Sticky, path) {
+				missing = errors.Join(missing, fmt.Errorf(errNotSticky, path, thread))
+				continue
+			}
+
+			var kept []string
+			for _, sticky := range metadata.Sticky {
+				if sticky != path {
+					kept = append(kept, sticky)
+				}
+			}
+			metadata.Sticky = kept
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return missing
+}
+
+// formatSticky lists the sticky files of a thread.
+func formatSticky(paths []string) string {
+	return fmt.Sprintf("**STICKY** 📎:\n%s\n", strings.Join(paths, "\n"))
+}
+
+func absPaths(paths []string) ([]string, error) {
+	var result []string
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, abs)
+	}
+	return result, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
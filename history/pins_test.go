@@ -0,0 +1,137 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/history"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnitPins(t *testing.T) {
+	spec.Run(t, "Testing pinned messages and sticky files", testPins, spec.Report(report.Terminal{}))
+}
+
+func testPins(t *testing.T, when spec.G, it spec.S) {
+	const thread = "thread"
+
+	var (
+		dir     string
+		store   *history.FileIO
+		manager *history.Manager
+	)
+
+	pins := func() []bool {
+		entries, err := store.ReadThread(thread)
+		Expect(err).NotTo(HaveOccurred())
+
+		var result []bool
+		for _, entry := range entries {
+			result = append(result, entry.Pinned)
+		}
+		return result
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		dir = t.TempDir()
+		store = (&history.FileIO{}).WithDirectory(dir)
+		manager = history.NewHistory(store)
+
+		store.SetThread(thread)
+		Expect(store.Write([]history.History{
+			{Message: api.Message{Role: "system", Content: "be brief"}},
+			{Message: api.Message{Role: "user", Content: "always answer in French"}},
+			{Message: api.Message{Role: "assistant", Content: "d'accord"}},
+			{Message: api.Message{Role: "user", Content: "hello"}},
+		})).To(Succeed())
+	})
+
+	when("Pin()", func() {
+		it("numbers messages from the system role and from the end", func() {
+			Expect(manager.Pin(thread, 2, true)).To(Succeed())
+			Expect(manager.Pin(thread, -1, true)).To(Succeed())
+			Expect(pins()).To(Equal([]bool{false, true, false, true}))
+
+			Expect(manager.Pin(thread, 4, false)).To(Succeed())
+			Expect(pins()).To(Equal([]bool{false, true, false, false}))
+		})
+
+		it("numbers messages like Fork() and Print()", func() {
+			Expect(manager.Pin(thread, 3, true)).To(Succeed())
+
+			result, err := manager.Print(thread)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring("#3 **ASSISTANT** 🤖 📌:\nd'accord\n"))
+
+			count, err := manager.Fork(thread, 3, "fork")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(3))
+
+			forked, err := store.ReadThread("fork")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(forked[2].Content).To(Equal("d'accord"))
+		})
+
+		it("does not pin the system role", func() {
+			Expect(manager.Pin(thread, 1, true)).To(MatchError(ContainSubstring("is the system role")))
+		})
+
+		it("leaves the current thread of the store as it was", func() {
+			store.SetThread("other")
+
+			Expect(manager.Pin(thread, 2, true)).To(Succeed())
+			Expect(store.GetThread()).To(Equal("other"))
+			Expect(pins()).To(Equal([]bool{false, true, false, false}))
+		})
+
+		it("rejects numbers outside the thread", func() {
+			for _, number := range []int{0, 5, -5} {
+				Expect(manager.Pin(thread, number, true)).To(MatchError(ContainSubstring("has no message")), "%d", number)
+			}
+		})
+
+		it("marks pinned messages in the printed history", func() {
+			Expect(manager.Pin(thread, 2, true)).To(Succeed())
+
+			result, err := manager.Print(thread)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(ContainSubstring("#2 **USER** 👤 📌:\nalways answer in French\n"))
+			Expect(result).To(ContainSubstring("#4 **USER** 👤:\nhello\n"))
+		})
+	})
+
+	when("Stick() and Unstick()", func() {
+		it("keeps absolute paths of files once", func() {
+			file := filepath.Join(dir, "notes.md")
+			Expect(os.WriteFile(file, []byte("notes"), 0644)).To(Succeed())
+
+			Expect(manager.Stick(thread, []string{file, file})).To(Succeed())
+			Expect(manager.Stick("new", []string{file})).To(Succeed())
+
+			metadata, err := store.ReadMetadata(thread)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata.Sticky).To(Equal([]string{file}))
+
+			result, err := manager.Print(thread)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HavePrefix("**STICKY** 📎:\n" + file + "\n"))
+
+			Expect(manager.Unstick(thread, []string{file})).To(Succeed())
+			metadata, err = store.ReadMetadata(thread)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata.Sticky).To(BeEmpty())
+
+			Expect(manager.Unstick(thread, []string{file})).To(MatchError(ContainSubstring("is not a sticky file")))
+		})
+
+		it("rejects files that do not exist and directories", func() {
+			Expect(os.IsNotExist(manager.Stick(thread, []string{filepath.Join(dir, "missing")}))).To(BeTrue())
+			Expect(manager.Stick(thread, []string{dir})).To(MatchError(ContainSubstring("is a directory")))
+		})
+	})
+}
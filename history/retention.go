@@ -0,0 +1,170 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/config"
+)
+
+const errInvalidRetention = "invalid retention max_age %q for %s: %w"
+
+// PruneCandidate is a thread the retention policy removes, and why.
+type PruneCandidate struct {
+	Thread  string
+	Updated time.Time
+	Reason  string
+}
+
+// retentionLimit is a rule of the retention config with its age parsed.
+type retentionLimit struct {
+	prefix   string
+	maxAge   time.Duration
+	age      string
+	maxCount int
+}
+
+// ReferencedBlobs returns the hashes of the blobs the messages of all threads
+// refer to. It fails when a thread cannot be read, since its blobs would look
+// unreferenced. A store without threads refers to none.
+func (h *Manager) ReferencedBlobs() (map[string]bool, error) {
+	lister, ok := h.store.(ThreadLister)
+	if !ok {
+		return nil, errors.New(errNoThreadList)
+	}
+
+	result := make(map[string]bool)
+
+	threads, err := lister.Threads()
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, thread := range threads {
+		entries, err := h.store.ReadThread(thread)
+		if errors.Is(err, os.ErrNotExist) {
+			// deleted since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			for _, part := range api.ContentParts(entry.Content) {
+				if part.Blob != "" {
+					result[part.Blob] = true
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Prunable returns the threads that retention removes at now, oldest first.
+// The current thread is always kept, as are the threads whose age is unknown,
+// like empty ones. Threads that are kept count towards the max_count of their
+// rule.
+func (h *Manager) Prunable(retention config.RetentionConfig, now time.Time) ([]PruneCandidate, error) {
+	limits, err := retentionLimits(retention)
+	if err != nil {
+		return nil, err
+	}
+
+	threads, err := h.ListThreads(ThreadFilter{}, SortUpdated)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result []PruneCandidate
+		kept   = make(map[string]int)
+	)
+
+	// the current thread is kept, however old, so it takes one of its slots
+	for _, thread := range threads {
+		if thread.Current {
+			kept[matchLimit(limits, thread.Name).prefix]++
+		}
+	}
+
+	// threads are sorted newest first, so the oldest exceed max_count
+	for _, thread := range threads {
+		if thread.Current {
+			continue
+		}
+
+		limit := matchLimit(limits, thread.Name)
+
+		var reason string
+		switch {
+		case thread.Updated.IsZero():
+		case limit.maxAge > 0 && now.Sub(thread.Updated) > limit.maxAge:
+			reason = fmt.Sprintf("not updated for %s", limit.age)
+		case limit.maxCount > 0 && kept[limit.prefix] >= limit.maxCount:
+			reason = fmt.Sprintf("more than %d threads", limit.maxCount)
+		}
+
+		if reason == "" {
+			kept[limit.prefix]++
+			continue
+		}
+
+		if limit.prefix != "" {
+			reason += fmt.Sprintf(" (rule for %s*)", limit.prefix)
+		}
+		result = append(result, PruneCandidate{Thread: thread.Name, Updated: thread.Updated, Reason: reason})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Updated.Before(result[j].Updated)
+	})
+
+	return result, nil
+}
+
+func retentionLimits(retention config.RetentionConfig) ([]retentionLimit, error) {
+	rules := append([]config.RetentionRule{{MaxAge: retention.MaxAge, MaxCount: retention.MaxCount}}, retention.Rules...)
+
+	var result []retentionLimit
+	for _, rule := range rules {
+		limit := retentionLimit{prefix: rule.Prefix, age: rule.MaxAge, maxCount: rule.MaxCount}
+
+		if rule.MaxAge != "" {
+			age, err := ParseAge(rule.MaxAge)
+			if err != nil {
+				scope := "all threads"
+				if rule.Prefix != "" {
+					scope = "prefix " + rule.Prefix
+				}
+				return nil, fmt.Errorf(errInvalidRetention, rule.MaxAge, scope, err)
+			}
+			limit.maxAge = age
+		}
+
+		result = append(result, limit)
+	}
+
+	return result, nil
+}
+
+// matchLimit returns the limit with the longest prefix of thread. The first
+// limit has no prefix and matches every thread.
+func matchLimit(limits []retentionLimit, thread string) retentionLimit {
+	result := limits[0]
+	for _, limit := range limits[1:] {
+		if strings.HasPrefix(thread, limit.prefix) && len(limit.prefix) > len(result.prefix) {
+			result = limit
+		}
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/config"
+	"github.com/kardolus/chatgpt-cli/history"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"testing"
+	"time"
+)
+
+func TestUnitRetention(t *testing.T) {
+	spec.Run(t, "Testing the retention policy", testRetention, spec.Report(report.Terminal{}))
+}
+
+func testRetention(t *testing.T, when spec.G, it spec.S) {
+	var (
+		store   *history.FileIO
+		manager *history.Manager
+		now     time.Time
+	)
+
+	write := func(thread string, age time.Duration) {
+		store.SetThread(thread)
+		Expect(store.Write(nil)).To(Succeed())
+		Expect(store.WriteMetadata(thread, history.Metadata{
+			Created:  now.Add(-age),
+			Updated:  now.Add(-age),
+			Messages: 2,
+		})).To(Succeed())
+	}
+
+	threads := func(candidates []history.PruneCandidate) []string {
+		var result []string
+		for _, candidate := range candidates {
+			result = append(result, candidate.Thread)
+		}
+		return result
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		store = (&history.FileIO{}).WithDirectory(t.TempDir())
+		manager = history.NewHistory(store)
+		now = time.Now().Truncate(time.Second)
+
+		write("notes", 60*24*time.Hour)
+		write("cmd_1", 10*24*time.Hour)
+		write("int_1", 9*24*time.Hour)
+		write("int_2", 3*24*time.Hour)
+		write("int_3", 2*time.Hour)
+		write("int_4", time.Hour)
+
+		store.SetThread("notes")
+	})
+
+	it("keeps every thread without limits", func() {
+		candidates, err := manager.Prunable(config.RetentionConfig{}, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(candidates).To(BeEmpty())
+	})
+
+	it("removes the threads older than max_age, but not the current thread", func() {
+		candidates, err := manager.Prunable(config.RetentionConfig{MaxAge: "1w"}, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(threads(candidates)).To(Equal([]string{"cmd_1", "int_1"}))
+		Expect(candidates[0].Reason).To(Equal("not updated for 1w"))
+	})
+
+	it("removes the oldest threads beyond max_count", func() {
+		candidates, err := manager.Prunable(config.RetentionConfig{MaxCount: 3}, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(threads(candidates)).To(Equal([]string{"cmd_1", "int_1", "int_2"}))
+		Expect(candidates[0].Reason).To(Equal("more than 3 threads"))
+	})
+
+	it("applies the rule with the longest matching prefix", func() {
+		retention := config.RetentionConfig{
+			MaxAge: "30d",
+			Rules: []config.RetentionRule{
+				{Prefix: "int_", MaxCount: 2},
+				{Prefix: "int_1", MaxAge: "1d"},
+			},
+		}
+
+		candidates, err := manager.Prunable(retention, now)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(threads(candidates)).To(Equal([]string{"int_1", "int_2"}))
+		Expect(candidates[0].Reason).To(Equal("not updated for 1d (rule for int_1*)"))
+		Expect(candidates[1].Reason).To(Equal("more than 2 threads (rule for int_*)"))
+	})
+
+	it("rejects an invalid max_age", func() {
+		retention := config.RetentionConfig{Rules: []config.RetentionRule{{Prefix: "int_", MaxAge: "soon"}}}
+
+		_, err := manager.Prunable(retention, now)
+		Expect(err).To(MatchError(ContainSubstring(`invalid retention max_age "soon" for prefix int_`)))
+	})
+}
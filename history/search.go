@@ -0,0 +1,179 @@
+package history
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	snippetBefore = 40
+	snippetAfter  = 80
+	ellipsis      = "…"
+)
+
+// Searcher is implemented by stores that can search the messages of all threads.
+type Searcher interface {
+	Search(query string, limit int) ([]SearchResult, error)
+}
+
+// SearchResult is a message that matched a search, with the text around the
+// first match.
+type SearchResult struct {
+	Thread    string
+	Role      string
+	Timestamp time.Time
+	Snippet   string
+}
+
+// Ensure FileIO implements the Searcher interface
+var _ Searcher = &FileIO{}
+
+// Threads returns the names of the threads in the history directory.
+func (f *FileIO) Threads() ([]string, error) {
+	files, err := os.ReadDir(f.historyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), jsonExtension) {
+			result = append(result, strings.TrimSuffix(file.Name(), jsonExtension))
+		}
+	}
+
+	return result, nil
+}
+
+// Search reads every thread and returns the messages that contain every word of
+// query, newest first. A limit of zero or less returns all of them.
+func (f *FileIO) Search(query string, limit int) ([]SearchResult, error) {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	threads, err := f.Threads()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []SearchResult
+	for _, thread := range threads {
+		entries, err := f.ReadThread(thread)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			if containsAll(tokenize(contentText(entry.Content)), terms) {
+				result = append(result, newSearchResult(thread, entry, terms))
+			}
+		}
+	}
+
+	return sortResults(result, limit), nil
+}
+
+// tokenize splits text into lowercase words of letters and digits, without
+// duplicates.
+func tokenize(text string) []string {
+	var (
+		result []string
+		seen   = make(map[string]bool)
+	)
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if !seen[word] {
+			seen[word] = true
+			result = append(result, word)
+		}
+	}
+
+	return result
+}
+
+func containsAll(words, terms []string) bool {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[word] = true
+	}
+
+	for _, term := range terms {
+		if !set[term] {
+			return false
+		}
+	}
+	return true
+}
+
+// contentText returns the text of a message, which is either a string or a list
+// of content parts.
+func contentText(content interface{}) string {
+	if s, ok := content.(string); ok {
+		return s
+	}
+
+	var parts []string
+	for _, part := range contentParts(content) {
+		if isTextPart(part) {
+			parts = append(parts, part.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func newSearchResult(thread string, entry History, terms []string) SearchResult {
+	return SearchResult{
+		Thread:    thread,
+		Role:      entry.Role,
+		Timestamp: entry.Timestamp,
+		Snippet:   snippet(contentText(entry.Content), terms[0]),
+	}
+}
+
+// snippet returns the text around the first occurrence of term on a single line.
+func snippet(text, term string) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	lower := []rune(strings.ToLower(string(runes)))
+
+	at := strings.Index(string(lower), term)
+	if at < 0 {
+		at = 0
+	}
+	// convert the byte offset into a rune offset
+	at = min(len([]rune(string(lower)[:at])), len(runes))
+
+	start, end := max(at-snippetBefore, 0), min(at+len([]rune(term))+snippetAfter, len(runes))
+
+	result := string(runes[start:end])
+	if start > 0 {
+		result = ellipsis + result
+	}
+	if end < len(runes) {
+		result += ellipsis
+	}
+
+	return result
+}
+
+// sortResults orders results newest first and cuts them to limit.
+func sortResults(results []SearchResult, limit int) []SearchResult {
+	sort.SliceStable(results, func(i, j int) bool {
+		if !results[i].Timestamp.Equal(results[j].Timestamp) {
+			return results[i].Timestamp.After(results[j].Timestamp)
+		}
+		return results[i].Thread < results[j].Thread
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
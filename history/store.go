@@ -2,14 +2,24 @@ package history
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/kardolus/chatgpt-cli/config"
 	"github.com/kardolus/chatgpt-cli/internal"
 	"os"
 	"path"
 	"path/filepath"
+	"sync"
 )
 
-const jsonExtension = ".json"
+const (
+	jsonExtension     = internal.ThreadExtension
+	backupExtension   = internal.BackupExtension
+	corruptExtension  = internal.CorruptExtension
+	FileBackend       = "file"
+	DatabaseBackend   = "database"
+	errUnknownBackend = "unknown history backend %q, expected %s or %s"
+)
 
 type Store interface {
 	Read() ([]History, error)
@@ -25,6 +35,12 @@ var _ Store = &FileIO{}
 type FileIO struct {
 	historyDir string
 	thread     string
+	// cipher encrypts the files of the store, which are plaintext when it is nil
+	cipher *Cipher
+	mu     sync.Mutex
+	// snapshots holds the version of each thread this store last read or wrote,
+	// which tells the writes of other processes apart from its own.
+	snapshots map[string][]History
 }
 
 func New() (*FileIO, error) {
@@ -52,6 +68,74 @@ func New() (*FileIO, error) {
 	}, err
 }
 
+// NewStore returns the store selected by the history_backend config key.
+func NewStore(backend string) (Store, error) {
+	files, err := New()
+	if files == nil {
+		return nil, err
+	}
+
+	switch backend {
+	case "", FileBackend:
+		return files, err
+	case DatabaseBackend:
+		if err != nil {
+			return nil, err
+		}
+		return OpenDatabase(files)
+	default:
+		return nil, fmt.Errorf(errUnknownBackend, backend, FileBackend, DatabaseBackend)
+	}
+}
+
+// OpenDatabase opens the history database in the config home. Until the
+// threads of files were imported into it completely, the ones it does not hold
+// yet are imported, so an import that failed halfway is resumed.
+func OpenDatabase(files *FileIO) (*DBStore, error) {
+	configHome, err := internal.GetConfigHome()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := NewDB(filepath.Join(configHome, DatabaseFile))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := importOnce(db, files); err != nil {
+		return nil, fmt.Errorf("failed to import the thread files: %w", err)
+	}
+
+	return db, nil
+}
+
+// importOnce imports the thread files into db unless they were imported before.
+// Threads that db holds already are kept, since they may have new turns.
+func importOnce(db *DBStore, files *FileIO) error {
+	imported, err := db.Imported()
+	if err != nil || imported {
+		return err
+	}
+
+	if _, err := files.Threads(); err != nil {
+		// there is nothing to import
+		return nil
+	}
+
+	threads, err := db.Threads()
+	if err != nil {
+		return err
+	}
+
+	skip := make(map[string]bool, len(threads))
+	for _, thread := range threads {
+		skip[thread] = true
+	}
+
+	_, err = db.importFiles(files, skip)
+	return err
+}
+
 func (f *FileIO) GetThread() string {
 	return f.thread
 }
@@ -65,21 +149,125 @@ func (f *FileIO) WithDirectory(historyDir string) *FileIO {
 	return f
 }
 
+// WithCipher encrypts the files the store writes with c. Plaintext files can
+// still be read, so encryption can be turned on for existing threads.
+func (f *FileIO) WithCipher(c *Cipher) *FileIO {
+	f.cipher = c
+	return f
+}
+
 func (f *FileIO) Read() ([]History, error) {
-	return parseFile(f.getPath(f.thread))
+	return f.ReadThread(f.thread)
 }
 
+// ReadThread reads a thread. When the thread file is corrupt, the backup that
+// was made by the previous write is returned instead.
 func (f *FileIO) ReadThread(thread string) ([]History, error) {
-	return parseFile(f.getPath(thread))
+	result, _, err := readWithBackup(f.cipher, f.getPath(thread))
+	if errors.Is(err, os.ErrNotExist) {
+		f.setSnapshot(thread, []History{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f.setSnapshot(thread, result)
+	return result, nil
 }
 
+// Write stores historyEntries as the current thread. The thread file is locked
+// while it is rewritten, so concurrent processes cannot overwrite each other's
+// turns: messages that were added by another process since this store read the
+// thread are kept. The file is replaced atomically and the previous version is
+// kept as a backup.
 func (f *FileIO) Write(historyEntries []History) error {
-	data, err := json.Marshal(historyEntries)
+	path := f.getPath(f.thread)
+
+	lock, err := acquireLock(path + lockExtension)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	stored, fromFile, err := readWithBackup(f.cipher, path)
+	switch {
+	case err == nil && fromFile:
+		if err := backup(path, f.fileMode()); err != nil {
+			return err
+		}
+	case errors.Is(err, ErrKey):
+		// the thread is fine, it just cannot be read with this key
+		return err
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		// neither the thread nor its backup can be read, keep it for inspection
+		if err := os.Rename(path, path+corruptExtension); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		stored = nil
+	}
+
+	merged := historyEntries
+	if snapshot, ok := f.snapshot(f.thread); ok {
+		merged = merge(snapshot, stored, historyEntries)
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+
+	if err := f.writeFile(path, data); err != nil {
+		return err
+	}
+
+	f.setSnapshot(f.thread, historyEntries)
+	return nil
+}
+
+func (f *FileIO) snapshot(thread string) ([]History, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result, ok := f.snapshots[thread]
+	return result, ok
+}
+
+func (f *FileIO) setSnapshot(thread string, entries []History) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.snapshots == nil {
+		f.snapshots = make(map[string][]History)
+	}
+	f.snapshots[thread] = entries
+}
+
+// writeFile atomically writes data to path, encrypted when the store has a
+// cipher. Encrypted files are only readable by their owner.
+func (f *FileIO) writeFile(path string, data []byte) error {
+	data, err := encode(f.cipher, data)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(f.getPath(f.thread), data, 0644)
+	return writeAtomic(path, data, f.fileMode())
+}
+
+// readFile reads the file at path, decrypting it when it is encrypted.
+func (f *FileIO) readFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return decode(f.cipher, path, data)
+}
+
+func (f *FileIO) fileMode() os.FileMode {
+	if f.cipher != nil {
+		return 0600
+	}
+	return 0644
 }
 
 func (f *FileIO) getPath(thread string) string {
@@ -125,7 +313,80 @@ func migrate() error {
 	return nil
 }
 
-func parseFile(fileName string) ([]History, error) {
+// merge combines the thread this process wants to store with the stored thread.
+// snapshot is the version this process last saw. Messages this process appended
+// are added after the ones another process appended in the meantime. When this
+// process rewrote the thread, for example while truncating it, its version wins
+// and only the messages appended by the other process are kept.
+func merge(snapshot, stored, ours []History) []History {
+	if commonPrefix(snapshot, ours) == len(snapshot) {
+		result := append([]History(nil), stored...)
+		return append(result, ours[len(snapshot):]...)
+	}
+
+	if len(stored) > len(snapshot) && commonPrefix(snapshot, stored) == len(snapshot) {
+		result := append([]History(nil), ours...)
+		return append(result, stored[len(snapshot):]...)
+	}
+
+	return ours
+}
+
+// readWithBackup reads the thread file at path, or its backup when the file is
+// corrupt. fromFile reports whether the thread file itself was read. A file
+// that cannot be decrypted is not corrupt, so its backup is not read.
+func readWithBackup(c *Cipher, path string) (result []History, fromFile bool, err error) {
+	result, err = parseFile(c, path)
+	if err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrKey) {
+		return result, err == nil, err
+	}
+
+	result, backupErr := parseFile(c, path+backupExtension)
+	if backupErr != nil {
+		return nil, false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return result, false, nil
+}
+
+// backup copies the thread file at path to its backup.
+func backup(path string, mode os.FileMode) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return writeAtomic(path+backupExtension, data, mode)
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it over
+// path, so a crash leaves either the old or the new file but never a partial one.
+func writeAtomic(path string, data []byte, mode os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), mode); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func parseFile(c *Cipher, fileName string) ([]History, error) {
 	var result []History
 
 	buf, err := os.ReadFile(fileName)
@@ -133,6 +394,10 @@ func parseFile(fileName string) ([]History, error) {
 		return nil, err
 	}
 
+	if buf, err = decode(c, fileName, buf); err != nil {
+		return nil, err
+	}
+
 	if err := json.Unmarshal(buf, &result); err != nil {
 		return nil, err
 	}
@@ -1,10 +1,15 @@
 package history_test
 
 import (
+	"fmt"
+	"github.com/kardolus/chatgpt-cli/api"
 	"github.com/kardolus/chatgpt-cli/history"
 	. "github.com/onsi/gomega"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 )
 
@@ -27,4 +32,162 @@ func testStore(t *testing.T, when spec.G, it spec.S) {
 			Expect(subject.GetThread()).To(Equal(thread))
 		})
 	})
+
+	when("writing and reading thread files", func() {
+		const thread = "thread"
+
+		var (
+			dir      string
+			filePath string
+		)
+
+		entry := func(role, content string) history.History {
+			return history.History{Message: api.Message{Role: role, Content: content}}
+		}
+
+		newStore := func() *history.FileIO {
+			result := (&history.FileIO{}).WithDirectory(dir)
+			result.SetThread(thread)
+			return result
+		}
+
+		it.Before(func() {
+			dir = t.TempDir()
+			filePath = filepath.Join(dir, thread+".json")
+		})
+
+		it("writes the thread without leaving temporary files", func() {
+			store := newStore()
+			entries := []history.History{entry("user", "hello")}
+
+			Expect(store.Write(entries)).To(Succeed())
+			Expect(store.Write(append(entries, entry("assistant", "hi")))).To(Succeed())
+
+			Expect(newStore().Read()).To(HaveLen(2))
+
+			matches, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(matches).To(BeEmpty())
+		})
+
+		it("creates the history directory on the first write", func() {
+			dir = filepath.Join(dir, "missing", "history")
+
+			Expect(newStore().Write([]history.History{entry("user", "hello")})).To(Succeed())
+			Expect(newStore().Read()).To(HaveLen(1))
+		})
+
+		it("keeps the turns another process appended since the thread was read", func() {
+			Expect(newStore().Write([]history.History{entry("system", "be brief")})).To(Succeed())
+
+			first, second := newStore(), newStore()
+			firstHistory, err := first.Read()
+			Expect(err).NotTo(HaveOccurred())
+			secondHistory, err := second.Read()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(first.Write(append(firstHistory, entry("user", "first")))).To(Succeed())
+			Expect(second.Write(append(secondHistory, entry("user", "second")))).To(Succeed())
+
+			result, err := newStore().Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]history.History{entry("system", "be brief"), entry("user", "first"), entry("user", "second")}))
+
+			// the next turn of the first process does not drop the second turn
+			Expect(first.Write(append(firstHistory, entry("user", "first"), entry("assistant", "answer")))).To(Succeed())
+
+			result, err = newStore().Read()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(HaveLen(4))
+			Expect(result[2]).To(Equal(entry("user", "second")))
+			Expect(result[3]).To(Equal(entry("assistant", "answer")))
+		})
+
+		it("keeps appended turns when the thread was rewritten", func() {
+			Expect(newStore().Write([]history.History{entry("system", "be brief"), entry("user", "old")})).To(Succeed())
+
+			first, second := newStore(), newStore()
+			_, err := first.Read()
+			Expect(err).NotTo(HaveOccurred())
+			secondHistory, err := second.Read()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(second.Write(append(secondHistory, entry("user", "new")))).To(Succeed())
+			Expect(first.Write([]history.History{entry("system", "be brief")})).To(Succeed())
+
+			Expect(newStore().Read()).To(Equal([]history.History{entry("system", "be brief"), entry("user", "new")}))
+		})
+
+		it("does not lose turns of concurrent writers", func() {
+			const writers = 8
+
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+
+					store := newStore()
+					entries, _ := store.Read()
+					Expect(store.Write(append(entries, entry("user", fmt.Sprintf("turn %d", i))))).To(Succeed())
+				}(i)
+			}
+			wg.Wait()
+
+			Expect(newStore().Read()).To(HaveLen(writers))
+		})
+
+		it("does not lose metadata changes of concurrent writers", func() {
+			const writers = 8
+
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+
+					_, err := newStore().UpdateMetadata(thread, func(metadata *history.Metadata) {
+						metadata.Tokens++
+						metadata.Tags = append(metadata.Tags, fmt.Sprintf("tag%d", i))
+					})
+					Expect(err).NotTo(HaveOccurred())
+				}(i)
+			}
+			wg.Wait()
+
+			metadata, err := newStore().ReadMetadata(thread)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata.Tokens).To(Equal(writers))
+			Expect(metadata.Tags).To(HaveLen(writers))
+		})
+
+		it("falls back to the backup when the thread is corrupt", func() {
+			store := newStore()
+			Expect(store.Write([]history.History{entry("user", "hello")})).To(Succeed())
+			Expect(store.Write([]history.History{entry("user", "hello"), entry("assistant", "hi")})).To(Succeed())
+
+			Expect(os.WriteFile(filePath, []byte(`[{"role":"user","cont`), 0644)).To(Succeed())
+
+			Expect(newStore().Read()).To(Equal([]history.History{entry("user", "hello")}))
+		})
+
+		it("returns an error when neither the thread nor its backup can be read", func() {
+			Expect(os.WriteFile(filePath, []byte("not json"), 0644)).To(Succeed())
+
+			_, err := newStore().Read()
+			Expect(err).To(HaveOccurred())
+			Expect(os.IsNotExist(err)).To(BeFalse())
+		})
+
+		it("keeps a corrupt thread aside when it is overwritten", func() {
+			Expect(os.WriteFile(filePath, []byte("not json"), 0644)).To(Succeed())
+
+			Expect(newStore().Write([]history.History{entry("user", "hello")})).To(Succeed())
+
+			data, err := os.ReadFile(filePath + ".corrupt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("not json"))
+			Expect(newStore().Read()).To(HaveLen(1))
+		})
+	})
 }
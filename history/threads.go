@@ -0,0 +1,293 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kardolus/chatgpt-cli/api"
+)
+
+const (
+	SortName        = "name"
+	SortUpdated     = "updated"
+	SortCreated     = "created"
+	SortTokens      = "tokens"
+	SortMessages    = "messages"
+	errUnknownSort  = "unknown sort order %q, expected name, updated, created, tokens or messages"
+	errInvalidAge   = "invalid age %q: expected a duration like 90m, 36h, 7d or 2w"
+	errNoMetadata   = "the history store does not keep metadata"
+	errNoThreadList = "the history store cannot list its threads"
+	tagSeparator    = ","
+	hoursPerDay     = 24
+	daysPerWeek     = 7
+	daySuffix       = "d"
+	weekSuffix      = "w"
+	maxTitleRunes   = 80
+	titlePrefix     = "Title:"
+	titleTrimCutset = " \t\"'`*.#"
+)
+
+// ThreadLister is implemented by stores that can list their threads.
+type ThreadLister interface {
+	Threads() ([]string, error)
+}
+
+// Ensure FileIO and DBStore implement the ThreadLister interface
+var (
+	_ ThreadLister = &FileIO{}
+	_ ThreadLister = &DBStore{}
+)
+
+// ThreadInfo is a thread with its metadata, as shown by --list-threads.
+type ThreadInfo struct {
+	Name    string
+	Current bool
+	Metadata
+}
+
+// ThreadFilter selects the threads returned by ListThreads. The zero value
+// selects every thread.
+type ThreadFilter struct {
+	// Tag selects the threads with this tag.
+	Tag string
+	// Since selects the threads that were updated after this time.
+	Since time.Time
+}
+
+// ListThreads returns the threads of the store that match filter, ordered by
+// sortBy. Names sort alphabetically, the other orders put the newest or largest
+// thread first. Threads written before metadata was kept get their message
+// count and times from their messages.
+func (h *Manager) ListThreads(filter ThreadFilter, sortBy string) ([]ThreadInfo, error) {
+	switch sortBy {
+	case SortName, SortUpdated, SortCreated, SortTokens, SortMessages:
+	default:
+		return nil, fmt.Errorf(errUnknownSort, sortBy)
+	}
+
+	lister, ok := h.store.(ThreadLister)
+	if !ok {
+		return nil, errors.New(errNoThreadList)
+	}
+
+	threads, err := lister.Threads()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ThreadInfo
+	for _, thread := range threads {
+		info, err := h.threadInfo(thread)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Tag != "" && !hasTag(info.Tags, filter.Tag) {
+			continue
+		}
+		if !filter.Since.IsZero() && !info.Updated.After(filter.Since) {
+			continue
+		}
+		result = append(result, info)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		switch {
+		case sortBy == SortUpdated && !a.Updated.Equal(b.Updated):
+			return a.Updated.After(b.Updated)
+		case sortBy == SortCreated && !a.Created.Equal(b.Created):
+			return a.Created.After(b.Created)
+		case sortBy == SortTokens && a.Tokens != b.Tokens:
+			return a.Tokens > b.Tokens
+		case sortBy == SortMessages && a.Messages != b.Messages:
+			return a.Messages > b.Messages
+		}
+		return a.Name < b.Name
+	})
+
+	return result, nil
+}
+
+// SetTitle replaces the title of thread.
+func (h *Manager) SetTitle(thread, title string) error {
+	return h.updateMetadata(thread, func(metadata *Metadata) {
+		metadata.Title = CleanTitle(title)
+	})
+}
+
+// SetTags replaces the tags of thread. Tags are trimmed, lowercased and
+// deduplicated, and an empty list removes all of them.
+func (h *Manager) SetTags(thread string, tags []string) error {
+	return h.updateMetadata(thread, func(metadata *Metadata) {
+		metadata.Tags = normalizeTags(tags)
+	})
+}
+
+// SetRole replaces the system role of thread. A thread keeps the role it was
+// created with, so this is the way to change it.
+func (h *Manager) SetRole(thread, role string) error {
+	entries, err := h.store.ReadThread(thread)
+	if err != nil {
+		return err
+	}
+
+	system := History{Message: api.Message{Role: systemRole, Content: role}}
+	if len(entries) > 0 && entries[0].Role == systemRole {
+		// entries is the snapshot of the store, so it is not changed in place
+		system.Timestamp = entries[0].Timestamp
+		entries = entries[1:]
+	}
+	entries = append([]History{system}, entries...)
+
+	current := h.store.GetThread()
+	defer h.store.SetThread(current)
+
+	h.store.SetThread(thread)
+	if err := h.store.Write(entries); err != nil {
+		return err
+	}
+
+	if _, ok := h.store.(MetadataStore); !ok {
+		return nil
+	}
+
+	return h.updateMetadata(thread, func(metadata *Metadata) {
+		metadata.Role = role
+	})
+}
+
+func (h *Manager) updateMetadata(thread string, update func(*Metadata)) error {
+	if _, ok := h.store.(MetadataStore); !ok {
+		return errors.New(errNoMetadata)
+	}
+
+	if _, err := h.store.ReadThread(thread); err != nil {
+		return err
+	}
+
+	return h.writeMetadata(thread, update)
+}
+
+// writeMetadata applies update to the metadata of thread, which does not have
+// to exist yet.
+func (h *Manager) writeMetadata(thread string, update func(*Metadata)) error {
+	store, ok := h.store.(MetadataStore)
+	if !ok {
+		return errors.New(errNoMetadata)
+	}
+
+	_, err := store.UpdateMetadata(thread, update)
+	return err
+}
+
+func (h *Manager) threadInfo(thread string) (ThreadInfo, error) {
+	info := ThreadInfo{Name: thread, Current: thread == h.store.GetThread()}
+
+	if store, ok := h.store.(MetadataStore); ok {
+		metadata, err := store.ReadMetadata(thread)
+		if err != nil {
+			return info, err
+		}
+		info.Metadata = metadata
+	}
+
+	if info.Messages > 0 {
+		return info, nil
+	}
+
+	historyEntries, err := h.store.ReadThread(thread)
+	if err != nil {
+		// an unreadable thread is still listed, so it can be deleted
+		return info, nil
+	}
+
+	info.Messages = len(historyEntries)
+	for _, entry := range historyEntries {
+		if entry.Timestamp.IsZero() {
+			continue
+		}
+		if info.Created.IsZero() || entry.Timestamp.Before(info.Created) {
+			info.Created = entry.Timestamp
+		}
+		if entry.Timestamp.After(info.Updated) {
+			info.Updated = entry.Timestamp
+		}
+	}
+
+	return info, nil
+}
+
+// ParseTags splits a comma separated list of tags.
+func ParseTags(tags string) []string {
+	return normalizeTags(strings.Split(tags, tagSeparator))
+}
+
+// ParseAge parses an age like "90m", "36h", "7d" or "2w".
+func ParseAge(age string) (time.Duration, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(age, daySuffix):
+		unit = hoursPerDay * time.Hour
+	case strings.HasSuffix(age, weekSuffix):
+		unit = daysPerWeek * hoursPerDay * time.Hour
+	default:
+		duration, err := time.ParseDuration(age)
+		if err != nil || duration <= 0 {
+			return 0, fmt.Errorf(errInvalidAge, age)
+		}
+		return duration, nil
+	}
+
+	n, err := strconv.Atoi(age[:len(age)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf(errInvalidAge, age)
+	}
+
+	return time.Duration(n) * unit, nil
+}
+
+// CleanTitle turns an answer of the model, or a title typed by the user, into a
+// single line title without quotes or markdown.
+func CleanTitle(title string) string {
+	title, _, _ = strings.Cut(strings.TrimSpace(title), "\n")
+	title = strings.TrimPrefix(title, titlePrefix)
+	title = strings.Trim(title, titleTrimCutset)
+
+	if runes := []rune(title); len(runes) > maxTitleRunes {
+		title = strings.TrimSpace(string(runes[:maxTitleRunes-1])) + ellipsis
+	}
+
+	return title
+}
+
+func normalizeTags(tags []string) []string {
+	var (
+		result []string
+		seen   = make(map[string]bool)
+	)
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+
+	return result
+}
+
+func hasTag(tags []string, tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
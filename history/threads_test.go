@@ -0,0 +1,226 @@
+package history_test
+
+import (
+	"github.com/kardolus/chatgpt-cli/api"
+	"github.com/kardolus/chatgpt-cli/history"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnitThreads(t *testing.T) {
+	spec.Run(t, "Testing the thread list", testThreads, spec.Report(report.Terminal{}))
+}
+
+func testThreads(t *testing.T, when spec.G, it spec.S) {
+	var (
+		dir     string
+		store   *history.FileIO
+		manager *history.Manager
+		now     time.Time
+	)
+
+	write := func(thread string, count int, metadata history.Metadata) {
+		var entries []history.History
+		for i := 0; i < count; i++ {
+			entries = append(entries, history.History{
+				Message:   api.Message{Role: "user", Content: "message"},
+				Timestamp: now.Add(-time.Duration(count-i) * time.Hour),
+			})
+		}
+
+		store.SetThread(thread)
+		Expect(store.Write(entries)).To(Succeed())
+		if metadata.Messages > 0 || metadata.Title != "" || metadata.Tags != nil {
+			Expect(store.WriteMetadata(thread, metadata)).To(Succeed())
+		}
+	}
+
+	names := func(threads []history.ThreadInfo) []string {
+		var result []string
+		for _, thread := range threads {
+			result = append(result, thread.Name)
+		}
+		return result
+	}
+
+	it.Before(func() {
+		RegisterTestingT(t)
+
+		dir = t.TempDir()
+		store = (&history.FileIO{}).WithDirectory(dir)
+		manager = history.NewHistory(store)
+		now = time.Now().Truncate(time.Second)
+
+		write("alpha", 2, history.Metadata{
+			Title:    "Fixing the build",
+			Tags:     []string{"work", "go"},
+			Created:  now.Add(-72 * time.Hour),
+			Updated:  now.Add(-48 * time.Hour),
+			Model:    "gpt-4o",
+			Messages: 6,
+			Tokens:   900,
+		})
+		write("beta", 3, history.Metadata{
+			Title:    "Holiday plans",
+			Tags:     []string{"personal"},
+			Created:  now.Add(-10 * time.Hour),
+			Updated:  now.Add(-time.Hour),
+			Model:    "gpt-4o-mini",
+			Messages: 4,
+			Tokens:   300,
+		})
+		write("legacy", 5, history.Metadata{})
+
+		store.SetThread("beta")
+	})
+
+	when("ListThreads()", func() {
+		it("lists every thread with its metadata, sorted by name", func() {
+			threads, err := manager.ListThreads(history.ThreadFilter{}, history.SortName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(threads)).To(Equal([]string{"alpha", "beta", "legacy"}))
+
+			Expect(threads[0].Title).To(Equal("Fixing the build"))
+			Expect(threads[0].Tags).To(Equal([]string{"work", "go"}))
+			Expect(threads[0].Tokens).To(Equal(900))
+			Expect(threads[0].Current).To(BeFalse())
+			Expect(threads[1].Current).To(BeTrue())
+		})
+
+		it("falls back to the messages of threads without metadata", func() {
+			threads, err := manager.ListThreads(history.ThreadFilter{}, history.SortName)
+			Expect(err).NotTo(HaveOccurred())
+
+			legacy := threads[2]
+			Expect(legacy.Messages).To(Equal(5))
+			Expect(legacy.Created.Equal(now.Add(-5 * time.Hour))).To(BeTrue())
+			Expect(legacy.Updated.Equal(now.Add(-time.Hour))).To(BeTrue())
+			Expect(legacy.Title).To(BeEmpty())
+		})
+
+		it("still lists threads that cannot be read", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "broken.json"), nil, 0644)).To(Succeed())
+
+			threads, err := manager.ListThreads(history.ThreadFilter{}, history.SortName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(threads)).To(ContainElement("broken"))
+		})
+
+		it("sorts by the other orders, newest or largest first", func() {
+			threads, err := manager.ListThreads(history.ThreadFilter{}, history.SortTokens)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(threads)).To(Equal([]string{"alpha", "beta", "legacy"}))
+
+			threads, err = manager.ListThreads(history.ThreadFilter{}, history.SortMessages)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(threads)).To(Equal([]string{"alpha", "legacy", "beta"}))
+
+			threads, err = manager.ListThreads(history.ThreadFilter{}, history.SortCreated)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(threads)).To(Equal([]string{"legacy", "beta", "alpha"}))
+
+			threads, err = manager.ListThreads(history.ThreadFilter{}, history.SortUpdated)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(threads)).To(Equal([]string{"beta", "legacy", "alpha"}))
+		})
+
+		it("filters by tag and age", func() {
+			threads, err := manager.ListThreads(history.ThreadFilter{Tag: "Work"}, history.SortName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(threads)).To(Equal([]string{"alpha"}))
+
+			threads, err = manager.ListThreads(history.ThreadFilter{Since: now.Add(-24 * time.Hour)}, history.SortName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(names(threads)).To(Equal([]string{"beta", "legacy"}))
+		})
+
+		it("rejects an unknown sort order", func() {
+			_, err := manager.ListThreads(history.ThreadFilter{}, "size")
+			Expect(err).To(MatchError(ContainSubstring("unknown sort order")))
+		})
+	})
+
+	when("SetTitle() and SetTags()", func() {
+		it("updates the metadata of an existing thread", func() {
+			Expect(manager.SetTitle("legacy", `  "Old notes."  `)).To(Succeed())
+			Expect(manager.SetTags("legacy", []string{" Notes", "notes", "", "archive"})).To(Succeed())
+
+			metadata, err := store.ReadMetadata("legacy")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata.Title).To(Equal("Old notes"))
+			Expect(metadata.Tags).To(Equal([]string{"notes", "archive"}))
+
+			Expect(manager.SetTags("legacy", history.ParseTags(""))).To(Succeed())
+			metadata, err = store.ReadMetadata("legacy")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata.Tags).To(BeEmpty())
+			Expect(metadata.Title).To(Equal("Old notes"))
+		})
+
+		it("fails for a thread that does not exist", func() {
+			err := manager.SetTitle("missing", "title")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	when("SetRole()", func() {
+		it("replaces the system role of a thread and records it", func() {
+			store.SetThread("persona")
+			Expect(store.Write([]history.History{
+				{Message: api.Message{Role: "system", Content: "You are a pirate."}},
+				{Message: api.Message{Role: "user", Content: "ahoy"}},
+			})).To(Succeed())
+
+			Expect(manager.SetRole("persona", "You are a lawyer.")).To(Succeed())
+
+			entries, err := store.ReadThread("persona")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Content).To(Equal("You are a lawyer."))
+			Expect(entries[1].Content).To(Equal("ahoy"))
+
+			metadata, err := store.ReadMetadata("persona")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(metadata.Role).To(Equal("You are a lawyer."))
+		})
+
+		it("adds a system role to a thread without one", func() {
+			store.SetThread("other")
+			Expect(manager.SetRole("legacy", "Be brief.")).To(Succeed())
+			Expect(store.GetThread()).To(Equal("other"))
+
+			entries, err := store.ReadThread("legacy")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries[0].Message).To(Equal(api.Message{Role: "system", Content: "Be brief."}))
+			Expect(entries[1].Content).To(Equal("message"))
+		})
+	})
+
+	when("ParseAge()", func() {
+		it("parses durations, days and weeks", func() {
+			Expect(history.ParseAge("90m")).To(Equal(90 * time.Minute))
+			Expect(history.ParseAge("36h")).To(Equal(36 * time.Hour))
+			Expect(history.ParseAge("7d")).To(Equal(7 * 24 * time.Hour))
+			Expect(history.ParseAge("2w")).To(Equal(14 * 24 * time.Hour))
+		})
+
+		it("rejects invalid ages", func() {
+			for _, age := range []string{"", "d", "-3d", "xw", "soon", "0h"} {
+				_, err := history.ParseAge(age)
+				Expect(err).To(MatchError(ContainSubstring("invalid age")), age)
+			}
+		})
+	})
+
+	when("CleanTitle()", func() {
+		it("keeps a single line without quotes or markdown", func() {
+			Expect(history.CleanTitle("Title: **Go build fixes**\nextra")).To(Equal("Go build fixes"))
+			Expect(history.CleanTitle("\"Trip to Rome.\"")).To(Equal("Trip to Rome"))
+		})
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/google/uuid"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
@@ -12,6 +13,11 @@ const (
 	DefaultConfigDir  = ".chatgpt-cli"
 	DefaultDataDir    = "history"
 	SlugPostfixLength = 4
+	ThreadExtension   = ".json"
+	BackupExtension   = ".bak"
+	CorruptExtension  = ".corrupt"
+	MetadataExtension = ".meta"
+	LockExtension     = ".lock"
 )
 
 func GenerateUniqueSlug(prefix string) string {
@@ -19,6 +25,18 @@ func GenerateUniqueSlug(prefix string) string {
 	return prefix + guid.String()[:SlugPostfixLength]
 }
 
+// ThreadSidecars returns the files that are kept next to the thread file at
+// path: its backup, its corrupt copy, its metadata and its lock. The lock comes
+// last, so it outlives everything it guards when they are deleted in order.
+func ThreadSidecars(path string) []string {
+	return []string{
+		path + BackupExtension,
+		path + CorruptExtension,
+		strings.TrimSuffix(path, ThreadExtension) + MetadataExtension,
+		path + LockExtension,
+	}
+}
+
 func GetConfigHome() (string, error) {
 	var result string
 
@@ -65,4 +65,15 @@ func testUtils(t *testing.T, when spec.G, it spec.S) {
 			Expect(result).To(HaveLen(len(prefix) + internal.SlugPostfixLength))
 		})
 	})
+
+	when("ThreadSidecars()", func() {
+		it("Lists the files next to a thread with the lock last", func() {
+			Expect(internal.ThreadSidecars("/history/work.json")).To(Equal([]string{
+				"/history/work.json.bak",
+				"/history/work.json.corrupt",
+				"/history/work.meta",
+				"/history/work.json.lock",
+			}))
+		})
+	})
 }
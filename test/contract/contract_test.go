@@ -2,6 +2,7 @@ package contract_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/kardolus/chatgpt-cli/api"
 	"github.com/kardolus/chatgpt-cli/api/client"
@@ -55,7 +56,7 @@ func testContract(t *testing.T, when spec.G, it spec.S) {
 			bytes, err := json.Marshal(body)
 			Expect(err).NotTo(HaveOccurred())
 
-			resp, err := restCaller.Post(cfg.URL+cfg.CompletionsPath, bytes, false)
+			resp, err := restCaller.Post(context.Background(), cfg.URL+cfg.CompletionsPath, bytes)
 			Expect(err).NotTo(HaveOccurred())
 
 			var data api.CompletionsResponse
@@ -83,7 +84,7 @@ func testContract(t *testing.T, when spec.G, it spec.S) {
 			bytes, err := json.Marshal(body)
 			Expect(err).NotTo(HaveOccurred())
 
-			resp, err := restCaller.Post(cfg.URL+cfg.CompletionsPath, bytes, false)
+			resp, err := restCaller.Post(context.Background(), cfg.URL+cfg.CompletionsPath, bytes)
 			Expect(err).To(HaveOccurred())
 
 			var errorData api.ErrorResponse
@@ -98,7 +99,7 @@ func testContract(t *testing.T, when spec.G, it spec.S) {
 
 	when("accessing the models endpoint", func() {
 		it("should have the expected keys in the response", func() {
-			resp, err := restCaller.Get(cfg.URL + cfg.ModelsPath)
+			resp, err := restCaller.Get(context.Background(), cfg.URL+cfg.ModelsPath)
 			Expect(err).NotTo(HaveOccurred())
 
 			var data api.ListModelsResponse
@@ -122,7 +123,7 @@ func testContract(t *testing.T, when spec.G, it spec.S) {
 		it("should return a successful response with expected keys and content", func() {
 			body := api.ResponsesRequest{
 				Model: "o1-pro",
-				Input: []api.Message{{
+				Input: []interface{}{api.Message{
 					Role:    client.UserRole,
 					Content: "what is the capital of sweden",
 				}},
@@ -135,7 +136,7 @@ func testContract(t *testing.T, when spec.G, it spec.S) {
 			bytes, err := json.Marshal(body)
 			Expect(err).NotTo(HaveOccurred())
 
-			resp, err := restCaller.Post(cfg.URL+cfg.ResponsesPath, bytes, false)
+			resp, err := restCaller.Post(context.Background(), cfg.URL+cfg.ResponsesPath, bytes)
 			Expect(err).NotTo(HaveOccurred())
 
 			var data api.ResponsesResponse
@@ -182,7 +183,7 @@ func testContract(t *testing.T, when spec.G, it spec.S) {
 			bytes, err := json.Marshal(body)
 			Expect(err).NotTo(HaveOccurred())
 
-			resp, err := restCaller.Post(cfg.URL+cfg.SpeechPath, bytes, false)
+			resp, err := restCaller.Post(context.Background(), cfg.URL+cfg.SpeechPath, bytes)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(resp).NotTo(BeEmpty())
 
@@ -223,7 +224,7 @@ func testContract(t *testing.T, when spec.G, it spec.S) {
 			err = writer.Close()
 			Expect(err).NotTo(HaveOccurred())
 
-			resp, err := restCaller.PostWithHeaders(cfg.URL+cfg.TranscriptionsPath, buf.Bytes(), map[string]string{
+			resp, err := restCaller.PostWithHeaders(context.Background(), cfg.URL+cfg.TranscriptionsPath, buf.Bytes(), map[string]string{
 				"Content-Type":  writer.FormDataContentType(),
 				"Authorization": "Bearer " + cfg.APIKey,
 			})
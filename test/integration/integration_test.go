@@ -1,7 +1,10 @@
 package integration_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/kardolus/chatgpt-cli/api"
 	"github.com/kardolus/chatgpt-cli/config"
@@ -206,8 +209,23 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 			Expect(result[2]).To(Equal("thread3.json"))
 		})
 
+		it("skips the backup and lock files when listing the threads", func() {
+			files := []string{"thread1.json", "thread1.json.bak", "thread1.json.lock"}
+
+			for _, file := range files {
+				file, err := os.Create(filepath.Join(historyDir, file))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(file.Close()).To(Succeed())
+			}
+
+			result, err := configIO.List()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal([]string{"thread1.json"}))
+		})
+
 		it("deletes the thread", func() {
-			files := []string{"thread1.json", "thread2.json", "thread3.json"}
+			files := []string{"thread1.json", "thread2.json", "thread2.json.bak", "thread2.meta", "thread3.json"}
 
 			for _, file := range files {
 				file, err := os.Create(filepath.Join(historyDir, file))
@@ -222,9 +240,46 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 			_, err = os.Stat(filepath.Join(historyDir, "thread2.json"))
 			Expect(os.IsNotExist(err)).To(BeTrue())
 
+			_, err = os.Stat(filepath.Join(historyDir, "thread2.json.bak"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			_, err = os.Stat(filepath.Join(historyDir, "thread2.meta"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
 			_, err = os.Stat(filepath.Join(historyDir, "thread3.json"))
 			Expect(os.IsNotExist(err)).To(BeFalse())
 		})
+
+		it("lists the files Delete would remove", func() {
+			files := []string{"int_1.json", "int_1.meta", "int_1.json.lock", "int_2.json", "int_2.json.bak", "int_2.json.corrupt", "cmd_1.json"}
+
+			for _, file := range files {
+				file, err := os.Create(filepath.Join(historyDir, file))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(file.Close()).To(Succeed())
+			}
+
+			matches, err := configIO.Matches("int_*")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(matches).To(Equal([]string{
+				filepath.Join(historyDir, "int_1.json"),
+				filepath.Join(historyDir, "int_1.meta"),
+				filepath.Join(historyDir, "int_1.json.lock"),
+				filepath.Join(historyDir, "int_2.json"),
+				filepath.Join(historyDir, "int_2.json.bak"),
+				filepath.Join(historyDir, "int_2.json.corrupt"),
+			}))
+
+			for _, file := range files {
+				_, err = os.Stat(filepath.Join(historyDir, file))
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			_, err = configIO.Matches("missing")
+			var fileNotFoundError *config.FileNotFoundError
+			Expect(errors.As(err, &fileNotFoundError)).To(BeTrue())
+		})
 	})
 
 	when("Performing the Lifecycle", func() {
@@ -780,6 +835,174 @@ func testIntegration(t *testing.T, when spec.G, it spec.S) {
 				Expect(output).To(ContainSubstring("- thread3"))
 			})
 
+			it("should filter the --list-threads output by the tags set with --thread-tags", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.Mkdir(historyDir, 0755)).To(Succeed())
+
+				for _, file := range []string{"thread1.json", "thread2.json"} {
+					Expect(os.WriteFile(filepath.Join(historyDir, file), []byte("[]"), 0644)).To(Succeed())
+				}
+
+				runCommand("--thread", "thread1", "--thread-title", "Release checklist", "--thread-tags", "work,release")
+
+				output := runCommand("--list-threads", "--tag", "work")
+
+				Expect(output).To(ContainSubstring("- thread1"))
+				Expect(output).To(ContainSubstring("Release checklist"))
+				Expect(output).To(ContainSubstring("work,release"))
+				Expect(output).NotTo(ContainSubstring("thread2"))
+			})
+
+			it("should change the role of a thread with --thread-role", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.Mkdir(historyDir, 0755)).To(Succeed())
+
+				data, err := json.Marshal([]history.History{
+					{Message: api.Message{Role: "system", Content: "You are a pirate."}},
+					{Message: api.Message{Role: "user", Content: "ahoy"}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(historyDir, "persona.json"), data, 0644)).To(Succeed())
+
+				runCommand("--thread", "persona", "--thread-role", "You are a lawyer.")
+
+				output := runCommand("--show-history", "persona")
+				Expect(output).To(ContainSubstring("**SYSTEM** 💻:\nYou are a lawyer.\n"))
+				Expect(output).To(ContainSubstring("ahoy"))
+				Expect(output).NotTo(ContainSubstring("pirate"))
+			})
+
+			it("should report the threads --prune-threads deletes and keep them with --dry-run", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.Mkdir(historyDir, 0755)).To(Succeed())
+
+				now := time.Now()
+				for thread, age := range map[string]time.Duration{"int_old": 30 * 24 * time.Hour, "int_new": time.Hour, "keep": 90 * 24 * time.Hour} {
+					data, err := json.Marshal([]history.History{{Message: api.Message{Role: "user", Content: "hi"}, Timestamp: now.Add(-age)}})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(os.WriteFile(filepath.Join(historyDir, thread+".json"), data, 0644)).To(Succeed())
+				}
+
+				configFile = path.Join(filePath, "config.yaml")
+				Expect(os.WriteFile(configFile, []byte("retention:\n  rules:\n    - prefix: int_\n      max_age: 7d\n"), 0644)).To(Succeed())
+
+				output := runCommand("--prune-threads", "--dry-run")
+				Expect(output).To(ContainSubstring("- int_old: not updated for 7d (rule for int_*)"))
+				Expect(output).To(ContainSubstring(filepath.Join(historyDir, "int_old.json")))
+				Expect(output).To(ContainSubstring("Would delete 1 threads."))
+				Expect(filepath.Join(historyDir, "int_old.json")).To(BeAnExistingFile())
+
+				output = runCommand("--prune-threads")
+				Expect(output).To(ContainSubstring("Deleted 1 threads."))
+				Expect(filepath.Join(historyDir, "int_old.json")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(historyDir, "int_new.json")).To(BeAnExistingFile())
+				Expect(filepath.Join(historyDir, "keep.json")).To(BeAnExistingFile())
+			})
+
+			it("should prune threads by their exact name and remove the attachments only they referred to", func() {
+				historyDir := path.Join(filePath, "history")
+				blobDir := filepath.Join(historyDir, history.BlobDir)
+				Expect(os.MkdirAll(blobDir, 0755)).To(Succeed())
+
+				blob := func(content string, age time.Duration) string {
+					sum := sha256.Sum256([]byte(content))
+					hash := hex.EncodeToString(sum[:])
+					path := filepath.Join(blobDir, hash)
+					Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+					modified := time.Now().Add(-age)
+					Expect(os.Chtimes(path, modified, modified)).To(Succeed())
+					return hash
+				}
+
+				kept := blob("kept", 48*time.Hour)
+				pruned := blob("pruned", 48*time.Hour)
+				fresh := blob("fresh", 0)
+
+				now := time.Now()
+				for thread, message := range map[string]struct {
+					age  time.Duration
+					blob string
+				}{"int_[1]": {30 * 24 * time.Hour, pruned}, "int_1": {time.Hour, kept}} {
+					data, err := json.Marshal([]history.History{{
+						Message: api.Message{Role: "user", Content: []api.ContentPart{
+							{Type: "image_url", Blob: message.blob, MediaType: "image/png"},
+						}},
+						Timestamp: now.Add(-message.age),
+					}})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(os.WriteFile(filepath.Join(historyDir, thread+".json"), data, 0644)).To(Succeed())
+				}
+
+				configFile = path.Join(filePath, "config.yaml")
+				Expect(os.WriteFile(configFile, []byte("retention:\n  rules:\n    - prefix: int_\n      max_age: 7d\n"), 0644)).To(Succeed())
+
+				output := runCommand("--prune-threads")
+				Expect(output).To(ContainSubstring("Deleted 1 threads."))
+				Expect(output).To(ContainSubstring("Removed 1 attachments no thread refers to."))
+				Expect(filepath.Join(historyDir, "int_[1].json")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(historyDir, "int_1.json")).To(BeAnExistingFile())
+
+				Expect(filepath.Join(blobDir, kept)).To(BeAnExistingFile())
+				Expect(filepath.Join(blobDir, pruned)).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(blobDir, fresh)).To(BeAnExistingFile())
+			})
+
+			it("should encrypt and decrypt the thread files in place with the history key", func() {
+				historyDir := path.Join(filePath, "history")
+				Expect(os.Mkdir(historyDir, 0755)).To(Succeed())
+
+				threadFile := filepath.Join(historyDir, "secret.json")
+				data, err := json.Marshal([]history.History{{Message: api.Message{Role: "user", Content: "account 4711"}}})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(threadFile, data, 0644)).To(Succeed())
+
+				keyFile := path.Join(filePath, "history.key")
+				Expect(os.WriteFile(keyFile, []byte("s3cret\n"), 0600)).To(Succeed())
+
+				configFile = path.Join(filePath, "config.yaml")
+				Expect(os.WriteFile(configFile, []byte("history_key_file: "+keyFile+"\n"), 0644)).To(Succeed())
+
+				output := runCommand("--encrypt-history")
+				Expect(output).To(ContainSubstring("Encrypted 1 threads"))
+
+				content, err := os.ReadFile(threadFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(history.Encrypted(content)).To(BeTrue())
+
+				output = runCommand("--show-history", "secret", "--history-encryption")
+				Expect(output).To(ContainSubstring("account 4711"))
+
+				output = runCommand("--decrypt-history")
+				Expect(output).To(ContainSubstring("Decrypted 1 threads"))
+
+				content, err = os.ReadFile(threadFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(ContainSubstring("account 4711"))
+			})
+
+			it("should never read the history key from the config file or show it", func() {
+				configFile = path.Join(filePath, "config.yaml")
+				Expect(os.WriteFile(configFile, []byte("history_key: plaintext-secret\n"), 0644)).To(Succeed())
+
+				output := runCommand("--config")
+				Expect(output).NotTo(ContainSubstring("plaintext-secret"))
+				Expect(output).To(ContainSubstring("history_key: '[redacted]'"))
+
+				command := exec.Command(binaryPath, "--encrypt-history")
+				session, err := gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("history encryption needs a key"))
+
+				command = exec.Command(binaryPath, "--set-history-key", "secret")
+				session, err = gexec.Start(command, io.Discard, io.Discard)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(exitFailure))
+				Expect(string(session.Err.Contents())).To(ContainSubstring("unknown flag: --set-history-key"))
+			})
+
 			it("should delete the expected thread using the --delete-threads flag", func() {
 				historyDir := path.Join(filePath, "history")
 				Expect(os.Mkdir(historyDir, 0755)).To(Succeed())
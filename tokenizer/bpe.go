@@ -0,0 +1,126 @@
+package tokenizer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+const (
+	maxLineSize     = 1024 * 1024
+	errInvalidVocab = "invalid vocabulary line %d: %q"
+)
+
+// BPE is a byte pair encoder compatible with tiktoken.
+type BPE struct {
+	ranks   map[string]int
+	pattern *regexp.Regexp
+}
+
+// Ensure BPE implements the Tokenizer interface
+var _ Tokenizer = &BPE{}
+
+// NewBPE reads a vocabulary in the tiktoken format for encoding.
+func NewBPE(encoding string, r io.Reader) (*BPE, error) {
+	pattern, ok := patterns[encoding]
+	if !ok {
+		return nil, fmt.Errorf(errUnknownEncoding, encoding)
+	}
+
+	ranks := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(errInvalidVocab, line, scanner.Text())
+		}
+
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf(errInvalidVocab, line, scanner.Text())
+		}
+
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf(errInvalidVocab, line, scanner.Text())
+		}
+
+		ranks[string(token)] = rank
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &BPE{ranks: ranks, pattern: pattern}, nil
+}
+
+// Encode returns the token ids of text.
+func (b *BPE) Encode(text string) []int {
+	var result []int
+	split(b.pattern, text, func(piece string) {
+		result = append(result, b.encodePiece(piece)...)
+	})
+	return result
+}
+
+func (b *BPE) Count(text string) int {
+	var result int
+	split(b.pattern, text, func(piece string) {
+		if _, ok := b.ranks[piece]; ok {
+			result++
+			return
+		}
+		result += len(b.encodePiece(piece))
+	})
+	return result
+}
+
+// encodePiece merges the bytes of piece pair by pair, always merging the pair
+// with the lowest rank first, until no adjacent pair is in the vocabulary.
+func (b *BPE) encodePiece(piece string) []int {
+	if rank, ok := b.ranks[piece]; ok {
+		return []int{rank}
+	}
+
+	// bounds holds the start of every part, followed by the end of the piece
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+
+	for len(bounds) > 2 {
+		best, index := math.MaxInt, -1
+		for i := 0; i < len(bounds)-2; i++ {
+			if rank, ok := b.ranks[piece[bounds[i]:bounds[i+2]]]; ok && rank < best {
+				best, index = rank, i
+			}
+		}
+		if index < 0 {
+			break
+		}
+		bounds = append(bounds[:index+1], bounds[index+2:]...)
+	}
+
+	result := make([]int, 0, len(bounds)-1)
+	for i := 0; i < len(bounds)-1; i++ {
+		rank, ok := b.ranks[piece[bounds[i]:bounds[i+1]]]
+		if !ok {
+			// a complete vocabulary holds every single byte; count it all the same
+			rank = -1
+		}
+		result = append(result, rank)
+	}
+	return result
+}
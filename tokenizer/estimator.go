@@ -0,0 +1,38 @@
+package tokenizer
+
+import "regexp"
+
+const (
+	asciiBytesPerToken = 6
+	otherBytesPerToken = 3
+)
+
+// Estimator approximates the token count when the vocabulary of an encoding is
+// not available. It splits the text like the real encoder and assumes that
+// ASCII pieces take a token per 6 bytes and other pieces a token per 3 bytes,
+// which keeps code and non-English text from being undercounted.
+type Estimator struct {
+	pattern *regexp.Regexp
+}
+
+// Ensure Estimator implements the Tokenizer interface
+var _ Tokenizer = &Estimator{}
+
+func NewEstimator(encoding string) *Estimator {
+	return &Estimator{pattern: patternFor(encoding)}
+}
+
+func (e *Estimator) Count(text string) int {
+	var result int
+	split(e.pattern, text, func(piece string) {
+		perToken := asciiBytesPerToken
+		for i := 0; i < len(piece); i++ {
+			if piece[i] >= 0x80 {
+				perToken = otherBytesPerToken
+				break
+			}
+		}
+		result += (len(piece) + perToken - 1) / perToken
+	})
+	return result
+}
@@ -0,0 +1,78 @@
+package tokenizer
+
+import (
+	"fmt"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+// whitespace extends \s, which only covers ASCII in Go, to the Unicode white space
+// the tiktoken patterns are written for.
+const whitespace = `\s\x{0B}\x{85}\p{Z}`
+
+// The pre-tokenization patterns of tiktoken. Go has no lookahead, so the \s+(?!\S)
+// alternative is left out and emulated by split.
+var patterns = map[string]*regexp.Regexp{
+	Cl100kBase: compile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)` +
+		`|[^\r\n\p{L}\p{N}]?\p{L}+` +
+		`|\p{N}{1,3}` +
+		`| ?[^%[1]s\p{L}\p{N}]+[\r\n]*` +
+		`|[%[1]s]*[\r\n]+` +
+		`|[%[1]s]+`),
+	O200kBase: compile(`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}` +
+		`| ?[^%[1]s\p{L}\p{N}]+[\r\n/]*` +
+		`|[%[1]s]*[\r\n]+` +
+		`|[%[1]s]+`),
+}
+
+func compile(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`^(?:` + fmt.Sprintf(pattern, whitespace) + `)`)
+}
+
+// patternFor returns the pattern of encoding, falling back to cl100k_base.
+func patternFor(encoding string) *regexp.Regexp {
+	if pattern, ok := patterns[encoding]; ok {
+		return pattern
+	}
+	return patterns[Cl100kBase]
+}
+
+// split cuts text into the pieces that are encoded independently. A run of
+// spaces followed by a word leaves its last space to the word, the way the
+// \s+(?!\S) alternative of the original patterns does.
+func split(pattern *regexp.Regexp, text string, piece func(string)) {
+	for len(text) > 0 {
+		end := 0
+		if loc := pattern.FindStringIndex(text); loc != nil {
+			end = loc[1]
+		}
+		if end == 0 {
+			_, end = utf8.DecodeRuneInString(text)
+		}
+
+		match := text[:end]
+		if end < len(text) && isSpaceRun(match) {
+			if _, size := utf8.DecodeLastRuneInString(match); size < len(match) {
+				end -= size
+				match = text[:end]
+			}
+		}
+
+		piece(match)
+		text = text[end:]
+	}
+}
+
+// isSpaceRun reports whether s is white space without line breaks, which only
+// the last alternative of the patterns produces.
+func isSpaceRun(s string) bool {
+	for _, r := range s {
+		if r == '\r' || r == '\n' || !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
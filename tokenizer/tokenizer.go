@@ -0,0 +1,84 @@
+package tokenizer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/kardolus/chatgpt-cli/internal"
+	"go.uber.org/zap"
+)
+
+const (
+	Cl100kBase         = "cl100k_base"
+	O200kBase          = "o200k_base"
+	DefaultDir         = "tokenizers"
+	vocabExtension     = ".tiktoken"
+	errUnknownEncoding = "unknown encoding %q"
+)
+
+// Tokenizer counts the tokens a model sees for a piece of text.
+type Tokenizer interface {
+	Count(text string) int
+}
+
+var (
+	mu    sync.Mutex
+	cache = make(map[string]Tokenizer)
+)
+
+// Get returns the tokenizer for encoding. The vocabulary is read once from the
+// tokenizers directory of the config home; when it is not there, the count is
+// estimated from the pre-tokenized text instead.
+func Get(encoding string) Tokenizer {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if t, ok := cache[encoding]; ok {
+		return t
+	}
+
+	var t Tokenizer
+
+	bpe, err := Load(encoding)
+	if err != nil {
+		zap.S().Debugf("Estimating tokens: %v", err)
+		t = NewEstimator(encoding)
+	} else {
+		t = bpe
+	}
+
+	cache[encoding] = t
+	return t
+}
+
+// Load reads the vocabulary of encoding from the tokenizers directory.
+func Load(encoding string) (*BPE, error) {
+	if _, ok := patterns[encoding]; !ok {
+		return nil, fmt.Errorf(errUnknownEncoding, encoding)
+	}
+
+	path, err := VocabPath(encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return NewBPE(encoding, file)
+}
+
+// VocabPath is where the vocabulary of encoding is expected, in the tiktoken
+// format: one base64 encoded token and its rank per line.
+func VocabPath(encoding string) (string, error) {
+	configHome, err := internal.GetConfigHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configHome, DefaultDir, encoding+vocabExtension), nil
+}
@@ -0,0 +1,125 @@
+package tokenizer_test
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kardolus/chatgpt-cli/internal"
+	"github.com/kardolus/chatgpt-cli/tokenizer"
+	. "github.com/onsi/gomega"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestUnitTokenizer(t *testing.T) {
+	spec.Run(t, "Testing the tokenizer", testTokenizer, spec.Report(report.Terminal{}))
+}
+
+func testTokenizer(t *testing.T, when spec.G, it spec.S) {
+	it.Before(func() {
+		RegisterTestingT(t)
+	})
+
+	when("NewBPE()", func() {
+		it("merges the pairs with the lowest rank first", func() {
+			subject := newBPE(tokenizer.Cl100kBase, "he", "ll", "hell", "hello", "lo")
+
+			Expect(subject.Encode("hello")).To(Equal([]int{259}))
+			Expect(subject.Encode("hellx")).To(Equal([]int{258, 'x'}))
+			Expect(subject.Encode("llo")).To(Equal([]int{257, 'o'}))
+			// " hello" is not in the vocabulary, so it takes the space and the merged word
+			Expect(subject.Count("hello hello")).To(Equal(3))
+		})
+		it("splits the text like tiktoken before merging", func() {
+			pieces := []string{"I", "'m", " fine", "\n\n", " ", " ok", "123", "4", "!!!"}
+			subject := newBPE(tokenizer.Cl100kBase, pieces...)
+
+			var expected []int
+			for i := range pieces {
+				expected = append(expected, 256+i)
+			}
+
+			Expect(subject.Encode("I'm fine\n\n  ok1234!!!")).To(Equal(expected))
+		})
+		it("gives the last space of a run to the next word", func() {
+			subject := newBPE(tokenizer.Cl100kBase, "a", "  ", " b", " ")
+
+			Expect(subject.Encode("a   b")).To(Equal([]int{256, 257, 258}))
+			Expect(subject.Encode("a   ")).To(Equal([]int{256, 257, 259}))
+		})
+		it("splits camel case and keeps contractions with their word with o200k_base", func() {
+			subject := newBPE(tokenizer.O200kBase, "Hello", "World's", " path", "./\n")
+
+			Expect(subject.Encode("HelloWorld's path./\n")).To(Equal([]int{256, 257, 258, 259}))
+		})
+		it("throws an error for unknown encodings and invalid lines", func() {
+			_, err := tokenizer.NewBPE("p50k_base", strings.NewReader(""))
+			Expect(err).To(MatchError(`unknown encoding "p50k_base"`))
+
+			_, err = tokenizer.NewBPE(tokenizer.Cl100kBase, strings.NewReader("aGk= one\n"))
+			Expect(err).To(MatchError(`invalid vocabulary line 1: "aGk= one"`))
+		})
+	})
+
+	when("NewEstimator()", func() {
+		it("counts a token per word for short English words", func() {
+			subject := tokenizer.NewEstimator(tokenizer.Cl100kBase)
+
+			Expect(subject.Count("")).To(Equal(0))
+			Expect(subject.Count("the cat sat")).To(Equal(3))
+		})
+		it("counts more tokens for non-English text", func() {
+			subject := tokenizer.NewEstimator(tokenizer.O200kBase)
+
+			Expect(subject.Count("日本語のテキスト")).To(Equal(8))
+		})
+	})
+
+	when("Get()", func() {
+		it("loads the vocabulary from the tokenizers directory of the config home", func() {
+			dir := t.TempDir()
+			t.Setenv(internal.ConfigHomeEnv, dir)
+
+			Expect(os.MkdirAll(filepath.Join(dir, tokenizer.DefaultDir), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, tokenizer.DefaultDir, "o200k_base.tiktoken"),
+				[]byte(vocab("hello")), 0644)).To(Succeed())
+
+			path, err := tokenizer.VocabPath(tokenizer.O200kBase)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(path).To(Equal(filepath.Join(dir, "tokenizers", "o200k_base.tiktoken")))
+
+			subject := tokenizer.Get(tokenizer.O200kBase)
+			Expect(subject).To(BeAssignableToTypeOf(&tokenizer.BPE{}))
+			Expect(subject.Count("hello")).To(Equal(1))
+		})
+		it("falls back to the estimator when the vocabulary is missing", func() {
+			t.Setenv(internal.ConfigHomeEnv, t.TempDir())
+
+			subject := tokenizer.Get(tokenizer.Cl100kBase)
+			Expect(subject).To(BeAssignableToTypeOf(&tokenizer.Estimator{}))
+		})
+	})
+}
+
+// vocab builds a vocabulary holding every single byte followed by tokens, so the
+// rank of tokens[i] is 256+i.
+func vocab(tokens ...string) string {
+	var b strings.Builder
+	for i := 0; i < 256; i++ {
+		_, _ = fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte{byte(i)}), i)
+	}
+	for i, token := range tokens {
+		_, _ = fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), 256+i)
+	}
+	return b.String()
+}
+
+func newBPE(encoding string, tokens ...string) *tokenizer.BPE {
+	subject, err := tokenizer.NewBPE(encoding, strings.NewReader(vocab(tokens...)))
+	Expect(err).NotTo(HaveOccurred())
+	return subject
+}